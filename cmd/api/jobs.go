@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"e-library-api/internal/config"
+	"e-library-api/internal/idempotency"
+	"e-library-api/internal/ratelimit"
+	"e-library-api/internal/repository"
+	"e-library-api/internal/scheduler"
+	"e-library-api/internal/service"
+	"log"
+	"time"
+)
+
+// registerJobs adds the loan lifecycle jobs to the scheduler.
+func registerJobs(s *scheduler.Scheduler, svc *service.LibraryService, cfg *config.Config) {
+	s.Add(scheduler.Job{
+		Name:     "expire-holds",
+		Interval: cfg.HoldExpiryInterval,
+		Run: func(ctx context.Context) error {
+			expired, err := svc.ExpireHolds(ctx)
+			if err != nil {
+				return err
+			}
+			if expired > 0 {
+				log.Printf("Expired %d holds past their pickup deadline", expired)
+			}
+			return nil
+		},
+	})
+
+	s.Add(scheduler.Job{
+		Name:     "expire-ebook-loans",
+		Interval: cfg.EbookExpiryInterval,
+		Run: func(ctx context.Context) error {
+			expired, err := svc.ExpireDigitalLoans(ctx)
+			if err != nil {
+				return err
+			}
+			if expired > 0 {
+				log.Printf("Automatically returned %d expired e-book loans", expired)
+			}
+			return nil
+		},
+	})
+
+	// Overdue loans are only reported to the log. Nothing about them changes:
+	// the fine is charged when the book comes back.
+	s.Add(scheduler.Job{
+		Name:     "overdue-report",
+		Interval: cfg.OverdueScanInterval,
+		Run: func(ctx context.Context) error {
+			overdue, err := svc.ListOverdueLoans(ctx)
+			if err != nil {
+				return err
+			}
+			for _, l := range overdue {
+				if ctx.Err() != nil {
+					return nil
+				}
+				log.Printf("Loan overdue: borrower %d, %q due %s", l.BorrowerID, l.BookTitle, l.ReturnDate.Format(time.DateOnly))
+			}
+			return nil
+		},
+	})
+
+	retention := time.Duration(cfg.RetentionDays) * 24 * time.Hour
+	s.Add(scheduler.Job{
+		Name:     "purge-old-data",
+		Interval: cfg.PurgeInterval,
+		Run: func(ctx context.Context) error {
+			purged, err := svc.PurgeHolds(ctx, retention)
+			if err != nil {
+				return err
+			}
+			if purged > 0 {
+				log.Printf("Purged %d closed holds older than %d days", purged, cfg.RetentionDays)
+			}
+			purged, err = svc.PurgeLoans(ctx, retention)
+			if err != nil {
+				return err
+			}
+			if purged > 0 {
+				log.Printf("Purged %d ended loans older than %d days", purged, cfg.RetentionDays)
+			}
+			return nil
+		},
+	})
+}
+
+// registerSnapshotJob adds the job that snapshots a persisted memory
+// repository and compacts its write-ahead log.
+func registerSnapshotJob(s *scheduler.Scheduler, repo *repository.DurableRepo, cfg *config.Config) {
+	s.Add(scheduler.Job{
+		Name:     "memory-snapshot",
+		Interval: cfg.SnapshotInterval,
+		Run: func(ctx context.Context) error {
+			return repo.Snapshot()
+		},
+	})
+}
+
+// registerRateLimitJob adds the job that drops rate limit buckets that have
+// refilled from the database.
+func registerRateLimitJob(s *scheduler.Scheduler, store *ratelimit.SQLStore, cfg *config.Config) {
+	s.Add(scheduler.Job{
+		Name:     "prune-rate-limits",
+		Interval: cfg.PurgeInterval,
+		Run: func(ctx context.Context) error {
+			_, err := store.Prune(ctx, time.Now())
+			return err
+		},
+	})
+}
+
+// registerIdempotencyJob adds the job that drops expired idempotency keys
+// from the database.
+func registerIdempotencyJob(s *scheduler.Scheduler, store *idempotency.SQLStore, cfg *config.Config) {
+	s.Add(scheduler.Job{
+		Name:     "prune-idempotency-keys",
+		Interval: cfg.PurgeInterval,
+		Run: func(ctx context.Context) error {
+			_, err := store.Prune(ctx, time.Now())
+			return err
+		},
+	})
+}
@@ -3,14 +3,21 @@ package main
 import (
 	"context"
 	"database/sql"
+	"e-library-api/internal/auth"
 	"e-library-api/internal/config"
 	"e-library-api/internal/handlers"
+	"e-library-api/internal/idempotency"
 	"e-library-api/internal/middleware"
+	"e-library-api/internal/openapi"
+	"e-library-api/internal/policy"
+	"e-library-api/internal/ratelimit"
 	"e-library-api/internal/repository"
+	"e-library-api/internal/scheduler"
 	"e-library-api/internal/service"
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -27,18 +34,34 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrateCommand(cfg, os.Args[2:])
+		return
+	}
+
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
 	r := gin.New()
+	// Client IPs, which anonymous callers are rate limited by, are only read
+	// from X-Forwarded-For when the request came through a trusted proxy
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	r.Use(middleware.Correlate())
 	r.Use(middleware.StructuredLogger())
-	r.Use(gin.Recovery())
+	r.Use(middleware.Recovery())
+	r.Use(middleware.QueryTimeout(cfg.QueryTimeout))
 
 	var repo repository.LibraryRepository
+	var locker scheduler.Locker = scheduler.LocalLocker{}
+	var durable *repository.DurableRepo
+	var db *sql.DB
 
-	if cfg.DBType == "postgres" {
-		db, err := sql.Open("postgres", cfg.DatabaseURL)
+	switch cfg.DBType {
+	case "postgres", "sqlite":
+		db, err = openDB(cfg)
 		if err != nil {
 			log.Fatalf("Failed to connect to database: %v", err)
 		}
@@ -48,33 +71,121 @@ func main() {
 			}
 		}()
 
-		db.SetMaxOpenConns(25)
-		db.SetMaxIdleConns(25)
-		db.SetConnMaxLifetime(5 * time.Minute)
+		// A SQLite file belongs to this process alone, so it is always brought up to date
+		if cfg.MigrateOnStart || cfg.DBType == "sqlite" {
+			if err := runMigrations(context.Background(), db, cfg.DBType, "up"); err != nil {
+				log.Fatalf("Failed to migrate database: %v", err)
+			}
+		}
+
+		if cfg.DBType == "sqlite" {
+			repo = repository.NewSQLiteRepo(db)
+			log.Printf("Using SQLite repository at %s", cfg.SQLitePath)
+		} else {
+			repo = repository.NewPostgresRepo(db)
+			locker = scheduler.NewPostgresLocker(db)
+			log.Println("Using Postgres repository")
+		}
+	default:
+		if cfg.MemoryDataDir == "" {
+			repo = repository.NewMemoryRepo()
+			log.Println("Using Memory repository")
+			break
+		}
+		durable, err = repository.OpenDurableRepo(cfg.MemoryDataDir)
+		if err != nil {
+			log.Fatalf("Failed to load memory repository: %v", err)
+		}
+		repo = durable
+		log.Printf("Using Memory repository persisted to %s", cfg.MemoryDataDir)
+	}
+
+	svc := service.NewLibraryService(repo, policy.FromConfig(cfg))
+	h := &handlers.LibraryHandler{Service: svc, Config: cfg}
+
+	authenticator, err := auth.New(cfg.APIKeys, cfg.APIKeyRoles, cfg.JWTSecret)
+	if err != nil {
+		log.Fatalf("Invalid API key configuration: %v", err)
+	}
+	if !authenticator.Enabled() {
+		if cfg.Environment == "production" {
+			log.Fatal("API_KEYS or JWT_SECRET must be set in production")
+		}
+		log.Println("Warning: no API_KEYS or JWT_SECRET configured, requests are not authenticated")
+	}
 
-		if err := db.Ping(); err != nil {
-			log.Fatalf("Failed to ping database: %v", err)
+	limits, err := ratelimit.ParseLimits(cfg.RateLimit, cfg.RateLimits)
+	if err != nil {
+		log.Fatalf("Invalid rate limit configuration: %v", err)
+	}
+	clientLimit, err := ratelimit.ParseLimit(cfg.ClientRateLimit)
+	if err != nil {
+		log.Fatalf("Invalid CLIENT_RATE_LIMIT: %v", err)
+	}
+	var limitStore ratelimit.Store
+	var sharedLimits *ratelimit.SQLStore
+	switch cfg.RateLimitStore {
+	case "memory":
+		limitStore = ratelimit.NewMemoryStore()
+	case "database":
+		if db == nil {
+			log.Fatal("RATE_LIMIT_STORE=database needs DB_TYPE postgres or sqlite")
 		}
+		sharedLimits = ratelimit.NewSQLStore(db)
+		limitStore = sharedLimits
+	default:
+		log.Fatalf("Unknown RATE_LIMIT_STORE %q", cfg.RateLimitStore)
+	}
+
+	// Retries are recognised across replicas when there is a database to share
+	var replies idempotency.Store = idempotency.NewMemoryStore()
+	var sharedReplies *idempotency.SQLStore
+	if db != nil {
+		sharedReplies = idempotency.NewSQLStore(db)
+		replies = sharedReplies
+	}
 
-		repo = repository.NewPostgresRepo(db)
-		log.Println("Using Postgres repository")
-	} else {
-		repo = repository.NewMemoryRepo()
-		log.Println("Using Memory repository")
+	spec, err := openapi.Load()
+	if err != nil {
+		log.Fatalf("Failed to load API document: %v", err)
+	}
+	// Outside production, responses are checked against the document too
+	var onResponseError func(*gin.Context, error)
+	if cfg.Environment != "production" {
+		onResponseError = func(c *gin.Context, err error) {
+			log.Printf("Response to %s %s does not match the API document: %v", c.Request.Method, c.FullPath(), err)
+		}
 	}
 
-	svc := service.NewLibraryService(repo)
-	h := &handlers.LibraryHandler{Service: svc}
+	registerRoutes(r, h, middleware.RateLimitClients(limitStore, clientLimit), middleware.Authenticate(authenticator), middleware.RateLimit(limitStore, limits),
+		middleware.ValidateAPI(spec, onResponseError), middleware.Idempotency(replies, cfg.IdempotencyTTL), cfg.LegacySunset)
 
-	r.GET("/Book", h.GetBook)
-	r.POST("/Borrow", h.BorrowBook)
-	r.POST("/Extend", h.ExtendLoan)
-	r.POST("/Return", h.ReturnBook)
-	r.GET("/health", h.HealthCheck)
+	jobs := scheduler.New(locker)
+	if cfg.SchedulerEnabled {
+		registerJobs(jobs, svc, cfg)
+		if sharedLimits != nil {
+			registerRateLimitJob(jobs, sharedLimits, cfg)
+		}
+		if sharedReplies != nil {
+			registerIdempotencyJob(jobs, sharedReplies, cfg)
+		}
+	}
+	// Snapshots keep the write-ahead log short even with the lending jobs disabled
+	if durable != nil {
+		registerSnapshotJob(jobs, durable, cfg)
+	}
+	if cfg.SchedulerEnabled || durable != nil {
+		jobs.Start(context.Background())
+	}
 
+	// Requests still running when the shutdown deadline passes have their
+	// queries cancelled through this context
+	requests, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", cfg.Port),
-		Handler: r,
+		Addr:        fmt.Sprintf(":%s", cfg.Port),
+		Handler:     r,
+		BaseContext: func(net.Listener) context.Context { return requests },
 	}
 
 	// Initializing the server in a goroutine so that
@@ -100,9 +211,153 @@ func main() {
 	// the request it is currently handling
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	context.AfterFunc(ctx, cancelRequests)
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+		// Their queries are cancelled; carry on stopping the rest
+		log.Printf("Server forced to shutdown: %v", err)
+	}
+	// Background jobs share the same deadline to finish or checkpoint their work
+	if err := jobs.Stop(ctx); err != nil {
+		log.Printf("Background jobs did not stop in time: %v", err)
+	}
+	// Requests and jobs are done, so the final snapshot holds every change
+	if durable != nil {
+		if err := durable.Close(); err != nil {
+			log.Printf("Error saving memory repository: %v", err)
+		}
 	}
 
 	log.Println("Server exiting")
 }
+
+// openDB opens and pings the configured Postgres or SQLite database.
+func openDB(cfg *config.Config) (*sql.DB, error) {
+	if cfg.DBType == "sqlite" {
+		return repository.OpenSQLite(cfg.SQLitePath)
+	}
+	if cfg.DBType != "postgres" {
+		return nil, fmt.Errorf("DB_TYPE %q has no database to open", cfg.DBType)
+	}
+
+	db, err := sql.Open("postgres", cfg.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(25)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// legacyDeprecation is when the unversioned routes were superseded by /v1.
+var legacyDeprecation = time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC)
+
+// legacySuccessors names the /v1 resources taking over the legacy
+// endpoints that do not map onto a /v1 path of their own.
+var legacySuccessors = map[string]string{
+	"GET /Book":    "/v1/books",
+	"POST /Borrow": "/v1/loans",
+	"POST /Extend": "/v1/loans",
+	"POST /Return": "/v1/loans",
+	"GET /Hold":    "/v1/books",
+	"POST /Hold":   "/v1/holds",
+}
+
+// registerRoutes wires every API endpoint onto the router. Resources are
+// served under /v1; the unversioned routes that came before it still work
+// but are deprecated, and sunset at the given time. Catalog reads and the
+// health check are public; everything else runs behind authenticate and
+// needs the permission named on its route. limitClients runs ahead of
+// authenticate, so that guessing credentials is throttled by client IP.
+// Every route but the health check and the API reference is rate limited,
+// and then checked by validate
+// against the API document. Authenticated routes then go through idempotent,
+// which replays the responses to retried POST requests. Updates to books and
+// loans under /v1 must name the version they change with If-Match.
+func registerRoutes(r *gin.Engine, h *handlers.LibraryHandler, limitClients, authenticate, rateLimit, validate, idempotent gin.HandlerFunc, sunset time.Time) {
+	require := middleware.Require
+	ifMatch := middleware.IfMatch(true)
+
+	v1Public := r.Group("/v1", rateLimit, validate)
+	v1 := r.Group("/v1", limitClients, authenticate, rateLimit, validate, idempotent)
+	registerResources(v1Public, v1, h, ifMatch)
+	v1.POST("/loans", require(auth.PermOwnLoans), h.BorrowBook)
+	v1.GET("/loans/:id", require(auth.PermOwnLoans), h.GetLoan)
+	v1.POST("/loans/:id/renewals", require(auth.PermOwnLoans), ifMatch, h.RenewLoan)
+	v1.DELETE("/loans/:id", require(auth.PermOwnLoans), ifMatch, h.ReturnLoan)
+	v1.POST("/holds", require(auth.PermOwnLoans), h.PlaceHold)
+	v1.GET("/books/:id/holds", require(auth.PermAnyLoan), h.ListHolds)
+
+	deprecated := middleware.Deprecated(legacyDeprecation, sunset, legacySuccessors)
+	public := r.Group("/", deprecated, rateLimit, validate)
+	api := r.Group("/", deprecated, limitClients, authenticate, rateLimit, validate, idempotent)
+	registerResources(public, api, h, middleware.IfMatch(false))
+	public.GET("/Book", h.GetBook)
+	api.POST("/Borrow", require(auth.PermOwnLoans), h.BorrowBook)
+	api.POST("/Extend", require(auth.PermOwnLoans), h.ExtendLoan)
+	api.POST("/Return", require(auth.PermOwnLoans), h.ReturnBook)
+	api.GET("/Hold", require(auth.PermAnyLoan), h.ListHolds)
+	api.POST("/Hold", require(auth.PermOwnLoans), h.PlaceHold)
+
+	r.GET("/health", validate, h.HealthCheck)
+
+	// API reference
+	r.GET("/openapi.json", h.OpenAPI)
+	r.GET("/docs", h.APIDocs)
+	r.GET("/docs/redoc.standalone.js", h.RedocScript)
+
+	r.NoRoute(h.NoRoute)
+}
+
+// registerResources wires the resource routes shared by /v1 and the legacy
+// unversioned paths onto the public and the authenticated group. ifMatch
+// guards the updates of versioned resources.
+func registerResources(public, api *gin.RouterGroup, h *handlers.LibraryHandler, ifMatch gin.HandlerFunc) {
+	require := middleware.Require
+	// Routes about one member are also open to that member
+	requireOrSelf := func(perm auth.Permission) gin.HandlerFunc {
+		return middleware.RequireOrSelf(perm, "id")
+	}
+
+	// Catalog management
+	public.GET("/books", h.ListBooks)
+	public.GET("/search", h.SearchBooks)
+	api.POST("/books", require(auth.PermCatalog), h.CreateBook)
+	public.GET("/books/:id", h.GetBook)
+	api.PUT("/books/:id", require(auth.PermCatalog), ifMatch, h.UpdateBook)
+	api.PATCH("/books/:id", require(auth.PermCatalog), ifMatch, h.AdjustCopies)
+	api.DELETE("/books/:id", require(auth.PermCatalog), ifMatch, h.DeleteBook)
+	public.GET("/works/:id", h.GetWork)
+
+	// Copies and licenses
+	public.GET("/books/:id/items", h.ListItems)
+	api.POST("/books/:id/items", require(auth.PermCatalog), h.AddItem)
+	api.GET("/items/:id", require(auth.PermCatalog), h.GetItem)
+	api.PUT("/items/:id", require(auth.PermCatalog), h.UpdateItem)
+
+	// Member registry
+	api.POST("/members", require(auth.PermMembers), h.CreateBorrower)
+	api.GET("/members/:id", requireOrSelf(auth.PermAnyLoan), h.GetBorrower)
+	api.PUT("/members/:id", require(auth.PermMembers), h.UpdateBorrower)
+	api.DELETE("/members/:id", require(auth.PermMembers), h.DeleteBorrower)
+
+	// Overdue loans and fines
+	api.GET("/loans/overdue", require(auth.PermAnyLoan), h.ListOverdueLoans)
+	api.GET("/members/:id/fines", requireOrSelf(auth.PermAnyLoan), h.GetFineAccount)
+	api.POST("/members/:id/fines/payments", require(auth.PermFines), h.PayFine)
+	api.POST("/members/:id/fines/waivers", require(auth.PermFines), h.WaiveFine)
+
+	// Loan history
+	api.GET("/members/:id/loans", requireOrSelf(auth.PermAnyLoan), h.ListBorrowerLoans)
+	api.GET("/books/:id/loans", require(auth.PermAnyLoan), h.ListBookLoans)
+	api.POST("/loans/:id/lost", require(auth.PermAnyLoan), h.MarkLoanLost)
+
+	// Administration
+	api.GET("/admin/config", require(auth.PermConfig), h.GetConfig)
+}
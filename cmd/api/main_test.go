@@ -2,32 +2,118 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"e-library-api/internal/auth"
+	"e-library-api/internal/config"
+	"e-library-api/internal/errors"
 	"e-library-api/internal/handlers"
+	"e-library-api/internal/idempotency"
+	"e-library-api/internal/middleware"
 	"e-library-api/internal/models"
+	"e-library-api/internal/openapi"
+	"e-library-api/internal/policy"
+	"e-library-api/internal/problem"
+	"e-library-api/internal/ratelimit"
 	"e-library-api/internal/repository"
 	"e-library-api/internal/service"
 	"encoding/json"
+	"fmt"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// ctx is passed to repository calls made directly by the tests
+var ctx = context.Background()
+
+// legacySunset is when the tests' unversioned routes are retired
+var legacySunset = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+
+// noRateLimit lets every request through, for tests about other things
+var noRateLimit = middleware.RateLimit(nil, ratelimit.Limits{})
+
+// noIdempotency carries out every request, retried or not
+var noIdempotency = middleware.Idempotency(nil, 0)
+
+// specCheck validates the traffic of every test against the API document.
+// Responses that do not match it fail the test run in TestMain.
+var (
+	spec, _         = openapi.Load()
+	specCheck       = middleware.ValidateAPI(spec, recordSpecViolation)
+	specViolationMu sync.Mutex
+	specViolations  []string
+)
+
+func recordSpecViolation(c *gin.Context, err error) {
+	specViolationMu.Lock()
+	defer specViolationMu.Unlock()
+	specViolations = append(specViolations, fmt.Sprintf("%s %s: %v", c.Request.Method, c.Request.URL, err))
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	for _, v := range specViolations {
+		fmt.Println("Response does not match the API document:", v)
+		code = 1
+	}
+	os.Exit(code)
+}
+
+// Member IDs assigned by seedBorrowers
+var alice, bob, carol int64
+
+// Book IDs of the editions seeded by NewMemoryRepo
+const (
+	goBook int64 = iota + 1
+	cleanCode
+	designPatterns
+)
+
+func bookPath(id int64) string {
+	return "/books/" + strconv.FormatInt(id, 10)
+}
+
+func seedBorrowers(repo *repository.MemoryRepo) {
+	expires := time.Now().AddDate(1, 0, 0)
+	for _, b := range []struct {
+		id   *int64
+		name string
+	}{{&alice, "Alice"}, {&bob, "Bob"}, {&carol, "Carol"}} {
+		created, _ := repo.CreateBorrower(ctx, &models.Borrower{
+			Name:                b.name,
+			Email:               b.name + "@example.com",
+			Status:              models.MemberActive,
+			MembershipExpiresAt: expires,
+		})
+		*b.id = created.ID
+	}
+}
+
 func setupTestRouter() (*gin.Engine, *repository.MemoryRepo) {
+	return setupTestRouterWithPolicy(policy.Default())
+}
+
+func setupTestRouterWithPolicy(p policy.Policy) (*gin.Engine, *repository.MemoryRepo) {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
 	repo := repository.NewMemoryRepo()
-	svc := service.NewLibraryService(repo)
+	seedBorrowers(repo)
+	svc := service.NewLibraryService(repo, p)
 	h := &handlers.LibraryHandler{Service: svc}
 
-	r.GET("/Book", h.GetBook)
-	r.POST("/Borrow", h.BorrowBook)
-	r.POST("/Extend", h.ExtendLoan)
-	r.POST("/Return", h.ReturnBook)
-	r.GET("/health", h.HealthCheck)
+	registerRoutes(r, h, noRateLimit, middleware.Authenticate(nil), noRateLimit, specCheck, noIdempotency, legacySunset)
 
 	return r, repo
 }
@@ -37,30 +123,30 @@ func TestEndpoints(t *testing.T) {
 
 	t.Run("GET /Book - Found", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/Book?title=Clean+Code", nil)
+		req, _ := http.NewRequest("GET", "/Book?id=2", nil)
 		router.ServeHTTP(w, req)
 		assert.Equal(t, http.StatusOK, w.Code)
 	})
 
 	t.Run("GET /Book - Not Found", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/Book?title=Unknown", nil)
+		req, _ := http.NewRequest("GET", "/Book?id=999", nil)
 		router.ServeHTTP(w, req)
 		assert.Equal(t, http.StatusNotFound, w.Code)
 	})
 
 	t.Run("POST /Borrow - Success", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		payload, _ := json.Marshal(map[string]string{"name_of_borrower": "Alice", "book_title": "Clean Code"})
+		payload, _ := json.Marshal(map[string]any{"borrower_id": alice, "book_id": cleanCode})
 		req, _ := http.NewRequest("POST", "/Borrow", bytes.NewBuffer(payload))
 		router.ServeHTTP(w, req)
 		assert.Equal(t, http.StatusCreated, w.Code)
 	})
 
 	t.Run("POST /Borrow - Conflict (Out of Stock)", func(t *testing.T) {
-		repo.Books["Design Patterns"].AvailableCopies = 0
+		_, _ = repo.AdjustCopies(ctx, designPatterns, -1, 0)
 		w := httptest.NewRecorder()
-		payload, _ := json.Marshal(map[string]string{"name_of_borrower": "Bob", "book_title": "Design Patterns"})
+		payload, _ := json.Marshal(map[string]any{"borrower_id": bob, "book_id": designPatterns})
 		req, _ := http.NewRequest("POST", "/Borrow", bytes.NewBuffer(payload))
 		router.ServeHTTP(w, req)
 		assert.Equal(t, http.StatusConflict, w.Code)
@@ -84,9 +170,10 @@ func TestGetBook_Scenarios(t *testing.T) {
 		query      string
 		expectCode int
 	}{
-		{"Happy Path", "?title=Clean+Code", http.StatusOK},
-		{"Missing Title Param", "", http.StatusBadRequest},
-		{"Book Not Found", "?title=NonExistent", http.StatusNotFound},
+		{"Happy Path", "?id=2", http.StatusOK},
+		{"Missing ID Param", "", http.StatusBadRequest},
+		{"Malformed ID", "?id=abc", http.StatusBadRequest},
+		{"Book Not Found", "?id=999", http.StatusNotFound},
 	}
 
 	for _, tt := range tests {
@@ -105,21 +192,21 @@ func TestBorrowBook_Scenarios(t *testing.T) {
 
 	t.Run("Success - Borrow Available Book", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		body, _ := json.Marshal(map[string]string{"name_of_borrower": "Alice", "book_title": "Clean Code"})
+		body, _ := json.Marshal(map[string]any{"borrower_id": alice, "book_id": cleanCode})
 		req, _ := http.NewRequest("POST", "/Borrow", bytes.NewBuffer(body))
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusCreated, w.Code)
 		// Verify side effect: copies should decrease
-		book, _ := repo.GetBook("Clean Code")
+		book, _ := repo.GetBook(ctx, cleanCode)
 		assert.Equal(t, 1, book.AvailableCopies)
 	})
 
 	t.Run("Error - Out of Stock", func(t *testing.T) {
 		// Empty the stock first
-		repo.Books["Clean Code"].AvailableCopies = 0
+		_, _ = repo.AdjustCopies(ctx, cleanCode, -1, 0)
 		w := httptest.NewRecorder()
-		body, _ := json.Marshal(map[string]string{"name_of_borrower": "Bob", "book_title": "Clean Code"})
+		body, _ := json.Marshal(map[string]any{"borrower_id": bob, "book_id": cleanCode})
 		req, _ := http.NewRequest("POST", "/Borrow", bytes.NewBuffer(body))
 		router.ServeHTTP(w, req)
 
@@ -128,7 +215,7 @@ func TestBorrowBook_Scenarios(t *testing.T) {
 
 	t.Run("Error - Missing JSON Body Fields", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		body, _ := json.Marshal(map[string]string{"name_of_borrower": "Alice"}) // Missing "title"
+		body, _ := json.Marshal(map[string]any{"borrower_id": alice}) // Missing "book_id"
 		req, _ := http.NewRequest("POST", "/Borrow", bytes.NewBuffer(body))
 		router.ServeHTTP(w, req)
 
@@ -137,7 +224,7 @@ func TestBorrowBook_Scenarios(t *testing.T) {
 
 	t.Run("Error - Duplicate Loan", func(t *testing.T) {
 		// First borrow
-		body, _ := json.Marshal(map[string]string{"name_of_borrower": "Alice", "book_title": "The Go Programming Language"})
+		body, _ := json.Marshal(map[string]any{"borrower_id": alice, "book_id": goBook})
 		w1 := httptest.NewRecorder()
 		req1, _ := http.NewRequest("POST", "/Borrow", bytes.NewBuffer(body))
 		router.ServeHTTP(w1, req1)
@@ -158,32 +245,32 @@ func TestExtendLoan_Scenarios(t *testing.T) {
 	t.Run("Success - Extend Existing Loan", func(t *testing.T) {
 		// Manually inject a loan to test extension
 		now := time.Now()
-		_, err := repo.BorrowBook(&models.LoanDetail{
-			NameOfBorrower: "Alice",
-			BookTitle:      "Clean Code",
-			LoanDate:       now,
-			ReturnDate:     now.AddDate(0, 0, 28),
-		})
+		_, err := repo.BorrowBook(ctx, &models.LoanDetail{
+			BorrowerID: alice,
+			BookID:     cleanCode,
+			LoanDate:   now,
+			ReturnDate: now.AddDate(0, 0, 28),
+		}, now, nil)
 		if err != nil {
 			t.Fatalf("Failed to setup test: %v", err)
 		}
-		initialReturnDate := repo.Loans["Clean Code"][0].ReturnDate
+		initialReturnDate := repo.Loans[cleanCode][0].ReturnDate
 
 		w := httptest.NewRecorder()
-		body, _ := json.Marshal(map[string]string{"name_of_borrower": "Alice", "book_title": "Clean Code"})
+		body, _ := json.Marshal(map[string]any{"borrower_id": alice, "book_id": cleanCode})
 		req, _ := http.NewRequest("POST", "/Extend", bytes.NewBuffer(body))
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
 		// Verify logic: should be exactly 21 days after the initial return date
 		expectedReturnDate := initialReturnDate.AddDate(0, 0, 21)
-		actualReturnDate := repo.Loans["Clean Code"][0].ReturnDate
+		actualReturnDate := repo.Loans[cleanCode][0].ReturnDate
 		assert.Equal(t, expectedReturnDate.Format(time.RFC3339), actualReturnDate.Format(time.RFC3339))
 	})
 
 	t.Run("Error - Loan Record Not Found", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		body, _ := json.Marshal(map[string]string{"name_of_borrower": "Stranger", "book_title": "Clean Code"})
+		body, _ := json.Marshal(map[string]any{"borrower_id": int64(999), "book_id": cleanCode})
 		req, _ := http.NewRequest("POST", "/Extend", bytes.NewBuffer(body))
 		router.ServeHTTP(w, req)
 
@@ -197,26 +284,1745 @@ func TestReturnBook_Scenarios(t *testing.T) {
 
 	t.Run("Success - Return Book", func(t *testing.T) {
 		now := time.Now()
-		_, err := repo.BorrowBook(&models.LoanDetail{
-			NameOfBorrower: "Alice",
-			BookTitle:      "Clean Code",
-			LoanDate:       now,
-			ReturnDate:     now.AddDate(0, 0, 28),
-		})
+		_, err := repo.BorrowBook(ctx, &models.LoanDetail{
+			BorrowerID: alice,
+			BookID:     cleanCode,
+			LoanDate:   now,
+			ReturnDate: now.AddDate(0, 0, 28),
+		}, now, nil)
 		if err != nil {
 			t.Fatalf("Failed to setup test: %v", err)
 		}
-		beforeReturn, _ := repo.GetBook("Clean Code")
+		beforeReturn, _ := repo.GetBook(ctx, cleanCode)
 		initialCopies := beforeReturn.AvailableCopies // is 1
 
 		w := httptest.NewRecorder()
-		body, _ := json.Marshal(map[string]string{"name_of_borrower": "Alice", "book_title": "Clean Code"})
+		body, _ := json.Marshal(map[string]any{"borrower_id": alice, "book_id": cleanCode})
 		req, _ := http.NewRequest("POST", "/Return", bytes.NewBuffer(body))
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
 		// Verify side effect: copies should increase
-		afterReturn, _ := repo.GetBook("Clean Code")
+		afterReturn, _ := repo.GetBook(ctx, cleanCode)
 		assert.Equal(t, initialCopies+1, afterReturn.AvailableCopies)
 	})
 }
+
+// --- Catalog management Tests ---
+func TestCatalog_Scenarios(t *testing.T) {
+	router, repo := setupTestRouter()
+
+	send := func(method, path string, payload any) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	var refactoring models.BookDetail
+	t.Run("Success - Create Book", func(t *testing.T) {
+		w := send("POST", "/books", map[string]any{
+			"title":            "Refactoring",
+			"authors":          []string{" Martin Fowler ", ""},
+			"isbn":             "0-201-48567-2",
+			"year":             1999,
+			"language":         "en",
+			"available_copies": 3,
+		})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &refactoring))
+		assert.NotZero(t, refactoring.WorkID)
+		assert.Equal(t, "9780201485677", refactoring.ISBN)
+		assert.Equal(t, []string{"Martin Fowler"}, refactoring.Authors)
+		assert.Equal(t, models.FormatPrint, refactoring.Format)
+
+		book, err := repo.GetBook(ctx, refactoring.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, book.AvailableCopies)
+		assert.Equal(t, http.StatusOK, send("GET", bookPath(refactoring.ID), nil).Code)
+	})
+
+	t.Run("Error - Create Duplicate ISBN", func(t *testing.T) {
+		w := send("POST", "/books", map[string]any{"title": "Refactoring", "isbn": "9780201485677", "available_copies": 1})
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("Error - Create With Invalid Fields", func(t *testing.T) {
+		w := send("POST", "/books", map[string]any{"title": "Negative", "available_copies": -1})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = send("POST", "/books", map[string]any{"title": "Bad Checksum", "isbn": "978-0-201-48567-8"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = send("POST", "/books", map[string]any{"title": "Bad Format", "format": "vinyl"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = send("POST", "/books", map[string]any{"title": "Orphan", "work_id": 999})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Success - Editions Of The Same Work Coexist", func(t *testing.T) {
+		w := send("POST", "/books", map[string]any{
+			"work_id":          refactoring.WorkID,
+			"title":            "Refactoring",
+			"isbn":             "978-0-13-475759-9",
+			"year":             2018,
+			"format":           "epub",
+			"available_copies": 2,
+		})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var epub models.BookDetail
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &epub))
+		assert.NotEqual(t, refactoring.ID, epub.ID)
+		assert.True(t, epub.Digital)
+
+		w = send("GET", "/works/"+strconv.FormatInt(refactoring.WorkID, 10), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var work models.Work
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &work))
+		assert.Len(t, work.Editions, 2)
+
+		assert.Equal(t, http.StatusNotFound, send("GET", "/works/999", nil).Code)
+		assert.Equal(t, http.StatusBadRequest, send("GET", "/works/abc", nil).Code)
+	})
+
+	t.Run("Success - Update Book", func(t *testing.T) {
+		w := send("PUT", bookPath(refactoring.ID), map[string]any{"title": "Refactoring (1st Edition)", "isbn": "0201485672", "available_copies": 4})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		book, err := repo.GetBook(ctx, refactoring.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Refactoring (1st Edition)", book.Title)
+		assert.Equal(t, refactoring.WorkID, book.WorkID)
+		// Copies are counted from the items, not taken from the request
+		assert.Equal(t, 3, book.AvailableCopies)
+	})
+
+	t.Run("Error - Update Unknown Book", func(t *testing.T) {
+		w := send("PUT", "/books/999", map[string]any{"title": "Unknown", "available_copies": 1})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		w = send("PUT", "/books/Unknown", map[string]any{"title": "Unknown", "available_copies": 1})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Success - Adjust Copies", func(t *testing.T) {
+		w := send("PATCH", bookPath(designPatterns), map[string]any{"delta": 2})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		book, _ := repo.GetBook(ctx, designPatterns)
+		assert.Equal(t, 3, book.AvailableCopies)
+	})
+
+	t.Run("Error - Adjust Below Zero", func(t *testing.T) {
+		w := send("PATCH", bookPath(designPatterns), map[string]any{"delta": -10})
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("Error - Delete Book With Outstanding Loan", func(t *testing.T) {
+		w := send("POST", "/Borrow", map[string]any{"borrower_id": alice, "book_id": cleanCode})
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		w = send("DELETE", bookPath(cleanCode), nil)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("Success - Delete Book", func(t *testing.T) {
+		book, _ := repo.GetBook(ctx, designPatterns)
+		w := send("DELETE", bookPath(designPatterns), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		_, err := repo.GetBook(ctx, designPatterns)
+		assert.Error(t, err)
+		// The work went with its only edition
+		_, err = repo.GetWork(ctx, book.WorkID)
+		assert.Error(t, err)
+	})
+
+	t.Run("Error - Delete Unknown Book", func(t *testing.T) {
+		w := send("DELETE", bookPath(designPatterns), nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+// --- Hold queue Tests ---
+func TestHold_Scenarios(t *testing.T) {
+	router, repo := setupTestRouter()
+
+	post := func(path string, borrowerID, bookID int64) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(map[string]any{"borrower_id": borrowerID, "book_id": bookID})
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Error - Hold While Copies Available", func(t *testing.T) {
+		w := post("/Hold", bob, designPatterns)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("Success - Queue Holds When Out of Stock", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, post("/Borrow", alice, designPatterns).Code)
+		assert.Equal(t, http.StatusConflict, post("/Borrow", bob, designPatterns).Code)
+
+		assert.Equal(t, http.StatusCreated, post("/Hold", bob, designPatterns).Code)
+		assert.Equal(t, http.StatusCreated, post("/Hold", carol, designPatterns).Code)
+		assert.Equal(t, http.StatusConflict, post("/Hold", bob, designPatterns).Code)
+		assert.Equal(t, http.StatusConflict, post("/Hold", alice, designPatterns).Code)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/Hold?book_id=3", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var holds []models.HoldDetail
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &holds))
+		if assert.Len(t, holds, 2) {
+			assert.Equal(t, "Bob", holds[0].NameOfBorrower)
+			assert.Equal(t, models.HoldWaiting, holds[0].Status)
+		}
+	})
+
+	t.Run("Success - Return Allocates Copy To Head Of Queue", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, post("/Return", alice, designPatterns).Code)
+
+		book, _ := repo.GetBook(ctx, designPatterns)
+		assert.Equal(t, 0, book.AvailableCopies)
+		assert.Equal(t, models.HoldReady, repo.Holds[designPatterns][0].Status)
+		assert.NotNil(t, repo.Holds[designPatterns][0].ExpiresAt)
+
+		// The copy is reserved for Bob, so Carol cannot jump the queue
+		assert.Equal(t, http.StatusConflict, post("/Borrow", carol, designPatterns).Code)
+	})
+
+	t.Run("Success - Expired Hold Rolls To Next Borrower", func(t *testing.T) {
+		past := time.Now().Add(-time.Hour)
+		repo.Holds[designPatterns][0].ExpiresAt = &past
+
+		assert.Equal(t, http.StatusCreated, post("/Borrow", carol, designPatterns).Code)
+		assert.Equal(t, models.HoldExpired, repo.Holds[designPatterns][0].Status)
+		assert.Equal(t, models.HoldFulfilled, repo.Holds[designPatterns][1].Status)
+
+		book, _ := repo.GetBook(ctx, designPatterns)
+		assert.Equal(t, 0, book.AvailableCopies)
+	})
+
+	t.Run("Error - Hold Unknown Book", func(t *testing.T) {
+		w := post("/Hold", bob, 999)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+// --- Member registry Tests ---
+func TestMembers_Scenarios(t *testing.T) {
+	router, _ := setupTestRouter()
+
+	send := func(method, path string, payload any) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	var dave models.Borrower
+	t.Run("Success - Register Member", func(t *testing.T) {
+		w := send("POST", "/members", map[string]any{"name": " Dave ", "email": "Dave@Example.com"})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &dave))
+		assert.Equal(t, "Dave", dave.Name)
+		assert.Equal(t, "dave@example.com", dave.Email)
+		assert.Equal(t, models.MemberActive, dave.Status)
+		assert.True(t, dave.MembershipExpiresAt.After(time.Now()))
+	})
+
+	t.Run("Error - Duplicate Email", func(t *testing.T) {
+		w := send("POST", "/members", map[string]any{"name": "Other Dave", "email": "dave@example.com "})
+		assert.Equal(t, http.StatusBadRequest, w.Code) // trailing space fails email validation
+
+		w = send("POST", "/members", map[string]any{"name": "Other Dave", "email": "DAVE@example.com"})
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("Lookup", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send("GET", "/members/"+strconv.FormatInt(dave.ID, 10), nil).Code)
+		assert.Equal(t, http.StatusNotFound, send("GET", "/members/999", nil).Code)
+		assert.Equal(t, http.StatusBadRequest, send("GET", "/members/abc", nil).Code)
+	})
+
+	t.Run("Error - Unknown Member Cannot Borrow", func(t *testing.T) {
+		w := send("POST", "/Borrow", map[string]any{"borrower_id": 999, "book_id": cleanCode})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Error - Suspended Member Cannot Borrow", func(t *testing.T) {
+		path := "/members/" + strconv.FormatInt(dave.ID, 10)
+		w := send("PUT", path, map[string]any{"name": "Dave", "email": "dave@example.com", "status": "suspended"})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = send("POST", "/Borrow", map[string]any{"borrower_id": dave.ID, "book_id": cleanCode})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Error - Expired Membership Cannot Borrow", func(t *testing.T) {
+		path := "/members/" + strconv.FormatInt(dave.ID, 10)
+		expired := time.Now().AddDate(0, 0, -1)
+		w := send("PUT", path, map[string]any{"name": "Dave", "email": "dave@example.com", "status": "active", "membership_expires_at": expired})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = send("POST", "/Borrow", map[string]any{"borrower_id": dave.ID, "book_id": cleanCode})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		w := send("POST", "/Borrow", map[string]any{"borrower_id": alice, "book_id": cleanCode})
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		assert.Equal(t, http.StatusConflict, send("DELETE", "/members/"+strconv.FormatInt(alice, 10), nil).Code)
+		assert.Equal(t, http.StatusOK, send("DELETE", "/members/"+strconv.FormatInt(dave.ID, 10), nil).Code)
+		assert.Equal(t, http.StatusNotFound, send("DELETE", "/members/"+strconv.FormatInt(dave.ID, 10), nil).Code)
+	})
+}
+
+// --- Lending policy Tests ---
+func TestPolicy_Scenarios(t *testing.T) {
+	p := policy.Default()
+	p.MaxConcurrentLoans = 1
+	p.MaxRenewals = 1
+	p.TierLoanDays = map[string]int{"staff": 56}
+	p.CategoryLoanDays = map[string]int{"reference": 7}
+	router, repo := setupTestRouterWithPolicy(p)
+
+	post := func(path string, borrowerID, bookID int64) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(map[string]any{"borrower_id": borrowerID, "book_id": bookID})
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Error - Concurrent Loan Limit", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, post("/Borrow", alice, cleanCode).Code)
+		assert.Equal(t, http.StatusConflict, post("/Borrow", alice, goBook).Code)
+	})
+
+	t.Run("Error - Renewal Limit", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, post("/Extend", alice, cleanCode).Code)
+		assert.Equal(t, http.StatusUnprocessableEntity, post("/Extend", alice, cleanCode).Code)
+	})
+
+	t.Run("Error - No Renewal While Hold Waiting", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, post("/Borrow", bob, designPatterns).Code)
+		assert.Equal(t, http.StatusCreated, post("/Hold", carol, designPatterns).Code)
+		assert.Equal(t, http.StatusConflict, post("/Extend", bob, designPatterns).Code)
+	})
+
+	t.Run("Success - Loan Period By Tier And Category", func(t *testing.T) {
+		staff, _ := repo.CreateBorrower(ctx, &models.Borrower{
+			Name:                "Erin",
+			Email:               "erin@example.com",
+			Tier:                "staff",
+			Status:              models.MemberActive,
+			MembershipExpiresAt: time.Now().AddDate(1, 0, 0),
+		})
+		encyclopedia, _ := repo.CreateBook(ctx, &models.BookDetail{Title: "Encyclopedia", Format: models.FormatPrint, Category: "reference", AvailableCopies: 2})
+
+		w := post("/Borrow", staff.ID, goBook)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var loan models.LoanDetail
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &loan))
+		assert.True(t, loan.ReturnDate.Equal(loan.LoanDate.AddDate(0, 0, 56)))
+
+		assert.Equal(t, http.StatusOK, post("/Return", staff.ID, goBook).Code)
+		w = post("/Borrow", staff.ID, encyclopedia.ID)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &loan))
+		assert.True(t, loan.ReturnDate.Equal(loan.LoanDate.AddDate(0, 0, 7)))
+	})
+}
+
+func TestPolicyLimits_Scenarios(t *testing.T) {
+	post := func(router http.Handler, path string, borrowerID, bookID int64) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(map[string]any{"borrower_id": borrowerID, "book_id": bookID})
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Success - Zero Means No Limit", func(t *testing.T) {
+		p := policy.Default()
+		p.MaxConcurrentLoans = 0
+		p.MaxRenewals = 0
+		router, _ := setupTestRouterWithPolicy(p)
+
+		for _, book := range []int64{cleanCode, goBook, designPatterns} {
+			assert.Equal(t, http.StatusCreated, post(router, "/Borrow", alice, book).Code)
+		}
+		for range 5 {
+			assert.Equal(t, http.StatusOK, post(router, "/Extend", alice, cleanCode).Code)
+		}
+	})
+
+	t.Run("Error - Concurrent Renewals Past The Limit", func(t *testing.T) {
+		p := policy.Default()
+		p.MaxRenewals = 1
+		router, _ := setupTestRouterWithPolicy(p)
+		assert.Equal(t, http.StatusCreated, post(router, "/Borrow", alice, cleanCode).Code)
+
+		const attempts = 10
+		codes := make(chan int, attempts)
+		var wg sync.WaitGroup
+		for range attempts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				codes <- post(router, "/Extend", alice, cleanCode).Code
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		renewed := 0
+		for code := range codes {
+			if code == http.StatusOK {
+				renewed++
+				continue
+			}
+			assert.Equal(t, http.StatusUnprocessableEntity, code)
+		}
+		assert.Equal(t, 1, renewed)
+	})
+
+	t.Run("Error - A Loan That Keeps Changing", func(t *testing.T) {
+		repo := &busyRepo{MemoryRepo: repository.NewMemoryRepo()}
+		seedBorrowers(repo.MemoryRepo)
+		router := gin.New()
+		h := &handlers.LibraryHandler{Service: service.NewLibraryService(repo, policy.Default())}
+		registerRoutes(router, h, noRateLimit, middleware.Authenticate(nil), noRateLimit, specCheck, noIdempotency, legacySunset)
+		assert.Equal(t, http.StatusCreated, post(router, "/Borrow", alice, cleanCode).Code)
+
+		// Renewals without a version give up after a few tries
+		assert.Equal(t, http.StatusPreconditionFailed, post(router, "/Extend", alice, cleanCode).Code)
+		assert.Equal(t, int32(3), repo.extends.Load())
+	})
+}
+
+// busyRepo is a memory repository in which every loan has changed again by
+// the time it is renewed.
+type busyRepo struct {
+	*repository.MemoryRepo
+	extends atomic.Int32
+}
+
+func (r *busyRepo) ExtendLoan(ctx context.Context, borrowerID, bookID int64, newReturnDate time.Time, version int64) (*models.LoanDetail, error) {
+	r.extends.Add(1)
+	return nil, errors.ErrVersionMismatch
+}
+
+// --- Overdue loans and fines Tests ---
+func TestFines_Scenarios(t *testing.T) {
+	p := policy.Default()
+	p.FinePerDayCents = 25
+	p.MaxOutstandingFinesCents = 50
+	router, repo := setupTestRouterWithPolicy(p)
+
+	send := func(method, path string, payload any) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+	finesPath := "/members/" + strconv.FormatInt(alice, 10) + "/fines"
+
+	t.Run("Success - List Overdue Loans", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, send("POST", "/Borrow", map[string]any{"borrower_id": alice, "book_id": cleanCode}).Code)
+		assert.Equal(t, http.StatusCreated, send("POST", "/Borrow", map[string]any{"borrower_id": bob, "book_id": cleanCode}).Code)
+		// Alice is two and a half days late
+		repo.Loans[cleanCode][0].ReturnDate = time.Now().Add(-60 * time.Hour)
+
+		w := send("GET", "/loans/overdue", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var overdue []models.LoanDetail
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &overdue))
+		if assert.Len(t, overdue, 1) {
+			assert.Equal(t, alice, overdue[0].BorrowerID)
+		}
+	})
+
+	t.Run("Success - Late Return Charges Fine", func(t *testing.T) {
+		w := send("POST", "/Return", map[string]any{"borrower_id": alice, "book_id": cleanCode})
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body struct {
+			Fine models.FineEntry `json:"fine"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, int64(75), body.Fine.AmountCents)
+
+		w = send("POST", "/Return", map[string]any{"borrower_id": bob, "book_id": cleanCode})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "fine")
+	})
+
+	t.Run("Error - Outstanding Fines Block Borrowing", func(t *testing.T) {
+		w := send("POST", "/Borrow", map[string]any{"borrower_id": alice, "book_id": designPatterns})
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("Payments And Waivers", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnprocessableEntity, send("POST", finesPath+"/payments", map[string]any{"amount_cents": 100}).Code)
+		assert.Equal(t, http.StatusBadRequest, send("POST", finesPath+"/payments", map[string]any{"amount_cents": -5}).Code)
+		assert.Equal(t, http.StatusCreated, send("POST", finesPath+"/payments", map[string]any{"amount_cents": 50}).Code)
+		assert.Equal(t, http.StatusCreated, send("POST", finesPath+"/waivers", map[string]any{"amount_cents": 25, "note": "first offence"}).Code)
+
+		w := send("GET", finesPath, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var account models.FineAccount
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &account))
+		assert.Equal(t, int64(0), account.BalanceCents)
+		assert.Len(t, account.Entries, 3)
+
+		w = send("POST", "/Borrow", map[string]any{"borrower_id": alice, "book_id": designPatterns})
+		assert.Equal(t, http.StatusCreated, w.Code)
+	})
+
+	t.Run("Error - Unknown Member Fines", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, send("GET", "/members/999/fines", nil).Code)
+	})
+}
+
+// --- E-book expiry Tests ---
+func TestEbookExpiry_Scenarios(t *testing.T) {
+	router, repo := setupTestRouter()
+	book, _ := repo.CreateBook(ctx, &models.BookDetail{Title: "Go in Action", Format: models.FormatEPUB, Digital: true, AvailableCopies: 1})
+	ebook := book.ID
+
+	post := func(path string, borrowerID, bookID int64) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(map[string]any{"borrower_id": borrowerID, "book_id": bookID})
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Success - Expired E-book Loan Ends Automatically", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, post("/Borrow", alice, ebook).Code)
+		assert.Equal(t, http.StatusCreated, post("/Hold", bob, ebook).Code)
+		due := time.Now().Add(-time.Hour)
+		repo.Loans[ebook][0].ReturnDate = due
+
+		// Access is revoked: the loan can neither be extended nor returned
+		assert.Equal(t, http.StatusNotFound, post("/Extend", alice, ebook).Code)
+		assert.Equal(t, http.StatusNotFound, post("/Return", alice, ebook).Code)
+
+		// It reads as ended although only the expire-ebook-loans job closes it
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/loans/"+strconv.FormatInt(repo.Loans[ebook][0].ID, 10), nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"returned_reason":"expired"`)
+		assert.Empty(t, repo.History, "reads change nothing")
+
+		_, err := repo.ExpireDigitalLoans(ctx, time.Now(), time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		if assert.Len(t, repo.History, 1) {
+			assert.Equal(t, models.ReturnReasonExpired, repo.History[0].ReturnedReason)
+			assert.True(t, repo.History[0].ReturnedAt.Equal(due))
+		}
+		// The released copy went to the head of the hold queue
+		assert.Equal(t, models.HoldReady, repo.Holds[ebook][0].Status)
+
+		fines, _ := repo.FineBalance(ctx, alice)
+		assert.Equal(t, int64(0), fines)
+	})
+
+	t.Run("Success - Expired E-book Can Be Borrowed Before The Job Runs", func(t *testing.T) {
+		book, _ := repo.CreateBook(ctx, &models.BookDetail{Title: "Go in Practice", Format: models.FormatEPUB, Digital: true, AvailableCopies: 1})
+		assert.Equal(t, http.StatusCreated, post("/Borrow", alice, book.ID).Code)
+		repo.Loans[book.ID][0].ReturnDate = time.Now().Add(-time.Hour)
+
+		// Neither a duplicate nor short of copies: the borrow closes the old loan
+		assert.Equal(t, http.StatusCreated, post("/v1/loans", alice, book.ID).Code)
+		last := repo.History[len(repo.History)-1]
+		assert.Equal(t, book.ID, last.BookID)
+		assert.Equal(t, models.ReturnReasonExpired, last.ReturnedReason)
+	})
+
+	t.Run("Success - Printed Books Are Not Auto-Returned", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, post("/Borrow", carol, cleanCode).Code)
+		repo.Loans[cleanCode][0].ReturnDate = time.Now().Add(-time.Hour)
+
+		assert.Equal(t, http.StatusOK, post("/Return", carol, cleanCode).Code)
+		last := repo.History[len(repo.History)-1]
+		assert.Equal(t, models.ReturnReasonReturned, last.ReturnedReason)
+	})
+}
+
+func TestLoanHistory_Scenarios(t *testing.T) {
+	router, repo := setupTestRouter()
+
+	post := func(path string, borrowerID, bookID int64) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(map[string]any{"borrower_id": borrowerID, "book_id": bookID})
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+	get := func(path string) (*httptest.ResponseRecorder, models.LoanPage) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		var page models.LoanPage
+		_ = json.Unmarshal(w.Body.Bytes(), &page)
+		return w, page
+	}
+	aliceLoans := "/members/" + strconv.FormatInt(alice, 10) + "/loans"
+
+	t.Run("Success - Returned Loans Stay In History", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, post("/Borrow", alice, cleanCode).Code)
+		assert.Equal(t, http.StatusOK, post("/Return", alice, cleanCode).Code)
+		// Borrowing the same book again is a new loan
+		assert.Equal(t, http.StatusCreated, post("/Borrow", alice, cleanCode).Code)
+
+		w, page := get(aliceLoans)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 2, page.Total)
+		if assert.Len(t, page.Items, 2) {
+			assert.Equal(t, models.LoanActive, page.Items[0].Status)
+			assert.Nil(t, page.Items[0].ReturnedAt)
+			assert.Equal(t, models.LoanReturned, page.Items[1].Status)
+			assert.NotNil(t, page.Items[1].ReturnedAt)
+			assert.NotEqual(t, page.Items[0].ID, page.Items[1].ID)
+		}
+	})
+
+	t.Run("Success - Book History Is Paginated", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, post("/Borrow", bob, cleanCode).Code)
+
+		_, page := get(bookPath(cleanCode) + "/loans?limit=2&offset=1")
+		assert.Equal(t, 3, page.Total)
+		assert.Equal(t, 2, page.Limit)
+		if assert.Len(t, page.Items, 2) {
+			assert.Equal(t, alice, page.Items[0].BorrowerID)
+			assert.Equal(t, models.LoanReturned, page.Items[1].Status)
+		}
+
+		_, page = get(bookPath(cleanCode) + "/loans?offset=10")
+		assert.Equal(t, models.DefaultPageLimit, page.Limit)
+		assert.Empty(t, page.Items)
+	})
+
+	t.Run("Fail - Invalid Page Or Unknown Owner", func(t *testing.T) {
+		w, _ := get(aliceLoans + "?limit=0&offset=-1")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w, _ = get("/members/999/loans")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		w, _ = get("/books/999/loans")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Success - Lost Copy Is Not Released", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, post("/Borrow", carol, designPatterns).Code)
+		loanID := repo.Loans[designPatterns][0].ID
+
+		path := "/loans/" + strconv.FormatInt(loanID, 10) + "/lost"
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		book, _ := repo.GetBook(ctx, designPatterns)
+		assert.Equal(t, 0, book.AvailableCopies)
+		assert.Equal(t, models.ItemLost, repo.Items[designPatterns][0].Status)
+
+		_, page := get(bookPath(designPatterns) + "/loans")
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, models.LoanLost, page.Items[0].Status)
+		}
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", path, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestListBooks_Scenarios(t *testing.T) {
+	router, repo := setupTestRouter()
+	for _, b := range []models.BookDetail{
+		{Title: "Clean Architecture", Format: models.FormatPrint, AvailableCopies: 3},
+		{Title: "Refactoring", Format: models.FormatPrint, AvailableCopies: 0},
+		{Title: "The Clean Coder", Format: models.FormatPrint, AvailableCopies: 0},
+	} {
+		_, _ = repo.CreateBook(ctx, &b)
+	}
+
+	list := func(query string) (*httptest.ResponseRecorder, models.BookPage) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/books?"+query, nil)
+		router.ServeHTTP(w, req)
+		var page models.BookPage
+		_ = json.Unmarshal(w.Body.Bytes(), &page)
+		return w, page
+	}
+	titles := func(page models.BookPage) []string {
+		result := []string{}
+		for _, b := range page.Items {
+			result = append(result, b.Title)
+		}
+		return result
+	}
+
+	t.Run("Success - Cursor Walks The Whole Catalog", func(t *testing.T) {
+		w, page := list("limit=4")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"Clean Architecture", "Clean Code", "Design Patterns", "Refactoring"}, titles(page))
+		assert.NotEmpty(t, page.NextCursor)
+
+		// A book added before the cursor does not shift the next page
+		_, _ = repo.CreateBook(ctx, &models.BookDetail{Title: "Algorithms", Format: models.FormatPrint, AvailableCopies: 1})
+		_, page = list("limit=4&cursor=" + page.NextCursor)
+		assert.Equal(t, []string{"The Clean Coder", "The Go Programming Language"}, titles(page))
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("Success - Partial Case-Insensitive Search", func(t *testing.T) {
+		_, page := list("q=CLEAN")
+		assert.Equal(t, []string{"Clean Architecture", "Clean Code", "The Clean Coder"}, titles(page))
+		_, page = list("q=clean&available=false")
+		assert.Equal(t, []string{"The Clean Coder"}, titles(page))
+	})
+
+	t.Run("Success - Sort By Availability", func(t *testing.T) {
+		_, first := list("sort=-available_copies&available=true&limit=2")
+		assert.Equal(t, []string{"The Go Programming Language", "Clean Architecture"}, titles(first))
+		_, next := list("sort=-available_copies&available=true&limit=2&cursor=" + first.NextCursor)
+		assert.Equal(t, []string{"Clean Code", "Design Patterns"}, titles(next))
+
+		_, page := list("sort=-title&limit=1")
+		assert.Equal(t, []string{"The Go Programming Language"}, titles(page))
+	})
+
+	t.Run("Fail - Invalid Parameters", func(t *testing.T) {
+		w, _ := list("sort=author")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w, _ = list("limit=500")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w, _ = list("cursor=not-a-cursor")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// A cursor only makes sense for the sort order it came from
+		_, page := list("limit=1")
+		w, _ = list("sort=available_copies&cursor=" + page.NextCursor)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestSearch_Scenarios(t *testing.T) {
+	router, _ := setupTestRouter()
+
+	search := func(query string) (*httptest.ResponseRecorder, []string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/search?"+query, nil)
+		router.ServeHTTP(w, req)
+		var results []models.SearchResult
+		_ = json.Unmarshal(w.Body.Bytes(), &results)
+		titles := []string{}
+		for _, r := range results {
+			titles = append(titles, r.Title)
+		}
+		return w, titles
+	}
+	create := func(book map[string]any) models.BookDetail {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(book)
+		req, _ := http.NewRequest("POST", "/books", bytes.NewBuffer(body))
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var created models.BookDetail
+		_ = json.Unmarshal(w.Body.Bytes(), &created)
+		return created
+	}
+
+	refactoring := create(map[string]any{
+		"title":            "Refactoring",
+		"authors":          []string{"Martin Fowler"},
+		"description":      "Improving the design of existing code through small behaviour-preserving changes.",
+		"available_copies": 1,
+	})
+
+	t.Run("Success - Title Matches Rank Above Descriptions", func(t *testing.T) {
+		w, titles := search("q=design")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"Design Patterns", "Refactoring"}, titles)
+	})
+
+	t.Run("Success - Authors, Stems And Prefixes", func(t *testing.T) {
+		_, titles := search("q=kernighan")
+		assert.Equal(t, []string{"The Go Programming Language"}, titles)
+		// A title match outranks the subject heading of Design Patterns
+		_, titles = search("q=programs")
+		assert.Equal(t, []string{"The Go Programming Language", "Design Patterns"}, titles)
+		_, titles = search("q=refact")
+		assert.Equal(t, []string{"Refactoring"}, titles)
+		// Every word has to match
+		_, titles = search("q=martin+fowler")
+		assert.Equal(t, []string{"Refactoring"}, titles)
+	})
+
+	t.Run("Success - Tolerates Typos", func(t *testing.T) {
+		_, titles := search("q=paterns")
+		assert.Equal(t, []string{"Design Patterns"}, titles)
+		_, titles = search("q=xyzzy")
+		assert.Empty(t, titles)
+	})
+
+	t.Run("Success - Index Follows Catalog Writes", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(map[string]any{"title": "Refactoring (2nd Edition)", "authors": []string{"Martin Fowler"}, "available_copies": 1})
+		req, _ := http.NewRequest("PUT", bookPath(refactoring.ID), bytes.NewBuffer(body))
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		_, titles := search("q=fowler")
+		assert.Equal(t, []string{"Refactoring (2nd Edition)"}, titles)
+		_, titles = search("q=behaviour")
+		assert.Empty(t, titles)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("DELETE", bookPath(refactoring.ID), nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		_, titles = search("q=fowler")
+		assert.Empty(t, titles)
+	})
+
+	t.Run("Fail - Missing Query", func(t *testing.T) {
+		w, _ := search("")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestItems_Scenarios(t *testing.T) {
+	router, repo := setupTestRouter()
+	book, _ := repo.CreateBook(ctx, &models.BookDetail{Title: "Refactoring", Format: models.FormatPrint})
+	itemsPath := bookPath(book.ID) + "/items"
+
+	send := func(method, path string, payload any) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+	itemPath := func(id int64) string {
+		return "/items/" + strconv.FormatInt(id, 10)
+	}
+
+	var first, second models.Item
+	t.Run("Success - Add Items", func(t *testing.T) {
+		w := send("POST", itemsPath, map[string]any{"barcode": " 31234000001 ", "condition": "new"})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+		assert.Equal(t, "31234000001", first.Barcode)
+		assert.Equal(t, models.ItemAvailable, first.Status)
+
+		w = send("POST", itemsPath, map[string]any{"barcode": "31234000002"})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+		assert.Equal(t, models.ConditionGood, second.Condition)
+
+		b, _ := repo.GetBook(ctx, book.ID)
+		assert.Equal(t, 2, b.AvailableCopies)
+	})
+
+	t.Run("Error - Invalid Items", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, send("POST", itemsPath, map[string]any{"barcode": "31234000001"}).Code)
+		assert.Equal(t, http.StatusBadRequest, send("POST", itemsPath, map[string]any{"condition": "soggy"}).Code)
+		assert.Equal(t, http.StatusBadRequest, send("POST", itemsPath, map[string]any{"status": "on_loan"}).Code)
+		assert.Equal(t, http.StatusNotFound, send("POST", "/books/999/items", map[string]any{}).Code)
+		assert.Equal(t, http.StatusNotFound, send("GET", "/items/999", nil).Code)
+		assert.Equal(t, http.StatusBadRequest, send("GET", "/items/abc", nil).Code)
+	})
+
+	t.Run("Success - Loan Records The Item", func(t *testing.T) {
+		// A damaged copy is taken out of circulation
+		w := send("PUT", itemPath(first.ID), map[string]any{"barcode": first.Barcode, "condition": "damaged", "status": "withdrawn"})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = send("POST", "/Borrow", map[string]any{"borrower_id": alice, "book_id": book.ID})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var loan models.LoanDetail
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &loan))
+		assert.Equal(t, second.ID, loan.ItemID)
+		assert.Equal(t, "31234000002", loan.Barcode)
+
+		// The item on loan cannot be withdrawn, but its condition can be noted
+		w = send("PUT", itemPath(second.ID), map[string]any{"barcode": second.Barcode, "status": "withdrawn"})
+		assert.Equal(t, http.StatusConflict, w.Code)
+		w = send("PUT", itemPath(second.ID), map[string]any{"barcode": second.Barcode, "condition": "fair"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"status":"on_loan"`)
+	})
+
+	t.Run("Success - Returned Item Is Set Aside For Hold", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, send("POST", "/Hold", map[string]any{"borrower_id": bob, "book_id": book.ID}).Code)
+		assert.Equal(t, http.StatusOK, send("POST", "/Return", map[string]any{"borrower_id": alice, "book_id": book.ID}).Code)
+
+		assert.Equal(t, second.ID, repo.Holds[book.ID][0].ItemID)
+		w := send("GET", itemPath(second.ID), nil)
+		assert.Contains(t, w.Body.String(), `"status":"reserved"`)
+
+		// Repairing the other copy puts it back on the shelf for anyone
+		w = send("PUT", itemPath(first.ID), map[string]any{"barcode": first.Barcode, "condition": "good", "status": "available"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = send("POST", "/Borrow", map[string]any{"borrower_id": carol, "book_id": book.ID})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"item_id":`+strconv.FormatInt(first.ID, 10))
+
+		w = send("POST", "/Borrow", map[string]any{"borrower_id": bob, "book_id": book.ID})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"item_id":`+strconv.FormatInt(second.ID, 10))
+	})
+
+	t.Run("Success - List Items", func(t *testing.T) {
+		w := send("GET", itemsPath, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var items []models.Item
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+		if assert.Len(t, items, 2) {
+			assert.Equal(t, models.ItemOnLoan, items[0].Status)
+			assert.Equal(t, models.ItemOnLoan, items[1].Status)
+		}
+		b, _ := repo.GetBook(ctx, book.ID)
+		assert.Equal(t, 0, b.AvailableCopies)
+	})
+}
+
+// --- Request deadline Tests ---
+
+// slowRepo is a memory repository whose book lookups hang until the request
+// context ends, as a stuck database query would.
+type slowRepo struct {
+	*repository.MemoryRepo
+}
+
+func (r slowRepo) GetBook(ctx context.Context, id int64) (*models.BookDetail, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestQueryTimeout_Scenarios(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.QueryTimeout(20 * time.Millisecond))
+	svc := service.NewLibraryService(slowRepo{repository.NewMemoryRepo()}, policy.Default())
+	registerRoutes(r, &handlers.LibraryHandler{Service: svc}, noRateLimit, middleware.Authenticate(nil), noRateLimit, specCheck, noIdempotency, legacySunset)
+
+	t.Run("Deadline Passes", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", bookPath(goBook), nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	})
+
+	t.Run("Client Goes Away", func(t *testing.T) {
+		gone, cancel := context.WithCancel(context.Background())
+		cancel()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequestWithContext(gone, "GET", bookPath(goBook), nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, 499, w.Code)
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("Other Calls Are Unaffected", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/books?limit=1", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+// --- Authentication Tests ---
+
+func TestAuth_Scenarios(t *testing.T) {
+	const secret = "test-secret"
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	repo := repository.NewMemoryRepo()
+	seedBorrowers(repo)
+	svc := service.NewLibraryService(repo, policy.Default())
+	authenticator, _ := auth.New(map[string]string{"kiosk": "k-123"}, nil, secret)
+	registerRoutes(r, &handlers.LibraryHandler{Service: svc}, noRateLimit, middleware.Authenticate(authenticator), noRateLimit, specCheck, noIdempotency, legacySunset)
+
+	aliceToken, _ := auth.IssueToken(secret, alice, auth.RolePatron, time.Hour)
+	send := func(method, path string, body any, header, value string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Catalog Reads Are Public", func(t *testing.T) {
+		w := send("GET", bookPath(goBook), nil, "", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Missing Credentials", func(t *testing.T) {
+		w := send("POST", "/Borrow", map[string]any{"borrower_id": alice, "book_id": goBook}, "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("Invalid Credentials", func(t *testing.T) {
+		w := send("GET", "/members/1", nil, "X-API-Key", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		w = send("GET", "/members/1", nil, "Authorization", "Bearer "+aliceToken+"x")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("API Key Names The Borrower", func(t *testing.T) {
+		w := send("POST", "/Borrow", map[string]any{"book_id": goBook}, "X-API-Key", "k-123")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = send("POST", "/Borrow", map[string]any{"borrower_id": bob, "book_id": goBook}, "X-API-Key", "k-123")
+		assert.Equal(t, http.StatusCreated, w.Code)
+	})
+
+	t.Run("Member Borrows As Themselves", func(t *testing.T) {
+		w := send("POST", "/Borrow", map[string]any{"book_id": cleanCode}, "Authorization", "Bearer "+aliceToken)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var loan models.LoanDetail
+		_ = json.Unmarshal(w.Body.Bytes(), &loan)
+		assert.Equal(t, alice, loan.BorrowerID)
+
+		w = send("POST", "/Return", map[string]any{"book_id": cleanCode}, "Authorization", "Bearer "+aliceToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Member Cannot Act For Others", func(t *testing.T) {
+		w := send("POST", "/Return", map[string]any{"borrower_id": bob, "book_id": goBook}, "Authorization", "Bearer "+aliceToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+// --- Access Control Tests ---
+
+func TestRBAC_Scenarios(t *testing.T) {
+	const secret = "test-secret"
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	repo := repository.NewMemoryRepo()
+	seedBorrowers(repo)
+	svc := service.NewLibraryService(repo, policy.Default())
+	cfg := &config.Config{JWTSecret: secret, APIKeys: map[string]string{"kiosk": "k-123"}}
+	authenticator, _ := auth.New(cfg.APIKeys, nil, secret)
+	registerRoutes(r, &handlers.LibraryHandler{Service: svc, Config: cfg}, noRateLimit, middleware.Authenticate(authenticator), noRateLimit, specCheck, noIdempotency, legacySunset)
+
+	token := func(id int64, role auth.Role) string {
+		signed, _ := auth.IssueToken(secret, id, role, time.Hour)
+		return "Bearer " + signed
+	}
+	patron, librarian, admin := token(alice, auth.RolePatron), token(bob, auth.RoleLibrarian), token(carol, auth.RoleAdmin)
+	send := func(method, path string, body any, credentials string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+		req.Header.Set("Authorization", credentials)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	memberPath := func(id int64) string {
+		return "/members/" + strconv.FormatInt(id, 10)
+	}
+
+	t.Run("Patron Sees Only Their Own Account", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send("GET", memberPath(alice), nil, patron).Code)
+		assert.Equal(t, http.StatusOK, send("GET", memberPath(alice)+"/loans", nil, patron).Code)
+		assert.Equal(t, http.StatusForbidden, send("GET", memberPath(bob), nil, patron).Code)
+		assert.Equal(t, http.StatusForbidden, send("GET", memberPath(bob)+"/fines", nil, patron).Code)
+	})
+
+	t.Run("Patron Cannot Edit The Catalog", func(t *testing.T) {
+		w := send("PATCH", bookPath(goBook), map[string]any{"delta": 1}, patron)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, http.StatusForbidden, send("GET", "/loans/overdue", nil, patron).Code)
+	})
+
+	t.Run("Librarian Handles Any Loan", func(t *testing.T) {
+		w := send("POST", "/Borrow", map[string]any{"borrower_id": alice, "book_id": goBook}, librarian)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		w = send("POST", "/Return", map[string]any{"borrower_id": alice, "book_id": goBook}, librarian)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, http.StatusOK, send("GET", memberPath(alice), nil, librarian).Code)
+	})
+
+	t.Run("Librarian Cannot Manage Members Or Configuration", func(t *testing.T) {
+		w := send("DELETE", memberPath(alice), nil, librarian)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, http.StatusForbidden, send("GET", "/admin/config", nil, librarian).Code)
+	})
+
+	t.Run("Admin Reads Configuration With Secrets Masked", func(t *testing.T) {
+		w := send("GET", "/admin/config", nil, admin)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var settings map[string]any
+		_ = json.Unmarshal(w.Body.Bytes(), &settings)
+		assert.Equal(t, "********", settings["JWT_SECRET"])
+		assert.Equal(t, map[string]any{"kiosk": "********"}, settings["API_KEYS"])
+		assert.NotContains(t, w.Body.String(), secret)
+	})
+}
+
+// --- Rate Limiting Tests ---
+
+func TestRateLimit_Scenarios(t *testing.T) {
+	const secret = "test-secret"
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	require.NoError(t, r.SetTrustedProxies(nil))
+	repo := repository.NewMemoryRepo()
+	seedBorrowers(repo)
+	svc := service.NewLibraryService(repo, policy.Default())
+	authenticator, _ := auth.New(nil, nil, secret)
+	limits, _ := ratelimit.ParseLimits("", map[string]string{"/books/:id": "2/m", "POST /Borrow": "1/m"})
+	store := ratelimit.NewMemoryStore()
+	clients := middleware.RateLimitClients(store, ratelimit.Limit{Requests: 3, Period: time.Minute})
+	registerRoutes(r, &handlers.LibraryHandler{Service: svc}, clients, middleware.Authenticate(authenticator),
+		middleware.RateLimit(store, limits), specCheck, noIdempotency, legacySunset)
+
+	send := func(method, path, remoteAddr string, header http.Header, body any) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+		req.RemoteAddr = remoteAddr
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Anonymous Callers Are Limited By IP", func(t *testing.T) {
+		for i := 1; i >= 0; i-- {
+			w := send("GET", bookPath(goBook), "192.0.2.1:1234", nil, nil)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+			assert.Equal(t, strconv.Itoa(i), w.Header().Get("RateLimit-Remaining"))
+			assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+		}
+
+		w := send("GET", bookPath(goBook), "192.0.2.1:1234", nil, nil)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+		// A forged X-Forwarded-For does not buy a fresh bucket
+		w = send("GET", bookPath(goBook), "192.0.2.1:1234", http.Header{"X-Forwarded-For": {"203.0.113.9"}}, nil)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		w = send("GET", bookPath(goBook), "192.0.2.2:1234", nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Routes Have Their Own Limits", func(t *testing.T) {
+		w := send("GET", "/books", "192.0.2.1:1234", nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+	})
+
+	t.Run("Members Are Limited By Account", func(t *testing.T) {
+		token := func(id int64) http.Header {
+			signed, _ := auth.IssueToken(secret, id, auth.RolePatron, time.Hour)
+			return http.Header{"Authorization": {"Bearer " + signed}}
+		}
+
+		w := send("POST", "/Borrow", "192.0.2.3:1234", token(alice), map[string]any{"book_id": goBook})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		w = send("POST", "/Borrow", "192.0.2.3:1234", token(alice), map[string]any{"book_id": cleanCode})
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		// Bob shares Alice's network but not her bucket
+		w = send("POST", "/Borrow", "192.0.2.3:1234", token(bob), map[string]any{"book_id": cleanCode})
+		assert.Equal(t, http.StatusCreated, w.Code)
+	})
+
+	t.Run("Credential Guessing Is Limited By IP", func(t *testing.T) {
+		guess := http.Header{"Authorization": {"Bearer not-a-token"}}
+		for range 3 {
+			assert.Equal(t, http.StatusUnauthorized, send("GET", "/v1/books/1/holds", "192.0.2.4:1234", guess, nil).Code)
+		}
+		w := send("GET", "/v1/books/1/holds", "192.0.2.4:1234", guess, nil)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "20", w.Header().Get("Retry-After"))
+
+		// The client's bucket is counted before the credentials are checked
+		assert.Equal(t, http.StatusUnauthorized, send("GET", "/v1/books/1/holds", "192.0.2.5:1234", guess, nil).Code)
+	})
+}
+
+// --- Versioned API Tests ---
+
+func TestV1_Scenarios(t *testing.T) {
+	const secret = "test-secret"
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	repo := repository.NewMemoryRepo()
+	seedBorrowers(repo)
+	svc := service.NewLibraryService(repo, policy.Default())
+	authenticator, _ := auth.New(nil, nil, secret)
+	registerRoutes(r, &handlers.LibraryHandler{Service: svc}, noRateLimit, middleware.Authenticate(authenticator), noRateLimit, specCheck, noIdempotency, legacySunset)
+
+	token := func(id int64, role auth.Role) string {
+		signed, _ := auth.IssueToken(secret, id, role, time.Hour)
+		return "Bearer " + signed
+	}
+	patron, librarian := token(alice, auth.RolePatron), token(bob, auth.RoleLibrarian)
+	send := func(method, path string, body any, credentials string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+		req.Header.Set("Authorization", credentials)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	// change sends an update that applies to whatever version is current
+	change := func(method, path, credentials string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", credentials)
+		req.Header.Set("If-Match", "*")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var loan models.LoanDetail
+	loanPath := func() string {
+		return "/v1/loans/" + strconv.FormatInt(loan.ID, 10)
+	}
+
+	t.Run("Borrow, Renew And Return A Loan", func(t *testing.T) {
+		w := send("POST", "/v1/loans", map[string]any{"book_id": goBook}, patron)
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &loan))
+		assert.Equal(t, alice, loan.BorrowerID)
+		assert.Empty(t, w.Header().Get("Deprecation"))
+
+		w = send("GET", loanPath(), nil, patron)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = change("POST", loanPath()+"/renewals", patron)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var renewed models.LoanDetail
+		_ = json.Unmarshal(w.Body.Bytes(), &renewed)
+		assert.Equal(t, 1, renewed.Renewals)
+		assert.True(t, renewed.ReturnDate.After(loan.ReturnDate))
+
+		w = change("DELETE", loanPath(), patron)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Ended Loan", func(t *testing.T) {
+		w := send("GET", loanPath(), nil, patron)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), models.LoanReturned)
+
+		assert.Equal(t, http.StatusConflict, change("POST", loanPath()+"/renewals", patron).Code)
+		assert.Equal(t, http.StatusConflict, change("DELETE", loanPath(), patron).Code)
+		assert.Equal(t, http.StatusNotFound, send("GET", "/v1/loans/999", nil, patron).Code)
+	})
+
+	t.Run("Overdue Is Not A Loan ID", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send("GET", "/v1/loans/overdue", nil, librarian).Code)
+	})
+
+	t.Run("Patron Cannot Touch Another Member's Loan", func(t *testing.T) {
+		w := send("POST", "/v1/loans", map[string]any{"borrower_id": carol, "book_id": cleanCode}, librarian)
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &loan))
+
+		assert.Equal(t, http.StatusForbidden, send("GET", loanPath(), nil, patron).Code)
+		assert.Equal(t, http.StatusForbidden, change("DELETE", loanPath(), patron).Code)
+		assert.Equal(t, http.StatusOK, change("DELETE", loanPath(), librarian).Code)
+	})
+
+	t.Run("Holds", func(t *testing.T) {
+		// The only copy goes out, so Carol has to wait for it
+		w := send("POST", "/v1/loans", map[string]any{"book_id": designPatterns}, patron)
+		require.Equal(t, http.StatusCreated, w.Code)
+		w = send("POST", "/v1/holds", map[string]any{"borrower_id": carol, "book_id": designPatterns}, librarian)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		w = send("GET", "/v1/books/"+strconv.FormatInt(designPatterns, 10)+"/holds", nil, librarian)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "Carol")
+	})
+
+	t.Run("Legacy Routes Are Deprecated", func(t *testing.T) {
+		w := send("GET", bookPath(goBook), nil, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "@1792195200", w.Header().Get("Deprecation"))
+		assert.Equal(t, "Fri, 30 Apr 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+		assert.Equal(t, `</v1/books/1>; rel="successor-version"`, w.Header().Get("Link"))
+
+		w = send("POST", "/Borrow", map[string]any{"book_id": goBook}, patron)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, `</v1/loans>; rel="successor-version"`, w.Header().Get("Link"))
+
+		// Even refusals tell the caller where to go
+		w = send("POST", "/Return", map[string]any{"book_id": goBook}, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Sunset"))
+	})
+
+	t.Run("Versioned And Health Routes Are Not Deprecated", func(t *testing.T) {
+		for _, path := range []string{"/v1" + bookPath(goBook), "/health"} {
+			w := send("GET", path, nil, "")
+			assert.Equal(t, http.StatusOK, w.Code, path)
+			assert.Empty(t, w.Header().Get("Deprecation"), path)
+		}
+	})
+}
+
+// --- Error Response Tests ---
+
+func TestProblem_Scenarios(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.Correlate())
+	repo := repository.NewMemoryRepo()
+	seedBorrowers(repo)
+	svc := service.NewLibraryService(repo, policy.Default())
+	registerRoutes(r, &handlers.LibraryHandler{Service: svc}, noRateLimit, middleware.Authenticate(nil), noRateLimit, specCheck, noIdempotency, legacySunset)
+
+	send := func(method, path string, body string, header http.Header) (*httptest.ResponseRecorder, problem.Problem) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		maps.Copy(req.Header, header)
+		r.ServeHTTP(w, req)
+		var p problem.Problem
+		if w.Header().Get("Content-Type") == problem.ContentType {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+		}
+		return w, p
+	}
+
+	var loan models.LoanDetail
+	t.Run("No Copies Says When One Is Due Back", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/loans", bytes.NewBufferString(fmt.Sprintf(`{"borrower_id": %d, "book_id": %d}`, alice, designPatterns)))
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &loan))
+
+		w, p := send("POST", "/v1/loans", fmt.Sprintf(`{"borrower_id": %d, "book_id": %d}`, bob, designPatterns), nil)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+		assert.Equal(t, "no_copies", p.Code)
+		assert.Equal(t, "urn:e-library:problem:no_copies", p.Type)
+		assert.Equal(t, http.StatusConflict, p.Status)
+		assert.Equal(t, "/v1/loans", p.Instance)
+		var extensions struct {
+			AvailableAt time.Time `json:"available_at"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &extensions))
+		assert.WithinDuration(t, loan.ReturnDate, extensions.AvailableAt, time.Second)
+	})
+
+	t.Run("Invalid Fields Are Listed", func(t *testing.T) {
+		w, p := send("POST", "/v1/loans", `{"book_id": "two"}`, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "invalid_request", p.Code)
+		require.Len(t, p.Errors, 1)
+		assert.Equal(t, "body.book_id", p.Errors[0].Field)
+
+		// Rules only the handler knows are reported the same way
+		w, p = send("POST", "/v1/books", `{"title": "Dune", "language": "not a language"}`, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, []errors.FieldError{{Field: "body.language", Message: "must be a BCP 47 language tag"}}, p.Errors)
+
+		w, p = send("GET", "/v1/loans/abc", "", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "path.id", p.Errors[0].Field)
+	})
+
+	t.Run("Lending Rules Name Their Limit", func(t *testing.T) {
+		renewals := "/v1/loans/" + strconv.FormatInt(loan.ID, 10) + "/renewals"
+		anyVersion := http.Header{"If-Match": {"*"}}
+		for i := 0; i < 2; i++ {
+			w, _ := send("POST", renewals, "", anyVersion)
+			require.Equal(t, http.StatusOK, w.Code)
+		}
+		w, _ := send("POST", renewals, "", anyVersion)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"renewal_limit_reached"`)
+		assert.Contains(t, w.Body.String(), `"limit":2`)
+	})
+
+	t.Run("Unknown Routes", func(t *testing.T) {
+		w, p := send("GET", "/v2/books", "", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "route_not_found", p.Code)
+	})
+
+	t.Run("Correlation ID", func(t *testing.T) {
+		w, p := send("GET", "/v1/books/999", "", http.Header{"X-Correlation-Id": {"client-42"}})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "client-42", w.Header().Get("X-Correlation-ID"))
+		assert.Equal(t, "client-42", p.CorrelationID)
+
+		w, p = send("GET", "/v1/books/999", "", http.Header{"X-Correlation-Id": {"not valid!"}})
+		assert.Len(t, w.Header().Get("X-Correlation-ID"), 32)
+		assert.Equal(t, w.Header().Get("X-Correlation-ID"), p.CorrelationID)
+
+		w, _ = send("GET", "/v1/books/1", "", nil)
+		assert.NotEmpty(t, w.Header().Get("X-Correlation-ID"), "successful responses carry it too")
+	})
+
+	t.Run("Codes Are Documented", func(t *testing.T) {
+		var doc struct {
+			Components struct {
+				Schemas map[string]struct {
+					Properties map[string]struct {
+						Enum []string `json:"enum"`
+					} `json:"properties"`
+				} `json:"schemas"`
+			} `json:"components"`
+		}
+		require.NoError(t, json.Unmarshal(openapi.Document(), &doc))
+		assert.ElementsMatch(t, problem.Codes(), doc.Components.Schemas["Problem"].Properties["code"].Enum)
+	})
+}
+
+// --- Idempotency Tests ---
+
+func TestIdempotency_Scenarios(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	repo := repository.NewMemoryRepo()
+	seedBorrowers(repo)
+	svc := service.NewLibraryService(repo, policy.Default())
+	registerRoutes(r, &handlers.LibraryHandler{Service: svc}, noRateLimit, middleware.Authenticate(nil), noRateLimit, specCheck,
+		middleware.Idempotency(idempotency.NewMemoryStore(), time.Hour), legacySunset)
+
+	send := func(path, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	borrow := fmt.Sprintf(`{"borrower_id": %d, "book_id": %d}`, alice, cleanCode)
+
+	t.Run("Retried Borrow Gets The First Response", func(t *testing.T) {
+		first := send("/Borrow", "borrow-1", borrow)
+		require.Equal(t, http.StatusCreated, first.Code)
+		assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+		// The same body, encoded differently, is the same request
+		retry := send("/Borrow", "borrow-1", fmt.Sprintf(`{"book_id":%d,"borrower_id":%d}`, cleanCode, alice))
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+		assert.Len(t, repo.Loans[cleanCode], 1)
+
+		// Without a key the retry is a second borrow
+		assert.Equal(t, http.StatusConflict, send("/Borrow", "", borrow).Code)
+	})
+
+	t.Run("Retried Extend Extends Once", func(t *testing.T) {
+		due := repo.Loans[cleanCode][0].ReturnDate
+		for i := 0; i < 3; i++ {
+			w := send("/Extend", "extend-1", borrow)
+			require.Equal(t, http.StatusOK, w.Code)
+		}
+		assert.Equal(t, due.AddDate(0, 0, 21), repo.Loans[cleanCode][0].ReturnDate)
+	})
+
+	t.Run("Key Reused For Another Request", func(t *testing.T) {
+		w := send("/Borrow", "borrow-1", fmt.Sprintf(`{"borrower_id": %d, "book_id": %d}`, bob, cleanCode))
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"idempotency_key_reused"`)
+
+		// The same body on another route is another request too
+		w = send("/Extend", "borrow-1", borrow)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("Retried Renewal Replays Its ETag", func(t *testing.T) {
+		loan := repo.Loans[cleanCode][0]
+		renew := func(key, ifMatch string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", fmt.Sprintf("/v1/loans/%d/renewals", loan.ID), http.NoBody)
+			req.Header.Set("Idempotency-Key", key)
+			req.Header.Set("If-Match", ifMatch)
+			r.ServeHTTP(w, req)
+			return w
+		}
+		current := fmt.Sprintf(`"%d"`, loan.Version)
+		first := renew("renew-1", current)
+		require.Equal(t, http.StatusOK, first.Code)
+		require.NotEmpty(t, first.Header().Get("ETag"))
+
+		retry := renew("renew-1", current)
+		assert.Equal(t, http.StatusOK, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, first.Header().Get("ETag"), retry.Header().Get("ETag"))
+
+		// The same key sent against another version is another request
+		w := renew("renew-1", first.Header().Get("ETag"))
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"idempotency_key_reused"`)
+	})
+
+	t.Run("Failures Are Replayed Too", func(t *testing.T) {
+		body := fmt.Sprintf(`{"borrower_id": %d, "book_id": 999}`, bob)
+		assert.Equal(t, http.StatusNotFound, send("/v1/loans", "missing-book", body).Code)
+		w := send("/v1/loans", "missing-book", body)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	})
+
+	t.Run("Overlong Key", func(t *testing.T) {
+		w := send("/v1/loans", strings.Repeat("k", 256), borrow)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `"field":"header.Idempotency-Key"`)
+	})
+}
+
+func TestIdempotency_PanickingHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.Recovery(), middleware.Idempotency(idempotency.NewMemoryStore(), time.Hour))
+	calls := 0
+	r.POST("/flaky", func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		c.JSON(http.StatusCreated, gin.H{"calls": calls})
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/flaky", bytes.NewBufferString(`{}`))
+		req.Header.Set("Idempotency-Key", "flaky-1")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusInternalServerError, send().Code)
+	// The key was released, so the retry runs instead of waiting forever
+	w := send()
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 2, calls)
+}
+
+// --- Conditional Request Tests ---
+
+func TestConditional_Scenarios(t *testing.T) {
+	router, repo := setupTestRouter()
+
+	send := func(method, path string, body any, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		var payload []byte
+		if body != nil {
+			payload, _ = json.Marshal(body)
+		}
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+		maps.Copy(req.Header, header)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	v1Book := "/v1" + bookPath(cleanCode)
+	edit := map[string]any{"title": "Clean Code", "authors": []string{"Robert C. Martin"}, "publisher": "Pearson"}
+
+	var tag string
+	t.Run("Reads Carry An ETag", func(t *testing.T) {
+		w := send("GET", v1Book, nil, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var book models.BookDetail
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &book))
+		tag = w.Header().Get("ETag")
+		assert.Equal(t, `"`+strconv.FormatInt(book.Version, 10)+`"`, tag)
+
+		// The legacy lookup tags the same version
+		w = send("GET", "/Book?id="+strconv.FormatInt(cleanCode, 10), nil, nil)
+		assert.Equal(t, tag, w.Header().Get("ETag"))
+	})
+
+	t.Run("Unchanged Books Are Not Sent Again", func(t *testing.T) {
+		for _, held := range []string{tag, "W/" + tag, `"0", ` + tag, "*"} {
+			w := send("GET", v1Book, nil, http.Header{"If-None-Match": {held}})
+			assert.Equal(t, http.StatusNotModified, w.Code, held)
+			assert.Empty(t, w.Body.String(), held)
+			assert.Equal(t, tag, w.Header().Get("ETag"), held)
+		}
+		w := send("GET", v1Book, nil, http.Header{"If-None-Match": {`"0"`}})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Updates Must Name A Version", func(t *testing.T) {
+		w := send("PUT", v1Book, edit, nil)
+		assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"precondition_required"`)
+		w = send("PATCH", v1Book, map[string]any{"delta": 1}, nil)
+		assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+		// The legacy routes keep working without one
+		w = send("PATCH", bookPath(cleanCode), map[string]any{"delta": 1}, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotEqual(t, tag, w.Header().Get("ETag"))
+	})
+
+	t.Run("Stale Versions Are Refused", func(t *testing.T) {
+		// The legacy update above moved the book past the tag read before it
+		w := send("PUT", v1Book, edit, http.Header{"If-Match": {tag}})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"version_mismatch"`)
+		w = send("PUT", v1Book, edit, http.Header{"If-Match": {"W/" + tag}})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, "If-Match never matches a weak tag")
+		w = send("PUT", v1Book, edit, http.Header{"If-Match": {"not a tag"}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `"field":"header.If-Match"`)
+
+		w = send("GET", v1Book, nil, http.Header{"If-None-Match": {tag}})
+		require.Equal(t, http.StatusOK, w.Code)
+		tag = w.Header().Get("ETag")
+		w = send("PUT", v1Book, edit, http.Header{"If-Match": {tag}})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"publisher":"Pearson"`)
+		assert.NotEqual(t, tag, w.Header().Get("ETag"))
+		tag = w.Header().Get("ETag")
+	})
+
+	t.Run("A List Of Tags Matches Any Of Them", func(t *testing.T) {
+		stale := `"1"`
+		w := send("PATCH", v1Book, map[string]any{"delta": 1}, http.Header{"If-Match": {stale + ", " + tag}})
+		require.Equal(t, http.StatusOK, w.Code)
+		previous := tag
+		tag = w.Header().Get("ETag")
+		assert.NotEqual(t, previous, tag)
+
+		w = send("PATCH", v1Book, map[string]any{"delta": 1}, http.Header{"If-Match": {stale + ", " + previous}})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, "none of them is current any more")
+		w = send("PATCH", v1Book, map[string]any{"delta": 1}, http.Header{"If-Match": {stale + ", W/" + tag}})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, "a weak tag matches nothing, in a list too")
+		w = send("PATCH", v1Book, map[string]any{"delta": 1}, http.Header{"If-Match": {stale + ", 7"}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `"field":"header.If-Match"`)
+	})
+
+	t.Run("Lending Changes The Book's Version", func(t *testing.T) {
+		w := send("POST", "/v1/loans", map[string]any{"borrower_id": alice, "book_id": cleanCode}, nil)
+		require.Equal(t, http.StatusCreated, w.Code)
+		var loan models.LoanDetail
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &loan))
+
+		w = send("GET", v1Book, nil, http.Header{"If-None-Match": {tag}})
+		assert.Equal(t, http.StatusOK, w.Code, "a copy fewer is on the shelf")
+
+		loanPath := "/v1/loans/" + strconv.FormatInt(loan.ID, 10)
+		w = send("GET", loanPath, nil, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		loanTag := w.Header().Get("ETag")
+		assert.Equal(t, http.StatusNotModified, send("GET", loanPath, nil, http.Header{"If-None-Match": {loanTag}}).Code)
+
+		w = send("POST", loanPath+"/renewals", nil, http.Header{"If-Match": {loanTag}})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotEqual(t, loanTag, w.Header().Get("ETag"))
+
+		// A second renewal made against the same read is refused
+		w = send("POST", loanPath+"/renewals", nil, http.Header{"If-Match": {loanTag}})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Equal(t, http.StatusPreconditionRequired, send("DELETE", loanPath, nil, nil).Code)
+		assert.Equal(t, http.StatusPreconditionFailed, send("DELETE", loanPath, nil, http.Header{"If-Match": {loanTag}}).Code)
+	})
+
+	t.Run("Deletes Must Name A Version", func(t *testing.T) {
+		book, err := repo.CreateBook(context.Background(), &models.BookDetail{Title: "Refactoring", Format: models.FormatPrint, AvailableCopies: 1})
+		require.NoError(t, err)
+		stale := `"` + strconv.FormatInt(book.Version, 10) + `"`
+		path := "/v1" + bookPath(book.ID)
+		require.Equal(t, http.StatusOK, send("PATCH", path, map[string]any{"delta": 1}, http.Header{"If-Match": {stale}}).Code)
+
+		assert.Equal(t, http.StatusPreconditionRequired, send("DELETE", path, nil, nil).Code)
+		w := send("DELETE", path, nil, http.Header{"If-Match": {stale}})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"version_mismatch"`)
+		assert.Equal(t, http.StatusOK, send("GET", path, nil, nil).Code, "a refused delete leaves the book")
+
+		tag := send("GET", path, nil, nil).Header().Get("ETag")
+		assert.Equal(t, http.StatusOK, send("DELETE", path, nil, http.Header{"If-Match": {tag}}).Code)
+		assert.Equal(t, http.StatusNotFound, send("GET", path, nil, nil).Code)
+	})
+}
+
+// --- API Document Tests ---
+
+func TestOpenAPI_Scenarios(t *testing.T) {
+	router, _ := setupTestRouter()
+
+	t.Run("Every Route Is Documented", func(t *testing.T) {
+		var registered []string
+		for _, route := range router.Routes() {
+			if route.Path == "/openapi.json" || strings.HasPrefix(route.Path, "/docs") {
+				continue
+			}
+			registered = append(registered, route.Method+" "+route.Path)
+		}
+		documented := spec.Routes()
+		slices.Sort(registered)
+		slices.Sort(documented)
+		assert.Equal(t, registered, documented)
+	})
+
+	t.Run("Document And Reference Are Served", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/openapi.json", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var doc map[string]any
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+		assert.Equal(t, "3.1.0", doc["openapi"])
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/docs", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "/openapi.json")
+		assert.NotContains(t, w.Body.String(), "https://", "the reference runs no script from elsewhere")
+
+		// Redoc comes from the binary, when the build has it
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/docs/redoc.standalone.js", nil)
+		router.ServeHTTP(w, req)
+		if _, ok := openapi.RedocScript(); ok {
+			assert.Equal(t, http.StatusOK, w.Code)
+		} else {
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("Invalid Requests Are Rejected", func(t *testing.T) {
+		for _, tc := range []struct {
+			method, path, body, want string
+		}{
+			{"POST", "/Borrow", `{"book_id": "two"}`, "body.book_id"},
+			{"POST", "/Borrow", `{"borrower_id": 1}`, "body.book_id: is required"},
+			{"POST", "/books", `{"title": "Dune", "format": "scroll"}`, "body.format"},
+			{"GET", "/books?limit=500", "", "query.limit"},
+			{"GET", "/books?available=maybe", "", "query.available"},
+			{"GET", "/books/abc", "", "path.id"},
+		} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code, tc.path)
+			assert.Contains(t, w.Body.String(), tc.want, tc.path)
+		}
+	})
+}
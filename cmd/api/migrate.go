@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"e-library-api/internal/config"
+	"e-library-api/internal/migrate"
+	"fmt"
+	"log"
+	"time"
+)
+
+const migrateUsage = "usage: api migrate up|down|status"
+
+// migrateCommand runs the migrate subcommand against the configured Postgres
+// or SQLite database.
+func migrateCommand(cfg *config.Config, args []string) {
+	if len(args) != 1 {
+		log.Fatal(migrateUsage)
+	}
+	db, err := openDB(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := runMigrations(context.Background(), db, cfg.DBType, args[0]); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+}
+
+// runMigrations applies (up), rolls back one step of (down) or prints the
+// state of (status) the embedded schema migrations.
+func runMigrations(ctx context.Context, db *sql.DB, dialect, command string) error {
+	m, err := migrate.New(db, dialect)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			log.Printf("Applied migration %04d_%s", mig.Version, mig.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			log.Println("Database schema is up to date")
+		}
+		return err
+	case "down":
+		mig, err := m.Down(ctx)
+		if err != nil {
+			return err
+		}
+		if mig == nil {
+			log.Println("No migrations to roll back")
+			return nil
+		}
+		log.Printf("Rolled back migration %04d_%s", mig.Version, mig.Name)
+		return nil
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, %s", command, migrateUsage)
+	}
+}
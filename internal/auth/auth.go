@@ -0,0 +1,110 @@
+// Package auth identifies who is calling the API. Integrations present a
+// static API key; members present a JWT signed with a secret shared with the
+// library's login service.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"e-library-api/internal/errors"
+	"fmt"
+	"strings"
+)
+
+// Kinds of principal.
+const (
+	KindAPIKey = "api_key"
+	KindMember = "member"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Kind string
+	// Subject is the API key's name, or the member's borrower ID
+	Subject string
+	// BorrowerID is set for members only
+	BorrowerID int64
+	Role       Role
+}
+
+// IsMember reports whether the principal is a member acting for themselves.
+func (p *Principal) IsMember() bool {
+	return p.Kind == KindMember
+}
+
+// Can reports whether the principal's role grants perm.
+func (p *Principal) Can(perm Permission) bool {
+	return p.Role.Can(perm)
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the request ctx belongs to, if it was
+// authenticated.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// Authenticator checks API keys and member tokens. The zero value accepts
+// neither.
+type Authenticator struct {
+	// apiKeys maps the SHA-256 digest of each key to its integration, so
+	// lookups do not compare the secrets themselves
+	apiKeys   map[[sha256.Size]byte]integration
+	jwtSecret []byte
+}
+
+type integration struct {
+	name string
+	role Role
+}
+
+// New returns an authenticator for the named API keys and the JWT signing
+// secret. Either may be empty to disable that kind of credential. keyRoles
+// gives the role of each named key; keys not listed act as librarians.
+func New(apiKeys, keyRoles map[string]string, jwtSecret string) (*Authenticator, error) {
+	a := &Authenticator{apiKeys: map[[sha256.Size]byte]integration{}}
+	for name, key := range apiKeys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		role := RoleLibrarian
+		if roleName, ok := keyRoles[name]; ok {
+			var err error
+			if role, err = ParseRole(roleName); err != nil {
+				return nil, fmt.Errorf("API key %q: %w", name, err)
+			}
+		}
+		// A patron acts on their own account, which an integration does not have
+		if role == RolePatron {
+			return nil, fmt.Errorf("API key %q: integrations cannot have the patron role", name)
+		}
+		a.apiKeys[sha256.Sum256([]byte(key))] = integration{name: name, role: role}
+	}
+	if jwtSecret != "" {
+		a.jwtSecret = []byte(jwtSecret)
+	}
+	return a, nil
+}
+
+// Enabled reports whether any credential is configured. Without one, every
+// request is let through unauthenticated.
+func (a *Authenticator) Enabled() bool {
+	return a != nil && (len(a.apiKeys) > 0 || len(a.jwtSecret) > 0)
+}
+
+// APIKey returns the integration the key belongs to.
+func (a *Authenticator) APIKey(key string) (*Principal, error) {
+	in, ok := a.apiKeys[sha256.Sum256([]byte(key))]
+	if !ok || key == "" {
+		return nil, errors.ErrInvalidCredentials
+	}
+	return &Principal{Kind: KindAPIKey, Subject: in.name, Role: in.role}, nil
+}
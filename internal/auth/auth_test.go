@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"e-library-api/internal/errors"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const secret = "test-secret"
+
+func TestToken(t *testing.T) {
+	a, _ := New(nil, nil, secret)
+	now := time.Now()
+
+	valid, err := IssueToken(secret, 42, "", time.Hour)
+	require.NoError(t, err)
+	p, err := a.Token(valid, now)
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Kind: KindMember, Subject: "42", BorrowerID: 42, Role: RolePatron}, p)
+
+	staff, _ := IssueToken(secret, 7, RoleLibrarian, time.Hour)
+	p, err = a.Token(staff, now)
+	require.NoError(t, err)
+	assert.Equal(t, RoleLibrarian, p.Role)
+
+	expired, _ := sign([]byte(secret), Claims{Subject: "42", ExpiresAt: now.Add(-time.Minute).Unix()})
+	notYet, _ := sign([]byte(secret), Claims{Subject: "42", ExpiresAt: now.Add(2 * time.Hour).Unix(), NotBefore: now.Add(time.Hour).Unix()})
+	noExpiry, _ := sign([]byte(secret), Claims{Subject: "42"})
+	badSubject, _ := sign([]byte(secret), Claims{Subject: "alice", ExpiresAt: now.Add(time.Hour).Unix()})
+	otherSecret, _ := IssueToken("other-secret", 42, "", time.Hour)
+	badRole, _ := IssueToken(secret, 42, "superuser", time.Hour)
+
+	header, _ := json.Marshal(jwtHeader{Alg: "none"})
+	payload, _ := json.Marshal(Claims{Subject: "42", ExpiresAt: now.Add(time.Hour).Unix()})
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload) + "."
+
+	for name, token := range map[string]string{
+		"expired":      expired,
+		"not yet":      notYet,
+		"no expiry":    noExpiry,
+		"bad subject":  badSubject,
+		"other secret": otherSecret,
+		"bad role":     badRole,
+		"alg none":     unsigned,
+		"tampered":     valid[:len(valid)-2] + "xx",
+		"malformed":    "not-a-token",
+	} {
+		_, err := a.Token(token, now)
+		assert.ErrorIs(t, err, errors.ErrInvalidCredentials, name)
+	}
+
+	// Tokens are refused outright when no secret is configured
+	none, _ := New(nil, nil, "")
+	_, err = none.Token(valid, now)
+	assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+}
+
+func TestAPIKey(t *testing.T) {
+	a, err := New(map[string]string{"kiosk": "k-123", "ops": "k-456"}, map[string]string{"ops": "admin"}, "")
+	require.NoError(t, err)
+	assert.True(t, a.Enabled())
+
+	p, err := a.APIKey("k-123")
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Kind: KindAPIKey, Subject: "kiosk", Role: RoleLibrarian}, p)
+	p, err = a.APIKey("k-456")
+	require.NoError(t, err)
+	assert.Equal(t, RoleAdmin, p.Role)
+
+	for _, key := range []string{"k-124", ""} {
+		_, err := a.APIKey(key)
+		assert.ErrorIs(t, err, errors.ErrInvalidCredentials, key)
+	}
+
+	for _, role := range []string{"patron", "superuser"} {
+		_, err := New(map[string]string{"kiosk": "k-123"}, map[string]string{"kiosk": role}, "")
+		assert.Error(t, err, role)
+	}
+
+	disabled, _ := New(nil, nil, "")
+	assert.False(t, disabled.Enabled())
+	var none *Authenticator
+	assert.False(t, none.Enabled())
+}
+
+func TestRoleCan(t *testing.T) {
+	assert.True(t, RolePatron.Can(PermOwnLoans))
+	assert.False(t, RolePatron.Can(PermAnyLoan))
+	assert.True(t, RoleLibrarian.Can(PermFines))
+	assert.False(t, RoleLibrarian.Can(PermMembers))
+	assert.True(t, RoleAdmin.Can(PermConfig))
+	assert.False(t, Role("").Can(PermOwnLoans))
+}
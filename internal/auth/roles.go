@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Role is what a principal may do. Each role can do everything the role
+// before it can.
+type Role string
+
+const (
+	// RolePatron borrows, renews, returns and holds books for themselves
+	RolePatron Role = "patron"
+	// RoleLibrarian works the desk: any member's loans, the catalog and fines
+	RoleLibrarian Role = "librarian"
+	// RoleAdmin also manages members and the server configuration
+	RoleAdmin Role = "admin"
+)
+
+// Permission names an action a route needs to be allowed.
+type Permission string
+
+const (
+	PermOwnLoans Permission = "loans:own"
+	PermAnyLoan  Permission = "loans:any"
+	PermCatalog  Permission = "catalog:write"
+	PermFines    Permission = "fines:manage"
+	PermMembers  Permission = "members:manage"
+	PermConfig   Permission = "config:read"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RolePatron:    {PermOwnLoans},
+	RoleLibrarian: {PermOwnLoans, PermAnyLoan, PermCatalog, PermFines},
+	RoleAdmin:     {PermOwnLoans, PermAnyLoan, PermCatalog, PermFines, PermMembers, PermConfig},
+}
+
+// ParseRole returns the role with the given name.
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", name)
+	}
+	return role, nil
+}
+
+// Can reports whether the role grants perm.
+func (r Role) Can(perm Permission) bool {
+	return slices.Contains(rolePermissions[r], perm)
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"log"
+	"reflect"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/joho/godotenv"
@@ -9,9 +11,70 @@ import (
 
 type Config struct {
 	Port        string `env:"PORT" envDefault:"3000"`
-	DatabaseURL string `env:"DATABASE_URL" envDefault:"host=localhost user=user password=pass dbname=lib sslmode=disable"`
+	DatabaseURL string `env:"DATABASE_URL" envDefault:"host=localhost user=user password=pass dbname=lib sslmode=disable" redact:"true"`
 	DBType      string `env:"DB_TYPE" envDefault:"memory"`
-	Environment string `env:"APP_ENV" envDefault:"development"`
+	// SQLitePath is the database file used when DBType is sqlite
+	SQLitePath string `env:"SQLITE_PATH" envDefault:"e-library.db"`
+	// MemoryDataDir, when set, persists the memory repository to a
+	// write-ahead log and snapshots in this directory
+	MemoryDataDir    string        `env:"MEMORY_DATA_DIR"`
+	SnapshotInterval time.Duration `env:"MEMORY_SNAPSHOT_INTERVAL" envDefault:"10m"`
+	Environment      string        `env:"APP_ENV" envDefault:"development"`
+	// MigrateOnStart applies pending schema migrations before serving. SQLite
+	// databases are always migrated on start.
+	MigrateOnStart bool `env:"MIGRATE_ON_START" envDefault:"false"`
+	// QueryTimeout bounds the database work of a single request; queries still
+	// running when it passes are cancelled. Zero disables the limit.
+	QueryTimeout time.Duration `env:"QUERY_TIMEOUT" envDefault:"10s"`
+
+	// Authentication. APIKeys maps integration names to their keys
+	// (name:key,name:key) and APIKeyRoles gives their roles (name:role);
+	// JWTSecret signs member tokens.
+	APIKeys     map[string]string `env:"API_KEYS" envKeyValSeparator:":" redact:"true"`
+	APIKeyRoles map[string]string `env:"API_KEY_ROLES" envKeyValSeparator:":"`
+	JWTSecret   string            `env:"JWT_SECRET" redact:"true"`
+
+	// Rate limiting. RateLimit applies to routes without their own entry in
+	// RateLimits ("POST /Borrow=10/m,/books/:id=120/m"). RateLimitStore is
+	// memory, or database to share the limits between replicas.
+	// ClientRateLimit caps what one client IP may send to the routes that
+	// need credentials, counted before they are checked.
+	RateLimit       string            `env:"RATE_LIMIT" envDefault:"300/m"`
+	RateLimits      map[string]string `env:"RATE_LIMITS" envKeyValSeparator:"=" envDefault:"POST /v1/loans=20/m,POST /Borrow=20/m"`
+	RateLimitStore  string            `env:"RATE_LIMIT_STORE" envDefault:"memory"`
+	ClientRateLimit string            `env:"CLIENT_RATE_LIMIT" envDefault:"600/m"`
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies whose
+	// X-Forwarded-For header names the client
+	TrustedProxies []string `env:"TRUSTED_PROXIES"`
+
+	// IdempotencyTTL is how long the response to a request sent with an
+	// Idempotency-Key is kept for retries. Responses are kept in the
+	// database when DBType is postgres or sqlite, in memory otherwise.
+	IdempotencyTTL time.Duration `env:"IDEMPOTENCY_TTL" envDefault:"24h"`
+
+	// LegacySunset is when the unversioned routes, superseded by /v1, stop
+	// being served. They announce it in a Sunset header until then.
+	LegacySunset time.Time `env:"LEGACY_SUNSET" envDefault:"2027-04-30T00:00:00Z"`
+
+	// Lending policy
+	LoanPeriodDays     int            `env:"LOAN_PERIOD_DAYS" envDefault:"28"`
+	ExtensionDays      int            `env:"EXTENSION_DAYS" envDefault:"21"`
+	MaxConcurrentLoans int            `env:"MAX_CONCURRENT_LOANS" envDefault:"5"`
+	MaxRenewals        int            `env:"MAX_RENEWALS" envDefault:"2"`
+	TierLoanDays       map[string]int `env:"TIER_LOAN_DAYS" envKeyValSeparator:":"`
+	CategoryLoanDays   map[string]int `env:"CATEGORY_LOAN_DAYS" envKeyValSeparator:":"`
+
+	// Fines
+	FinePerDayCents          int64 `env:"FINE_PER_DAY_CENTS" envDefault:"25"`
+	MaxOutstandingFinesCents int64 `env:"MAX_OUTSTANDING_FINES_CENTS" envDefault:"500"`
+
+	// Background jobs
+	SchedulerEnabled    bool          `env:"SCHEDULER_ENABLED" envDefault:"true"`
+	HoldExpiryInterval  time.Duration `env:"HOLD_EXPIRY_INTERVAL" envDefault:"5m"`
+	EbookExpiryInterval time.Duration `env:"EBOOK_EXPIRY_INTERVAL" envDefault:"5m"`
+	OverdueScanInterval time.Duration `env:"OVERDUE_SCAN_INTERVAL" envDefault:"1h"`
+	PurgeInterval       time.Duration `env:"PURGE_INTERVAL" envDefault:"24h"`
+	RetentionDays       int           `env:"RETENTION_DAYS" envDefault:"365"`
 }
 
 func LoadConfig() (*Config, error) {
@@ -27,3 +90,34 @@ func LoadConfig() (*Config, error) {
 
 	return cfg, nil
 }
+
+// redacted replaces secrets in Settings.
+const redacted = "********"
+
+// Settings returns the configuration keyed by environment variable, for
+// administrators to check what a server is running with. Secrets are masked;
+// of secret maps such as the API keys only the names are shown.
+func (c *Config) Settings() map[string]any {
+	settings := map[string]any{}
+	v := reflect.ValueOf(*c)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i).Interface()
+		switch {
+		case field.Tag.Get("redact") != "true":
+			if d, ok := value.(time.Duration); ok {
+				value = d.String()
+			}
+		case v.Field(i).Kind() == reflect.Map:
+			masked := map[string]string{}
+			for _, name := range v.Field(i).MapKeys() {
+				masked[name.String()] = redacted
+			}
+			value = masked
+		case !v.Field(i).IsZero():
+			value = redacted
+		}
+		settings[field.Tag.Get("env")] = value
+	}
+	return settings
+}
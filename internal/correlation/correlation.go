@@ -0,0 +1,54 @@
+// Package correlation ties a request's response, log lines and problem
+// reports together with one ID. Callers may send their own ID so it carries
+// across services; otherwise one is made up.
+package correlation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header carries the ID on requests and responses.
+const Header = "X-Correlation-ID"
+
+// maxLength bounds IDs sent by callers, which end up in every log line.
+const maxLength = 128
+
+// New returns a random ID.
+func New() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether an ID sent by a caller is safe to keep: short, and
+// made of letters, digits, dashes, dots, colons and underscores only.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '.', r == ':', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type idKey struct{}
+
+// WithID returns a copy of ctx carrying id.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// FromContext returns the ID of the request ctx belongs to, or "" if it has
+// none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
@@ -0,0 +1,31 @@
+package correlation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	id := New()
+	assert.Len(t, id, 32)
+	assert.True(t, Valid(id))
+	assert.NotEqual(t, id, New())
+}
+
+func TestValid(t *testing.T) {
+	for _, id := range []string{"abc-123", "req_1.2:3", "7f9c2ba4e88f827d616045507605853e"} {
+		assert.True(t, Valid(id), id)
+	}
+	for _, id := range []string{"", "two words", "line\nbreak", "<script>", strings.Repeat("a", 129)} {
+		assert.False(t, Valid(id), id)
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Empty(t, FromContext(ctx))
+	assert.Equal(t, "abc", FromContext(WithID(ctx, "abc")))
+}
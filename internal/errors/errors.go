@@ -1,10 +1,141 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 var (
-	ErrBookNotFound  = errors.New("book not found")
-	ErrNoCopies      = errors.New("no copies available")
-	ErrLoanNotFound  = errors.New("loan not found")
-	ErrDuplicateLoan = errors.New("borrower already has an active loan for this book")
+	ErrBookNotFound         = errors.New("book not found")
+	ErrNoCopies             = errors.New("no copies available")
+	ErrLoanNotFound         = errors.New("loan not found")
+	ErrLoanEnded            = errors.New("loan has already ended")
+	ErrDuplicateLoan        = errors.New("borrower already has an active loan for this book")
+	ErrBookExists           = errors.New("a book with this ISBN already exists")
+	ErrBookHasLoans         = errors.New("book has been lent out or has holds waiting")
+	ErrInvalidCopyCount     = errors.New("available copies cannot go below zero")
+	ErrDuplicateHold        = errors.New("borrower already has a hold on this book")
+	ErrCopiesAvailable      = errors.New("copies are available, borrow the book instead")
+	ErrBorrowerNotFound     = errors.New("borrower not found")
+	ErrBorrowerExists       = errors.New("a borrower with this email already exists")
+	ErrBorrowerInactive     = errors.New("borrower membership is not active")
+	ErrBorrowerHasLoans     = errors.New("borrower has loans on record, holds waiting or fines owed")
+	ErrAmountExceedsBalance = errors.New("amount exceeds the outstanding fine balance")
+	ErrInvalidCursor        = errors.New("invalid or expired pagination cursor")
+	ErrInvalidISBN          = errors.New("invalid ISBN")
+	ErrWorkNotFound         = errors.New("work not found")
+	ErrItemNotFound         = errors.New("item not found")
+	ErrItemExists           = errors.New("an item with this barcode already exists")
+	ErrItemInUse            = errors.New("item is on loan or set aside for a hold")
+
+	// Requests the API cannot serve
+	ErrInvalidRequest     = errors.New("the request has missing or malformed fields")
+	ErrRouteNotFound      = errors.New("no route matches the request")
+	ErrConfigUnavailable  = errors.New("configuration is not available")
+	ErrStorageUnavailable = errors.New("storage is unavailable")
+	ErrTimeout            = errors.New("request timed out")
+
+	// Conditional requests
+	ErrVersionMismatch      = errors.New("the resource has changed since it was read")
+	ErrPreconditionRequired = errors.New("the request must say which version it changes, with If-Match")
+
+	// Authentication, access control and rate limiting
+	ErrUnauthenticated    = errors.New("authentication required")
+	ErrInvalidCredentials = errors.New("invalid or expired credentials")
+	ErrBorrowerMismatch   = errors.New("members can only act on their own account")
+	ErrForbidden          = errors.New("your role does not allow this action")
+	ErrRateLimited        = errors.New("too many requests, try again later")
+
+	// Retries under an Idempotency-Key
+	ErrIdempotencyKeyReused  = errors.New("idempotency key was already used for a different request")
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is still being processed")
+
+	// Lending policy violations
+	ErrLoanLimitReached     = errors.New("borrower has reached the maximum number of concurrent loans")
+	ErrRenewalLimitReached  = errors.New("loan has reached the maximum number of renewals")
+	ErrRenewalBlockedByHold = errors.New("loan cannot be renewed while other borrowers are waiting for the book")
+	ErrFinesOutstanding     = errors.New("borrower has outstanding fines above the borrowing threshold")
 )
+
+// PolicyError reports a lending rule with a numeric limit that blocked an
+// operation. It wraps one of the policy sentinels above, so callers match it
+// with errors.Is.
+type PolicyError struct {
+	Err   error
+	Limit int
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s (limit %d)", e.Err, e.Limit)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// NoCopiesError reports that no copy of a book can be lent right now. It
+// wraps ErrNoCopies. AvailableAt is when the first copy on loan is due back,
+// or zero when none is on loan.
+type NoCopiesError struct {
+	AvailableAt time.Time
+}
+
+func (e *NoCopiesError) Error() string {
+	if e.AvailableAt.IsZero() {
+		return ErrNoCopies.Error()
+	}
+	return fmt.Sprintf("%s (next due back %s)", ErrNoCopies, e.AvailableAt.UTC().Format(time.RFC3339))
+}
+
+func (e *NoCopiesError) Unwrap() error {
+	return ErrNoCopies
+}
+
+// RateLimitError reports a caller who ran out of requests. It wraps
+// ErrRateLimited.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return ErrRateLimited.Error()
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// FieldError is a request parameter or body field that is missing or
+// malformed. Field names where it is, such as "path.id" or "body.book_id".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// InvalidRequestError lists what is wrong with a request. It wraps
+// ErrInvalidRequest.
+type InvalidRequestError struct {
+	Fields []FieldError
+}
+
+// Invalid reports a single field of a request that is missing or malformed.
+func Invalid(field, message string) error {
+	return &InvalidRequestError{Fields: []FieldError{{Field: field, Message: message}}}
+}
+
+func (e *InvalidRequestError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		problems[i] = f.Field + ": " + f.Message
+	}
+	if len(problems) == 0 {
+		return ErrInvalidRequest.Error()
+	}
+	return strings.Join(problems, "; ")
+}
+
+func (e *InvalidRequestError) Unwrap() error {
+	return ErrInvalidRequest
+}
@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"e-library-api/internal/errors"
+	"e-library-api/internal/problem"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetConfig shows the settings the server is running with, secrets masked.
+func (h *LibraryHandler) GetConfig(c *gin.Context) {
+	if h.Config == nil {
+		problem.Error(c, errors.ErrConfigUnavailable)
+		return
+	}
+	c.JSON(http.StatusOK, h.Config.Settings())
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"e-library-api/internal/errors"
+	"e-library-api/internal/problem"
+	"encoding/json"
+	stdErrors "errors"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Field errors name fields the way clients write them
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(fieldName)
+	}
+}
+
+// fieldName is a struct field's name in JSON bodies or query strings.
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+// bindJSON binds the request body, writing a 400 response listing the
+// fields that are missing or malformed when it does not fit
+func bindJSON(c *gin.Context, obj any) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		problem.Error(c, invalidInput(err, "body"))
+		return false
+	}
+	return true
+}
+
+// bindQuery binds the query string, writing a 400 response listing the
+// parameters that are missing or malformed when it does not fit
+func bindQuery(c *gin.Context, obj any) bool {
+	if err := c.ShouldBindQuery(obj); err != nil {
+		problem.Error(c, invalidInput(err, "query"))
+		return false
+	}
+	return true
+}
+
+// invalidInput describes a binding failure field by field. in is where the
+// fields are: "body" or "query".
+func invalidInput(err error, in string) error {
+	var rules validator.ValidationErrors
+	if stdErrors.As(err, &rules) {
+		invalid := &errors.InvalidRequestError{}
+		for _, rule := range rules {
+			invalid.Fields = append(invalid.Fields, errors.FieldError{
+				Field:   in + "." + rule.Field(),
+				Message: ruleMessage(rule),
+			})
+		}
+		return invalid
+	}
+	var wrongType *json.UnmarshalTypeError
+	if stdErrors.As(err, &wrongType) && wrongType.Field != "" {
+		return errors.Invalid(in+"."+wrongType.Field, "must not be a "+wrongType.Value)
+	}
+	return errors.Invalid(in, err.Error())
+}
+
+// ruleMessage explains a failed validation rule.
+func ruleMessage(rule validator.FieldError) string {
+	switch rule.Tag() {
+	case "required":
+		return "is required"
+	case "gt":
+		return "must be more than " + rule.Param()
+	case "gte":
+		return "must be at least " + rule.Param()
+	case "lte":
+		return "must be at most " + rule.Param()
+	case "oneof":
+		return "must be one of [" + rule.Param() + "]"
+	case "email":
+		return "must be an email address"
+	case "bcp47_language_tag":
+		return "must be a BCP 47 language tag"
+	default:
+		return "fails the " + rule.Tag() + " rule"
+	}
+}
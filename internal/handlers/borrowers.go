@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"e-library-api/internal/errors"
+	"e-library-api/internal/models"
+	"e-library-api/internal/problem"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// borrowerID parses the :id path parameter, writing a 400 response when it is malformed
+func borrowerID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		problem.Error(c, errors.Invalid("path.id", "must be a borrower id"))
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *LibraryHandler) CreateBorrower(c *gin.Context) {
+	var input models.Borrower
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	borrower, err := h.Service.CreateBorrower(c.Request.Context(), &input)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, borrower)
+}
+
+func (h *LibraryHandler) GetBorrower(c *gin.Context) {
+	id, ok := borrowerID(c)
+	if !ok {
+		return
+	}
+
+	borrower, err := h.Service.GetBorrower(c.Request.Context(), id)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, borrower)
+}
+
+func (h *LibraryHandler) UpdateBorrower(c *gin.Context) {
+	id, ok := borrowerID(c)
+	if !ok {
+		return
+	}
+	var input models.Borrower
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	borrower, err := h.Service.UpdateBorrower(c.Request.Context(), id, &input)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, borrower)
+}
+
+func (h *LibraryHandler) DeleteBorrower(c *gin.Context) {
+	id, ok := borrowerID(c)
+	if !ok {
+		return
+	}
+
+	err := h.Service.DeleteBorrower(c.Request.Context(), id)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "borrower deleted successfully"})
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"e-library-api/internal/errors"
+	"e-library-api/internal/problem"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etag is the entity tag of a book or loan at the given version.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatch reads the If-Match header, the version of the resource the request
+// means to change. It returns zero, which matches every version, when the
+// header is missing or "*". The header may list several tags (RFC 9110
+// §13.1.1): current then reads the resource's version, which is returned if
+// any of them names it. Weak and unknown tags never match, so a header
+// without a tag that can gives a 412; a malformed header gives a 400. Either
+// is written to c, as is an error from current.
+func ifMatch(c *gin.Context, current func() (int64, error)) (int64, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	var versions []int64
+	for _, candidate := range strings.Split(header, ",") {
+		tag, weak, ok := parseETag(strings.TrimSpace(candidate))
+		if !ok {
+			problem.Error(c, errors.Invalid("header.If-Match", "must be a list of entity tags, or *"))
+			return 0, false
+		}
+		// If-Match compares tags strongly, so a weak tag matches nothing
+		if version, err := strconv.ParseInt(tag, 10, 64); !weak && err == nil && version > 0 {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		problem.Error(c, errors.ErrVersionMismatch)
+		return 0, false
+	}
+	// A single version is left to the update to compare, as it must anyway
+	if len(versions) == 1 {
+		return versions[0], true
+	}
+
+	version, err := current()
+	if err != nil {
+		problem.Error(c, err)
+		return 0, false
+	}
+	if !slices.Contains(versions, version) {
+		problem.Error(c, errors.ErrVersionMismatch)
+		return 0, false
+	}
+	return version, true
+}
+
+// loadedVersion is current for ifMatch when the resource is already loaded.
+func loadedVersion(version int64) func() (int64, error) {
+	return func() (int64, error) { return version, nil }
+}
+
+// notModified answers a 304 when the request's If-None-Match header names
+// the resource at its current version, or is "*". Tags are compared weakly.
+func notModified(c *gin.Context, version int64) bool {
+	header := strings.TrimSpace(c.GetHeader("If-None-Match"))
+	if header == "" {
+		return false
+	}
+	current := etag(version)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate != "*" {
+			tag, _, ok := parseETag(candidate)
+			if !ok || `"`+tag+`"` != current {
+				continue
+			}
+		}
+		c.Header("ETag", current)
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// parseETag splits an entity tag such as W/"3" into its opaque part and
+// whether it is weak.
+func parseETag(s string) (tag string, weak bool, ok bool) {
+	if rest, found := strings.CutPrefix(s, "W/"); found {
+		s, weak = rest, true
+	}
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' || strings.Contains(s[1:len(s)-1], `"`) {
+		return "", false, false
+	}
+	return s[1 : len(s)-1], weak, true
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"e-library-api/internal/openapi"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPI serves the OpenAPI document of the API.
+func (h *LibraryHandler) OpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openapi.Document())
+}
+
+// APIDocs serves the browsable API reference.
+func (h *LibraryHandler) APIDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", openapi.DocsPage())
+}
+
+// RedocScript serves the Redoc bundle the API reference runs.
+func (h *LibraryHandler) RedocScript(c *gin.Context) {
+	script, ok := openapi.RedocScript()
+	if !ok {
+		h.NoRoute(c)
+		return
+	}
+	c.Data(http.StatusOK, "text/javascript; charset=utf-8", script)
+}
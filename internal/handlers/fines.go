@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"e-library-api/internal/models"
+	"e-library-api/internal/problem"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *LibraryHandler) GetFineAccount(c *gin.Context) {
+	id, ok := borrowerID(c)
+	if !ok {
+		return
+	}
+
+	account, err := h.Service.GetFineAccount(c.Request.Context(), id)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, account)
+}
+
+func (h *LibraryHandler) PayFine(c *gin.Context) {
+	h.recordCredit(c, h.Service.PayFine)
+}
+
+func (h *LibraryHandler) WaiveFine(c *gin.Context) {
+	h.recordCredit(c, h.Service.WaiveFine)
+}
+
+// recordCredit handles the shared request flow for payments and waivers
+func (h *LibraryHandler) recordCredit(c *gin.Context, record func(context.Context, int64, *models.FineTransaction) (*models.FineEntry, error)) {
+	id, ok := borrowerID(c)
+	if !ok {
+		return
+	}
+	var input models.FineTransaction
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	entry, err := record(c.Request.Context(), id, &input)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, entry)
+}
@@ -1,68 +1,240 @@
 package handlers
 
 import (
+	"e-library-api/internal/auth"
+	"e-library-api/internal/config"
 	"e-library-api/internal/errors"
 	"e-library-api/internal/models"
+	"e-library-api/internal/problem"
 	"e-library-api/internal/service"
-	stdErrors "errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
 type LibraryHandler struct {
 	Service service.LibraryServiceInterface
+	// Config is shown to administrators; it may be nil
+	Config *config.Config
 }
 
-// Create a private helper to handle the repetitive request binding logic
+// bindRequest binds the body of a borrow, renew, return or hold request.
+// A patron always acts for themselves, so the borrower comes from their
+// token; only staff name the borrower in the body.
 func (h *LibraryHandler) bindRequest(c *gin.Context) (*models.LoanDetail, bool) {
 	var input models.LoanDetail
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &input) {
+		return nil, false
+	}
+
+	if principal, ok := auth.FromContext(c.Request.Context()); ok && !principal.Can(auth.PermAnyLoan) {
+		if input.BorrowerID != 0 && input.BorrowerID != principal.BorrowerID {
+			problem.Error(c, errors.ErrBorrowerMismatch)
+			return nil, false
+		}
+		input.BorrowerID = principal.BorrowerID
+	}
+	if input.BorrowerID == 0 {
+		problem.Error(c, errors.Invalid("body.borrower_id", "is required"))
 		return nil, false
 	}
 	return &input, true
 }
 
+// bookID parses the :id path parameter, writing a 400 response when it is malformed
+func bookID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		problem.Error(c, errors.Invalid("path.id", "must be a book id"))
+		return 0, false
+	}
+	return id, true
+}
+
+// bookIDQuery parses a required book ID query parameter, writing a 400
+// response when it is missing or malformed
+func bookIDQuery(c *gin.Context, name string) (int64, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		problem.Error(c, errors.Invalid("query."+name, "is required"))
+		return 0, false
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		problem.Error(c, errors.Invalid("query."+name, "must be a book id"))
+		return 0, false
+	}
+	return id, true
+}
+
+// GetBook serves both GET /books/:id and the legacy GET /Book?id= lookup.
 func (h *LibraryHandler) GetBook(c *gin.Context) {
-	title := c.Query("title")
-	if title == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "title parameter is required"})
+	var (
+		id int64
+		ok bool
+	)
+	if c.Param("id") != "" {
+		id, ok = bookID(c)
+	} else {
+		id, ok = bookIDQuery(c, "id")
+	}
+	if !ok {
+		return
+	}
+	book, err := h.Service.GetBook(c.Request.Context(), id)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	if notModified(c, book.Version) {
+		return
+	}
+	c.Header("ETag", etag(book.Version))
+	c.JSON(http.StatusOK, book)
+}
+
+func (h *LibraryHandler) ListBooks(c *gin.Context) {
+	var query models.BookQuery
+	if !bindQuery(c, &query) {
+		return
+	}
+
+	page, err := h.Service.ListBooks(c.Request.Context(), query)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *LibraryHandler) SearchBooks(c *gin.Context) {
+	var query models.SearchQuery
+	if !bindQuery(c, &query) {
+		return
+	}
+
+	results, err := h.Service.SearchBooks(c.Request.Context(), query)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+func (h *LibraryHandler) CreateBook(c *gin.Context) {
+	var input models.BookDetail
+	if !bindJSON(c, &input) {
 		return
 	}
-	book, err := h.Service.GetBook(title)
+
+	book, err := h.Service.CreateBook(c.Request.Context(), &input)
 	if err != nil {
-		if stdErrors.Is(err, errors.ErrBookNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-			return
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, book)
+}
+
+// bookVersion is current for ifMatch: it reads the book's version.
+func (h *LibraryHandler) bookVersion(c *gin.Context, id int64) func() (int64, error) {
+	return func() (int64, error) {
+		book, err := h.Service.GetBook(c.Request.Context(), id)
+		if err != nil {
+			return 0, err
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return book.Version, nil
+	}
+}
+
+func (h *LibraryHandler) UpdateBook(c *gin.Context) {
+	id, ok := bookID(c)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(c, h.bookVersion(c, id))
+	if !ok {
+		return
+	}
+	var input models.BookDetail
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	book, err := h.Service.UpdateBook(c.Request.Context(), id, &input, version)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.Header("ETag", etag(book.Version))
+	c.JSON(http.StatusOK, book)
+}
+
+func (h *LibraryHandler) AdjustCopies(c *gin.Context) {
+	id, ok := bookID(c)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(c, h.bookVersion(c, id))
+	if !ok {
+		return
+	}
+	var input models.CopyAdjustment
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	book, err := h.Service.AdjustCopies(c.Request.Context(), id, input.Delta, version)
+	if err != nil {
+		problem.Error(c, err)
 		return
 	}
+	c.Header("ETag", etag(book.Version))
 	c.JSON(http.StatusOK, book)
 }
 
+func (h *LibraryHandler) DeleteBook(c *gin.Context) {
+	id, ok := bookID(c)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(c, h.bookVersion(c, id))
+	if !ok {
+		return
+	}
+	err := h.Service.DeleteBook(c.Request.Context(), id, version)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "book deleted successfully"})
+}
+
+func (h *LibraryHandler) GetWork(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		problem.Error(c, errors.Invalid("path.id", "must be a work id"))
+		return
+	}
+
+	work, err := h.Service.GetWork(c.Request.Context(), id)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, work)
+}
+
 func (h *LibraryHandler) BorrowBook(c *gin.Context) {
 	input, ok := h.bindRequest(c)
 	if !ok {
 		return
 	}
 
-	loan, err := h.Service.BorrowBook(input.NameOfBorrower, input.BookTitle)
+	loan, err := h.Service.BorrowBook(c.Request.Context(), input.BorrowerID, input.BookID)
 	if err != nil {
-		if stdErrors.Is(err, errors.ErrBookNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-			return
-		}
-		if stdErrors.Is(err, errors.ErrNoCopies) {
-			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
-			return
-		}
-		if stdErrors.Is(err, errors.ErrDuplicateLoan) {
-			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		problem.Error(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, loan)
@@ -74,15 +246,12 @@ func (h *LibraryHandler) ExtendLoan(c *gin.Context) {
 		return
 	}
 
-	loan, err := h.Service.ExtendLoan(input.NameOfBorrower, input.BookTitle)
+	loan, err := h.Service.ExtendLoan(c.Request.Context(), input.BorrowerID, input.BookID)
 	if err != nil {
-		if stdErrors.Is(err, errors.ErrLoanNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		problem.Error(c, err)
 		return
 	}
+	c.Header("ETag", etag(loan.Version))
 	c.JSON(http.StatusOK, loan)
 }
 
@@ -92,21 +261,71 @@ func (h *LibraryHandler) ReturnBook(c *gin.Context) {
 		return
 	}
 
-	err := h.Service.ReturnBook(input.NameOfBorrower, input.BookTitle)
+	fine, err := h.Service.ReturnBook(c.Request.Context(), input.BorrowerID, input.BookID)
 	if err != nil {
-		if stdErrors.Is(err, errors.ErrLoanNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		problem.Error(c, err)
+		return
+	}
+	if fine != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "book returned late, a fine was charged", "fine": fine})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "book returned successfully"})
 }
 
+func (h *LibraryHandler) ListOverdueLoans(c *gin.Context) {
+	loans, err := h.Service.ListOverdueLoans(c.Request.Context())
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, loans)
+}
+
+func (h *LibraryHandler) PlaceHold(c *gin.Context) {
+	input, ok := h.bindRequest(c)
+	if !ok {
+		return
+	}
+
+	hold, err := h.Service.PlaceHold(c.Request.Context(), input.BorrowerID, input.BookID)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, hold)
+}
+
+// ListHolds serves both GET /books/:id/holds and the legacy GET /Hold?book_id= queue.
+func (h *LibraryHandler) ListHolds(c *gin.Context) {
+	var (
+		id int64
+		ok bool
+	)
+	if c.Param("id") != "" {
+		id, ok = bookID(c)
+	} else {
+		id, ok = bookIDQuery(c, "book_id")
+	}
+	if !ok {
+		return
+	}
+	holds, err := h.Service.ListHolds(c.Request.Context(), id)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, holds)
+}
+
+// NoRoute answers requests that no route matches.
+func (h *LibraryHandler) NoRoute(c *gin.Context) {
+	problem.Error(c, errors.ErrRouteNotFound)
+}
+
 func (h *LibraryHandler) HealthCheck(c *gin.Context) {
-	if err := h.Service.HealthCheck(); err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	if err := h.Service.HealthCheck(c.Request.Context()); err != nil {
+		problem.Error(c, fmt.Errorf("%w: %v", errors.ErrStorageUnavailable, err))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "UP"})
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"e-library-api/internal/errors"
+	"e-library-api/internal/models"
+	"e-library-api/internal/problem"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// itemID parses the :id path parameter, writing a 400 response when it is malformed
+func itemID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		problem.Error(c, errors.Invalid("path.id", "must be a item id"))
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *LibraryHandler) ListItems(c *gin.Context) {
+	id, ok := bookID(c)
+	if !ok {
+		return
+	}
+
+	items, err := h.Service.ListItems(c.Request.Context(), id)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *LibraryHandler) AddItem(c *gin.Context) {
+	id, ok := bookID(c)
+	if !ok {
+		return
+	}
+	var input models.Item
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	item, err := h.Service.AddItem(c.Request.Context(), id, &input)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, item)
+}
+
+func (h *LibraryHandler) GetItem(c *gin.Context) {
+	id, ok := itemID(c)
+	if !ok {
+		return
+	}
+
+	item, err := h.Service.GetItem(c.Request.Context(), id)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+func (h *LibraryHandler) UpdateItem(c *gin.Context) {
+	id, ok := itemID(c)
+	if !ok {
+		return
+	}
+	var input models.Item
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	item, err := h.Service.UpdateItem(c.Request.Context(), id, &input)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
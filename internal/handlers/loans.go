@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"e-library-api/internal/auth"
+	"e-library-api/internal/errors"
+	"e-library-api/internal/models"
+	"e-library-api/internal/problem"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bindPage reads limit/offset query parameters, writing a 400 response when they are invalid
+func bindPage(c *gin.Context) (models.Page, bool) {
+	var page models.Page
+	if !bindQuery(c, &page) {
+		return page, false
+	}
+	return page, true
+}
+
+// loanID parses the :id path parameter, writing a 400 response when it is malformed
+func loanID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		problem.Error(c, errors.Invalid("path.id", "must be a loan id"))
+		return 0, false
+	}
+	return id, true
+}
+
+// ownLoan looks up the loan named by the :id path parameter. A patron only
+// gets to see and act on their own loans.
+func (h *LibraryHandler) ownLoan(c *gin.Context) (*models.LoanDetail, bool) {
+	id, ok := loanID(c)
+	if !ok {
+		return nil, false
+	}
+	loan, err := h.Service.GetLoan(c.Request.Context(), id)
+	if err != nil {
+		problem.Error(c, err)
+		return nil, false
+	}
+	if principal, ok := auth.FromContext(c.Request.Context()); ok && !principal.Can(auth.PermAnyLoan) &&
+		loan.BorrowerID != principal.BorrowerID {
+		problem.Error(c, errors.ErrBorrowerMismatch)
+		return nil, false
+	}
+	return loan, true
+}
+
+func (h *LibraryHandler) GetLoan(c *gin.Context) {
+	loan, ok := h.ownLoan(c)
+	if !ok {
+		return
+	}
+	if notModified(c, loan.Version) {
+		return
+	}
+	c.Header("ETag", etag(loan.Version))
+	c.JSON(http.StatusOK, loan)
+}
+
+func (h *LibraryHandler) RenewLoan(c *gin.Context) {
+	loan, ok := h.ownLoan(c)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(c, loadedVersion(loan.Version))
+	if !ok {
+		return
+	}
+
+	loan, err := h.Service.RenewLoan(c.Request.Context(), loan.ID, version)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.Header("ETag", etag(loan.Version))
+	c.JSON(http.StatusOK, loan)
+}
+
+func (h *LibraryHandler) ReturnLoan(c *gin.Context) {
+	loan, ok := h.ownLoan(c)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(c, loadedVersion(loan.Version))
+	if !ok {
+		return
+	}
+
+	fine, err := h.Service.ReturnLoan(c.Request.Context(), loan.ID, version)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	if fine != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "book returned late, a fine was charged", "fine": fine})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "book returned successfully"})
+}
+
+func (h *LibraryHandler) ListBorrowerLoans(c *gin.Context) {
+	id, ok := borrowerID(c)
+	if !ok {
+		return
+	}
+	page, ok := bindPage(c)
+	if !ok {
+		return
+	}
+
+	loans, err := h.Service.ListBorrowerLoans(c.Request.Context(), id, page)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, loans)
+}
+
+func (h *LibraryHandler) ListBookLoans(c *gin.Context) {
+	id, ok := bookID(c)
+	if !ok {
+		return
+	}
+	page, ok := bindPage(c)
+	if !ok {
+		return
+	}
+
+	loans, err := h.Service.ListBookLoans(c.Request.Context(), id, page)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, loans)
+}
+
+func (h *LibraryHandler) MarkLoanLost(c *gin.Context) {
+	id, ok := loanID(c)
+	if !ok {
+		return
+	}
+
+	loan, err := h.Service.MarkLoanLost(c.Request.Context(), id)
+	if err != nil {
+		problem.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, loan)
+}
@@ -0,0 +1,97 @@
+// Package idempotency remembers the responses to requests sent with an
+// Idempotency-Key header, so that a client retrying a request whose answer
+// it never got is given that answer again instead of having the request
+// carried out twice. Responses live in a Store: in memory for a single
+// server, or in the database when several replicas must share them.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Header is the request header carrying the key the client chose.
+const Header = "Idempotency-Key"
+
+// ReplayedHeader is set to "true" on responses replayed from a store.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// MaxKeyLength is the longest key accepted, in bytes.
+const MaxKeyLength = 255
+
+// abandonAfter is how long a key stays claimed by a request that never
+// finished, because the server running it stopped. After that the key may
+// be claimed again.
+const abandonAfter = 5 * time.Minute
+
+// ReplayedHeaders are the response headers kept with a response and sent
+// again with it. They describe the resource the request created or changed;
+// other headers are the server's own business each time it answers.
+var ReplayedHeaders = []string{"ETag", "Location"}
+
+// Response is a response kept for replay.
+type Response struct {
+	Status      int
+	ContentType string
+	// Header holds those of ReplayedHeaders the response set
+	Header http.Header
+	Body   []byte
+}
+
+// Record is what a store knows about a key.
+type Record struct {
+	// Fingerprint identifies the request that claimed the key
+	Fingerprint string
+	// Response is nil while that request is still running
+	Response *Response
+}
+
+// Store keeps the responses by key.
+//
+// Begin claims key for a request with the given fingerprint until now+ttl.
+// It returns nil when the key was free, or its earlier claim has expired, and
+// the record of the earlier request otherwise. Complete stores the response
+// to the request that claimed key; Release gives up a claim whose request
+// did not complete, so that it can be retried in full.
+type Store interface {
+	Begin(ctx context.Context, key, fingerprint string, now time.Time, ttl time.Duration) (*Record, error)
+	Complete(ctx context.Context, key string, res Response) error
+	Release(ctx context.Context, key string) error
+}
+
+// Fingerprint identifies a request by its method, path, If-Match header and
+// body, so that a retry made against another version of the resource is
+// another request. JSON bodies are compared by value, so a retry may encode
+// them differently.
+func Fingerprint(method, path, ifMatch string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	// Left out when empty, so requests without it keep the fingerprint they
+	// had before it counted
+	if ifMatch != "" {
+		h.Write([]byte("If-Match: " + ifMatch + "\n"))
+	}
+	h.Write(canonical(body))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// canonical re-encodes a JSON body with its object keys sorted and without
+// insignificant whitespace. Other bodies are returned as they are.
+func canonical(body []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return body
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return out
+}
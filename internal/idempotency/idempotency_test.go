@@ -0,0 +1,117 @@
+package idempotency_test
+
+import (
+	"context"
+	"e-library-api/internal/idempotency"
+	"e-library-api/internal/migrate"
+	"e-library-api/internal/repository"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+func TestFingerprint(t *testing.T) {
+	a := idempotency.Fingerprint("POST", "/v1/loans", "", []byte(`{"borrower_id": 1, "book_id": 2}`))
+	assert.Equal(t, a, idempotency.Fingerprint("POST", "/v1/loans", "", []byte(`{"book_id":2,"borrower_id":1}`)))
+	assert.NotEqual(t, a, idempotency.Fingerprint("POST", "/v1/loans", "", []byte(`{"borrower_id": 1, "book_id": 3}`)))
+	assert.NotEqual(t, a, idempotency.Fingerprint("POST", "/v1/holds", "", []byte(`{"borrower_id": 1, "book_id": 2}`)))
+	// A request made against another version of the resource is another request
+	renewal := idempotency.Fingerprint("POST", "/v1/loans/1/renewals", `"1"`, nil)
+	assert.Equal(t, renewal, idempotency.Fingerprint("POST", "/v1/loans/1/renewals", `"1"`, nil))
+	assert.NotEqual(t, renewal, idempotency.Fingerprint("POST", "/v1/loans/1/renewals", `"2"`, nil))
+	assert.NotEqual(t, renewal, idempotency.Fingerprint("POST", "/v1/loans/1/renewals", "", nil))
+	// Bodies that are not JSON are compared as they are
+	assert.NotEqual(t, idempotency.Fingerprint("POST", "/Return", "", []byte("a")), idempotency.Fingerprint("POST", "/Return", "", []byte("a ")))
+}
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) idempotency.Store{
+		"Memory": func(t *testing.T) idempotency.Store {
+			return idempotency.NewMemoryStore()
+		},
+		"SQLite": func(t *testing.T) idempotency.Store {
+			return newSQLStore(t)
+		},
+	}
+	start := time.Unix(1_700_000_000, 0)
+	created := idempotency.Response{
+		Status:      201,
+		ContentType: "application/json",
+		Header:      http.Header{"Etag": {`"1"`}},
+		Body:        []byte(`{"id":1}`),
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			record, err := store.Begin(ctx, "alice borrow", "f1", start, time.Hour)
+			require.NoError(t, err)
+			assert.Nil(t, record, "a new key is claimed")
+
+			// A retry while the first request runs finds it unfinished
+			record, err = store.Begin(ctx, "alice borrow", "f1", start.Add(time.Second), time.Hour)
+			require.NoError(t, err)
+			require.NotNil(t, record)
+			assert.Equal(t, "f1", record.Fingerprint)
+			assert.Nil(t, record.Response)
+
+			require.NoError(t, store.Complete(ctx, "alice borrow", created))
+			record, err = store.Begin(ctx, "alice borrow", "f2", start.Add(time.Minute), time.Hour)
+			require.NoError(t, err)
+			require.NotNil(t, record)
+			assert.Equal(t, "f1", record.Fingerprint)
+			assert.Equal(t, &created, record.Response)
+
+			// Released keys and expired ones can be claimed again
+			record, err = store.Begin(ctx, "alice return", "f3", start, time.Hour)
+			require.NoError(t, err)
+			require.Nil(t, record)
+			require.NoError(t, store.Release(ctx, "alice return"))
+			record, err = store.Begin(ctx, "alice return", "f3", start.Add(time.Second), time.Hour)
+			require.NoError(t, err)
+			assert.Nil(t, record)
+
+			record, err = store.Begin(ctx, "alice borrow", "f2", start.Add(time.Hour), time.Hour)
+			require.NoError(t, err)
+			assert.Nil(t, record)
+
+			// and so can keys whose request never finished
+			record, err = store.Begin(ctx, "alice return", "f4", start.Add(10*time.Minute), time.Hour)
+			require.NoError(t, err)
+			assert.Nil(t, record)
+		})
+	}
+}
+
+func TestSQLStore_Prune(t *testing.T) {
+	store := newSQLStore(t)
+	start := time.Unix(1_700_000_000, 0)
+
+	_, err := store.Begin(ctx, "alice", "f1", start, time.Hour)
+	require.NoError(t, err)
+	_, err = store.Begin(ctx, "bob", "f2", start, 2*time.Hour)
+	require.NoError(t, err)
+
+	// Alice's key has expired; Bob's has not
+	pruned, err := store.Prune(ctx, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pruned)
+}
+
+func newSQLStore(t *testing.T) *idempotency.SQLStore {
+	db, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "library.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	m, err := migrate.New(db, migrate.SQLite)
+	require.NoError(t, err)
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+	return idempotency.NewSQLStore(db)
+}
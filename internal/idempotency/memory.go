@@ -0,0 +1,78 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the memory store drops expired keys.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps responses in this process. Each replica remembers only
+// the requests it served.
+type MemoryStore struct {
+	mu        sync.Mutex
+	records   map[string]*memoryRecord
+	lastSweep time.Time
+}
+
+type memoryRecord struct {
+	Record
+	createdAt time.Time
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]*memoryRecord)}
+}
+
+func (s *MemoryStore) Begin(ctx context.Context, key, fingerprint string, now time.Time, ttl time.Duration) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, r := range s.records {
+			if !now.Before(r.expiresAt) {
+				delete(s.records, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if r, ok := s.records[key]; ok && !r.reclaimable(now) {
+		record := r.Record
+		return &record, nil
+	}
+	s.records[key] = &memoryRecord{
+		Record:    Record{Fingerprint: fingerprint},
+		createdAt: now,
+		expiresAt: now.Add(ttl),
+	}
+	return nil, nil
+}
+
+// reclaimable reports whether the key may be claimed again by now.
+func (r *memoryRecord) reclaimable(now time.Time) bool {
+	return !now.Before(r.expiresAt) || (r.Response == nil && !now.Before(r.createdAt.Add(abandonAfter)))
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, key string, res Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.records[key]; ok {
+		r.Response = &res
+	}
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.records[key]; ok && r.Response == nil {
+		delete(s.records, key)
+	}
+	return nil
+}
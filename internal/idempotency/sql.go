@@ -0,0 +1,86 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// SQLStore keeps responses in the idempotency_keys table, so that a retry
+// reaching another replica of a Postgres deployment is still recognised. It
+// works on SQLite too.
+type SQLStore struct {
+	db *sql.DB
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) Begin(ctx context.Context, key, fingerprint string, now time.Time, ttl time.Duration) (*Record, error) {
+	// The update only takes over keys that have expired or were abandoned;
+	// when it does not, no row is affected and the earlier record stands
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (request_key, fingerprint, created_at, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (request_key) DO UPDATE SET fingerprint = excluded.fingerprint, status = NULL,
+			content_type = NULL, headers = NULL, body = NULL, created_at = excluded.created_at, expires_at = excluded.expires_at
+		WHERE idempotency_keys.expires_at <= $3
+			OR (idempotency_keys.status IS NULL AND idempotency_keys.created_at <= $5)`,
+		key, fingerprint, now.UnixNano(), now.Add(ttl).UnixNano(), now.Add(-abandonAfter).UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := res.RowsAffected()
+	if err != nil || claimed > 0 {
+		return nil, err
+	}
+
+	var record Record
+	var status sql.NullInt64
+	var contentType, header sql.NullString
+	var body []byte
+	err = s.db.QueryRowContext(ctx,
+		"SELECT fingerprint, status, content_type, headers, body FROM idempotency_keys WHERE request_key = $1",
+		key).Scan(&record.Fingerprint, &status, &contentType, &header, &body)
+	if err != nil {
+		return nil, err
+	}
+	if status.Valid {
+		record.Response = &Response{Status: int(status.Int64), ContentType: contentType.String, Body: body}
+		// Responses kept before headers were have none
+		if header.Valid {
+			if err := json.Unmarshal([]byte(header.String), &record.Response.Header); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &record, nil
+}
+
+func (s *SQLStore) Complete(ctx context.Context, key string, res Response) error {
+	header, err := json.Marshal(res.Header)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		"UPDATE idempotency_keys SET status = $2, content_type = $3, headers = $4, body = $5 WHERE request_key = $1",
+		key, res.Status, res.ContentType, string(header), res.Body)
+	return err
+}
+
+func (s *SQLStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx,
+		"DELETE FROM idempotency_keys WHERE request_key = $1 AND status IS NULL", key)
+	return err
+}
+
+// Prune deletes the keys that have expired by now and returns how many it
+// deleted.
+func (s *SQLStore) Prune(ctx context.Context, now time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= $1", now.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,70 @@
+// Package isbn validates International Standard Book Numbers.
+package isbn
+
+import (
+	"e-library-api/internal/errors"
+	"strings"
+)
+
+// Normalize validates an ISBN-10 or ISBN-13, ignoring hyphens and spaces,
+// and returns it as ISBN-13 digits so both forms of one edition compare equal.
+func Normalize(raw string) (string, error) {
+	digits := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(raw))
+	switch len(digits) {
+	case 10:
+		if !valid10(digits) {
+			return "", errors.ErrInvalidISBN
+		}
+		isbn13 := "978" + digits[:9]
+		return isbn13 + string(checkDigit13(isbn13)), nil
+	case 13:
+		if !allDigits(digits) || checkDigit13(digits[:12]) != digits[12] {
+			return "", errors.ErrInvalidISBN
+		}
+		return digits, nil
+	default:
+		return "", errors.ErrInvalidISBN
+	}
+}
+
+// valid10 checks the mod-11 checksum of an ISBN-10, whose last character may be X for 10.
+func valid10(digits string) bool {
+	if !allDigits(digits[:9]) {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(digits[i]-'0') * (10 - i)
+	}
+	switch last := digits[9]; {
+	case last == 'X':
+		sum += 10
+	case last >= '0' && last <= '9':
+		sum += int(last - '0')
+	default:
+		return false
+	}
+	return sum%11 == 0
+}
+
+// checkDigit13 computes the check digit for the first 12 digits of an ISBN-13.
+func checkDigit13(first12 string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(first12[i]-'0') * weight
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func allDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
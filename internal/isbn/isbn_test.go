@@ -0,0 +1,33 @@
+package isbn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range []struct {
+		raw  string
+		want string
+		ok   bool
+	}{
+		{"978-0-13-235088-4", "9780132350884", true},
+		{"0132350882", "9780132350884", true},
+		{"0-8044-2957-X", "9780804429573", true},
+		{"0-8044-2957-x", "9780804429573", true},
+		{"9780132350885", "", false},
+		{"0132350883", "", false},
+		{"01323508X2", "", false},
+		{"12345", "", false},
+		{"", "", false},
+	} {
+		got, err := Normalize(tc.raw)
+		if tc.ok {
+			assert.NoError(t, err, tc.raw)
+			assert.Equal(t, tc.want, got, tc.raw)
+		} else {
+			assert.Error(t, err, tc.raw)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"e-library-api/internal/auth"
+	"e-library-api/internal/errors"
+	"e-library-api/internal/problem"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Require lets a request through only if the caller's role grants perm. It
+// runs after Authenticate; requests it left unauthenticated, because no
+// credentials are configured, pass.
+func Require(perm auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal, ok := auth.FromContext(c.Request.Context()); ok && !principal.Can(perm) {
+			problem.Error(c, errors.ErrForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireOrSelf is Require for routes about one member, named by the param
+// path parameter: a member may always reach their own.
+func RequireOrSelf(perm auth.Permission, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := auth.FromContext(c.Request.Context())
+		if !ok || principal.Can(perm) {
+			c.Next()
+			return
+		}
+		id, err := strconv.ParseInt(c.Param(param), 10, 64)
+		if !principal.IsMember() || err != nil || id != principal.BorrowerID {
+			problem.Error(c, errors.ErrBorrowerMismatch)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"e-library-api/internal/auth"
+	"e-library-api/internal/errors"
+	"e-library-api/internal/problem"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Authenticate identifies the caller from an X-API-Key header or an
+// "Authorization: Bearer" member token and stores the principal in the
+// request context. Requests without valid credentials get a 401. When no
+// credentials are configured every request passes unauthenticated.
+func Authenticate(a *auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+
+		principal, err := authenticate(a, c.Request)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="e-library"`)
+			problem.Error(c, err)
+			return
+		}
+		c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), principal))
+		c.Next()
+	}
+}
+
+func authenticate(a *auth.Authenticator, r *http.Request) (*auth.Principal, error) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return a.APIKey(key)
+	}
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, errors.ErrUnauthenticated
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, errors.ErrInvalidCredentials
+	}
+	return a.Token(strings.TrimSpace(token), time.Now())
+}
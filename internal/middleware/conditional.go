@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"e-library-api/internal/errors"
+	"e-library-api/internal/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IfMatch guards routes that change a versioned resource. When required,
+// requests without an If-Match header naming the version they change are
+// refused with a 428, so that a client cannot overwrite a change it never
+// saw. Otherwise they pass, and change whatever version is current.
+func IfMatch(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if required && c.GetHeader("If-Match") == "" {
+			problem.Error(c, errors.ErrPreconditionRequired)
+			return
+		}
+		c.Next()
+	}
+}
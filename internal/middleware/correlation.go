@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"e-library-api/internal/correlation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Correlate gives every request a correlation ID: the one the caller sent in
+// the X-Correlation-ID header, if it is well formed, or a new one. The ID is
+// sent back in the same header, logged with the request and quoted in
+// problem responses, so a failure a client reports can be found in the logs.
+func Correlate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(correlation.Header)
+		if !correlation.Valid(id) {
+			id = correlation.New()
+		}
+		c.Header(correlation.Header, id)
+		c.Request = c.Request.WithContext(correlation.WithID(c.Request.Context(), id))
+		c.Next()
+	}
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecated marks the responses of routes kept only for older clients. They
+// carry a Deprecation header with the date the routes were superseded, a
+// Sunset header with the date they go away, and a Link to their successor.
+// successors is keyed by method and route ("POST /Borrow"); routes without
+// an entry are succeeded by the same path under /v1. A zero sunset is left
+// out.
+func Deprecated(since, sunset time.Time, successors map[string]string) gin.HandlerFunc {
+	deprecation := "@" + strconv.FormatInt(since.Unix(), 10)
+	return func(c *gin.Context) {
+		c.Header("Deprecation", deprecation)
+		if !sunset.IsZero() {
+			c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		successor, ok := successors[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			successor = "/v1" + c.Request.URL.Path
+		}
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
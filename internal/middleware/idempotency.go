@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"e-library-api/internal/errors"
+	"e-library-api/internal/idempotency"
+	"e-library-api/internal/problem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Idempotency lets clients retry POST requests safely. The first response to
+// a request carrying an Idempotency-Key is kept in store for ttl and sent
+// again, with its ETag and Location and an Idempotent-Replayed header, to
+// retries from the same caller with the same key. A key reused for a different request gets a 422, and a
+// retry arriving while the first request still runs a 409. Server errors,
+// and requests whose handler panicked, are not kept, so those requests can be
+// retried in full. Requests without a key, and every request when store is
+// nil, pass.
+func Idempotency(store idempotency.Store, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotency.Header)
+		if store == nil || key == "" || c.Request.Method != http.MethodPost {
+			c.Next()
+			return
+		}
+		if len(key) > idempotency.MaxKeyLength {
+			problem.Error(c, errors.Invalid("header."+idempotency.Header,
+				fmt.Sprintf("must be at most %d characters", idempotency.MaxKeyLength)))
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			problem.Error(c, err)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Keys are the caller's own; other callers may pick the same one
+		key = caller(c) + " " + key
+		fingerprint := idempotency.Fingerprint(c.Request.Method, c.Request.URL.Path, c.GetHeader("If-Match"), body)
+		record, err := store.Begin(c.Request.Context(), key, fingerprint, time.Now(), ttl)
+		if err != nil {
+			problem.Error(c, fmt.Errorf("%w: %v", errors.ErrStorageUnavailable, err))
+			return
+		}
+		if record != nil {
+			switch {
+			case record.Fingerprint != fingerprint:
+				problem.Error(c, errors.ErrIdempotencyKeyReused)
+			case record.Response == nil:
+				problem.Error(c, errors.ErrIdempotencyInProgress)
+			default:
+				for name, values := range record.Response.Header {
+					c.Writer.Header()[name] = values
+				}
+				c.Header(idempotency.ReplayedHeader, "true")
+				c.Data(record.Response.Status, record.Response.ContentType, record.Response.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		// The request is over, but what it did must still be recorded
+		ctx := context.WithoutCancel(c.Request.Context())
+		defer func() {
+			// A handler that panicked left no response to keep. Free the key so
+			// the request can be retried, and let Recovery answer it.
+			if recovered := recover(); recovered != nil {
+				if err := store.Release(ctx, key); err != nil {
+					log.Printf("Failed to release an idempotency key: %v", err)
+				}
+				panic(recovered)
+			}
+		}()
+
+		w := &responseWriter{body: bytes.NewBuffer(nil), ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		status := w.Status()
+		if status >= http.StatusInternalServerError || status == problem.StatusClientClosedRequest {
+			err = store.Release(ctx, key)
+		} else {
+			err = store.Complete(ctx, key, idempotency.Response{
+				Status:      status,
+				ContentType: w.Header().Get("Content-Type"),
+				Header:      replayedHeader(w.Header()),
+				Body:        w.body.Bytes(),
+			})
+		}
+		if err != nil {
+			log.Printf("Failed to record the response for an idempotency key: %v", err)
+		}
+	}
+}
+
+// replayedHeader picks the headers kept for replay out of a response's.
+func replayedHeader(header http.Header) http.Header {
+	kept := http.Header{}
+	for _, name := range idempotency.ReplayedHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			kept[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return kept
+}
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"bytes"
+	"e-library-api/internal/auth"
+	"e-library-api/internal/correlation"
 	"io"
 	"os"
 	"time"
@@ -39,9 +41,17 @@ func StructuredLogger() gin.HandlerFunc {
 
 		c.Next()
 
+		// Later middleware may have authenticated the caller
+		principal := ""
+		if p, ok := auth.FromContext(c.Request.Context()); ok {
+			principal = p.Kind + ":" + p.Subject
+		}
+
 		// Log everything to stdout as JSON
 		logger.Info().
 			Str("method", c.Request.Method).
+			Str("principal", principal).
+			Str("correlation_id", correlation.FromContext(c.Request.Context())).
 			Str("path", c.Request.URL.Path).
 			Int("status", c.Writer.Status()).
 			Str("duration", time.Since(start).String()).
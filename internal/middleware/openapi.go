@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"e-library-api/internal/errors"
+	"e-library-api/internal/openapi"
+	"e-library-api/internal/problem"
+	stdErrors "errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ValidateAPI rejects requests whose parameters or body do not match the
+// API document with a 400. Routes the document does not describe pass.
+// When onResponseError is set, responses are checked too and it is told
+// about those that do not match; they are still sent as they are.
+func ValidateAPI(spec *openapi.Spec, onResponseError func(c *gin.Context, err error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op := spec.Operation(c.Request.Method, c.FullPath())
+		if op == nil {
+			c.Next()
+			return
+		}
+		if err := op.ValidateRequest(c.Request, c.Param); err != nil {
+			problem.Error(c, invalidRequest(err))
+			return
+		}
+		if onResponseError == nil {
+			c.Next()
+			return
+		}
+
+		w := &responseWriter{body: bytes.NewBuffer(nil), ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		// Nobody reads the response to a request the client gave up on
+		if stdErrors.Is(c.Request.Context().Err(), context.Canceled) {
+			return
+		}
+		if err := op.ValidateResponse(w.Status(), w.Header().Get("Content-Type"), w.body.Bytes()); err != nil {
+			onResponseError(c, err)
+		}
+	}
+}
+
+// invalidRequest reports a request the document does not allow as a problem
+// with the offending field. Failures to read the body are left as they are.
+func invalidRequest(err error) error {
+	var invalid *openapi.ValidationError
+	if stdErrors.As(err, &invalid) {
+		return errors.Invalid(invalid.Path, invalid.Message)
+	}
+	return err
+}
@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"e-library-api/internal/problem"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovery turns a panic in a handler into a 500 problem response. gin logs
+// the panic and its stack first.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		problem.Error(c, fmt.Errorf("panic: %v", recovered))
+	})
+}
@@ -0,0 +1,302 @@
+// Package migrate applies the versioned schema migrations embedded in the
+// binary and records them in the schema_migrations table. Postgres and SQLite
+// each have their own set of migrations.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var embedded embed.FS
+
+// Dialects with embedded migrations, named after their DB_TYPE.
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// Migration is one schema change, read from a pair of files named
+// <version>_<name>.up.sql and <version>_<name>.down.sql.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a known migration and when it was applied, if it has been.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrations returns the migrations embedded in the binary for a dialect,
+// oldest first.
+func Migrations(dialect string) ([]Migration, error) {
+	if dialect != Postgres && dialect != SQLite {
+		return nil, fmt.Errorf("no migrations for database type %q", dialect)
+	}
+	sub, err := fs.Sub(embedded, "migrations/"+dialect)
+	if err != nil {
+		return nil, err
+	}
+	return Load(sub)
+}
+
+// Load reads the migrations from the root of fsys, oldest first. Every
+// version needs both an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, file := range files {
+		base, direction, ok := cutDirection(file)
+		if !ok {
+			return nil, fmt.Errorf("migration %s: name must end in .up.sql or .down.sql", file)
+		}
+		prefix, name, ok := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if !ok || err != nil || version <= 0 || name == "" {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_name.%s.sql", file, direction)
+		}
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, found := byVersion[version]
+		if !found {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, name)
+		}
+		dest := &m.Up
+		if direction == "down" {
+			dest = &m.Down
+		}
+		if *dest != "" {
+			return nil, fmt.Errorf("migration %d has more than one %s file", version, direction)
+		}
+		*dest = string(body)
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// cutDirection splits "0001_name.up.sql" into "0001_name" and "up".
+func cutDirection(file string) (string, string, bool) {
+	for _, direction := range []string{"up", "down"} {
+		if base, ok := strings.CutSuffix(file, "."+direction+".sql"); ok {
+			return base, direction, true
+		}
+	}
+	return "", "", false
+}
+
+// Migrator applies and rolls back migrations. On Postgres every operation
+// holds a session-level advisory lock, so replicas starting together apply
+// each migration exactly once; the others wait and then find nothing to do.
+// A SQLite database belongs to a single process and needs no lock.
+type Migrator struct {
+	DB         *sql.DB
+	Dialect    string
+	Migrations []Migration
+}
+
+// New returns a migrator for the dialect's embedded migrations.
+func New(db *sql.DB, dialect string) (*Migrator, error) {
+	migrations, err := Migrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Dialect: dialect, Migrations: migrations}, nil
+}
+
+// Up applies every pending migration in order and returns those it applied.
+// Each migration runs in its own transaction together with its
+// schema_migrations row, so a failed migration leaves no trace.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.Migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", mig.Version, mig.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the most recently applied migration and returns it, or
+// nil when no migration has been applied.
+func (m *Migrator) Down(ctx context.Context) (*Migration, error) {
+	var rolledBack *Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		var version int64
+		err := conn.QueryRowContext(ctx, "SELECT version FROM schema_migrations ORDER BY version DESC LIMIT 1").Scan(&version)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mig, ok := m.find(version)
+		if !ok {
+			return fmt.Errorf("migration %d is applied but unknown to this build", version)
+		}
+		err = inTx(ctx, conn, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", mig.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+		}
+		rolledBack = &mig
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Status lists every known migration, oldest first, with when it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.Migrations {
+			s := Status{Migration: mig}
+			if at, ok := done[mig.Version]; ok {
+				s.AppliedAt = &at
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, mig := range m.Migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+	return Migration{}, false
+}
+
+// locked runs fn on a dedicated connection holding the migration lock, if
+// the dialect has one, waiting for any other replica that holds it. The
+// schema_migrations table is created first if needed.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.Dialect == Postgres {
+		key := lockKey()
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			return err
+		}
+		defer func() {
+			// Use a fresh context: ctx may already be cancelled
+			if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+				log.Printf("Failed to release migration lock: %v", err)
+			}
+		}()
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		done[version] = at
+	}
+	return done, rows.Err()
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockKey is the advisory lock key shared by every replica running migrations.
+func lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte("e-library-migrate"))
+	return int64(h.Sum64())
+}
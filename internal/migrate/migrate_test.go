@@ -0,0 +1,80 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func file(body string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(body)}
+}
+
+func TestLoad_PairsAndSortsByVersion(t *testing.T) {
+	migrations, err := Load(fstest.MapFS{
+		"0010_later.up.sql":   file("CREATE TABLE b ();"),
+		"0010_later.down.sql": file("DROP TABLE b;"),
+		"0002_first.up.sql":   file("CREATE TABLE a ();"),
+		"0002_first.down.sql": file("DROP TABLE a;"),
+	})
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, Migration{Version: 2, Name: "first", Up: "CREATE TABLE a ();", Down: "DROP TABLE a;"}, migrations[0])
+	assert.Equal(t, int64(10), migrations[1].Version)
+	assert.Equal(t, "later", migrations[1].Name)
+}
+
+func TestLoad_RejectsMalformedSets(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"missing down": {
+			"0001_a.up.sql": file("SELECT 1;"),
+		},
+		"no direction": {
+			"0001_a.sql": file("SELECT 1;"),
+		},
+		"no version": {
+			"initial.up.sql":   file("SELECT 1;"),
+			"initial.down.sql": file("SELECT 1;"),
+		},
+		"name mismatch": {
+			"0001_a.up.sql":   file("SELECT 1;"),
+			"0001_b.down.sql": file("SELECT 1;"),
+		},
+		"duplicate version": {
+			"0001_a.up.sql":   file("SELECT 1;"),
+			"0001_a.down.sql": file("SELECT 1;"),
+			"01_a.up.sql":     file("SELECT 1;"),
+		},
+	}
+	for name, fsys := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(fsys)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMigrations_Embedded(t *testing.T) {
+	postgres, err := Migrations(Postgres)
+	require.NoError(t, err)
+	require.NotEmpty(t, postgres)
+	for i, m := range postgres {
+		assert.Equal(t, int64(i+1), m.Version, "versions should be consecutive")
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+
+	// Both dialects go through the same schema versions
+	sqlite, err := Migrations(SQLite)
+	require.NoError(t, err)
+	require.Len(t, sqlite, len(postgres))
+	for i, m := range sqlite {
+		assert.Equal(t, postgres[i].Version, m.Version)
+		assert.Equal(t, postgres[i].Name, m.Name)
+	}
+
+	_, err = Migrations("memory")
+	assert.Error(t, err)
+}
@@ -0,0 +1,5 @@
+DROP VIEW catalog;
+DROP TABLE items;
+DROP TABLE books;
+DROP FUNCTION books_search_vector();
+DROP TABLE works;
@@ -0,0 +1,73 @@
+-- A database set up by hand from the original README has a books table
+-- keyed by title and loans keyed by (borrower, title). Those tables are set
+-- aside under other names, and 0008_adopt_baseline moves their rows over.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = current_schema() AND table_name = 'books' AND column_name = 'available_copies') THEN
+        ALTER TABLE loans RENAME TO baseline_loans;
+        ALTER TABLE baseline_loans RENAME CONSTRAINT loans_pkey TO baseline_loans_pkey;
+        ALTER TABLE books RENAME TO baseline_books;
+        ALTER TABLE baseline_books RENAME CONSTRAINT books_pkey TO baseline_books_pkey;
+    END IF;
+END
+$$;
+
+-- A work is the abstract book; each row of books is one edition of it
+CREATE TABLE works (
+    id BIGSERIAL PRIMARY KEY,
+    title TEXT NOT NULL
+);
+
+CREATE TABLE books (
+    id BIGSERIAL PRIMARY KEY,
+    work_id BIGINT NOT NULL REFERENCES works(id),
+    title TEXT NOT NULL,
+    authors TEXT[] NOT NULL DEFAULT '{}',
+    description TEXT NOT NULL DEFAULT '',
+    isbn TEXT UNIQUE,
+    publisher TEXT NOT NULL DEFAULT '',
+    year INT NOT NULL DEFAULT 0,
+    language TEXT NOT NULL DEFAULT '',
+    subjects TEXT[] NOT NULL DEFAULT '{}',
+    format TEXT NOT NULL DEFAULT 'print',
+    category TEXT NOT NULL DEFAULT '',
+    digital BOOLEAN NOT NULL DEFAULT false,
+    search_vector TSVECTOR
+);
+CREATE INDEX books_work_idx ON books (work_id);
+-- Catalog browsing: partial title search and sorting by title
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX books_title_trgm_idx ON books USING gin (title gin_trgm_ops);
+CREATE INDEX books_title_idx ON books (title, id);
+
+-- Every copy of a print edition, or license of a digital one
+CREATE TABLE items (
+    id BIGSERIAL PRIMARY KEY,
+    book_id BIGINT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    barcode TEXT UNIQUE,
+    condition TEXT NOT NULL DEFAULT 'good',
+    status TEXT NOT NULL DEFAULT 'available'
+);
+CREATE INDEX items_book_idx ON items (book_id, status);
+
+-- Books as the API shows them, with their copies on the shelf counted
+CREATE VIEW catalog AS
+    SELECT b.*, (SELECT COUNT(*) FROM items i WHERE i.book_id = b.id AND i.status = 'available')::int AS available_copies
+    FROM books b;
+
+-- Full-text search: titles rank above authors, authors above subjects and
+-- descriptions
+CREATE FUNCTION books_search_vector() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('english', NEW.title), 'A') ||
+        setweight(to_tsvector('english', array_to_string(NEW.authors, ' ')), 'B') ||
+        setweight(to_tsvector('english', array_to_string(NEW.subjects, ' ')), 'C') ||
+        setweight(to_tsvector('english', NEW.description), 'C');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER books_search_vector_trg BEFORE INSERT OR UPDATE ON books
+    FOR EACH ROW EXECUTE FUNCTION books_search_vector();
+CREATE INDEX books_search_idx ON books USING gin (search_vector);
@@ -0,0 +1,10 @@
+-- Token buckets of the database rate limit store, shared by every replica.
+-- Times are Unix nanoseconds.
+CREATE TABLE rate_limit_buckets (
+    bucket TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at BIGINT NOT NULL,
+    -- When the bucket will have refilled; rows past it can be dropped
+    full_at BIGINT NOT NULL
+);
+CREATE INDEX rate_limit_buckets_full_idx ON rate_limit_buckets (full_at);
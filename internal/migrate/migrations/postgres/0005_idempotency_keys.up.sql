@@ -0,0 +1,14 @@
+-- Responses kept for requests sent with an Idempotency-Key, shared by every
+-- replica. Keys are scoped to the caller. Times are Unix nanoseconds.
+CREATE TABLE idempotency_keys (
+    request_key TEXT PRIMARY KEY,
+    -- Hash of the method, path and body of the request that claimed the key
+    fingerprint TEXT NOT NULL,
+    -- The response, NULL while the request is still running
+    status INTEGER,
+    content_type TEXT,
+    body BYTEA,
+    created_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL
+);
+CREATE INDEX idempotency_keys_expires_idx ON idempotency_keys (expires_at);
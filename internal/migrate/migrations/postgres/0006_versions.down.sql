@@ -0,0 +1,14 @@
+DROP TRIGGER books_search_vector_trg ON books;
+CREATE TRIGGER books_search_vector_trg BEFORE INSERT OR UPDATE ON books
+    FOR EACH ROW EXECUTE FUNCTION books_search_vector();
+
+DROP TRIGGER items_status_touch_book_trg ON items;
+DROP TRIGGER items_touch_book_trg ON items;
+DROP FUNCTION items_touch_book();
+
+DROP VIEW catalog;
+ALTER TABLE loans DROP COLUMN version;
+ALTER TABLE books DROP COLUMN version;
+CREATE VIEW catalog AS
+    SELECT b.*, (SELECT COUNT(*) FROM items i WHERE i.book_id = b.id AND i.status = 'available')::int AS available_copies
+    FROM books b;
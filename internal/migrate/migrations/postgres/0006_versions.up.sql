@@ -0,0 +1,32 @@
+-- Books and loans count their changes, so that clients can tell whether the
+-- copy they read is still current
+ALTER TABLE books ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE loans ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+
+-- The view lists the columns books had when it was created
+DROP VIEW catalog;
+CREATE VIEW catalog AS
+    SELECT b.*, (SELECT COUNT(*) FROM items i WHERE i.book_id = b.id AND i.status = 'available')::int AS available_copies
+    FROM books b;
+
+-- A book shows how many of its items are on the shelf, so adding, removing
+-- or lending an item changes the book
+CREATE FUNCTION items_touch_book() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        UPDATE books SET version = version + 1 WHERE id = OLD.book_id;
+    ELSE
+        UPDATE books SET version = version + 1 WHERE id = NEW.book_id;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER items_touch_book_trg AFTER INSERT OR DELETE ON items
+    FOR EACH ROW EXECUTE FUNCTION items_touch_book();
+CREATE TRIGGER items_status_touch_book_trg AFTER UPDATE OF status ON items
+    FOR EACH ROW WHEN (OLD.status IS DISTINCT FROM NEW.status) EXECUTE FUNCTION items_touch_book();
+
+-- Which is no reason to index the book again
+DROP TRIGGER books_search_vector_trg ON books;
+CREATE TRIGGER books_search_vector_trg BEFORE INSERT OR UPDATE OF title, authors, subjects, description ON books
+    FOR EACH ROW EXECUTE FUNCTION books_search_vector();
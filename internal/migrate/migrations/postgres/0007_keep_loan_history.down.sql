@@ -0,0 +1,4 @@
+ALTER TABLE loans DROP CONSTRAINT loans_item_id_fkey,
+    ADD CONSTRAINT loans_item_id_fkey FOREIGN KEY (item_id) REFERENCES items(id) ON DELETE CASCADE;
+ALTER TABLE loans DROP CONSTRAINT loans_book_id_fkey,
+    ADD CONSTRAINT loans_book_id_fkey FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE;
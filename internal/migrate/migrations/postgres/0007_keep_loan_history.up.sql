@@ -0,0 +1,6 @@
+-- Loans are the lending history, and outlive neither the book nor the item
+-- they were made against: deleting either is refused while loans refer to it
+ALTER TABLE loans DROP CONSTRAINT loans_book_id_fkey,
+    ADD CONSTRAINT loans_book_id_fkey FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE RESTRICT;
+ALTER TABLE loans DROP CONSTRAINT loans_item_id_fkey,
+    ADD CONSTRAINT loans_item_id_fkey FOREIGN KEY (item_id) REFERENCES items(id) ON DELETE RESTRICT;
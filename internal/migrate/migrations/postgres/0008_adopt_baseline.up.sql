@@ -0,0 +1,41 @@
+-- Moves the rows of the original README schema, set aside by 0001_catalog,
+-- into the tables that replaced it: each title becomes a work with one print
+-- edition, its copies become items, and each borrower name becomes a member
+-- with a placeholder email. Databases that never had it are left alone.
+DO $$
+BEGIN
+    IF to_regclass('baseline_books') IS NULL THEN
+        RETURN;
+    END IF;
+
+    CREATE TEMP TABLE baseline_titles ON COMMIT DROP AS
+        SELECT title, available_copies, nextval('works_id_seq') AS work_id, nextval('books_id_seq') AS book_id
+        FROM baseline_books;
+    INSERT INTO works (id, title) SELECT work_id, title FROM baseline_titles;
+    INSERT INTO books (id, work_id, title) SELECT book_id, work_id, title FROM baseline_titles;
+    INSERT INTO items (book_id, status)
+        SELECT t.book_id, 'available' FROM baseline_titles t, generate_series(1, t.available_copies);
+
+    CREATE TEMP TABLE baseline_members ON COMMIT DROP AS
+        SELECT name, nextval('borrowers_id_seq') AS borrower_id
+        FROM (SELECT DISTINCT borrower AS name FROM baseline_loans) names;
+    INSERT INTO borrowers (id, name, email, status, membership_expires_at)
+        SELECT borrower_id, name, 'member-' || borrower_id || '@baseline.invalid', 'active',
+            (now() AT TIME ZONE 'UTC') + interval '1 year'
+        FROM baseline_members;
+
+    -- The original schema counted copies out on loan nowhere, so each loan
+    -- brings an item of its own
+    CREATE TEMP TABLE baseline_lent ON COMMIT DROP AS
+        SELECT m.borrower_id, t.book_id, nextval('items_id_seq') AS item_id, l.loan_date, l.return_date
+        FROM baseline_loans l
+        JOIN baseline_titles t ON t.title = l.title
+        JOIN baseline_members m ON m.name = l.borrower;
+    INSERT INTO items (id, book_id, status) SELECT item_id, book_id, 'on_loan' FROM baseline_lent;
+    INSERT INTO loans (borrower_id, book_id, item_id, loan_date, return_date)
+        SELECT borrower_id, book_id, item_id, loan_date, return_date FROM baseline_lent;
+
+    DROP TABLE baseline_loans;
+    DROP TABLE baseline_books;
+END
+$$;
@@ -0,0 +1,5 @@
+DROP TABLE books_fts;
+DROP VIEW catalog;
+DROP TABLE items;
+DROP TABLE books;
+DROP TABLE works;
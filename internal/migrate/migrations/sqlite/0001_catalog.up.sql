@@ -0,0 +1,59 @@
+-- A work is the abstract book; each row of books is one edition of it
+CREATE TABLE works (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    title TEXT NOT NULL
+);
+
+-- authors and subjects are JSON arrays of strings
+CREATE TABLE books (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    work_id INTEGER NOT NULL REFERENCES works(id),
+    title TEXT NOT NULL,
+    authors TEXT NOT NULL DEFAULT '[]',
+    description TEXT NOT NULL DEFAULT '',
+    isbn TEXT UNIQUE,
+    publisher TEXT NOT NULL DEFAULT '',
+    year INTEGER NOT NULL DEFAULT 0,
+    language TEXT NOT NULL DEFAULT '',
+    subjects TEXT NOT NULL DEFAULT '[]',
+    format TEXT NOT NULL DEFAULT 'print',
+    category TEXT NOT NULL DEFAULT '',
+    digital BOOLEAN NOT NULL DEFAULT false
+);
+CREATE INDEX books_work_idx ON books (work_id);
+CREATE INDEX books_title_idx ON books (title, id);
+
+-- Every copy of a print edition, or license of a digital one
+CREATE TABLE items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    barcode TEXT UNIQUE,
+    condition TEXT NOT NULL DEFAULT 'good',
+    status TEXT NOT NULL DEFAULT 'available'
+);
+CREATE INDEX items_book_idx ON items (book_id, status);
+
+-- Books as the API shows them, with their copies on the shelf counted
+CREATE VIEW catalog AS
+    SELECT b.*, (SELECT COUNT(*) FROM items i WHERE i.book_id = b.id AND i.status = 'available') AS available_copies
+    FROM books b;
+
+-- Full-text search over the books table, kept in sync by triggers
+CREATE VIRTUAL TABLE books_fts USING fts5(
+    title, authors, subjects, description,
+    content = 'books', content_rowid = 'id', tokenize = 'porter unicode61'
+);
+CREATE TRIGGER books_fts_insert AFTER INSERT ON books BEGIN
+    INSERT INTO books_fts (rowid, title, authors, subjects, description)
+        VALUES (new.id, new.title, new.authors, new.subjects, new.description);
+END;
+CREATE TRIGGER books_fts_delete AFTER DELETE ON books BEGIN
+    INSERT INTO books_fts (books_fts, rowid, title, authors, subjects, description)
+        VALUES ('delete', old.id, old.title, old.authors, old.subjects, old.description);
+END;
+CREATE TRIGGER books_fts_update AFTER UPDATE ON books BEGIN
+    INSERT INTO books_fts (books_fts, rowid, title, authors, subjects, description)
+        VALUES ('delete', old.id, old.title, old.authors, old.subjects, old.description);
+    INSERT INTO books_fts (rowid, title, authors, subjects, description)
+        VALUES (new.id, new.title, new.authors, new.subjects, new.description);
+END;
@@ -0,0 +1,9 @@
+CREATE TABLE borrowers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL UNIQUE,
+    phone TEXT NOT NULL DEFAULT '',
+    tier TEXT NOT NULL DEFAULT 'standard',
+    status TEXT NOT NULL,
+    membership_expires_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,3 @@
+DROP TABLE fines;
+DROP TABLE holds;
+DROP TABLE loans;
@@ -0,0 +1,41 @@
+-- Timestamps are stored in UTC
+CREATE TABLE loans (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    borrower_id INTEGER NOT NULL REFERENCES borrowers(id),
+    book_id INTEGER NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    item_id INTEGER NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    loan_date TIMESTAMP NOT NULL,
+    return_date TIMESTAMP NOT NULL,
+    renewals INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'active',
+    returned_at TIMESTAMP,
+    returned_reason TEXT NOT NULL DEFAULT ''
+);
+-- A member can have only one active loan per book
+CREATE UNIQUE INDEX loans_active_idx ON loans (borrower_id, book_id) WHERE status = 'active';
+CREATE INDEX loans_borrower_idx ON loans (borrower_id, loan_date DESC);
+CREATE INDEX loans_book_idx ON loans (book_id, loan_date DESC);
+
+CREATE TABLE holds (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    borrower_id INTEGER NOT NULL REFERENCES borrowers(id),
+    book_id INTEGER NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    placed_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP,
+    -- The item set aside while the hold is ready
+    item_id INTEGER REFERENCES items(id) ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX holds_open_idx ON holds (borrower_id, book_id) WHERE status IN ('waiting', 'ready');
+CREATE INDEX holds_queue_idx ON holds (book_id, id) WHERE status = 'waiting';
+
+CREATE TABLE fines (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    borrower_id INTEGER NOT NULL REFERENCES borrowers(id),
+    kind TEXT NOT NULL,
+    amount_cents INTEGER NOT NULL CHECK (amount_cents > 0),
+    book_title TEXT NOT NULL DEFAULT '',
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX fines_borrower_idx ON fines (borrower_id);
@@ -0,0 +1,10 @@
+-- Token buckets of the database rate limit store.
+-- Times are Unix nanoseconds.
+CREATE TABLE rate_limit_buckets (
+    bucket TEXT PRIMARY KEY,
+    tokens REAL NOT NULL,
+    updated_at INTEGER NOT NULL,
+    -- When the bucket will have refilled; rows past it can be dropped
+    full_at INTEGER NOT NULL
+);
+CREATE INDEX rate_limit_buckets_full_idx ON rate_limit_buckets (full_at);
@@ -0,0 +1,19 @@
+DROP TRIGGER books_fts_update;
+CREATE TRIGGER books_fts_update AFTER UPDATE ON books BEGIN
+    INSERT INTO books_fts (books_fts, rowid, title, authors, subjects, description)
+        VALUES ('delete', old.id, old.title, old.authors, old.subjects, old.description);
+    INSERT INTO books_fts (rowid, title, authors, subjects, description)
+        VALUES (new.id, new.title, new.authors, new.subjects, new.description);
+END;
+
+DROP TRIGGER items_touch_book_update;
+DROP TRIGGER items_touch_book_delete;
+DROP TRIGGER items_touch_book_insert;
+
+-- The catalog view reads books.*, so it is set aside while the column goes
+DROP VIEW catalog;
+ALTER TABLE loans DROP COLUMN version;
+ALTER TABLE books DROP COLUMN version;
+CREATE VIEW catalog AS
+    SELECT b.*, (SELECT COUNT(*) FROM items i WHERE i.book_id = b.id AND i.status = 'available') AS available_copies
+    FROM books b;
@@ -0,0 +1,25 @@
+-- Books and loans count their changes, so that clients can tell whether the
+-- copy they read is still current
+ALTER TABLE books ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE loans ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- A book shows how many of its items are on the shelf, so adding, removing
+-- or lending an item changes the book
+CREATE TRIGGER items_touch_book_insert AFTER INSERT ON items BEGIN
+    UPDATE books SET version = version + 1 WHERE id = new.book_id;
+END;
+CREATE TRIGGER items_touch_book_delete AFTER DELETE ON items BEGIN
+    UPDATE books SET version = version + 1 WHERE id = old.book_id;
+END;
+CREATE TRIGGER items_touch_book_update AFTER UPDATE OF status ON items WHEN old.status IS NOT new.status BEGIN
+    UPDATE books SET version = version + 1 WHERE id = new.book_id;
+END;
+
+-- Which is no reason to index the book again
+DROP TRIGGER books_fts_update;
+CREATE TRIGGER books_fts_update AFTER UPDATE OF title, authors, subjects, description ON books BEGIN
+    INSERT INTO books_fts (books_fts, rowid, title, authors, subjects, description)
+        VALUES ('delete', old.id, old.title, old.authors, old.subjects, old.description);
+    INSERT INTO books_fts (rowid, title, authors, subjects, description)
+        VALUES (new.id, new.title, new.authors, new.subjects, new.description);
+END;
@@ -0,0 +1,20 @@
+CREATE TABLE loans_rebuilt (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    borrower_id INTEGER NOT NULL REFERENCES borrowers(id),
+    book_id INTEGER NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    item_id INTEGER NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    loan_date TIMESTAMP NOT NULL,
+    return_date TIMESTAMP NOT NULL,
+    renewals INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'active',
+    returned_at TIMESTAMP,
+    returned_reason TEXT NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1
+);
+INSERT INTO loans_rebuilt SELECT id, borrower_id, book_id, item_id, loan_date, return_date, renewals, status,
+    returned_at, returned_reason, version FROM loans;
+DROP TABLE loans;
+ALTER TABLE loans_rebuilt RENAME TO loans;
+CREATE UNIQUE INDEX loans_active_idx ON loans (borrower_id, book_id) WHERE status = 'active';
+CREATE INDEX loans_borrower_idx ON loans (borrower_id, loan_date DESC);
+CREATE INDEX loans_book_idx ON loans (book_id, loan_date DESC);
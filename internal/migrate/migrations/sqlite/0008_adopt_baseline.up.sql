@@ -0,0 +1,3 @@
+-- The original README schema was only ever set up on Postgres; SQLite
+-- databases start from 0001_catalog and have nothing to adopt
+SELECT 1;
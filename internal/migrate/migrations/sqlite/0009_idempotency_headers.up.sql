@@ -0,0 +1,3 @@
+-- Response headers replayed with the body, such as ETag, as a JSON object of
+-- header names to their values
+ALTER TABLE idempotency_keys ADD COLUMN headers TEXT;
@@ -4,14 +4,260 @@ import (
 	"time"
 )
 
+// Book formats. Print editions are lent as physical copies, the others are digital.
+const (
+	FormatPrint     = "print"
+	FormatEPUB      = "epub"
+	FormatPDF       = "pdf"
+	FormatAudiobook = "audiobook"
+)
+
+// BookDetail is one edition of a work, such as a paperback or an audiobook,
+// and the unit that is lent out. Editions are identified by ID, so several
+// editions may share a title.
 type BookDetail struct {
-	Title           string `json:"title" binding:"required"`
-	AvailableCopies int    `json:"available_copies"`
+	ID int64 `json:"id"`
+	// WorkID groups the editions of the same work. It is assigned when the
+	// edition is created: left empty, the edition starts a new work.
+	WorkID      int64    `json:"work_id"`
+	Title       string   `json:"title" binding:"required"`
+	Authors     []string `json:"authors"`
+	Description string   `json:"description"`
+	// ISBN is stored as ISBN-13; an ISBN-10 is accepted and converted
+	ISBN      string   `json:"isbn"`
+	Publisher string   `json:"publisher"`
+	Year      int      `json:"year" binding:"omitempty,gte=1,lte=9999"`
+	Language  string   `json:"language" binding:"omitempty,bcp47_language_tag"`
+	Subjects  []string `json:"subjects"`
+	Format    string   `json:"format" binding:"omitempty,oneof=print epub pdf audiobook"`
+	Category  string   `json:"category"`
+	// Digital is derived from the format
+	Digital bool `json:"digital"`
+	// AvailableCopies counts the book's items on the shelf. It is derived
+	// from the items and cannot be updated; a new book starts with this many
+	// items without barcodes.
+	AvailableCopies int `json:"available_copies" binding:"gte=0"`
+	// Version changes whenever the book does, including when its available
+	// copies change. It is sent as the ETag of the book and cannot be updated.
+	Version int64 `json:"version"`
+}
+
+// Item statuses. Only available items can be lent. A reserved item is set
+// aside for the borrower at the head of the hold queue.
+const (
+	ItemAvailable = "available"
+	ItemOnLoan    = "on_loan"
+	ItemReserved  = "reserved"
+	ItemLost      = "lost"
+	ItemWithdrawn = "withdrawn"
+)
+
+// Item conditions.
+const (
+	ConditionNew     = "new"
+	ConditionGood    = "good"
+	ConditionFair    = "fair"
+	ConditionPoor    = "poor"
+	ConditionDamaged = "damaged"
+)
+
+// Item is one lendable copy of a book: a physical copy or a license of a
+// digital edition. Loans are made against a specific item.
+type Item struct {
+	ID     int64 `json:"id"`
+	BookID int64 `json:"book_id"`
+	// Barcode is the copy's barcode, or the license ID of a digital item.
+	// It is optional but unique.
+	Barcode   string `json:"barcode"`
+	Condition string `json:"condition" binding:"omitempty,oneof=new good fair poor damaged"`
+	// Status can only be set to available or withdrawn; lending moves items
+	// through the other statuses
+	Status string `json:"status" binding:"omitempty,oneof=available withdrawn"`
+}
+
+// Work is a book independent of any edition, listed with all its editions.
+type Work struct {
+	ID       int64        `json:"id"`
+	Title    string       `json:"title"`
+	Editions []BookDetail `json:"editions"`
 }
 
 type LoanDetail struct {
-	NameOfBorrower string    `json:"name_of_borrower" binding:"required"`
-	BookTitle      string    `json:"book_title" binding:"required"`
+	ID int64 `json:"id"`
+	// BorrowerID may be left out of requests made with a member token
+	BorrowerID     int64     `json:"borrower_id"`
+	NameOfBorrower string    `json:"name_of_borrower"`
+	BookID         int64     `json:"book_id" binding:"required"`
+	BookTitle      string    `json:"book_title"`
+	ItemID         int64     `json:"item_id"`
+	Barcode        string    `json:"barcode,omitempty"`
 	LoanDate       time.Time `json:"loan_date"`
 	ReturnDate     time.Time `json:"return_date"`
+	Renewals       int       `json:"renewals"`
+	Status         string    `json:"status"`
+	// Set once the loan has ended
+	ReturnedAt     *time.Time `json:"returned_at,omitempty"`
+	ReturnedReason string     `json:"returned_reason,omitempty"`
+	// Version changes whenever the loan is renewed or ends. It is sent as
+	// the ETag of the loan.
+	Version int64 `json:"version"`
+}
+
+// Loan statuses. Ended loans are kept as the borrowing history of both the
+// member and the title.
+const (
+	LoanActive   = "active"
+	LoanReturned = "returned"
+	LoanLost     = "lost"
+)
+
+// Reasons a loan ended.
+const (
+	ReturnReasonReturned = "returned"
+	ReturnReasonExpired  = "expired"
+	ReturnReasonLost     = "lost"
+)
+
+// Page selects a window of a list using limit/offset pagination.
+type Page struct {
+	Limit  int `form:"limit" binding:"omitempty,gte=1,lte=100"`
+	Offset int `form:"offset" binding:"omitempty,gte=0"`
+}
+
+// DefaultPageLimit is used when a request does not specify a limit.
+const DefaultPageLimit = 20
+
+// LoanPage is one page of a loan history, newest first.
+type LoanPage struct {
+	Items  []LoanDetail `json:"items"`
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
+
+// Catalog sort orders. A leading "-" sorts descending; ties are broken by
+// title, then by ID.
+const (
+	SortTitle      = "title"
+	SortTitleDesc  = "-title"
+	SortCopies     = "available_copies"
+	SortCopiesDesc = "-available_copies"
+)
+
+// BookQuery filters and pages through the catalog. Cursor is the next_cursor
+// of the previous page and must be used with the same sort order.
+type BookQuery struct {
+	// Query matches any part of the title, ignoring case
+	Query     string `form:"q"`
+	Available *bool  `form:"available"`
+	Sort      string `form:"sort" binding:"omitempty,oneof=title -title available_copies -available_copies"`
+	Limit     int    `form:"limit" binding:"omitempty,gte=1,lte=100"`
+	Cursor    string `form:"cursor"`
+}
+
+// BookPage is one page of the catalog. NextCursor is empty on the last page.
+type BookPage struct {
+	Items      []BookDetail `json:"items"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// SearchQuery is a full-text search over titles, authors and descriptions.
+type SearchQuery struct {
+	Query string `form:"q" binding:"required"`
+	Limit int    `form:"limit" binding:"omitempty,gte=1,lte=100"`
+}
+
+// SearchResult is a book matching a search. Results with a higher score are
+// more relevant; scores are only comparable within one search.
+type SearchResult struct {
+	BookDetail
+	Score float64 `json:"score"`
+}
+
+// CopyAdjustment is the body of a PATCH request that adds (positive delta)
+// or withdraws (negative delta) copies of a book. Added items have no barcode;
+// withdrawn items are taken from the shelf, newest first.
+type CopyAdjustment struct {
+	Delta int `json:"delta" binding:"required"`
+}
+
+// Hold statuses. A hold waits in a FIFO queue per title until a copy is
+// returned, at which point it becomes ready and the copy is set aside for
+// the borrower until the pickup deadline.
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldFulfilled = "fulfilled"
+	HoldExpired   = "expired"
+)
+
+type HoldDetail struct {
+	ID             int64      `json:"id"`
+	BorrowerID     int64      `json:"borrower_id" binding:"required"`
+	NameOfBorrower string     `json:"name_of_borrower"`
+	BookID         int64      `json:"book_id" binding:"required"`
+	BookTitle      string     `json:"book_title"`
+	Status         string     `json:"status"`
+	PlacedAt       time.Time  `json:"placed_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	// ItemID is the item set aside while the hold is ready
+	ItemID int64 `json:"item_id,omitempty"`
+}
+
+// DefaultTier is assigned to members registered without a tier.
+const DefaultTier = "standard"
+
+// Membership statuses.
+const (
+	MemberActive    = "active"
+	MemberSuspended = "suspended"
+	MemberExpired   = "expired"
+)
+
+// Borrower is a registered library member. Loans and holds refer to the
+// member by ID, so the display name can change without losing history.
+type Borrower struct {
+	ID                  int64     `json:"id"`
+	Name                string    `json:"name" binding:"required"`
+	Email               string    `json:"email" binding:"required,email"`
+	Phone               string    `json:"phone"`
+	Tier                string    `json:"tier"`
+	Status              string    `json:"status" binding:"omitempty,oneof=active suspended expired"`
+	MembershipExpiresAt time.Time `json:"membership_expires_at"`
+}
+
+// IsActive reports whether the member may borrow at the given moment.
+func (b *Borrower) IsActive(now time.Time) bool {
+	return b.Status == MemberActive && now.Before(b.MembershipExpiresAt)
+}
+
+// Fine ledger entry kinds. Charges add to a member's balance; payments and
+// waivers reduce it.
+const (
+	FineCharge  = "charge"
+	FinePayment = "payment"
+	FineWaiver  = "waiver"
+)
+
+type FineEntry struct {
+	ID          int64     `json:"id"`
+	BorrowerID  int64     `json:"borrower_id"`
+	Kind        string    `json:"kind"`
+	AmountCents int64     `json:"amount_cents"`
+	BookTitle   string    `json:"book_title,omitempty"`
+	Note        string    `json:"note,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// FineAccount is a member's fines ledger together with the outstanding balance.
+type FineAccount struct {
+	BorrowerID   int64       `json:"borrower_id"`
+	BalanceCents int64       `json:"balance_cents"`
+	Entries      []FineEntry `json:"entries"`
+}
+
+// FineTransaction is the body of a payment or waiver request.
+type FineTransaction struct {
+	AmountCents int64  `json:"amount_cents" binding:"required,gt=0"`
+	Note        string `json:"note"`
 }
@@ -0,0 +1,332 @@
+// Package openapi serves the OpenAPI 3.1 document describing the API and
+// checks requests and responses against it.
+package openapi
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//go:embed openapi.json
+var document []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+//go:embed docs_unbundled.html
+var unbundledDocsPage []byte
+
+//go:generate curl -fsSL -o redoc/redoc.standalone.js https://cdn.redoc.ly/redoc/v2.1.5/bundles/redoc.standalone.js
+
+//go:embed redoc
+var redoc embed.FS
+
+// Document returns the OpenAPI document of the API.
+func Document() []byte {
+	return document
+}
+
+// DocsPage returns an HTML page that renders the document with the Redoc
+// bundle RedocScript returns. Builds without the bundle get a page saying so
+// instead, rather than one running a script fetched from elsewhere.
+func DocsPage() []byte {
+	if _, ok := RedocScript(); !ok {
+		return unbundledDocsPage
+	}
+	return docsPage
+}
+
+// RedocScript returns the Redoc bundle embedded in the binary, and false when
+// the build was made without it. go generate fetches it into the redoc
+// directory.
+func RedocScript() ([]byte, bool) {
+	script, err := redoc.ReadFile("redoc/redoc.standalone.js")
+	return script, err == nil
+}
+
+// Spec is a parsed OpenAPI document, indexed for checking traffic.
+type Spec struct {
+	// operations is keyed by method and route, with routes written the way
+	// gin registers them: "GET /books/:id"
+	operations map[string]*Operation
+}
+
+// Operation is one method of one path in the document.
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Parameters  []*Parameter         `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+	Security    []map[string]any     `json:"security"`
+}
+
+type Parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Ref     string               `json:"$ref"`
+	Content map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type components struct {
+	Schemas    map[string]*Schema    `json:"schemas"`
+	Parameters map[string]*Parameter `json:"parameters"`
+	Responses  map[string]*Response  `json:"responses"`
+}
+
+// Load parses the document embedded in the binary.
+func Load() (*Spec, error) {
+	return Parse(document)
+}
+
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Parse reads an OpenAPI document and resolves its local $refs.
+func Parse(data []byte) (*Spec, error) {
+	var doc struct {
+		Paths      map[string]map[string]*Operation `json:"paths"`
+		Components components                       `json:"components"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse OpenAPI document: %w", err)
+	}
+
+	c := doc.Components
+	for _, s := range c.Schemas {
+		if err := c.resolveSchema(s); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range c.Parameters {
+		if err := c.resolveSchema(p.Schema); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range c.Responses {
+		if err := c.resolveContent(r.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	spec := &Spec{operations: map[string]*Operation{}}
+	for path, item := range doc.Paths {
+		route := pathParam.ReplaceAllString(path, ":$1")
+		for method, op := range item {
+			if err := c.resolveOperation(op); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+			spec.operations[strings.ToUpper(method)+" "+route] = op
+		}
+	}
+	return spec, nil
+}
+
+func (c components) resolveOperation(op *Operation) error {
+	for i, p := range op.Parameters {
+		if p.Ref != "" {
+			resolved, ok := c.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+			if !ok {
+				return fmt.Errorf("unknown parameter %s", p.Ref)
+			}
+			op.Parameters[i] = resolved
+		} else if err := c.resolveSchema(p.Schema); err != nil {
+			return err
+		}
+	}
+	if op.RequestBody != nil {
+		if err := c.resolveContent(op.RequestBody.Content); err != nil {
+			return err
+		}
+	}
+	for status, r := range op.Responses {
+		if r.Ref != "" {
+			resolved, ok := c.Responses[strings.TrimPrefix(r.Ref, "#/components/responses/")]
+			if !ok {
+				return fmt.Errorf("unknown response %s", r.Ref)
+			}
+			op.Responses[status] = resolved
+		} else if err := c.resolveContent(r.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c components) resolveContent(content map[string]MediaType) error {
+	for _, m := range content {
+		if err := c.resolveSchema(m.Schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c components) resolveSchema(s *Schema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		resolved, ok := c.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("unknown schema %s", s.Ref)
+		}
+		s.resolved = resolved
+		return nil
+	}
+	for _, p := range s.Properties {
+		if err := c.resolveSchema(p); err != nil {
+			return err
+		}
+	}
+	for _, part := range s.AllOf {
+		if err := c.resolveSchema(part); err != nil {
+			return err
+		}
+	}
+	return c.resolveSchema(s.Items)
+}
+
+// Operation returns the operation of a method and gin route, or nil if the
+// document does not describe it.
+func (s *Spec) Operation(method, route string) *Operation {
+	return s.operations[method+" "+route]
+}
+
+// Routes lists the method and route of every operation, e.g. "GET /books/:id".
+func (s *Spec) Routes() []string {
+	routes := make([]string, 0, len(s.operations))
+	for route := range s.operations {
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// ValidateRequest checks the parameters and body of a request. param looks
+// up path parameters by name. The body is read and put back for the handler.
+func (op *Operation) ValidateRequest(r *http.Request, param func(string) string) error {
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var raw string
+		var present bool
+		switch p.In {
+		case "path":
+			raw, present = param(p.Name), true
+		case "query":
+			present = query.Has(p.Name)
+			raw = query.Get(p.Name)
+		case "header":
+			raw = r.Header.Get(p.Name)
+			present = raw != ""
+		}
+		if !present {
+			if p.Required {
+				return invalid(p.In+"."+p.Name, "is required")
+			}
+			continue
+		}
+		if err := p.Schema.validate(parameterValue(p.Schema, raw), p.In+"."+p.Name); err != nil {
+			return err
+		}
+	}
+
+	if op.RequestBody == nil {
+		return nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return invalid("body", "is required")
+		}
+		return nil
+	}
+	media, ok := op.RequestBody.Content["application/json"]
+	if !ok {
+		return nil
+	}
+	return validateJSON(media.Schema, body, "body")
+}
+
+// parameterValue converts a parameter to the JSON type its schema asks for,
+// so it is checked like a body value. Values that do not convert are left
+// as strings and fail the type check.
+func parameterValue(s *Schema, raw string) any {
+	for s.resolved != nil {
+		s = s.resolved
+	}
+	for _, t := range s.Type {
+		switch t {
+		case "integer", "number":
+			if _, err := strconv.ParseFloat(raw, 64); err == nil {
+				return json.Number(raw)
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(raw); err == nil {
+				return b
+			}
+		}
+	}
+	return raw
+}
+
+// ValidateResponse checks a response's status code and JSON body.
+func (op *Operation) ValidateResponse(status int, contentType string, body []byte) error {
+	r, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		r, ok = op.Responses[strconv.Itoa(status/100)+"XX"]
+	}
+	if !ok {
+		r, ok = op.Responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("status %d is not documented", status)
+	}
+	if len(r.Content) == 0 {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, ok := r.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("status %d: content type %q is not documented", status, contentType)
+	}
+	if media.Schema == nil {
+		return nil
+	}
+	return validateJSON(media.Schema, body, "response")
+}
+
+func validateJSON(s *Schema, data []byte, path string) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return invalid(path, "is not valid JSON")
+	}
+	return s.validate(v, path)
+}
@@ -0,0 +1,102 @@
+package openapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	spec, err := Load()
+	require.NoError(t, err)
+	assert.NotNil(t, spec.Operation("GET", "/books/:id"))
+	assert.Nil(t, spec.Operation("GET", "/books/{id}"))
+
+	_, err = Parse([]byte(`{"paths": {"/x": {"get": {"responses": {"200": {"$ref": "#/components/responses/Missing"}}}}}}`))
+	assert.Error(t, err)
+}
+
+const testDocument = `{
+  "paths": {
+    "/pets/{id}": {
+      "put": {
+        "parameters": [
+          {"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}},
+          {"name": "notify", "in": "query", "schema": {"type": "boolean"}}
+        ],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}},
+        "responses": {
+          "200": {"description": "", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}},
+          "204": {"description": ""},
+          "default": {"description": "", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Pet": {
+        "type": "object",
+        "required": ["name"],
+        "properties": {
+          "name": {"type": "string", "minLength": 1, "maxLength": 20},
+          "kind": {"type": "string", "enum": ["cat", "dog"]},
+          "age": {"type": "integer", "minimum": 0, "maximum": 40},
+          "tags": {"type": ["array", "null"], "items": {"type": "string"}},
+          "born": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Error": {"type": "object", "required": ["error"], "properties": {"error": {"type": "string"}}, "additionalProperties": false}
+    }
+  }
+}`
+
+func TestValidateRequest(t *testing.T) {
+	spec, err := Parse([]byte(testDocument))
+	require.NoError(t, err)
+	op := spec.Operation("PUT", "/pets/:id")
+	require.NotNil(t, op)
+
+	for _, tc := range []struct {
+		url, id, body string
+		want          string
+	}{
+		{"/pets/1?notify=true", "1", `{"name": "Rex", "kind": "dog", "age": 3, "tags": null, "born": "2020-01-02T03:04:05Z"}`, ""},
+		{"/pets/1", "1", `{"name": "Rex", "tags": ["good", "boy"], "owner": "Ann"}`, ""},
+		{"/pets/x", "x", `{"name": "Rex"}`, "path.id: must be integer"},
+		{"/pets/1?notify=often", "1", `{"name": "Rex"}`, "query.notify: must be boolean"},
+		{"/pets/1", "1", ``, "body: is required"},
+		{"/pets/1", "1", `{"name": "Rex"`, "body: is not valid JSON"},
+		{"/pets/1", "1", `{"kind": "cat"}`, "body.name: is required"},
+		{"/pets/1", "1", `{"name": ""}`, "body.name: must not be empty"},
+		{"/pets/1", "1", `{"name": "Rex the Wonder Dog of Elm Street"}`, "body.name: must be at most 20 characters"},
+		{"/pets/1", "1", `{"name": "Rex", "kind": "fish"}`, "body.kind: must be one of [cat dog]"},
+		{"/pets/1", "1", `{"name": "Rex", "age": 2.5}`, "body.age: must be integer"},
+		{"/pets/1", "1", `{"name": "Rex", "age": 41}`, "body.age: must be at most 40"},
+		{"/pets/1", "1", `{"name": "Rex", "tags": ["a", 1]}`, "body.tags[1]: must be string"},
+		{"/pets/1", "1", `{"name": "Rex", "born": "yesterday"}`, "body.born: must be an RFC 3339 date-time"},
+	} {
+		req, _ := http.NewRequest("PUT", tc.url, strings.NewReader(tc.body))
+		err := op.ValidateRequest(req, func(string) string { return tc.id })
+		if tc.want == "" {
+			assert.NoError(t, err, tc.body)
+		} else {
+			assert.EqualError(t, err, tc.want, tc.body)
+		}
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	spec, err := Parse([]byte(testDocument))
+	require.NoError(t, err)
+	op := spec.Operation("PUT", "/pets/:id")
+
+	assert.NoError(t, op.ValidateResponse(200, "application/json; charset=utf-8", []byte(`{"name": "Rex"}`)))
+	assert.NoError(t, op.ValidateResponse(204, "", nil))
+	assert.NoError(t, op.ValidateResponse(500, "application/json", []byte(`{"error": "boom"}`)))
+	assert.EqualError(t, op.ValidateResponse(404, "application/json", []byte(`{"error": "gone", "id": 1}`)), "response.id: is not allowed")
+	assert.Error(t, op.ValidateResponse(200, "text/plain", []byte(`Rex`)))
+}
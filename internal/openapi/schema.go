@@ -0,0 +1,174 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of JSON Schema the API document uses. A schema is
+// either a $ref to a component or a description of the value.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 types              `json:"type"`
+	Format               string             `json:"format"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	AllOf                []*Schema          `json:"allOf"`
+	Enum                 []any              `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            int                `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+
+	// resolved is the component a $ref points to, filled in by Parse
+	resolved *Schema
+}
+
+// types is a schema's "type", which may be one name or a list of them, as
+// in ["string", "null"].
+type types []string
+
+func (t *types) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = types{name}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	*t = names
+	return nil
+}
+
+// ValidationError is a value that does not match its schema. Path names
+// where the value is, e.g. "body.authors[1]".
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+func invalid(path, format string, args ...any) error {
+	return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// validate checks a value decoded with json.Decoder.UseNumber.
+func (s *Schema) validate(v any, path string) error {
+	if s.resolved != nil {
+		return s.resolved.validate(v, path)
+	}
+	for _, part := range s.AllOf {
+		if err := part.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if len(s.Type) > 0 && !s.Type.match(v) {
+		return invalid(path, "must be %s", strings.Join(s.Type, " or "))
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return fmt.Sprint(e) == fmt.Sprint(v) }) {
+		return invalid(path, "must be one of %v", s.Enum)
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		n, _ := new(big.Float).SetString(v.String())
+		if s.Minimum != nil && n.Cmp(big.NewFloat(*s.Minimum)) < 0 {
+			return invalid(path, "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && n.Cmp(big.NewFloat(*s.Maximum)) > 0 {
+			return invalid(path, "must be at most %v", *s.Maximum)
+		}
+	case string:
+		if len(v) < s.MinLength {
+			return invalid(path, "must not be empty")
+		}
+		if s.MaxLength != nil && len(v) > *s.MaxLength {
+			return invalid(path, "must be at most %d characters", *s.MaxLength)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				return invalid(path, "must be an RFC 3339 date-time")
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return invalid(path+"."+name, "is required")
+			}
+		}
+		// Check properties in a fixed order, so the first error is always the same
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return invalid(path+"."+name, "is not allowed")
+				}
+				continue
+			}
+			if err := prop.validate(v[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t types) match(v any) bool {
+	for _, name := range t {
+		switch v := v.(type) {
+		case nil:
+			if name == "null" {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case json.Number:
+			if name == "number" {
+				return true
+			}
+			if _, err := v.Int64(); err == nil && name == "integer" {
+				return true
+			}
+		case string:
+			if name == "string" {
+				return true
+			}
+		case []any:
+			if name == "array" {
+				return true
+			}
+		case map[string]any:
+			if name == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
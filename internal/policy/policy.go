@@ -0,0 +1,105 @@
+package policy
+
+import (
+	"e-library-api/internal/config"
+	"e-library-api/internal/errors"
+	"time"
+)
+
+// Policy holds the lending rules the service applies to loans and renewals.
+type Policy struct {
+	LoanPeriodDays int
+	ExtensionDays  int
+	// MaxConcurrentLoans caps the loans a member holds at once; zero means no limit
+	MaxConcurrentLoans int
+	// MaxRenewals caps how often a loan may be extended; zero means no limit
+	MaxRenewals int
+	// TierLoanDays overrides the loan period for members of a given tier
+	TierLoanDays map[string]int
+	// CategoryLoanDays overrides the loan period for books of a given category.
+	// It takes precedence over the member tier, since it describes the item itself.
+	CategoryLoanDays map[string]int
+	// FinePerDayCents is charged for every started day a book is returned late
+	FinePerDayCents int64
+	// MaxOutstandingFinesCents blocks new loans once a member owes more than
+	// this. Zero blocks anyone who owes anything at all.
+	MaxOutstandingFinesCents int64
+}
+
+// Default returns the library's standard rules: 4-week loans, 3-week
+// extensions, up to 5 books at a time, 2 renewals per loan, and a fine of
+// 25 cents per day late with borrowing blocked above 5.00 owed.
+func Default() Policy {
+	return Policy{
+		LoanPeriodDays:           28,
+		ExtensionDays:            21,
+		MaxConcurrentLoans:       5,
+		MaxRenewals:              2,
+		FinePerDayCents:          25,
+		MaxOutstandingFinesCents: 500,
+	}
+}
+
+func FromConfig(cfg *config.Config) Policy {
+	return Policy{
+		LoanPeriodDays:     cfg.LoanPeriodDays,
+		ExtensionDays:      cfg.ExtensionDays,
+		MaxConcurrentLoans: cfg.MaxConcurrentLoans,
+		MaxRenewals:        cfg.MaxRenewals,
+		TierLoanDays:       cfg.TierLoanDays,
+		CategoryLoanDays:   cfg.CategoryLoanDays,
+
+		FinePerDayCents:          cfg.FinePerDayCents,
+		MaxOutstandingFinesCents: cfg.MaxOutstandingFinesCents,
+	}
+}
+
+// LoanPeriod returns the number of days a member of the given tier may keep
+// a book of the given category.
+func (p Policy) LoanPeriod(tier, category string) int {
+	if days, ok := p.CategoryLoanDays[category]; ok && category != "" {
+		return days
+	}
+	if days, ok := p.TierLoanDays[tier]; ok && tier != "" {
+		return days
+	}
+	return p.LoanPeriodDays
+}
+
+// CheckBorrow rejects a new loan once the member already holds the maximum
+// number of concurrent loans or owes more than the fines threshold.
+func (p Policy) CheckBorrow(activeLoans int, finesOutstanding int64) error {
+	if p.MaxConcurrentLoans > 0 && activeLoans >= p.MaxConcurrentLoans {
+		return &errors.PolicyError{Err: errors.ErrLoanLimitReached, Limit: p.MaxConcurrentLoans}
+	}
+	if finesOutstanding > p.MaxOutstandingFinesCents {
+		return &errors.PolicyError{Err: errors.ErrFinesOutstanding, Limit: int(p.MaxOutstandingFinesCents)}
+	}
+	return nil
+}
+
+// FineFor returns the fine owed for a book due at dueDate and returned at
+// returnedAt. Every started day past the due date counts as a full day.
+func (p Policy) FineFor(dueDate, returnedAt time.Time) int64 {
+	if !returnedAt.After(dueDate) {
+		return 0
+	}
+	late := returnedAt.Sub(dueDate)
+	days := int64(late / (24 * time.Hour))
+	if late%(24*time.Hour) != 0 {
+		days++
+	}
+	return days * p.FinePerDayCents
+}
+
+// CheckRenewal rejects an extension once the loan has been renewed the
+// maximum number of times, or while other members are waiting for the book.
+func (p Policy) CheckRenewal(renewals int, holdsWaiting bool) error {
+	if holdsWaiting {
+		return errors.ErrRenewalBlockedByHold
+	}
+	if p.MaxRenewals > 0 && renewals >= p.MaxRenewals {
+		return &errors.PolicyError{Err: errors.ErrRenewalLimitReached, Limit: p.MaxRenewals}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+// Package problem answers failed requests with RFC 9457 problem details
+// (application/problem+json). Every error the API reports is mapped here,
+// once, to a status and a stable code clients can match on instead of the
+// message.
+package problem
+
+import (
+	"context"
+	"e-library-api/internal/correlation"
+	"e-library-api/internal/errors"
+	"encoding/json"
+	stdErrors "errors"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem responses.
+const ContentType = "application/problem+json"
+
+// typePrefix makes a problem type URI out of its code.
+const typePrefix = "urn:e-library:problem:"
+
+// StatusClientClosedRequest is logged for requests whose client went away
+// before they finished. Nobody is left to read a problem, so none is sent.
+const StatusClientClosedRequest = 499
+
+// Problem is a problem details object.
+type Problem struct {
+	// Type is a URI naming the kind of problem, and Title its summary. Both
+	// are the same for every occurrence of a kind.
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail explains this occurrence
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request that failed
+	Instance string `json:"instance,omitempty"`
+	// Code is the last part of Type, for clients that match on a word
+	Code string `json:"code"`
+	// CorrelationID is also in the X-Correlation-ID header and in the
+	// server's logs for the request
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// Errors lists the fields of an invalid request
+	Errors []errors.FieldError `json:"errors,omitempty"`
+	// Extensions are further members about this occurrence, such as when a
+	// book is available again
+	Extensions map[string]any `json:"-"`
+}
+
+// MarshalJSON writes the extensions as members of the problem itself.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	body, err := json.Marshal((*problem)(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return body, err
+	}
+	members := map[string]any{}
+	if err := json.Unmarshal(body, &members); err != nil {
+		return nil, err
+	}
+	extended := maps.Clone(p.Extensions)
+	maps.Copy(extended, members)
+	return json.Marshal(extended)
+}
+
+// kind is a problem type an error maps to.
+type kind struct {
+	err    error
+	status int
+	code   string
+}
+
+// kinds maps the errors of the API to problem types. Errors are matched
+// with errors.Is, in order. The codes are part of the API: they must not
+// change once published.
+var kinds = []kind{
+	{errors.ErrInvalidRequest, http.StatusBadRequest, "invalid_request"},
+	{errors.ErrInvalidCursor, http.StatusBadRequest, "invalid_cursor"},
+	{errors.ErrInvalidISBN, http.StatusBadRequest, "invalid_isbn"},
+
+	{errors.ErrUnauthenticated, http.StatusUnauthorized, "unauthenticated"},
+	{errors.ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+	{errors.ErrForbidden, http.StatusForbidden, "forbidden"},
+	{errors.ErrBorrowerMismatch, http.StatusForbidden, "borrower_mismatch"},
+	{errors.ErrBorrowerInactive, http.StatusForbidden, "borrower_inactive"},
+
+	{errors.ErrRouteNotFound, http.StatusNotFound, "route_not_found"},
+	{errors.ErrBookNotFound, http.StatusNotFound, "book_not_found"},
+	{errors.ErrWorkNotFound, http.StatusNotFound, "work_not_found"},
+	{errors.ErrItemNotFound, http.StatusNotFound, "item_not_found"},
+	{errors.ErrLoanNotFound, http.StatusNotFound, "loan_not_found"},
+	{errors.ErrBorrowerNotFound, http.StatusNotFound, "borrower_not_found"},
+	{errors.ErrConfigUnavailable, http.StatusNotFound, "config_unavailable"},
+
+	{errors.ErrBookExists, http.StatusConflict, "book_exists"},
+	{errors.ErrBookHasLoans, http.StatusConflict, "book_has_loans"},
+	{errors.ErrInvalidCopyCount, http.StatusConflict, "invalid_copy_count"},
+	{errors.ErrItemExists, http.StatusConflict, "item_exists"},
+	{errors.ErrItemInUse, http.StatusConflict, "item_in_use"},
+	{errors.ErrBorrowerExists, http.StatusConflict, "borrower_exists"},
+	{errors.ErrBorrowerHasLoans, http.StatusConflict, "borrower_has_loans"},
+	{errors.ErrNoCopies, http.StatusConflict, "no_copies"},
+	{errors.ErrCopiesAvailable, http.StatusConflict, "copies_available"},
+	{errors.ErrDuplicateLoan, http.StatusConflict, "duplicate_loan"},
+	{errors.ErrDuplicateHold, http.StatusConflict, "duplicate_hold"},
+	{errors.ErrLoanEnded, http.StatusConflict, "loan_ended"},
+	{errors.ErrLoanLimitReached, http.StatusConflict, "loan_limit_reached"},
+	{errors.ErrFinesOutstanding, http.StatusConflict, "fines_outstanding"},
+	{errors.ErrRenewalBlockedByHold, http.StatusConflict, "renewal_blocked_by_hold"},
+	{errors.ErrIdempotencyInProgress, http.StatusConflict, "idempotency_in_progress"},
+
+	{errors.ErrRenewalLimitReached, http.StatusUnprocessableEntity, "renewal_limit_reached"},
+	{errors.ErrAmountExceedsBalance, http.StatusUnprocessableEntity, "amount_exceeds_balance"},
+	{errors.ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, "idempotency_key_reused"},
+
+	{errors.ErrVersionMismatch, http.StatusPreconditionFailed, "version_mismatch"},
+	{errors.ErrPreconditionRequired, http.StatusPreconditionRequired, "precondition_required"},
+
+	{errors.ErrRateLimited, http.StatusTooManyRequests, "rate_limited"},
+	{errors.ErrStorageUnavailable, http.StatusServiceUnavailable, "storage_unavailable"},
+	{errors.ErrTimeout, http.StatusGatewayTimeout, "timeout"},
+}
+
+// Codes returns the code of every kind of problem.
+func Codes() []string {
+	codes := make([]string, 0, len(kinds)+1)
+	for _, k := range kinds {
+		codes = append(codes, k.code)
+	}
+	return append(codes, "internal")
+}
+
+// From describes err as a problem. Errors the API does not know are
+// internal server errors. The message of neither those nor any other server
+// error is shown to the client, as it may quote the database driver; the
+// detail of a known server error is its title.
+func From(err error) *Problem {
+	// A request that ran out of time fails with whatever its query said
+	if stdErrors.Is(err, context.DeadlineExceeded) {
+		err = errors.ErrTimeout
+	}
+	for _, k := range kinds {
+		if stdErrors.Is(err, k.err) {
+			p := newProblem(k.status, k.code, k.err.Error())
+			p.Detail = err.Error()
+			if k.status >= http.StatusInternalServerError {
+				p.Detail = p.Title
+			}
+			extend(p, err)
+			return p
+		}
+	}
+	return newProblem(http.StatusInternalServerError, "internal", "internal server error")
+}
+
+func newProblem(status int, code, title string) *Problem {
+	return &Problem{Type: typePrefix + code, Title: title, Status: status, Code: code}
+}
+
+// extend adds the fields typed errors carry about the occurrence.
+func extend(p *Problem, err error) {
+	var invalid *errors.InvalidRequestError
+	if stdErrors.As(err, &invalid) {
+		p.Errors = invalid.Fields
+	}
+	var noCopies *errors.NoCopiesError
+	if stdErrors.As(err, &noCopies) && !noCopies.AvailableAt.IsZero() {
+		p.Extensions = map[string]any{"available_at": noCopies.AvailableAt.UTC()}
+	}
+	var policy *errors.PolicyError
+	if stdErrors.As(err, &policy) {
+		p.Extensions = map[string]any{"limit": policy.Limit}
+	}
+	var limited *errors.RateLimitError
+	if stdErrors.As(err, &limited) {
+		p.Extensions = map[string]any{"retry_after": int64(math.Ceil(limited.RetryAfter.Seconds()))}
+	}
+}
+
+// Error answers the request with the problem err maps to and stops the
+// handler chain. Server errors are logged in full with the request's
+// correlation ID, so the client can quote it. Requests whose client went
+// away get no answer.
+func Error(c *gin.Context, err error) {
+	if stdErrors.Is(err, context.Canceled) {
+		c.AbortWithStatus(StatusClientClosedRequest)
+		return
+	}
+	p := From(err)
+	if p.Status >= http.StatusInternalServerError {
+		log.Printf("%s on %s %s [%s]: %v", http.StatusText(p.Status), c.Request.Method, c.Request.URL.Path,
+			correlation.FromContext(c.Request.Context()), err)
+	}
+	write(c, p)
+}
+
+// write answers the request with p and stops the handler chain. The
+// request's path and correlation ID are filled in.
+func write(c *gin.Context, p *Problem) {
+	p.Instance = c.Request.URL.Path
+	p.CorrelationID = correlation.FromContext(c.Request.Context())
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
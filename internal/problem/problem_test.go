@@ -0,0 +1,99 @@
+package problem
+
+import (
+	"context"
+	"e-library-api/internal/correlation"
+	"e-library-api/internal/errors"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrom(t *testing.T) {
+	due := time.Date(2026, time.November, 2, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		err        error
+		status     int
+		code       string
+		extensions map[string]any
+	}{
+		{errors.ErrBookNotFound, http.StatusNotFound, "book_not_found", nil},
+		{fmt.Errorf("lending: %w", errors.ErrDuplicateLoan), http.StatusConflict, "duplicate_loan", nil},
+		{&errors.NoCopiesError{AvailableAt: due}, http.StatusConflict, "no_copies", map[string]any{"available_at": due}},
+		{&errors.NoCopiesError{}, http.StatusConflict, "no_copies", nil},
+		{&errors.PolicyError{Err: errors.ErrLoanLimitReached, Limit: 5}, http.StatusConflict, "loan_limit_reached", map[string]any{"limit": 5}},
+		{&errors.RateLimitError{RetryAfter: 1500 * time.Millisecond}, http.StatusTooManyRequests, "rate_limited", map[string]any{"retry_after": int64(2)}},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "timeout", nil},
+	} {
+		p := From(tc.err)
+		assert.Equal(t, tc.status, p.Status, tc.code)
+		assert.Equal(t, tc.code, p.Code)
+		assert.Equal(t, "urn:e-library:problem:"+tc.code, p.Type)
+		assert.Equal(t, tc.extensions, p.Extensions, tc.code)
+	}
+
+	p := From(errors.Invalid("body.book_id", "is required"))
+	assert.Equal(t, http.StatusBadRequest, p.Status)
+	assert.Equal(t, []errors.FieldError{{Field: "body.book_id", Message: "is required"}}, p.Errors)
+	assert.Equal(t, "body.book_id: is required", p.Detail)
+
+	p = From(fmt.Errorf("%w: %v", errors.ErrStorageUnavailable, "dial tcp 10.0.0.5:5432: connection refused"))
+	assert.Equal(t, http.StatusServiceUnavailable, p.Status)
+	assert.Equal(t, p.Title, p.Detail, "server errors do not show what the driver said")
+
+	p = From(fmt.Errorf("pq: password authentication failed"))
+	assert.Equal(t, http.StatusInternalServerError, p.Status)
+	assert.Equal(t, "internal", p.Code)
+	assert.Empty(t, p.Detail, "internal errors are not shown to clients")
+}
+
+func TestCodesAreUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, code := range Codes() {
+		assert.False(t, seen[code], code)
+		seen[code] = true
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	p := From(&errors.PolicyError{Err: errors.ErrRenewalLimitReached, Limit: 2})
+	p.Extensions["code"] = "overridden"
+	body, err := json.Marshal(p)
+	require.NoError(t, err)
+	var members map[string]any
+	require.NoError(t, json.Unmarshal(body, &members))
+	assert.Equal(t, float64(2), members["limit"])
+	assert.Equal(t, "renewal_limit_reached", members["code"], "extensions do not replace standard members")
+	assert.Equal(t, float64(http.StatusUnprocessableEntity), members["status"])
+}
+
+func TestError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	serve := func(ctx context.Context, err error) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequestWithContext(correlation.WithID(ctx, "req-1"), "GET", "/v1/books/9", nil)
+		Error(c, err)
+		return w
+	}
+
+	w := serve(context.Background(), errors.ErrBookNotFound)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	var p Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, "/v1/books/9", p.Instance)
+	assert.Equal(t, "req-1", p.CorrelationID)
+	assert.Equal(t, "book not found", p.Title)
+
+	w = serve(context.Background(), context.Canceled)
+	assert.Equal(t, StatusClientClosedRequest, w.Code)
+	assert.Empty(t, w.Body.String())
+}
@@ -0,0 +1,140 @@
+// Package ratelimit keeps token buckets that limit how often one caller may
+// use one route. Buckets live in a Store: in memory for a single server, or
+// in the database when several replicas must share them.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests requests per Period, which may all come at once. A
+// caller who has used them up gets one more every Period/Requests. The zero
+// Limit allows everything.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Unlimited reports whether the limit allows everything.
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// rate is how many tokens a bucket regains per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// ParseLimit reads a limit written as <requests>/<period>, e.g. "10/m",
+// "600/h" or "5/30s". An empty string or "0" means no limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+	count, period, ok := strings.Cut(s, "/")
+	requests, err := strconv.Atoi(count)
+	if !ok || err != nil || requests < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, want e.g. 10/m", s)
+	}
+	// A bare unit stands for one of it
+	if period == "s" || period == "m" || period == "h" {
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit period in %q", s)
+	}
+	return Limit{Requests: requests, Period: d}, nil
+}
+
+// Limits are the limits of every route.
+type Limits struct {
+	// Default applies to routes without a limit of their own
+	Default Limit
+	// Routes maps "METHOD /path" or "/path", with the path as registered
+	// (e.g. "/books/:id"), to its limit
+	Routes map[string]Limit
+}
+
+// ParseLimits parses the default limit and the per-route limits.
+func ParseLimits(def string, routes map[string]string) (Limits, error) {
+	limits := Limits{Routes: map[string]Limit{}}
+	var err error
+	if limits.Default, err = ParseLimit(def); err != nil {
+		return Limits{}, err
+	}
+	for route, spec := range routes {
+		if limits.Routes[route], err = ParseLimit(spec); err != nil {
+			return Limits{}, fmt.Errorf("route %s: %w", route, err)
+		}
+	}
+	return limits, nil
+}
+
+// For returns the limit of a route. A limit for the method and path wins
+// over one for the path alone.
+func (l Limits) For(method, path string) Limit {
+	if limit, ok := l.Routes[method+" "+path]; ok {
+		return limit
+	}
+	if limit, ok := l.Routes[path]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+// Result is the state of a bucket after a request tried to take a token.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next token, when none was left
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Store keeps the buckets. Take refills the bucket named key for the time
+// since it was last used and takes a token from it if one is left.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// bucket is the state of one token bucket. A new bucket is full.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newBucket(limit Limit, now time.Time) bucket {
+	return bucket{tokens: float64(limit.Requests), updated: now}
+}
+
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	rate := limit.rate()
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Requests), b.tokens+elapsed.Seconds()*rate)
+		b.updated = now
+	}
+
+	result := Result{Limit: limit.Requests}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((float64(limit.Requests) - b.tokens) / rate)
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
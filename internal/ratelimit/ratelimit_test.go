@@ -0,0 +1,134 @@
+package ratelimit_test
+
+import (
+	"context"
+	"e-library-api/internal/migrate"
+	"e-library-api/internal/ratelimit"
+	"e-library-api/internal/repository"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+func TestParseLimit(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want ratelimit.Limit
+		ok   bool
+	}{
+		{"10/m", ratelimit.Limit{Requests: 10, Period: time.Minute}, true},
+		{"600/h", ratelimit.Limit{Requests: 600, Period: time.Hour}, true},
+		{"5/30s", ratelimit.Limit{Requests: 5, Period: 30 * time.Second}, true},
+		{"", ratelimit.Limit{}, true},
+		{"0", ratelimit.Limit{}, true},
+		{"10", ratelimit.Limit{}, false},
+		{"ten/m", ratelimit.Limit{}, false},
+		{"10/fortnight", ratelimit.Limit{}, false},
+		{"10/-1m", ratelimit.Limit{}, false},
+	} {
+		got, err := ratelimit.ParseLimit(tc.spec)
+		if tc.ok {
+			assert.NoError(t, err, tc.spec)
+			assert.Equal(t, tc.want, got, tc.spec)
+		} else {
+			assert.Error(t, err, tc.spec)
+		}
+	}
+}
+
+func TestLimitsFor(t *testing.T) {
+	limits, err := ratelimit.ParseLimits("100/m", map[string]string{
+		"POST /Hold": "5/m",
+		"/Hold":      "50/m",
+		"/books/:id": "0",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, limits.For("POST", "/Hold").Requests)
+	assert.Equal(t, 50, limits.For("GET", "/Hold").Requests)
+	assert.True(t, limits.For("GET", "/books/:id").Unlimited())
+	assert.Equal(t, 100, limits.For("GET", "/books").Requests)
+
+	_, err = ratelimit.ParseLimits("100/m", map[string]string{"/Hold": "lots"})
+	assert.Error(t, err)
+}
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) ratelimit.Store{
+		"Memory": func(t *testing.T) ratelimit.Store {
+			return ratelimit.NewMemoryStore()
+		},
+		"SQLite": func(t *testing.T) ratelimit.Store {
+			return newSQLStore(t)
+		},
+	}
+	limit := ratelimit.Limit{Requests: 3, Period: 3 * time.Second}
+	start := time.Unix(1_700_000_000, 0)
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			for i := 2; i >= 0; i-- {
+				res, err := store.Take(ctx, "alice", limit, start)
+				require.NoError(t, err)
+				assert.True(t, res.Allowed)
+				assert.Equal(t, i, res.Remaining)
+			}
+
+			res, err := store.Take(ctx, "alice", limit, start)
+			require.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Equal(t, time.Second, res.RetryAfter)
+			assert.Equal(t, 3*time.Second, res.Reset)
+
+			// Other callers have buckets of their own
+			res, err = store.Take(ctx, "bob", limit, start)
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+
+			// One token comes back every second
+			res, err = store.Take(ctx, "alice", limit, start.Add(time.Second))
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+			assert.Equal(t, 0, res.Remaining)
+
+			// and the bucket never holds more than the limit
+			res, err = store.Take(ctx, "alice", limit, start.Add(time.Hour))
+			require.NoError(t, err)
+			assert.Equal(t, 2, res.Remaining)
+		})
+	}
+}
+
+func TestSQLStore_Prune(t *testing.T) {
+	store := newSQLStore(t)
+	limit := ratelimit.Limit{Requests: 2, Period: time.Minute}
+	start := time.Unix(1_700_000_000, 0)
+
+	_, err := store.Take(ctx, "alice", limit, start)
+	require.NoError(t, err)
+	_, err = store.Take(ctx, "bob", limit, start.Add(time.Minute))
+	require.NoError(t, err)
+
+	// Alice's bucket has refilled after 30 seconds; Bob's has not
+	pruned, err := store.Prune(ctx, start.Add(time.Minute+time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pruned)
+}
+
+func newSQLStore(t *testing.T) *ratelimit.SQLStore {
+	db, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "library.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	m, err := migrate.New(db, migrate.SQLite)
+	require.NoError(t, err)
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+	return ratelimit.NewSQLStore(db)
+}
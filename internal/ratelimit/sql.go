@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SQLStore keeps buckets in the rate_limit_buckets table, so that every
+// replica of a Postgres deployment draws from the same buckets. It works on
+// SQLite too. Replicas' clocks are assumed to agree to within a second or so.
+type SQLStore struct {
+	db *sql.DB
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	// The no-op update locks an existing row until the transaction ends, so
+	// concurrent requests take their tokens one after the other
+	b := newBucket(limit, now)
+	var updated int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO rate_limit_buckets (bucket, tokens, updated_at, full_at) VALUES ($1, $2, $3, $3)
+		ON CONFLICT (bucket) DO UPDATE SET bucket = excluded.bucket
+		RETURNING tokens, updated_at`,
+		key, b.tokens, now.UnixNano()).Scan(&b.tokens, &updated)
+	if err != nil {
+		return Result{}, err
+	}
+	b.updated = time.Unix(0, updated)
+
+	result := b.take(limit, now)
+	_, err = tx.ExecContext(ctx,
+		"UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3, full_at = $4 WHERE bucket = $1",
+		key, b.tokens, b.updated.UnixNano(), now.Add(result.Reset).UnixNano())
+	if err != nil {
+		return Result{}, err
+	}
+	return result, tx.Commit()
+}
+
+// Prune deletes the buckets that have refilled by now and returns how many
+// it deleted.
+func (s *SQLStore) Prune(ctx context.Context, now time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE full_at <= $1", now.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
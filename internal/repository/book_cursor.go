@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"e-library-api/internal/errors"
+	"e-library-api/internal/models"
+	"encoding/base64"
+	"encoding/json"
+)
+
+// bookCursor is the position of the last book on a catalog page. It is handed
+// to clients as an opaque token.
+type bookCursor struct {
+	Sort   string `json:"s"`
+	Title  string `json:"t"`
+	Copies int    `json:"c"`
+	ID     int64  `json:"i"`
+}
+
+func encodeBookCursor(sort string, last models.BookDetail) string {
+	raw, _ := json.Marshal(bookCursor{Sort: sort, Title: last.Title, Copies: last.AvailableCopies, ID: last.ID})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeBookCursor returns nil for the first page. A cursor issued for a
+// different sort order is rejected, since its position means nothing there.
+func decodeBookCursor(query models.BookQuery) (*bookCursor, error) {
+	if query.Cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, errors.ErrInvalidCursor
+	}
+	var cursor bookCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.Sort != query.Sort {
+		return nil, errors.ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// book returns the position the cursor stands for.
+func (c *bookCursor) book() models.BookDetail {
+	return models.BookDetail{ID: c.ID, Title: c.Title, AvailableCopies: c.Copies}
+}
+
+// bookBefore reports whether a sorts before b in the given order.
+func bookBefore(sort string, a, b models.BookDetail) bool {
+	switch sort {
+	case models.SortTitleDesc:
+		return titleBefore(b, a)
+	case models.SortCopies:
+		if a.AvailableCopies != b.AvailableCopies {
+			return a.AvailableCopies < b.AvailableCopies
+		}
+		return titleBefore(a, b)
+	case models.SortCopiesDesc:
+		if a.AvailableCopies != b.AvailableCopies {
+			return a.AvailableCopies > b.AvailableCopies
+		}
+		return titleBefore(b, a)
+	default:
+		return titleBefore(a, b)
+	}
+}
+
+// titleBefore orders books by title, and editions sharing a title by ID.
+func titleBefore(a, b models.BookDetail) bool {
+	if a.Title != b.Title {
+		return a.Title < b.Title
+	}
+	return a.ID < b.ID
+}
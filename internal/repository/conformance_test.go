@@ -0,0 +1,68 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"e-library-api/internal/migrate"
+	"e-library-api/internal/repository"
+	"e-library-api/internal/repository/repotest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRepo_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.LibraryRepository {
+		return repository.NewMemoryRepo()
+	})
+}
+
+func TestDurableRepo_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.LibraryRepository {
+		repo, err := repository.OpenDurableRepo(t.TempDir())
+		require.NoError(t, err)
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	})
+}
+
+func TestSQLiteRepo_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.LibraryRepository {
+		db, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "library.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		migrateUp(t, db, migrate.SQLite)
+		return repository.NewSQLiteRepo(db)
+	})
+}
+
+// TestPostgresRepo_Conformance runs against the database in
+// TEST_DATABASE_URL. Every table is emptied before each test, so never point
+// it at a database holding real data.
+func TestPostgresRepo_Conformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.Ping())
+	migrateUp(t, db, migrate.Postgres)
+
+	repotest.Run(t, func(t *testing.T) repository.LibraryRepository {
+		_, err := db.Exec("TRUNCATE works, books, items, borrowers, loans, holds, fines RESTART IDENTITY CASCADE")
+		require.NoError(t, err)
+		return repository.NewPostgresRepo(db)
+	})
+}
+
+func migrateUp(t *testing.T, db *sql.DB, dialect string) {
+	t.Helper()
+	m, err := migrate.New(db, dialect)
+	require.NoError(t, err)
+	_, err = m.Up(context.Background())
+	require.NoError(t, err)
+}
@@ -0,0 +1,717 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"e-library-api/internal/models"
+	"encoding/json"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File names inside a DurableRepo's directory.
+const (
+	walFile      = "wal.jsonl"
+	snapshotFile = "snapshot.json"
+)
+
+// DurableRepo is a MemoryRepo that survives restarts. Every mutation is
+// appended to a write-ahead log and synced to disk before it is applied;
+// Snapshot writes the whole state and empties the log. On open the latest
+// snapshot is loaded and the log replayed on top of it.
+//
+// Reads go straight to the embedded MemoryRepo. Every mutating method of
+// LibraryRepository must be overridden here, or its changes are not logged.
+type DurableRepo struct {
+	*MemoryRepo
+
+	// mu serialises mutations, so the log records them in the order they
+	// were applied
+	mu  sync.Mutex
+	dir string
+	wal *os.File
+	// seq numbers the log records; snapshotSeq is the last record included
+	// in the snapshot on disk
+	seq         int64
+	snapshotSeq int64
+	// err is set once an append fails. The log may then end in part of a
+	// record, so further mutations are refused.
+	err error
+}
+
+// walRecord is one logged mutation: the repository method and the arguments
+// it was called with. Replaying the calls in order reproduces the state,
+// since MemoryRepo assigns IDs in sequence and takes every timestamp from
+// its arguments. A call that failed is followed by an abort record naming
+// its Seq in ID, and is not replayed.
+type walRecord struct {
+	Seq        int64              `json:"seq"`
+	Op         string             `json:"op"`
+	ID         int64              `json:"id,omitempty"`
+	BorrowerID int64              `json:"borrower_id,omitempty"`
+	BookID     int64              `json:"book_id,omitempty"`
+	Delta      int                `json:"delta,omitempty"`
+	Version    int64              `json:"version,omitempty"`
+	Amount     int64              `json:"amount,omitempty"`
+	At         time.Time          `json:"at"`
+	Until      time.Time          `json:"until"`
+	Book       *models.BookDetail `json:"book,omitempty"`
+	Item       *models.Item       `json:"item,omitempty"`
+	Loan       *models.LoanDetail `json:"loan,omitempty"`
+	Hold       *models.HoldDetail `json:"hold,omitempty"`
+	Borrower   *models.Borrower   `json:"borrower,omitempty"`
+	Fine       *models.FineEntry  `json:"fine,omitempty"`
+}
+
+// apply repeats the logged call against m.
+func (r *walRecord) apply(m *MemoryRepo) error {
+	// Replay runs at startup, outside any request
+	ctx := context.Background()
+	var err error
+	switch r.Op {
+	case opAbort:
+	case "CreateBook":
+		_, err = m.CreateBook(ctx, r.Book)
+	case "UpdateBook":
+		_, err = m.UpdateBook(ctx, r.ID, r.Book, r.Version)
+	case "AdjustCopies":
+		_, err = m.AdjustCopies(ctx, r.ID, r.Delta, r.Version)
+	case "DeleteBook":
+		err = m.DeleteBook(ctx, r.ID, r.Version)
+	case "AddItem":
+		_, err = m.AddItem(ctx, r.Item)
+	case "UpdateItem":
+		_, err = m.UpdateItem(ctx, r.ID, r.Item)
+	case "BorrowBook":
+		_, err = m.BorrowBook(ctx, r.Loan, r.Until, nil)
+	case "ExtendLoan":
+		_, err = m.ExtendLoan(ctx, r.BorrowerID, r.BookID, r.At, r.Version)
+	case "ReturnBook":
+		_, err = m.ReturnBook(ctx, r.BorrowerID, r.BookID, r.At, r.Until, r.Version, chargedFee(r.Amount))
+	case "ExpireDigitalLoans":
+		_, err = m.ExpireDigitalLoans(ctx, r.At, r.Until)
+	case "ExpireBookLoans":
+		m.expireBookLoans(r.BookID, r.At, r.Until)
+	case "MarkLoanLost":
+		_, err = m.MarkLoanLost(ctx, r.ID, r.At)
+	case "PurgeLoans":
+		_, err = m.PurgeLoans(ctx, r.At)
+	case "PlaceHold":
+		_, err = m.PlaceHold(ctx, r.Hold)
+	case "ExpireHolds":
+		_, err = m.ExpireHolds(ctx, r.At, r.Until)
+	case "PurgeHolds":
+		_, err = m.PurgeHolds(ctx, r.At)
+	case "CreateBorrower":
+		_, err = m.CreateBorrower(ctx, r.Borrower)
+	case "UpdateBorrower":
+		_, err = m.UpdateBorrower(ctx, r.ID, r.Borrower)
+	case "DeleteBorrower":
+		err = m.deleteBorrowerAndHistory(r.ID)
+	case "AddFineEntry":
+		_, err = m.AddFineEntry(ctx, r.Fine)
+	default:
+		err = fmt.Errorf("unknown operation %q", r.Op)
+	}
+	return err
+}
+
+// opAbort marks the record whose Seq is in ID as a call that failed.
+const opAbort = "Abort"
+
+// memorySnapshot is the full state of a MemoryRepo as of a log record.
+type memorySnapshot struct {
+	Seq            int64                          `json:"seq"`
+	Books          map[int64]*models.BookDetail   `json:"books"`
+	NextBookID     int64                          `json:"next_book_id"`
+	Works          map[int64]*models.Work         `json:"works"`
+	NextWorkID     int64                          `json:"next_work_id"`
+	Items          map[int64][]*models.Item       `json:"items"`
+	NextItemID     int64                          `json:"next_item_id"`
+	Loans          map[int64][]models.LoanDetail  `json:"loans"`
+	NextLoanID     int64                          `json:"next_loan_id"`
+	History        []models.LoanDetail            `json:"history"`
+	Holds          map[int64][]*models.HoldDetail `json:"holds"`
+	NextHoldID     int64                          `json:"next_hold_id"`
+	Borrowers      map[int64]*models.Borrower     `json:"borrowers"`
+	NextBorrowerID int64                          `json:"next_borrower_id"`
+	Fines          []models.FineEntry             `json:"fines"`
+	NextFineID     int64                          `json:"next_fine_id"`
+}
+
+// OpenDurableRepo loads the repository kept in dir, creating the directory
+// if needed. A new directory starts from the same seed data as NewMemoryRepo.
+func OpenDurableRepo(dir string) (*DurableRepo, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	d := &DurableRepo{dir: dir}
+
+	snap, err := readSnapshot(filepath.Join(dir, snapshotFile))
+	switch {
+	case stdErrors.Is(err, fs.ErrNotExist):
+		d.MemoryRepo = NewMemoryRepo()
+	case err != nil:
+		return nil, fmt.Errorf("reading snapshot: %w", err)
+	default:
+		d.MemoryRepo = snap.restore()
+		d.seq = snap.Seq
+		d.snapshotSeq = snap.Seq
+	}
+
+	if d.wal, err = os.OpenFile(filepath.Join(dir, walFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+		return nil, err
+	}
+	if err := d.replay(); err != nil {
+		d.wal.Close()
+		return nil, fmt.Errorf("replaying %s: %w", walFile, err)
+	}
+	return d, nil
+}
+
+func readSnapshot(path string) (*memorySnapshot, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap memorySnapshot
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// replay applies the log records newer than the snapshot, except those of
+// failed calls. A record cut short by a crash while it was being written was
+// never acknowledged, so it is dropped from the end of the log. So is a last
+// record that fails without an abort record: the crash came before the
+// abort was written.
+func (d *DurableRepo) replay() error {
+	type logged struct {
+		rec    walRecord
+		offset int64
+	}
+	var records []logged
+	aborted := map[int64]bool{}
+	r := bufio.NewReader(d.wal)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("Dropping incomplete record at the end of %s", walFile)
+				if err := d.wal.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("record after seq %d: %w", d.seq, err)
+		}
+		if rec.Op == opAbort {
+			aborted[rec.ID] = true
+		}
+		records = append(records, logged{rec, offset})
+		offset += int64(len(line))
+	}
+
+	for i, l := range records {
+		// Records up to the snapshot are left over from a crash while it was taken
+		if l.rec.Seq <= d.seq {
+			continue
+		}
+		if !aborted[l.rec.Seq] {
+			if err := l.rec.apply(d.MemoryRepo); err != nil {
+				if i < len(records)-1 {
+					return fmt.Errorf("seq %d %s: %w", l.rec.Seq, l.rec.Op, err)
+				}
+				log.Printf("Dropping failed call at the end of %s: seq %d %s: %v", walFile, l.rec.Seq, l.rec.Op, err)
+				return d.wal.Truncate(l.offset)
+			}
+		}
+		d.seq = l.rec.Seq
+	}
+	return nil
+}
+
+// mutateIf logs rec and syncs the log, then runs apply. validate, unless
+// nil, runs first: the call is logged and applied only if it reports true
+// without an error, so calls that are refused, or have nothing to do, leave
+// no record. It may fill in rec with what it found. Mutations are
+// serialised, so the state validate saw is the one apply changes. Should
+// apply fail all the same, an abort record is logged after rec, so that
+// replay skips it.
+func (d *DurableRepo) mutateIf(rec *walRecord, validate func() (bool, error), apply func() error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+	if validate != nil {
+		if ok, err := validate(); !ok || err != nil {
+			return err
+		}
+	}
+	rec.Seq = d.seq + 1
+	if err := d.append(*rec); err != nil {
+		return err
+	}
+	if err := d.wal.Sync(); err != nil {
+		d.err = fmt.Errorf("write-ahead log: %w", err)
+		return d.err
+	}
+	d.seq = rec.Seq
+
+	if err := apply(); err != nil {
+		// The abort need not be synced: if it is lost in a crash, nothing
+		// follows rec in the log and replay drops it as the last record
+		abort := walRecord{Seq: d.seq + 1, Op: opAbort, ID: rec.Seq}
+		if aerr := d.append(abort); aerr != nil {
+			return fmt.Errorf("%w (aborting seq %d: %w)", err, rec.Seq, aerr)
+		}
+		d.seq = abort.Seq
+		return err
+	}
+	return nil
+}
+
+// checked is validate for a MemoryRepo check, which it runs under the read
+// lock: the call goes ahead only if the check passes.
+func (d *DurableRepo) checked(check func() error) func() (bool, error) {
+	return func() (bool, error) {
+		d.MemoryRepo.RLock()
+		defer d.MemoryRepo.RUnlock()
+		err := check()
+		return err == nil, err
+	}
+}
+
+// append writes rec at the end of the log.
+func (d *DurableRepo) append(rec walRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := d.wal.Write(append(line, '\n')); err != nil {
+		d.err = fmt.Errorf("write-ahead log: %w", err)
+		return d.err
+	}
+	return nil
+}
+
+// Snapshot writes the current state to disk and empties the log. It does
+// nothing when nothing changed since the last snapshot.
+func (d *DurableRepo) Snapshot() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+	if d.seq == d.snapshotSeq {
+		return nil
+	}
+
+	raw, err := json.Marshal(d.MemoryRepo.snapshot(d.seq))
+	if err != nil {
+		return err
+	}
+	// Write a new file and rename it over the old one, so a crash leaves
+	// either snapshot intact
+	tmp := filepath.Join(d.dir, snapshotFile+".tmp")
+	if err := writeFileSync(tmp, raw); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(d.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if err := syncDir(d.dir); err != nil {
+		return err
+	}
+	d.snapshotSeq = d.seq
+
+	// Should truncating fail, replay skips the records the snapshot holds
+	if err := d.wal.Truncate(0); err != nil {
+		return err
+	}
+	return d.wal.Sync()
+}
+
+// Close takes a final snapshot and closes the log.
+func (d *DurableRepo) Close() error {
+	err := d.Snapshot()
+	if cerr := d.wal.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, bytes.NewReader(data)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir makes a rename inside dir durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// snapshot copies the state as of log record seq.
+func (m *MemoryRepo) snapshot(seq int64) *memorySnapshot {
+	m.RLock()
+	defer m.RUnlock()
+	// Encoding happens after the lock is released, so the snapshot must not
+	// share anything a later mutation could change; a JSON round trip is the
+	// simplest deep copy
+	snap := &memorySnapshot{
+		Seq:            seq,
+		Books:          m.Books,
+		NextBookID:     m.nextBookID,
+		Works:          m.Works,
+		NextWorkID:     m.nextWorkID,
+		Items:          m.Items,
+		NextItemID:     m.nextItemID,
+		Loans:          m.Loans,
+		NextLoanID:     m.nextLoanID,
+		History:        m.History,
+		Holds:          m.Holds,
+		NextHoldID:     m.nextHoldID,
+		Borrowers:      m.Borrowers,
+		NextBorrowerID: m.nextBorrowerID,
+		Fines:          m.Fines,
+		NextFineID:     m.nextFineID,
+	}
+	raw, _ := json.Marshal(snap)
+	var copied memorySnapshot
+	_ = json.Unmarshal(raw, &copied)
+	return &copied
+}
+
+// restore builds a repository from the snapshot, rebuilding the indexes.
+// Books and loans from snapshots taken before versions were kept start at
+// version 1, as they do in the database.
+func (s *memorySnapshot) restore() *MemoryRepo {
+	m := newMemoryRepo()
+	for id, b := range s.Books {
+		b.Version = max(b.Version, 1)
+		m.Books[id] = b
+		m.indexBook(b)
+	}
+	for id, w := range s.Works {
+		m.Works[id] = w
+	}
+	for id, items := range s.Items {
+		m.Items[id] = items
+	}
+	for id, loans := range s.Loans {
+		for i := range loans {
+			loans[i].Version = max(loans[i].Version, 1)
+		}
+		m.Loans[id] = loans
+	}
+	for id, holds := range s.Holds {
+		m.Holds[id] = holds
+	}
+	for id, b := range s.Borrowers {
+		m.Borrowers[id] = b
+	}
+	m.History = s.History
+	for i := range m.History {
+		m.History[i].Version = max(m.History[i].Version, 1)
+	}
+	m.Fines = s.Fines
+	m.nextBookID = s.NextBookID
+	m.nextWorkID = s.NextWorkID
+	m.nextItemID = s.NextItemID
+	m.nextLoanID = s.NextLoanID
+	m.nextHoldID = s.NextHoldID
+	m.nextBorrowerID = s.NextBorrowerID
+	m.nextFineID = s.NextFineID
+	return m
+}
+
+func (d *DurableRepo) CreateBook(ctx context.Context, book *models.BookDetail) (*models.BookDetail, error) {
+	var result *models.BookDetail
+	validate := d.checked(func() error { return d.MemoryRepo.checkNewBook(book) })
+	err := d.mutateIf(&walRecord{Op: "CreateBook", Book: book}, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.CreateBook(ctx, book)
+		return err
+	})
+	return result, err
+}
+
+func (d *DurableRepo) UpdateBook(ctx context.Context, id int64, book *models.BookDetail, version int64) (*models.BookDetail, error) {
+	var result *models.BookDetail
+	validate := d.checked(func() error { return d.MemoryRepo.checkBookUpdate(id, book, version) })
+	err := d.mutateIf(&walRecord{Op: "UpdateBook", ID: id, Book: book, Version: version}, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.UpdateBook(ctx, id, book, version)
+		return err
+	})
+	return result, err
+}
+
+func (d *DurableRepo) AdjustCopies(ctx context.Context, id int64, delta int, version int64) (*models.BookDetail, error) {
+	var result *models.BookDetail
+	validate := d.checked(func() error { return d.MemoryRepo.checkCopies(id, delta, version) })
+	err := d.mutateIf(&walRecord{Op: "AdjustCopies", ID: id, Delta: delta, Version: version}, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.AdjustCopies(ctx, id, delta, version)
+		return err
+	})
+	return result, err
+}
+
+func (d *DurableRepo) DeleteBook(ctx context.Context, id, version int64) error {
+	validate := d.checked(func() error { return d.MemoryRepo.checkBookDelete(id, version) })
+	return d.mutateIf(&walRecord{Op: "DeleteBook", ID: id, Version: version}, validate, func() error {
+		return d.MemoryRepo.DeleteBook(ctx, id, version)
+	})
+}
+
+func (d *DurableRepo) AddItem(ctx context.Context, item *models.Item) (*models.Item, error) {
+	var result *models.Item
+	validate := d.checked(func() error { return d.MemoryRepo.checkNewItem(item) })
+	err := d.mutateIf(&walRecord{Op: "AddItem", Item: item}, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.AddItem(ctx, item)
+		return err
+	})
+	return result, err
+}
+
+func (d *DurableRepo) UpdateItem(ctx context.Context, id int64, item *models.Item) (*models.Item, error) {
+	var result *models.Item
+	validate := d.checked(func() error { return d.MemoryRepo.checkItemUpdate(id, item) })
+	err := d.mutateIf(&walRecord{Op: "UpdateItem", ID: id, Item: item}, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.UpdateItem(ctx, id, item)
+		return err
+	})
+	return result, err
+}
+
+// BorrowBook logs only loans that passed check, so they are replayed
+// without it. The book's e-book loans that ran out are closed by a record of
+// their own first, since that stands even when the borrow then fails.
+func (d *DurableRepo) BorrowBook(ctx context.Context, loan *models.LoanDetail, pickupDeadline time.Time, check BorrowCheck) (*models.LoanDetail, error) {
+	now := loan.LoanDate
+	due := func() (bool, error) {
+		return d.MemoryRepo.anyActiveLoan(func(b *models.BookDetail, l models.LoanDetail) bool {
+			return b.ID == loan.BookID && loanExpired(b, l, now)
+		}), nil
+	}
+	rec := walRecord{Op: "ExpireBookLoans", BookID: loan.BookID, At: now, Until: pickupDeadline}
+	err := d.mutateIf(&rec, due, func() error {
+		d.MemoryRepo.expireBookLoans(loan.BookID, now, pickupDeadline)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result *models.LoanDetail
+	validate := d.checked(func() error {
+		if err := d.MemoryRepo.checkBorrower(loan.BorrowerID, now, check); err != nil {
+			return err
+		}
+		return d.MemoryRepo.checkBorrow(loan)
+	})
+	err = d.mutateIf(&walRecord{Op: "BorrowBook", Loan: loan, Until: pickupDeadline}, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.BorrowBook(ctx, loan, pickupDeadline, nil)
+		return err
+	})
+	return result, err
+}
+
+func (d *DurableRepo) ExtendLoan(ctx context.Context, borrowerID, bookID int64, newReturnDate time.Time, version int64) (*models.LoanDetail, error) {
+	var result *models.LoanDetail
+	rec := walRecord{Op: "ExtendLoan", BorrowerID: borrowerID, BookID: bookID, At: newReturnDate, Version: version}
+	validate := d.checked(func() error {
+		_, err := d.MemoryRepo.findLoan(borrowerID, bookID, version)
+		return err
+	})
+	err := d.mutateIf(&rec, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.ExtendLoan(ctx, borrowerID, bookID, newReturnDate, version)
+		return err
+	})
+	return result, err
+}
+
+// ReturnBook logs the late fee as fee priced it, so that replay charges the
+// same amount whatever the fee schedule is by then.
+func (d *DurableRepo) ReturnBook(ctx context.Context, borrowerID, bookID int64, returnedAt, pickupDeadline time.Time, version int64, fee LateFee) (*models.FineEntry, error) {
+	var result *models.FineEntry
+	rec := walRecord{Op: "ReturnBook", BorrowerID: borrowerID, BookID: bookID, At: returnedAt, Until: pickupDeadline, Version: version}
+	validate := func() (bool, error) {
+		var err error
+		rec.Amount, err = d.MemoryRepo.lateFee(borrowerID, bookID, version, returnedAt, fee)
+		return err == nil, err
+	}
+	err := d.mutateIf(&rec, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.ReturnBook(ctx, borrowerID, bookID, returnedAt, pickupDeadline, version, chargedFee(rec.Amount))
+		return err
+	})
+	return result, err
+}
+
+// chargedFee is a LateFee that comes to the amount already priced. Records
+// logged before returns charged their fee carry none, and are followed by an
+// AddFineEntry record of their own instead.
+func chargedFee(amount int64) LateFee {
+	return func(time.Time, time.Time) int64 { return amount }
+}
+
+// ExpireDigitalLoans, like the other sweeps, is only logged when it has
+// something to do, as sweeps run often and mostly find nothing.
+func (d *DurableRepo) ExpireDigitalLoans(ctx context.Context, now, pickupDeadline time.Time) (int, error) {
+	var expired int
+	due := func() (bool, error) {
+		return d.MemoryRepo.anyActiveLoan(func(b *models.BookDetail, l models.LoanDetail) bool { return loanExpired(b, l, now) }), nil
+	}
+	err := d.mutateIf(&walRecord{Op: "ExpireDigitalLoans", At: now, Until: pickupDeadline}, due, func() error {
+		var err error
+		expired, err = d.MemoryRepo.ExpireDigitalLoans(ctx, now, pickupDeadline)
+		return err
+	})
+	return expired, err
+}
+
+func (d *DurableRepo) MarkLoanLost(ctx context.Context, id int64, at time.Time) (*models.LoanDetail, error) {
+	var result *models.LoanDetail
+	validate := d.checked(func() error {
+		_, _, err := d.MemoryRepo.findLoanByID(id)
+		return err
+	})
+	err := d.mutateIf(&walRecord{Op: "MarkLoanLost", ID: id, At: at}, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.MarkLoanLost(ctx, id, at)
+		return err
+	})
+	return result, err
+}
+
+func (d *DurableRepo) PurgeLoans(ctx context.Context, before time.Time) (int, error) {
+	var purged int
+	due := func() (bool, error) {
+		return d.MemoryRepo.anyEndedLoan(func(l models.LoanDetail) bool { return loanPurgeable(l, before) }), nil
+	}
+	err := d.mutateIf(&walRecord{Op: "PurgeLoans", At: before}, due, func() error {
+		var err error
+		purged, err = d.MemoryRepo.PurgeLoans(ctx, before)
+		return err
+	})
+	return purged, err
+}
+
+func (d *DurableRepo) PlaceHold(ctx context.Context, hold *models.HoldDetail) (*models.HoldDetail, error) {
+	var result *models.HoldDetail
+	validate := d.checked(func() error { return d.MemoryRepo.checkHold(hold) })
+	err := d.mutateIf(&walRecord{Op: "PlaceHold", Hold: hold}, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.PlaceHold(ctx, hold)
+		return err
+	})
+	return result, err
+}
+
+func (d *DurableRepo) ExpireHolds(ctx context.Context, now, pickupDeadline time.Time) (int, error) {
+	var expired int
+	due := func() (bool, error) {
+		return d.MemoryRepo.anyHold(func(h *models.HoldDetail) bool { return holdExpired(h, now) }), nil
+	}
+	err := d.mutateIf(&walRecord{Op: "ExpireHolds", At: now, Until: pickupDeadline}, due, func() error {
+		var err error
+		expired, err = d.MemoryRepo.ExpireHolds(ctx, now, pickupDeadline)
+		return err
+	})
+	return expired, err
+}
+
+func (d *DurableRepo) PurgeHolds(ctx context.Context, before time.Time) (int, error) {
+	var purged int
+	due := func() (bool, error) {
+		return d.MemoryRepo.anyHold(func(h *models.HoldDetail) bool { return holdPurgeable(h, before) }), nil
+	}
+	err := d.mutateIf(&walRecord{Op: "PurgeHolds", At: before}, due, func() error {
+		var err error
+		purged, err = d.MemoryRepo.PurgeHolds(ctx, before)
+		return err
+	})
+	return purged, err
+}
+
+func (d *DurableRepo) CreateBorrower(ctx context.Context, borrower *models.Borrower) (*models.Borrower, error) {
+	var result *models.Borrower
+	validate := d.checked(func() error { return d.MemoryRepo.checkBorrowerDetails(0, borrower) })
+	err := d.mutateIf(&walRecord{Op: "CreateBorrower", Borrower: borrower}, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.CreateBorrower(ctx, borrower)
+		return err
+	})
+	return result, err
+}
+
+func (d *DurableRepo) UpdateBorrower(ctx context.Context, id int64, borrower *models.Borrower) (*models.Borrower, error) {
+	var result *models.Borrower
+	validate := d.checked(func() error { return d.MemoryRepo.checkBorrowerDetails(id, borrower) })
+	err := d.mutateIf(&walRecord{Op: "UpdateBorrower", ID: id, Borrower: borrower}, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.UpdateBorrower(ctx, id, borrower)
+		return err
+	})
+	return result, err
+}
+
+func (d *DurableRepo) DeleteBorrower(ctx context.Context, id int64) error {
+	validate := d.checked(func() error { return d.MemoryRepo.checkBorrowerDelete(id) })
+	return d.mutateIf(&walRecord{Op: "DeleteBorrower", ID: id}, validate, func() error {
+		return d.MemoryRepo.DeleteBorrower(ctx, id)
+	})
+}
+
+func (d *DurableRepo) AddFineEntry(ctx context.Context, entry *models.FineEntry) (*models.FineEntry, error) {
+	var result *models.FineEntry
+	validate := d.checked(func() error { return d.MemoryRepo.checkFine(entry) })
+	err := d.mutateIf(&walRecord{Op: "AddFineEntry", Fine: entry}, validate, func() error {
+		var err error
+		result, err = d.MemoryRepo.AddFineEntry(ctx, entry)
+		return err
+	})
+	return result, err
+}
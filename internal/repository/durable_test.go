@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"context"
+	"e-library-api/internal/errors"
+	"e-library-api/internal/models"
+	stdErrors "errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ctx is passed to repository calls made directly by the tests
+var ctx = context.Background()
+
+func addBorrower(t *testing.T, repo LibraryRepository, name string) *models.Borrower {
+	t.Helper()
+	b, err := repo.CreateBorrower(ctx, &models.Borrower{
+		Name:                name,
+		Email:               name + "@example.com",
+		Tier:                models.DefaultTier,
+		Status:              models.MemberActive,
+		MembershipExpiresAt: time.Now().AddDate(1, 0, 0),
+	})
+	require.NoError(t, err)
+	return b
+}
+
+func newLoan(borrower *models.Borrower, book *models.BookDetail) *models.LoanDetail {
+	now := time.Now()
+	return &models.LoanDetail{
+		BorrowerID: borrower.ID,
+		BookID:     book.ID,
+		LoanDate:   now,
+		ReturnDate: now.AddDate(0, 0, 14),
+	}
+}
+
+func openDurable(t *testing.T, dir string) *DurableRepo {
+	t.Helper()
+	repo, err := OpenDurableRepo(dir)
+	require.NoError(t, err)
+	return repo
+}
+
+func TestDurableRepo_ReplaysLogAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	repo := openDurable(t, dir)
+
+	book, err := repo.CreateBook(ctx, &models.BookDetail{Title: "Refactoring", Authors: []string{"Martin Fowler"}, AvailableCopies: 1})
+	require.NoError(t, err)
+	alice := addBorrower(t, repo, "alice")
+	loan, err := repo.BorrowBook(ctx, newLoan(alice, book), time.Now(), nil)
+	require.NoError(t, err)
+	extended, err := repo.ExtendLoan(ctx, alice.ID, book.ID, loan.ReturnDate.AddDate(0, 0, 7), loan.Version)
+	require.NoError(t, err)
+
+	// Simulate a crash: the log is never snapshotted or closed cleanly
+	require.NoError(t, repo.wal.Close())
+	_, err = os.Stat(filepath.Join(dir, snapshotFile))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	repo = openDurable(t, dir)
+	defer repo.Close()
+	got, err := repo.GetLoan(ctx, alice.ID, book.ID, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, loan.ID, got.ID)
+	assert.True(t, extended.ReturnDate.Equal(got.ReturnDate))
+	assert.Equal(t, extended.Version, got.Version, "versions come back with the loans")
+
+	results, err := repo.SearchBooks(ctx, "fowler", 10)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, book.ID, results[0].ID)
+
+	_, err = repo.BorrowBook(ctx, newLoan(addBorrower(t, repo, "bob"), book), time.Now(), nil)
+	assert.ErrorIs(t, err, errors.ErrNoCopies)
+}
+
+func TestDurableRepo_SnapshotCompactsLog(t *testing.T) {
+	dir := t.TempDir()
+	repo := openDurable(t, dir)
+
+	book, err := repo.CreateBook(ctx, &models.BookDetail{Title: "Domain-Driven Design", AvailableCopies: 2})
+	require.NoError(t, err)
+	alice := addBorrower(t, repo, "alice")
+	_, err = repo.BorrowBook(ctx, newLoan(alice, book), time.Now(), nil)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.Snapshot())
+	info, err := os.Stat(filepath.Join(dir, walFile))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	// Changes after the snapshot come back from the log, late fee and all
+	now := time.Now()
+	fee := func(time.Time, time.Time) int64 { return 40 }
+	_, err = repo.ReturnBook(ctx, alice.ID, book.ID, now, now.Add(time.Hour), 0, fee)
+	require.NoError(t, err)
+	bob := addBorrower(t, repo, "bob")
+	require.NoError(t, repo.wal.Close())
+
+	repo = openDurable(t, dir)
+	defer repo.Close()
+	_, err = repo.GetLoan(ctx, alice.ID, book.ID, time.Now())
+	assert.ErrorIs(t, err, errors.ErrLoanNotFound)
+	balance, err := repo.FineBalance(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(40), balance)
+	got, err := repo.GetBorrower(ctx, bob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", got.Name)
+	book, err = repo.GetBook(ctx, book.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, book.AvailableCopies)
+
+	// IDs carry on from the restored counters
+	carol := addBorrower(t, repo, "carol")
+	assert.Greater(t, carol.ID, bob.ID)
+}
+
+func TestDurableRepo_DropsTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	repo := openDurable(t, dir)
+	alice := addBorrower(t, repo, "alice")
+	require.NoError(t, repo.wal.Close())
+
+	// A record cut short mid-write, as if the process died during the append
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"seq":2,"op":"CreateBorr`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	repo = openDurable(t, dir)
+	_, err = repo.GetBorrower(ctx, alice.ID)
+	require.NoError(t, err)
+	bob := addBorrower(t, repo, "bob")
+	require.NoError(t, repo.wal.Close())
+
+	repo = openDurable(t, dir)
+	defer repo.Close()
+	_, err = repo.GetBorrower(ctx, bob.ID)
+	assert.NoError(t, err)
+}
+
+func TestDurableRepo_SkipsFailedCalls(t *testing.T) {
+	dir := t.TempDir()
+	repo := openDurable(t, dir)
+	book, err := repo.CreateBook(ctx, &models.BookDetail{Title: "Middlemarch", AvailableCopies: 1})
+	require.NoError(t, err)
+	alice := addBorrower(t, repo, "alice")
+	bob := addBorrower(t, repo, "bob")
+	_, err = repo.BorrowBook(ctx, newLoan(alice, book), time.Now(), nil)
+	require.NoError(t, err)
+
+	walSize := func() int64 {
+		info, err := os.Stat(filepath.Join(dir, walFile))
+		require.NoError(t, err)
+		return info.Size()
+	}
+	size := walSize()
+	// Refused calls, and sweeps with nothing to do, are not logged
+	refused := stdErrors.New("refused")
+	_, err = repo.BorrowBook(ctx, newLoan(bob, book), time.Now(), func(int, int64) error { return refused })
+	assert.ErrorIs(t, err, refused)
+	_, err = repo.BorrowBook(ctx, newLoan(bob, book), time.Now(), nil)
+	assert.ErrorIs(t, err, errors.ErrNoCopies)
+	assert.ErrorIs(t, repo.DeleteBook(ctx, book.ID, 0), errors.ErrBookHasLoans)
+	assert.ErrorIs(t, repo.DeleteBorrower(ctx, 999), errors.ErrBorrowerNotFound)
+	_, err = repo.ExpireHolds(ctx, time.Now(), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, size, walSize())
+
+	// A call that fails once logged all the same is marked aborted, so
+	// replay skips it
+	err = repo.mutateIf(&walRecord{Op: "DeleteBorrower", ID: 999}, nil, func() error {
+		return repo.MemoryRepo.DeleteBorrower(ctx, 999)
+	})
+	assert.ErrorIs(t, err, errors.ErrBorrowerNotFound)
+	assert.Greater(t, walSize(), size)
+	carol := addBorrower(t, repo, "carol")
+	require.NoError(t, repo.wal.Close())
+
+	repo = openDurable(t, dir)
+	defer repo.Close()
+	_, err = repo.GetBorrower(ctx, carol.ID)
+	assert.NoError(t, err)
+	_, err = repo.GetLoan(ctx, bob.ID, book.ID, time.Now())
+	assert.ErrorIs(t, err, errors.ErrLoanNotFound)
+}
+
+func TestDurableRepo_ReplaysDeletesThatTookHistory(t *testing.T) {
+	dir := t.TempDir()
+	repo := openDurable(t, dir)
+	book, err := repo.CreateBook(ctx, &models.BookDetail{Title: "Emma", AvailableCopies: 1})
+	require.NoError(t, err)
+	alice := addBorrower(t, repo, "alice")
+	_, err = repo.BorrowBook(ctx, newLoan(alice, book), time.Now(), nil)
+	require.NoError(t, err)
+	now := time.Now()
+	_, err = repo.ReturnBook(ctx, alice.ID, book.ID, now, now.Add(time.Hour), 0, nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, repo.DeleteBorrower(ctx, alice.ID), errors.ErrBorrowerHasLoans)
+
+	// Logged the way deletes were carried out before ended loans kept a member
+	err = repo.mutateIf(&walRecord{Op: "DeleteBorrower", ID: alice.ID}, nil, func() error {
+		return repo.MemoryRepo.deleteBorrowerAndHistory(alice.ID)
+	})
+	require.NoError(t, err)
+	bob := addBorrower(t, repo, "bob")
+	require.NoError(t, repo.wal.Close())
+
+	repo = openDurable(t, dir)
+	defer repo.Close()
+	_, err = repo.GetBorrower(ctx, alice.ID)
+	assert.ErrorIs(t, err, errors.ErrBorrowerNotFound)
+	_, err = repo.GetBorrower(ctx, bob.ID)
+	assert.NoError(t, err)
+}
+
+func TestDurableRepo_DropsFailedLastCall(t *testing.T) {
+	dir := t.TempDir()
+	repo := openDurable(t, dir)
+	alice := addBorrower(t, repo, "alice")
+	require.NoError(t, repo.wal.Close())
+
+	// A call that failed, logged without its abort as if the process died first
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"seq":2,"op":"DeleteBorrower","id":999,"at":"0001-01-01T00:00:00Z","until":"0001-01-01T00:00:00Z"}` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	repo = openDurable(t, dir)
+	_, err = repo.GetBorrower(ctx, alice.ID)
+	require.NoError(t, err)
+	bob := addBorrower(t, repo, "bob")
+	require.NoError(t, repo.wal.Close())
+
+	repo = openDurable(t, dir)
+	defer repo.Close()
+	_, err = repo.GetBorrower(ctx, bob.ID)
+	assert.NoError(t, err)
+}
+
+func TestDurableRepo_RejectsCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, walFile), []byte("not json\n"), 0o644))
+	_, err := OpenDurableRepo(dir)
+	assert.Error(t, err)
+}
@@ -1,117 +1,1447 @@
 package repository
 
 import (
+	"context"
 	"e-library-api/internal/errors"
 	"e-library-api/internal/models"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
 type MemoryRepo struct {
 	sync.RWMutex
-	Books map[string]*models.BookDetail
-	Loans map[string][]models.LoanDetail
+	Books      map[int64]*models.BookDetail
+	nextBookID int64
+	Works      map[int64]*models.Work
+	nextWorkID int64
+	// byTitle lists every key of Books ordered by title, then ID, so catalog
+	// pages can seek to a cursor instead of sorting the whole catalog
+	byTitle []int64
+	// search is the full-text index over the catalog
+	search *searchIndex
+	// Items keeps the copies and licenses per book ID. Books store no copy
+	// count of their own; it is counted from the items.
+	Items      map[int64][]*models.Item
+	nextItemID int64
+	// Loans keeps the active loans per book ID
+	Loans      map[int64][]models.LoanDetail
+	nextLoanID int64
+	// History keeps loans that have ended, in the order they ended
+	History []models.LoanDetail
+	// Holds keeps every hold per book ID in the order it was placed, so the
+	// first waiting entry is the head of the queue.
+	Holds      map[int64][]*models.HoldDetail
+	nextHoldID int64
+
+	Borrowers      map[int64]*models.Borrower
+	nextBorrowerID int64
+
+	Fines      []models.FineEntry
+	nextFineID int64
 }
 
+// NewMemoryRepo returns a repository seeded with a few books.
 func NewMemoryRepo() *MemoryRepo {
-	repo := &MemoryRepo{
-		Books: make(map[string]*models.BookDetail),
-		Loans: make(map[string][]models.LoanDetail),
-	}
+	repo := newMemoryRepo()
 	// Seed data
-	repo.Books["The Go Programming Language"] = &models.BookDetail{Title: "The Go Programming Language", AvailableCopies: 5}
-	repo.Books["Clean Code"] = &models.BookDetail{Title: "Clean Code", AvailableCopies: 2}
-	repo.Books["Design Patterns"] = &models.BookDetail{Title: "Design Patterns", AvailableCopies: 1}
+	for _, b := range []models.BookDetail{
+		{
+			Title:           "The Go Programming Language",
+			Authors:         []string{"Alan A. A. Donovan", "Brian W. Kernighan"},
+			ISBN:            "9780134190440",
+			Publisher:       "Addison-Wesley",
+			Year:            2015,
+			Language:        "en",
+			Subjects:        []string{"Go (Computer program language)"},
+			Format:          models.FormatPrint,
+			AvailableCopies: 5,
+		},
+		{
+			Title:           "Clean Code",
+			Authors:         []string{"Robert C. Martin"},
+			ISBN:            "9780132350884",
+			Publisher:       "Prentice Hall",
+			Year:            2008,
+			Language:        "en",
+			Subjects:        []string{"Agile software development", "Computer software -- Reliability"},
+			Format:          models.FormatPrint,
+			AvailableCopies: 2,
+		},
+		{
+			Title:           "Design Patterns",
+			Authors:         []string{"Erich Gamma", "Richard Helm", "Ralph Johnson", "John Vlissides"},
+			ISBN:            "9780201633610",
+			Publisher:       "Addison-Wesley",
+			Year:            1994,
+			Language:        "en",
+			Subjects:        []string{"Object-oriented programming (Computer science)", "Software patterns"},
+			Format:          models.FormatPrint,
+			AvailableCopies: 1,
+		},
+	} {
+		_, _ = repo.CreateBook(context.Background(), &b)
+	}
 	return repo
 }
 
-func (m *MemoryRepo) GetBook(title string) (*models.BookDetail, error) {
+// newMemoryRepo returns an empty repository.
+func newMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		Books:     make(map[int64]*models.BookDetail),
+		Works:     make(map[int64]*models.Work),
+		Items:     make(map[int64][]*models.Item),
+		Loans:     make(map[int64][]models.LoanDetail),
+		Holds:     make(map[int64][]*models.HoldDetail),
+		Borrowers: make(map[int64]*models.Borrower),
+		search:    newSearchIndex(),
+	}
+}
+
+func (m *MemoryRepo) GetBook(ctx context.Context, id int64) (*models.BookDetail, error) {
 	m.RLock()
 	defer m.RUnlock()
-	book, ok := m.Books[title]
+	if _, ok := m.Books[id]; !ok {
+		return nil, errors.ErrBookNotFound
+	}
+	return m.book(id), nil
+}
+
+// book returns a copy of the stored book with its available copies counted.
+// Callers must hold the lock.
+func (m *MemoryRepo) book(id int64) *models.BookDetail {
+	b := *m.Books[id]
+	b.Authors = slices.Clone(b.Authors)
+	b.Subjects = slices.Clone(b.Subjects)
+	b.AvailableCopies = m.availableCopies(id)
+	return &b
+}
+
+// touchBook records a change to the book's items, which shows in its
+// available copies. Callers must hold the lock.
+func (m *MemoryRepo) touchBook(id int64) {
+	if b, ok := m.Books[id]; ok {
+		b.Version++
+	}
+}
+
+// checkVersion fails with ErrVersionMismatch unless expected is zero or the
+// current version.
+func checkVersion(current, expected int64) error {
+	if expected != 0 && expected != current {
+		return errors.ErrVersionMismatch
+	}
+	return nil
+}
+
+// availableCopies counts the book's items on the shelf. Callers must hold the lock.
+func (m *MemoryRepo) availableCopies(bookID int64) int {
+	count := 0
+	for _, item := range m.Items[bookID] {
+		if item.Status == models.ItemAvailable {
+			count++
+		}
+	}
+	return count
+}
+
+func (m *MemoryRepo) ListBooks(ctx context.Context, query models.BookQuery) (*models.BookPage, error) {
+	cursor, err := decodeBookCursor(query)
+	if err != nil {
+		return nil, err
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	needle := strings.ToLower(query.Query)
+	matches := func(b *models.BookDetail) bool {
+		if query.Available != nil && *query.Available != (m.availableCopies(b.ID) > 0) {
+			return false
+		}
+		return needle == "" || strings.Contains(strings.ToLower(b.Title), needle)
+	}
+
+	// Collect one more than requested to know whether another page follows
+	var found []models.BookDetail
+	switch query.Sort {
+	case models.SortTitle, models.SortTitleDesc:
+		// The title index is already in order, so walk it from the cursor
+		desc := query.Sort == models.SortTitleDesc
+		i, step := 0, 1
+		if desc {
+			i, step = len(m.byTitle)-1, -1
+		}
+		if cursor != nil {
+			// Position of the first book at or after the cursor
+			i = m.titlePosition(cursor.book())
+			if desc {
+				i--
+			} else if i < len(m.byTitle) && m.byTitle[i] == cursor.ID {
+				i++
+			}
+		}
+		for ; i >= 0 && i < len(m.byTitle) && len(found) <= query.Limit; i += step {
+			if b := m.Books[m.byTitle[i]]; matches(b) {
+				found = append(found, *m.book(b.ID))
+			}
+		}
+	default:
+		for id, b := range m.Books {
+			if !matches(b) {
+				continue
+			}
+			book := m.book(id)
+			if cursor != nil && !bookBefore(query.Sort, cursor.book(), *book) {
+				continue
+			}
+			found = append(found, *book)
+		}
+		sort.Slice(found, func(i, j int) bool { return bookBefore(query.Sort, found[i], found[j]) })
+	}
+
+	page := &models.BookPage{Items: []models.BookDetail{}}
+	if len(found) > query.Limit {
+		found = found[:query.Limit]
+		page.NextCursor = encodeBookCursor(query.Sort, found[len(found)-1])
+	}
+	page.Items = append(page.Items, found...)
+	return page, nil
+}
+
+func (m *MemoryRepo) SearchBooks(ctx context.Context, query string, limit int) ([]models.SearchResult, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	results := []models.SearchResult{}
+	for id, score := range m.search.search(query) {
+		results = append(results, models.SearchResult{BookDetail: *m.book(id), Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return titleBefore(results[i].BookDetail, results[j].BookDetail)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// titlePosition finds where a book sorts in the title index. Callers must hold the lock.
+func (m *MemoryRepo) titlePosition(b models.BookDetail) int {
+	return sort.Search(len(m.byTitle), func(i int) bool {
+		return !titleBefore(*m.Books[m.byTitle[i]], b)
+	})
+}
+
+// indexBook adds a stored book to the title and search indexes. Callers must hold the lock.
+func (m *MemoryRepo) indexBook(b *models.BookDetail) {
+	m.byTitle = slices.Insert(m.byTitle, m.titlePosition(*b), b.ID)
+	m.search.add(b)
+}
+
+// unindexBook removes a stored book from the title and search indexes. It
+// must run before the book's title changes. Callers must hold the lock.
+func (m *MemoryRepo) unindexBook(b *models.BookDetail) {
+	if i := m.titlePosition(*b); i < len(m.byTitle) && m.byTitle[i] == b.ID {
+		m.byTitle = slices.Delete(m.byTitle, i, i+1)
+	}
+	m.search.remove(b.ID)
+}
+
+// isbnTaken reports whether another book than exceptID already has the ISBN.
+// Callers must hold the lock.
+func (m *MemoryRepo) isbnTaken(isbn string, exceptID int64) bool {
+	if isbn == "" {
+		return false
+	}
+	for id, b := range m.Books {
+		if id != exceptID && b.ISBN == isbn {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryRepo) CreateBook(ctx context.Context, book *models.BookDetail) (*models.BookDetail, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkNewBook(book); err != nil {
+		return nil, err
+	}
+	stored := *book
+	if stored.WorkID == 0 {
+		m.nextWorkID++
+		stored.WorkID = m.nextWorkID
+		m.Works[stored.WorkID] = &models.Work{ID: stored.WorkID, Title: book.Title}
+	}
+	m.nextBookID++
+	stored.ID = m.nextBookID
+	stored.AvailableCopies = 0
+	stored.Version = 1
+	m.Books[stored.ID] = &stored
+	m.indexBook(&stored)
+	m.addItems(stored.ID, book.AvailableCopies)
+	return m.book(stored.ID), nil
+}
+
+// checkNewBook reports why the book could not be added. Like the other
+// check functions, it lets DurableRepo refuse a call before logging it.
+// Callers must hold the lock.
+func (m *MemoryRepo) checkNewBook(book *models.BookDetail) error {
+	if m.isbnTaken(book.ISBN, 0) {
+		return errors.ErrBookExists
+	}
+	if _, ok := m.Works[book.WorkID]; book.WorkID != 0 && !ok {
+		return errors.ErrWorkNotFound
+	}
+	return nil
+}
+
+func (m *MemoryRepo) UpdateBook(ctx context.Context, id int64, book *models.BookDetail, version int64) (*models.BookDetail, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkBookUpdate(id, book, version); err != nil {
+		return nil, err
+	}
+
+	existing := m.Books[id]
+	m.unindexBook(existing)
+	updated := *book
+	updated.ID = id
+	updated.WorkID = existing.WorkID
+	updated.AvailableCopies = 0
+	updated.Version = existing.Version + 1
+	*existing = updated
+	m.indexBook(existing)
+	return m.book(id), nil
+}
+
+// checkBookUpdate reports why the book could not be updated. Callers must
+// hold the lock.
+func (m *MemoryRepo) checkBookUpdate(id int64, book *models.BookDetail, version int64) error {
+	if err := m.checkBook(id, version); err != nil {
+		return err
+	}
+	if m.isbnTaken(book.ISBN, id) {
+		return errors.ErrBookExists
+	}
+	return nil
+}
+
+// checkBook reports whether the book exists at version, unless that is zero.
+// Callers must hold the lock.
+func (m *MemoryRepo) checkBook(id, version int64) error {
+	book, ok := m.Books[id]
 	if !ok {
+		return errors.ErrBookNotFound
+	}
+	return checkVersion(book.Version, version)
+}
+
+func (m *MemoryRepo) AdjustCopies(ctx context.Context, id int64, delta int, version int64) (*models.BookDetail, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkCopies(id, delta, version); err != nil {
+		return nil, err
+	}
+	if delta != 0 {
+		m.touchBook(id)
+	}
+	if delta > 0 {
+		m.addItems(id, delta)
+	}
+	items := m.Items[id]
+	for i := len(items) - 1; i >= 0 && delta < 0; i-- {
+		if items[i].Status == models.ItemAvailable {
+			items[i].Status = models.ItemWithdrawn
+			delta++
+		}
+	}
+	return m.book(id), nil
+}
+
+// checkCopies reports why the book's copies could not change by delta.
+// Callers must hold the lock.
+func (m *MemoryRepo) checkCopies(id int64, delta int, version int64) error {
+	if err := m.checkBook(id, version); err != nil {
+		return err
+	}
+	if m.availableCopies(id)+delta < 0 {
+		return errors.ErrInvalidCopyCount
+	}
+	return nil
+}
+
+// addItems puts count new items without barcodes on the shelf. Callers must hold the lock.
+func (m *MemoryRepo) addItems(bookID int64, count int) {
+	for range count {
+		m.nextItemID++
+		m.Items[bookID] = append(m.Items[bookID], &models.Item{
+			ID:        m.nextItemID,
+			BookID:    bookID,
+			Condition: models.ConditionGood,
+			Status:    models.ItemAvailable,
+		})
+	}
+}
+
+func (m *MemoryRepo) DeleteBook(ctx context.Context, id, version int64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkBookDelete(id, version); err != nil {
+		return err
+	}
+	book := m.Books[id]
+	m.unindexBook(book)
+	delete(m.Books, id)
+	delete(m.Items, id)
+	delete(m.Loans, id)
+	delete(m.Holds, id)
+
+	for _, b := range m.Books {
+		if b.WorkID == book.WorkID {
+			return nil
+		}
+	}
+	delete(m.Works, book.WorkID)
+	return nil
+}
+
+// checkBookDelete reports why the book could not be deleted. Callers must
+// hold the lock.
+func (m *MemoryRepo) checkBookDelete(id, version int64) error {
+	if err := m.checkBook(id, version); err != nil {
+		return err
+	}
+	if len(m.Loans[id]) > 0 || m.hasOpenHold(id) {
+		return errors.ErrBookHasLoans
+	}
+	for _, l := range m.History {
+		if l.BookID == id {
+			return errors.ErrBookHasLoans
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRepo) GetWork(ctx context.Context, id int64) (*models.Work, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	work, ok := m.Works[id]
+	if !ok {
+		return nil, errors.ErrWorkNotFound
+	}
+	result := *work
+	result.Editions = []models.BookDetail{}
+	for bookID, b := range m.Books {
+		if b.WorkID == id {
+			result.Editions = append(result.Editions, *m.book(bookID))
+		}
+	}
+	sort.Slice(result.Editions, func(i, j int) bool { return result.Editions[i].ID < result.Editions[j].ID })
+	return &result, nil
+}
+
+func (m *MemoryRepo) AddItem(ctx context.Context, item *models.Item) (*models.Item, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkNewItem(item); err != nil {
+		return nil, err
+	}
+	m.nextItemID++
+	stored := *item
+	stored.ID = m.nextItemID
+	m.Items[item.BookID] = append(m.Items[item.BookID], &stored)
+	m.touchBook(item.BookID)
+	result := stored
+	return &result, nil
+}
+
+// checkNewItem reports why the item could not be added. Callers must hold
+// the lock.
+func (m *MemoryRepo) checkNewItem(item *models.Item) error {
+	if _, ok := m.Books[item.BookID]; !ok {
+		return errors.ErrBookNotFound
+	}
+	if m.barcodeTaken(item.Barcode, 0) {
+		return errors.ErrItemExists
+	}
+	return nil
+}
+
+func (m *MemoryRepo) GetItem(ctx context.Context, id int64) (*models.Item, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	item := m.findItem(id)
+	if item == nil {
+		return nil, errors.ErrItemNotFound
+	}
+	result := *item
+	return &result, nil
+}
+
+func (m *MemoryRepo) ListItems(ctx context.Context, bookID int64) ([]models.Item, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if _, ok := m.Books[bookID]; !ok {
 		return nil, errors.ErrBookNotFound
 	}
-	return book, nil
+	items := []models.Item{}
+	for _, item := range m.Items[bookID] {
+		items = append(items, *item)
+	}
+	return items, nil
+}
+
+func (m *MemoryRepo) UpdateItem(ctx context.Context, id int64, item *models.Item) (*models.Item, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkItemUpdate(id, item); err != nil {
+		return nil, err
+	}
+	existing := m.findItem(id)
+	status := item.Status
+	if status == "" {
+		status = existing.Status
+	}
+	if status != existing.Status {
+		m.touchBook(existing.BookID)
+	}
+	existing.Barcode = item.Barcode
+	existing.Condition = item.Condition
+	existing.Status = status
+	result := *existing
+	return &result, nil
+}
+
+// checkItemUpdate reports why the item could not be updated. Items on loan
+// or set aside for a hold keep their status. Callers must hold the lock.
+func (m *MemoryRepo) checkItemUpdate(id int64, item *models.Item) error {
+	existing := m.findItem(id)
+	if existing == nil {
+		return errors.ErrItemNotFound
+	}
+	if m.barcodeTaken(item.Barcode, id) {
+		return errors.ErrItemExists
+	}
+	changed := item.Status != "" && item.Status != existing.Status
+	if changed && (existing.Status == models.ItemOnLoan || existing.Status == models.ItemReserved) {
+		return errors.ErrItemInUse
+	}
+	return nil
+}
+
+// findItem looks an item up by ID across all books. Callers must hold the lock.
+func (m *MemoryRepo) findItem(id int64) *models.Item {
+	for _, items := range m.Items {
+		for _, item := range items {
+			if item.ID == id {
+				return item
+			}
+		}
+	}
+	return nil
+}
+
+// bookItem returns the book's item with the given ID. Callers must hold the lock.
+func (m *MemoryRepo) bookItem(bookID, itemID int64) *models.Item {
+	for _, item := range m.Items[bookID] {
+		if item.ID == itemID {
+			return item
+		}
+	}
+	return nil
+}
+
+// barcodeTaken reports whether another item than exceptID already has the
+// barcode. Callers must hold the lock.
+func (m *MemoryRepo) barcodeTaken(barcode string, exceptID int64) bool {
+	if barcode == "" {
+		return false
+	}
+	for _, items := range m.Items {
+		for _, item := range items {
+			if item.ID != exceptID && item.Barcode == barcode {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func (m *MemoryRepo) GetLoan(name, title string) (*models.LoanDetail, error) {
+func (m *MemoryRepo) GetLoan(ctx context.Context, borrowerID, bookID int64, now time.Time) (*models.LoanDetail, error) {
 	m.RLock()
 	defer m.RUnlock()
 
-	loans, ok := m.Loans[title]
+	loans, ok := m.Loans[bookID]
 	if !ok {
 		return nil, errors.ErrLoanNotFound
 	}
 
 	for _, l := range loans {
-		if l.NameOfBorrower == name {
-			return &l, nil
+		if l.BorrowerID == borrowerID {
+			if loan := m.loanAt(l, now); loan.Status == models.LoanActive {
+				return loan, nil
+			}
+			break
 		}
 	}
 	return nil, errors.ErrLoanNotFound
 }
 
-func (m *MemoryRepo) BorrowBook(loan *models.LoanDetail) (*models.LoanDetail, error) {
+func (m *MemoryRepo) GetLoanByID(ctx context.Context, id int64, now time.Time) (*models.LoanDetail, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, loans := range m.Loans {
+		for _, l := range loans {
+			if l.ID == id {
+				return m.loanAt(l, now), nil
+			}
+		}
+	}
+	for _, l := range m.History {
+		if l.ID == id {
+			return m.withNames(l), nil
+		}
+	}
+	return nil, errors.ErrLoanNotFound
+}
+
+func (m *MemoryRepo) NextReturnDate(ctx context.Context, bookID int64, now time.Time) (time.Time, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var next time.Time
+	for _, l := range m.Loans[bookID] {
+		if m.ended(l, now) {
+			continue
+		}
+		if next.IsZero() || l.ReturnDate.Before(next) {
+			next = l.ReturnDate
+		}
+	}
+	return next, nil
+}
+
+func (m *MemoryRepo) BorrowBook(ctx context.Context, loan *models.LoanDetail, pickupDeadline time.Time, check BorrowCheck) (*models.LoanDetail, error) {
 	m.Lock()
 	defer m.Unlock()
 
-	book, ok := m.Books[loan.BookTitle]
-	if !ok {
+	now := loan.LoanDate
+	if err := m.checkBorrower(loan.BorrowerID, now, check); err != nil {
+		return nil, err
+	}
+	// Closing them stands even if the borrow is refused, as the sweep would
+	// have closed them all the same
+	m.expireLoans(loan.BookID, now, pickupDeadline)
+	if err := m.checkBorrow(loan); err != nil {
+		return nil, err
+	}
+
+	// A ready hold already has an item set aside for this borrower
+	var item *models.Item
+	if hold := m.findHold(loan.BookID, loan.BorrowerID, models.HoldReady); hold != nil {
+		hold.Status = models.HoldFulfilled
+		item = m.bookItem(loan.BookID, hold.ItemID)
+	} else {
+		item = m.shelvedItem(loan.BookID)
+	}
+	item.Status = models.ItemOnLoan
+	m.touchBook(loan.BookID)
+	m.nextLoanID++
+	stored := *loan
+	stored.ID = m.nextLoanID
+	stored.ItemID = item.ID
+	stored.Status = models.LoanActive
+	stored.Version = 1
+	m.Loans[loan.BookID] = append(m.Loans[loan.BookID], stored)
+	return m.withNames(stored), nil
+}
+
+// checkBorrow reports why the loan could not be made, once the book's e-book
+// loans that ran out are closed. Callers must hold the lock.
+func (m *MemoryRepo) checkBorrow(loan *models.LoanDetail) error {
+	if _, ok := m.Books[loan.BookID]; !ok {
+		return errors.ErrBookNotFound
+	}
+	for _, l := range m.Loans[loan.BookID] {
+		if l.BorrowerID == loan.BorrowerID && !m.ended(l, loan.LoanDate) {
+			return errors.ErrDuplicateLoan
+		}
+	}
+	if m.findHold(loan.BookID, loan.BorrowerID, models.HoldReady) == nil && m.shelvedItem(loan.BookID) == nil {
+		return errors.ErrNoCopies
+	}
+	return nil
+}
+
+// shelvedItem returns the book's first available item, or nil. Callers must
+// hold the lock.
+func (m *MemoryRepo) shelvedItem(bookID int64) *models.Item {
+	for _, it := range m.Items[bookID] {
+		if it.Status == models.ItemAvailable {
+			return it
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRepo) ExtendLoan(ctx context.Context, borrowerID, bookID int64, newReturnDate time.Time, version int64) (*models.LoanDetail, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	i, err := m.findLoan(borrowerID, bookID, version)
+	if err != nil {
+		return nil, err
+	}
+	m.Loans[bookID][i].ReturnDate = newReturnDate
+	m.Loans[bookID][i].Renewals++
+	m.Loans[bookID][i].Version++
+	return m.withNames(m.Loans[bookID][i]), nil
+}
+
+func (m *MemoryRepo) CountLoans(ctx context.Context, borrowerID int64) (int, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.countLoans(borrowerID, time.Time{}), nil
+}
+
+// checkBorrower runs check, unless nil, on the loans the member holds at now
+// and the fines they owe. Callers must hold the lock.
+func (m *MemoryRepo) checkBorrower(borrowerID int64, now time.Time, check BorrowCheck) error {
+	if check == nil {
+		return nil
+	}
+	return check(m.countLoans(borrowerID, now), m.fineBalance(borrowerID))
+}
+
+// countLoans counts the member's loans still active at now; the zero time
+// counts them as stored. Callers must hold the lock.
+func (m *MemoryRepo) countLoans(borrowerID int64, now time.Time) int {
+	count := 0
+	for _, loans := range m.Loans {
+		for _, l := range loans {
+			if l.BorrowerID == borrowerID && !m.ended(l, now) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// ended reports whether the active loan is an e-book loan that ran out by
+// now. Callers must hold the lock.
+func (m *MemoryRepo) ended(l models.LoanDetail, now time.Time) bool {
+	book, ok := m.Books[l.BookID]
+	return ok && loanExpired(book, l, now)
+}
+
+func (m *MemoryRepo) ListOverdueLoans(ctx context.Context, now time.Time) ([]models.LoanDetail, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	overdue := []models.LoanDetail{}
+	for bookID, loans := range m.Loans {
+		if b, ok := m.Books[bookID]; ok && b.Digital {
+			continue
+		}
+		for _, l := range loans {
+			if l.ReturnDate.Before(now) {
+				overdue = append(overdue, *m.withNames(l))
+			}
+		}
+	}
+	sort.Slice(overdue, func(i, j int) bool {
+		return overdue[i].ReturnDate.Before(overdue[j].ReturnDate)
+	})
+	return overdue, nil
+}
+
+func (m *MemoryRepo) ReturnBook(ctx context.Context, borrowerID, bookID int64, returnedAt, pickupDeadline time.Time, version int64, fee LateFee) (*models.FineEntry, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	i, err := m.findLoan(borrowerID, bookID, version)
+	if err != nil {
+		return nil, err
+	}
+	due := m.Loans[bookID][i].ReturnDate
+	m.endLoan(bookID, i, returnedAt, models.ReturnReasonReturned, pickupDeadline)
+	if fee == nil {
+		return nil, nil
+	}
+	amount := fee(due, returnedAt)
+	if amount == 0 {
+		return nil, nil
+	}
+	return m.addFine(models.FineEntry{
+		BorrowerID:  borrowerID,
+		Kind:        models.FineCharge,
+		AmountCents: amount,
+		BookTitle:   m.Books[bookID].Title,
+		Note:        lateReturnNote,
+		CreatedAt:   returnedAt,
+	}), nil
+}
+
+// lateFee runs fee on the return date of the borrower's loan of the book, if
+// the loan is still at version unless that is zero.
+func (m *MemoryRepo) lateFee(borrowerID, bookID, version int64, returnedAt time.Time, fee LateFee) (int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	i, err := m.findLoan(borrowerID, bookID, version)
+	if err != nil || fee == nil {
+		return 0, err
+	}
+	return fee(m.Loans[bookID][i].ReturnDate, returnedAt), nil
+}
+
+// findLoan returns the index of the borrower's active loan of the book in
+// Loans, if it is still at version unless that is zero. Callers must hold
+// the lock.
+func (m *MemoryRepo) findLoan(borrowerID, bookID, version int64) (int, error) {
+	for i, l := range m.Loans[bookID] {
+		if l.BorrowerID == borrowerID {
+			return i, checkVersion(l.Version, version)
+		}
+	}
+	return 0, errors.ErrLoanNotFound
+}
+
+func (m *MemoryRepo) ExpireDigitalLoans(ctx context.Context, now, pickupDeadline time.Time) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	// In ID order, so that replaying the sweep ends the loans in the order
+	// it first did
+	expired := 0
+	for _, bookID := range slices.Sorted(maps.Keys(m.Books)) {
+		expired += m.expireLoans(bookID, now, pickupDeadline)
+	}
+	return expired, nil
+}
+
+// expireBookLoans is ExpireDigitalLoans for a single book.
+func (m *MemoryRepo) expireBookLoans(bookID int64, now, pickupDeadline time.Time) int {
+	m.Lock()
+	defer m.Unlock()
+	return m.expireLoans(bookID, now, pickupDeadline)
+}
+
+// expireLoans closes the book's e-book loans that ran out by now and returns
+// how many it closed. Callers must hold the lock.
+func (m *MemoryRepo) expireLoans(bookID int64, now, pickupDeadline time.Time) int {
+	book, ok := m.Books[bookID]
+	if !ok || !book.Digital {
+		return 0
+	}
+	expired := 0
+	for i := len(m.Loans[bookID]) - 1; i >= 0; i-- {
+		l := m.Loans[bookID][i]
+		if loanExpired(book, l, now) {
+			m.endLoan(bookID, i, l.ReturnDate, models.ReturnReasonExpired, pickupDeadline)
+			expired++
+		}
+	}
+	return expired
+}
+
+// loanExpired reports whether the active loan of book ends by itself by now.
+func loanExpired(book *models.BookDetail, l models.LoanDetail, now time.Time) bool {
+	return book.Digital && l.ReturnDate.Before(now)
+}
+
+// endLoan moves the i-th active loan of the book to history and releases its item.
+// Callers must hold the lock.
+func (m *MemoryRepo) endLoan(bookID int64, i int, returnedAt time.Time, reason string, pickupDeadline time.Time) {
+	loan := m.closeLoan(bookID, i, models.LoanReturned, returnedAt, reason)
+	m.releaseItem(bookID, loan.ItemID, pickupDeadline)
+}
+
+// closeLoan moves the i-th active loan of the book to history with the given status.
+// Callers must hold the lock.
+func (m *MemoryRepo) closeLoan(bookID int64, i int, status string, at time.Time, reason string) models.LoanDetail {
+	loan := m.Loans[bookID][i]
+	loan.Status = status
+	loan.ReturnedAt = &at
+	loan.ReturnedReason = reason
+	loan.Version++
+	m.History = append(m.History, loan)
+
+	m.Loans[bookID] = append(m.Loans[bookID][:i], m.Loans[bookID][i+1:]...)
+	return loan
+}
+
+func (m *MemoryRepo) MarkLoanLost(ctx context.Context, id int64, at time.Time) (*models.LoanDetail, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	bookID, i, err := m.findLoanByID(id)
+	if err != nil {
+		return nil, err
+	}
+	// The item is gone, so unlike a return nothing is released to the
+	// shelf or the hold queue
+	lost := m.closeLoan(bookID, i, models.LoanLost, at, models.ReturnReasonLost)
+	if item := m.bookItem(bookID, lost.ItemID); item != nil {
+		item.Status = models.ItemLost
+		m.touchBook(bookID)
+	}
+	return m.withNames(lost), nil
+}
+
+// findLoanByID returns the book of the active loan with the given ID and
+// its index in Loans. Callers must hold the lock.
+func (m *MemoryRepo) findLoanByID(id int64) (int64, int, error) {
+	for bookID, loans := range m.Loans {
+		for i, l := range loans {
+			if l.ID == id {
+				return bookID, i, nil
+			}
+		}
+	}
+	return 0, 0, errors.ErrLoanNotFound
+}
+
+func (m *MemoryRepo) ListLoansByBorrower(ctx context.Context, borrowerID int64, page models.Page, now time.Time) (*models.LoanPage, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if _, ok := m.Borrowers[borrowerID]; !ok {
+		return nil, errors.ErrBorrowerNotFound
+	}
+	return m.loanPage(func(l models.LoanDetail) bool { return l.BorrowerID == borrowerID }, page, now), nil
+}
+
+func (m *MemoryRepo) ListLoansByBook(ctx context.Context, bookID int64, page models.Page, now time.Time) (*models.LoanPage, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if _, ok := m.Books[bookID]; !ok {
 		return nil, errors.ErrBookNotFound
 	}
-	if book.AvailableCopies <= 0 {
-		return nil, errors.ErrNoCopies
+	return m.loanPage(func(l models.LoanDetail) bool { return l.BookID == bookID }, page, now), nil
+}
+
+// loanPage collects active and ended loans matching keep, newest first, and
+// cuts out the requested page. Callers must hold the lock.
+func (m *MemoryRepo) loanPage(keep func(models.LoanDetail) bool, page models.Page, now time.Time) *models.LoanPage {
+	matched := []models.LoanDetail{}
+	for _, loans := range m.Loans {
+		for _, l := range loans {
+			if keep(l) {
+				matched = append(matched, *m.loanAt(l, now))
+			}
+		}
+	}
+	for _, l := range m.History {
+		if keep(l) {
+			matched = append(matched, *m.withNames(l))
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].LoanDate.Equal(matched[j].LoanDate) {
+			return matched[i].LoanDate.After(matched[j].LoanDate)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+
+	result := &models.LoanPage{Items: []models.LoanDetail{}, Total: len(matched), Limit: page.Limit, Offset: page.Offset}
+	if page.Offset < len(matched) {
+		end := min(page.Offset+page.Limit, len(matched))
+		result.Items = matched[page.Offset:end]
 	}
+	return result
+}
 
-	for _, l := range m.Loans[loan.BookTitle] {
-		if l.NameOfBorrower == loan.NameOfBorrower {
-			return nil, errors.ErrDuplicateLoan
+// loanPurgeable reports whether the ended loan closed before the cutoff.
+func loanPurgeable(l models.LoanDetail, before time.Time) bool {
+	return l.ReturnedAt != nil && l.ReturnedAt.Before(before)
+}
+
+func (m *MemoryRepo) PurgeLoans(ctx context.Context, before time.Time) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	purged := 0
+	history := m.History[:0]
+	for _, l := range m.History {
+		if loanPurgeable(l, before) {
+			purged++
+			continue
 		}
+		history = append(history, l)
 	}
+	m.History = history
+	return purged, nil
+}
+
+func (m *MemoryRepo) PlaceHold(ctx context.Context, hold *models.HoldDetail) (*models.HoldDetail, error) {
+	m.Lock()
+	defer m.Unlock()
 
-	book.AvailableCopies--
-	m.Loans[loan.BookTitle] = append(m.Loans[loan.BookTitle], *loan)
-	return loan, nil
+	if err := m.checkHold(hold); err != nil {
+		return nil, err
+	}
+	m.nextHoldID++
+	stored := *hold
+	stored.ID = m.nextHoldID
+	stored.Status = models.HoldWaiting
+	stored.ExpiresAt = nil
+	stored.ItemID = 0
+	m.Holds[hold.BookID] = append(m.Holds[hold.BookID], &stored)
+	return m.holdWithNames(&stored), nil
 }
 
-func (m *MemoryRepo) ExtendLoan(name, title string, newReturnDate time.Time) (*models.LoanDetail, error) {
+// checkHold reports why the hold could not be placed. Callers must hold the
+// lock.
+func (m *MemoryRepo) checkHold(hold *models.HoldDetail) error {
+	if _, ok := m.Books[hold.BookID]; !ok {
+		return errors.ErrBookNotFound
+	}
+	for _, l := range m.Loans[hold.BookID] {
+		if l.BorrowerID == hold.BorrowerID {
+			return errors.ErrDuplicateLoan
+		}
+	}
+	if m.findHold(hold.BookID, hold.BorrowerID, models.HoldWaiting, models.HoldReady) != nil {
+		return errors.ErrDuplicateHold
+	}
+	if m.availableCopies(hold.BookID) > 0 {
+		return errors.ErrCopiesAvailable
+	}
+	return nil
+}
+
+func (m *MemoryRepo) ListHolds(ctx context.Context, bookID int64) ([]models.HoldDetail, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if _, ok := m.Books[bookID]; !ok {
+		return nil, errors.ErrBookNotFound
+	}
+	holds := []models.HoldDetail{}
+	for _, h := range m.Holds[bookID] {
+		if h.Status == models.HoldWaiting || h.Status == models.HoldReady {
+			holds = append(holds, *m.holdWithNames(h))
+		}
+	}
+	return holds, nil
+}
+
+// holdExpired reports whether the hold was ready and not picked up by now.
+func holdExpired(h *models.HoldDetail, now time.Time) bool {
+	return h.Status == models.HoldReady && h.ExpiresAt != nil && h.ExpiresAt.Before(now)
+}
+
+func (m *MemoryRepo) ExpireHolds(ctx context.Context, now, pickupDeadline time.Time) (int, error) {
 	m.Lock()
 	defer m.Unlock()
 
-	loans, ok := m.Loans[title]
-	if !ok {
-		return nil, errors.ErrLoanNotFound
+	expired := 0
+	for _, bookID := range slices.Sorted(maps.Keys(m.Holds)) {
+		for _, h := range m.Holds[bookID] {
+			if holdExpired(h, now) {
+				h.Status = models.HoldExpired
+				m.releaseItem(bookID, h.ItemID, pickupDeadline)
+				expired++
+			}
+		}
+	}
+	return expired, nil
+}
+
+// holdPurgeable reports whether the hold closed and was placed before the cutoff.
+func holdPurgeable(h *models.HoldDetail, before time.Time) bool {
+	closed := h.Status == models.HoldFulfilled || h.Status == models.HoldExpired
+	return closed && h.PlacedAt.Before(before)
+}
+
+func (m *MemoryRepo) PurgeHolds(ctx context.Context, before time.Time) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	purged := 0
+	for bookID, holds := range m.Holds {
+		kept := holds[:0]
+		for _, h := range holds {
+			if holdPurgeable(h, before) {
+				purged++
+				continue
+			}
+			kept = append(kept, h)
+		}
+		m.Holds[bookID] = kept
 	}
+	return purged, nil
+}
 
-	for i, l := range loans {
-		if l.NameOfBorrower == name {
-			m.Loans[title][i].ReturnDate = newReturnDate
-			return &m.Loans[title][i], nil
+// findHold returns the borrower's hold on the book in one of the given statuses.
+// Callers must hold the lock.
+func (m *MemoryRepo) findHold(bookID, borrowerID int64, statuses ...string) *models.HoldDetail {
+	for _, h := range m.Holds[bookID] {
+		if h.BorrowerID != borrowerID {
+			continue
+		}
+		for _, status := range statuses {
+			if h.Status == status {
+				return h
+			}
 		}
 	}
-	return nil, errors.ErrLoanNotFound
+	return nil
+}
+
+// hasOpenHold reports whether anyone is waiting for the book or has a copy
+// set aside. Callers must hold the lock.
+func (m *MemoryRepo) hasOpenHold(bookID int64) bool {
+	for _, h := range m.Holds[bookID] {
+		if h.Status == models.HoldWaiting || h.Status == models.HoldReady {
+			return true
+		}
+	}
+	return false
 }
 
-func (m *MemoryRepo) ReturnBook(name, title string) error {
+// releaseItem sets a freed item aside for the head of the hold queue, or puts
+// it back on the shelf when nobody is waiting. Callers must hold the lock.
+func (m *MemoryRepo) releaseItem(bookID, itemID int64, pickupDeadline time.Time) {
+	item := m.bookItem(bookID, itemID)
+	m.touchBook(bookID)
+	for _, h := range m.Holds[bookID] {
+		if h.Status == models.HoldWaiting {
+			deadline := pickupDeadline
+			h.Status = models.HoldReady
+			h.ExpiresAt = &deadline
+			h.ItemID = itemID
+			item.Status = models.ItemReserved
+			return
+		}
+	}
+	item.Status = models.ItemAvailable
+}
+
+// withNames returns a copy of the loan labelled with the borrower's current
+// name, the book's current title and the item's barcode. Callers must hold the lock.
+func (m *MemoryRepo) withNames(l models.LoanDetail) *models.LoanDetail {
+	if b, ok := m.Borrowers[l.BorrowerID]; ok {
+		l.NameOfBorrower = b.Name
+	}
+	if b, ok := m.Books[l.BookID]; ok {
+		l.BookTitle = b.Title
+	}
+	if item := m.bookItem(l.BookID, l.ItemID); item != nil {
+		l.Barcode = item.Barcode
+	}
+	return &l
+}
+
+// loanAt returns a copy of the active loan labelled by withNames, shown as
+// ended if it is an e-book loan past its return date. Callers must hold the
+// lock.
+func (m *MemoryRepo) loanAt(l models.LoanDetail, now time.Time) *models.LoanDetail {
+	loan := m.withNames(l)
+	if b, ok := m.Books[l.BookID]; ok && b.Digital {
+		showExpired(loan, now)
+	}
+	return loan
+}
+
+// holdWithNames returns a copy of the hold labelled with the borrower's
+// current name and the book's current title. Callers must hold the lock.
+func (m *MemoryRepo) holdWithNames(h *models.HoldDetail) *models.HoldDetail {
+	result := *h
+	if b, ok := m.Borrowers[h.BorrowerID]; ok {
+		result.NameOfBorrower = b.Name
+	}
+	if b, ok := m.Books[h.BookID]; ok {
+		result.BookTitle = b.Title
+	}
+	return &result
+}
+
+func (m *MemoryRepo) CreateBorrower(ctx context.Context, borrower *models.Borrower) (*models.Borrower, error) {
 	m.Lock()
 	defer m.Unlock()
 
-	loans, ok := m.Loans[title]
+	if err := m.checkBorrowerDetails(0, borrower); err != nil {
+		return nil, err
+	}
+	m.nextBorrowerID++
+	stored := *borrower
+	stored.ID = m.nextBorrowerID
+	m.Borrowers[stored.ID] = &stored
+	result := stored
+	return &result, nil
+}
+
+func (m *MemoryRepo) GetBorrower(ctx context.Context, id int64) (*models.Borrower, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	b, ok := m.Borrowers[id]
 	if !ok {
-		return errors.ErrLoanNotFound
+		return nil, errors.ErrBorrowerNotFound
 	}
+	result := *b
+	return &result, nil
+}
 
-	for i, l := range loans {
-		if l.NameOfBorrower == name {
-			m.Loans[title] = append(loans[:i], loans[i+1:]...)
-			m.Books[title].AvailableCopies++
-			return nil
+func (m *MemoryRepo) UpdateBorrower(ctx context.Context, id int64, borrower *models.Borrower) (*models.Borrower, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkBorrowerDetails(id, borrower); err != nil {
+		return nil, err
+	}
+	stored := *borrower
+	stored.ID = id
+	m.Borrowers[id] = &stored
+	result := stored
+	return &result, nil
+}
+
+func (m *MemoryRepo) DeleteBorrower(ctx context.Context, id int64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkBorrowerDelete(id); err != nil {
+		return err
+	}
+	m.removeBorrower(id)
+	return nil
+}
+
+// deleteBorrowerAndHistory is DeleteBorrower as it was before ended loans
+// kept a member: their history goes with them. DurableRepo replays the
+// deletes logged back then with it; those logged since find no history.
+func (m *MemoryRepo) deleteBorrowerAndHistory(id int64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkBorrowerSettled(id); err != nil {
+		return err
+	}
+	m.History = slices.DeleteFunc(m.History, func(l models.LoanDetail) bool { return l.BorrowerID == id })
+	m.removeBorrower(id)
+	return nil
+}
+
+// removeBorrower deletes the borrower with their holds and fines ledger.
+// Callers must hold the lock.
+func (m *MemoryRepo) removeBorrower(id int64) {
+	delete(m.Borrowers, id)
+	for bookID, holds := range m.Holds {
+		kept := holds[:0]
+		for _, h := range holds {
+			if h.BorrowerID != id {
+				kept = append(kept, h)
+			}
+		}
+		m.Holds[bookID] = kept
+	}
+	fines := m.Fines[:0]
+	for _, f := range m.Fines {
+		if f.BorrowerID != id {
+			fines = append(fines, f)
 		}
 	}
-	return errors.ErrLoanNotFound
+	m.Fines = fines
 }
 
-func (m *MemoryRepo) Ping() error {
+// checkBorrowerDetails reports why the borrower could not be stored under
+// id, or added when id is zero. Callers must hold the lock.
+func (m *MemoryRepo) checkBorrowerDetails(id int64, borrower *models.Borrower) error {
+	if _, ok := m.Borrowers[id]; id != 0 && !ok {
+		return errors.ErrBorrowerNotFound
+	}
+	if m.emailTaken(borrower.Email, id) {
+		return errors.ErrBorrowerExists
+	}
+	return nil
+}
+
+// checkBorrowerDelete reports why the borrower could not be deleted. Callers
+// must hold the lock.
+func (m *MemoryRepo) checkBorrowerDelete(id int64) error {
+	if err := m.checkBorrowerSettled(id); err != nil {
+		return err
+	}
+	// Ended loans are history, kept like a book's until PurgeLoans drops them
+	for _, l := range m.History {
+		if l.BorrowerID == id {
+			return errors.ErrBorrowerHasLoans
+		}
+	}
 	return nil
 }
+
+// checkBorrowerSettled reports whether the borrower exists with no active
+// loans, no open holds and no fines owed. Callers must hold the lock.
+func (m *MemoryRepo) checkBorrowerSettled(id int64) error {
+	if _, ok := m.Borrowers[id]; !ok {
+		return errors.ErrBorrowerNotFound
+	}
+	for _, loans := range m.Loans {
+		for _, l := range loans {
+			if l.BorrowerID == id {
+				return errors.ErrBorrowerHasLoans
+			}
+		}
+	}
+	for bookID := range m.Holds {
+		if m.findHold(bookID, id, models.HoldWaiting, models.HoldReady) != nil {
+			return errors.ErrBorrowerHasLoans
+		}
+	}
+	if m.fineBalance(id) > 0 {
+		return errors.ErrBorrowerHasLoans
+	}
+	return nil
+}
+
+// emailTaken reports whether another borrower than exceptID already uses the
+// email. Callers must hold the lock.
+func (m *MemoryRepo) emailTaken(email string, exceptID int64) bool {
+	for id, b := range m.Borrowers {
+		if id != exceptID && b.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryRepo) AddFineEntry(ctx context.Context, entry *models.FineEntry) (*models.FineEntry, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkFine(entry); err != nil {
+		return nil, err
+	}
+	return m.addFine(*entry), nil
+}
+
+// checkFine reports why the entry could not be added to the ledger.
+// Payments and waivers cannot take the balance below zero. Callers must hold
+// the lock.
+func (m *MemoryRepo) checkFine(entry *models.FineEntry) error {
+	if _, ok := m.Borrowers[entry.BorrowerID]; !ok {
+		return errors.ErrBorrowerNotFound
+	}
+	if entry.Kind != models.FineCharge && entry.AmountCents > m.fineBalance(entry.BorrowerID) {
+		return errors.ErrAmountExceedsBalance
+	}
+	return nil
+}
+
+// addFine appends the entry to the ledger. Callers must hold the lock.
+func (m *MemoryRepo) addFine(entry models.FineEntry) *models.FineEntry {
+	m.nextFineID++
+	entry.ID = m.nextFineID
+	m.Fines = append(m.Fines, entry)
+	return &entry
+}
+
+func (m *MemoryRepo) ListFineEntries(ctx context.Context, borrowerID int64) ([]models.FineEntry, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if _, ok := m.Borrowers[borrowerID]; !ok {
+		return nil, errors.ErrBorrowerNotFound
+	}
+	entries := []models.FineEntry{}
+	for _, f := range m.Fines {
+		if f.BorrowerID == borrowerID {
+			entries = append(entries, f)
+		}
+	}
+	return entries, nil
+}
+
+func (m *MemoryRepo) FineBalance(ctx context.Context, borrowerID int64) (int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if _, ok := m.Borrowers[borrowerID]; !ok {
+		return 0, errors.ErrBorrowerNotFound
+	}
+	return m.fineBalance(borrowerID), nil
+}
+
+// fineBalance sums the member's ledger. Callers must hold the lock.
+func (m *MemoryRepo) fineBalance(borrowerID int64) int64 {
+	var balance int64
+	for _, f := range m.Fines {
+		if f.BorrowerID != borrowerID {
+			continue
+		}
+		if f.Kind == models.FineCharge {
+			balance += f.AmountCents
+		} else {
+			balance -= f.AmountCents
+		}
+	}
+	return balance
+}
+
+func (m *MemoryRepo) Ping(ctx context.Context) error {
+	return nil
+}
+
+// anyActiveLoan reports whether an active loan matches. DurableRepo asks it,
+// and the other any functions, before logging a sweep, so that sweeps with
+// nothing to do leave no record.
+func (m *MemoryRepo) anyActiveLoan(match func(*models.BookDetail, models.LoanDetail) bool) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	for bookID, loans := range m.Loans {
+		book, ok := m.Books[bookID]
+		if !ok {
+			continue
+		}
+		for _, l := range loans {
+			if match(book, l) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anyEndedLoan reports whether an ended loan matches.
+func (m *MemoryRepo) anyEndedLoan(match func(models.LoanDetail) bool) bool {
+	m.RLock()
+	defer m.RUnlock()
+	return slices.ContainsFunc(m.History, match)
+}
+
+// anyHold reports whether a hold matches.
+func (m *MemoryRepo) anyHold(match func(*models.HoldDetail) bool) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, holds := range m.Holds {
+		if slices.ContainsFunc(holds, match) {
+			return true
+		}
+	}
+	return false
+}
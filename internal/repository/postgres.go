@@ -1,13 +1,26 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"e-library-api/internal/errors"
 	"e-library-api/internal/models"
 	stdErrors "errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
+// uniqueViolation is the Postgres SQLSTATE raised when a unique constraint fails.
+const uniqueViolation = "23505"
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return stdErrors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
 type PostgresRepo struct {
 	DB *sql.DB
 }
@@ -16,111 +29,1308 @@ func NewPostgresRepo(db *sql.DB) *PostgresRepo {
 	return &PostgresRepo{DB: db}
 }
 
-func (p *PostgresRepo) GetBook(title string) (*models.BookDetail, error) {
+// bookColumns lists the columns scanBook reads, in order. They are read from
+// the catalog view, which counts each book's available items. Books without
+// an ISBN store NULL so the unique constraint ignores them.
+const bookColumns = `id, work_id, title, authors, description, COALESCE(isbn, ''), publisher, year, language,
+	subjects, format, category, digital, available_copies, version`
+
+func scanBook(row rowScanner, extra ...any) (*models.BookDetail, error) {
 	var b models.BookDetail
-	err := p.DB.QueryRow("SELECT title, available_copies FROM books WHERE title = $1", title).Scan(&b.Title, &b.AvailableCopies)
+	dest := []any{&b.ID, &b.WorkID, &b.Title, pq.Array(&b.Authors), &b.Description, &b.ISBN, &b.Publisher, &b.Year,
+		&b.Language, pq.Array(&b.Subjects), &b.Format, &b.Category, &b.Digital, &b.AvailableCopies, &b.Version}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	if b.Authors == nil {
+		b.Authors = []string{}
+	}
+	if b.Subjects == nil {
+		b.Subjects = []string{}
+	}
+	return &b, nil
+}
+
+func (p *PostgresRepo) GetBook(ctx context.Context, id int64) (*models.BookDetail, error) {
+	return getBook(ctx, p.DB, id)
+}
+
+func getBook(ctx context.Context, q queryRower, id int64) (*models.BookDetail, error) {
+	b, err := scanBook(q.QueryRowContext(ctx, "SELECT "+bookColumns+" FROM catalog WHERE id = $1", id))
 	if err != nil {
 		if stdErrors.Is(err, sql.ErrNoRows) {
 			return nil, errors.ErrBookNotFound
 		}
 		return nil, err
 	}
-	return &b, nil
+	return b, nil
 }
 
-func (p *PostgresRepo) GetLoan(name, title string) (*models.LoanDetail, error) {
-	var l models.LoanDetail
-	err := p.DB.QueryRow("SELECT borrower, title, loan_date, return_date FROM loans WHERE borrower = $1 AND title = $2", name, title).Scan(&l.NameOfBorrower, &l.BookTitle, &l.LoanDate, &l.ReturnDate)
+// bookExists returns ErrBookNotFound unless the book exists.
+func (p *PostgresRepo) bookExists(ctx context.Context, id int64) error {
+	var exists bool
+	if err := p.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM books WHERE id = $1)", id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return errors.ErrBookNotFound
+	}
+	return nil
+}
+
+// bookOrder maps a catalog sort to its ORDER BY clause and the operator of
+// the keyset comparison that continues after a cursor. The title orders match
+// the index on books.
+var bookOrder = map[string]struct {
+	orderBy  string
+	after    string
+	byCopies bool
+}{
+	models.SortTitle:      {"title, id", ">", false},
+	models.SortTitleDesc:  {"title DESC, id DESC", "<", false},
+	models.SortCopies:     {"available_copies, title, id", ">", true},
+	models.SortCopiesDesc: {"available_copies DESC, title DESC, id DESC", "<", true},
+}
+
+// likeEscaper escapes LIKE wildcards so a search matches them literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (p *PostgresRepo) ListBooks(ctx context.Context, query models.BookQuery) (*models.BookPage, error) {
+	cursor, err := decodeBookCursor(query)
 	if err != nil {
-		if stdErrors.Is(err, sql.ErrNoRows) {
-			return nil, errors.ErrLoanNotFound
+		return nil, err
+	}
+	order := bookOrder[query.Sort]
+
+	var where []string
+	var args []any
+	if query.Query != "" {
+		// Served by the trigram index on title
+		args = append(args, "%"+likeEscaper.Replace(query.Query)+"%")
+		where = append(where, fmt.Sprintf("title ILIKE $%d", len(args)))
+	}
+	if query.Available != nil {
+		if *query.Available {
+			where = append(where, "available_copies > 0")
+		} else {
+			where = append(where, "available_copies = 0")
+		}
+	}
+	if cursor != nil {
+		if order.byCopies {
+			args = append(args, cursor.Copies, cursor.Title, cursor.ID)
+			where = append(where, fmt.Sprintf("(available_copies, title, id) %s ($%d, $%d, $%d)",
+				order.after, len(args)-2, len(args)-1, len(args)))
+		} else {
+			args = append(args, cursor.Title, cursor.ID)
+			where = append(where, fmt.Sprintf("(title, id) %s ($%d, $%d)", order.after, len(args)-1, len(args)))
 		}
+	}
+
+	sqlQuery := "SELECT " + bookColumns + " FROM catalog"
+	if len(where) > 0 {
+		sqlQuery += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Fetch one more than requested to know whether another page follows
+	args = append(args, query.Limit+1)
+	sqlQuery += fmt.Sprintf(" ORDER BY %s LIMIT $%d", order.orderBy, len(args))
+
+	rows, err := p.DB.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
 		return nil, err
 	}
-	return &l, nil
+	defer rows.Close()
+
+	page := &models.BookPage{Items: []models.BookDetail{}}
+	for rows.Next() {
+		b, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, *b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Items) > query.Limit {
+		page.Items = page.Items[:query.Limit]
+		page.NextCursor = encodeBookCursor(query.Sort, page.Items[len(page.Items)-1])
+	}
+	return page, nil
+}
+
+func (p *PostgresRepo) SearchBooks(ctx context.Context, query string, limit int) ([]models.SearchResult, error) {
+	words := tokenize(query)
+	results := []models.SearchResult{}
+	if len(words) == 0 {
+		return results, nil
+	}
+	// Every word may also be the start of a longer one. The trigram match on
+	// the title catches typos the stemmed text search cannot.
+	prefixes := make([]string, len(words))
+	for i, w := range words {
+		prefixes[i] = w + ":*"
+	}
+	sqlQuery := `SELECT ` + bookColumns + `, ts_rank(search_vector, q) + word_similarity($2, title) AS score
+		FROM catalog, to_tsquery('english', $1) q
+		WHERE search_vector @@ q OR $2 <% title
+		ORDER BY score DESC, title, id
+		LIMIT $3`
+	rows, err := p.DB.QueryContext(ctx, sqlQuery, strings.Join(prefixes, " & "), strings.Join(words, " "), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var score float64
+		b, err := scanBook(rows, &score)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, models.SearchResult{BookDetail: *b, Score: score})
+	}
+	return results, rows.Err()
 }
 
-func (p *PostgresRepo) BorrowBook(loan *models.LoanDetail) (*models.LoanDetail, error) {
-	tx, err := p.DB.Begin()
+func (p *PostgresRepo) CreateBook(ctx context.Context, book *models.BookDetail) (*models.BookDetail, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	var currentCopies int
-	err = tx.QueryRow("SELECT available_copies FROM books WHERE title = $1 FOR UPDATE", loan.BookTitle).Scan(&currentCopies)
+	workID := book.WorkID
+	if workID == 0 {
+		if err = tx.QueryRowContext(ctx, "INSERT INTO works (title) VALUES ($1) RETURNING id", book.Title).Scan(&workID); err != nil {
+			return nil, err
+		}
+	} else {
+		// Lock the work so it cannot be deleted along with its last edition meanwhile
+		err = tx.QueryRowContext(ctx, "SELECT id FROM works WHERE id = $1 FOR SHARE", workID).Scan(&workID)
+		if err != nil {
+			if stdErrors.Is(err, sql.ErrNoRows) {
+				return nil, errors.ErrWorkNotFound
+			}
+			return nil, err
+		}
+	}
+
+	query := `INSERT INTO books (work_id, title, authors, description, isbn, publisher, year, language, subjects,
+			format, category, digital)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id`
+	var id int64
+	err = tx.QueryRowContext(ctx, query, workID, book.Title, pq.Array(book.Authors), book.Description, book.ISBN,
+		book.Publisher, book.Year, book.Language, pq.Array(book.Subjects), book.Format, book.Category,
+		book.Digital).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, errors.ErrBookExists
+		}
+		return nil, err
+	}
+	if err = addItems(ctx, tx, id, book.AvailableCopies); err != nil {
+		return nil, err
+	}
+
+	b, err := getBook(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return b, tx.Commit()
+}
+
+// addItems puts count new items without barcodes on the shelf.
+func addItems(ctx context.Context, tx *sql.Tx, bookID int64, count int) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO items (book_id, condition, status) SELECT $1, $2, $3 FROM generate_series(1, $4)",
+		bookID, models.ConditionGood, models.ItemAvailable, count)
+	return err
+}
+
+func (p *PostgresRepo) UpdateBook(ctx context.Context, id int64, book *models.BookDetail, version int64) (*models.BookDetail, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = lockBookAt(ctx, tx, id, version); err != nil {
+		return nil, err
+	}
+	query := `UPDATE books SET title = $1, authors = $2, description = $3, isbn = NULLIF($4, ''), publisher = $5,
+			year = $6, language = $7, subjects = $8, format = $9, category = $10, digital = $11, version = version + 1
+		WHERE id = $12`
+	_, err = tx.ExecContext(ctx, query, book.Title, pq.Array(book.Authors), book.Description, book.ISBN,
+		book.Publisher, book.Year, book.Language, pq.Array(book.Subjects), book.Format, book.Category, book.Digital, id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, errors.ErrBookExists
+		}
+		return nil, err
+	}
+
+	b, err := getBook(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return b, tx.Commit()
+}
+
+func (p *PostgresRepo) AdjustCopies(ctx context.Context, id int64, delta int, version int64) (*models.BookDetail, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = lockBookAt(ctx, tx, id, version); err != nil {
+		return nil, err
+	}
+	if delta > 0 {
+		if err = addItems(ctx, tx, id, delta); err != nil {
+			return nil, err
+		}
+	} else {
+		res, err := tx.ExecContext(ctx, `UPDATE items SET status = $1 WHERE id IN (
+				SELECT id FROM items WHERE book_id = $2 AND status = $3 ORDER BY id DESC LIMIT $4)`,
+			models.ItemWithdrawn, id, models.ItemAvailable, -delta)
+		if err != nil {
+			return nil, err
+		}
+		withdrawn, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if withdrawn < int64(-delta) {
+			return nil, errors.ErrInvalidCopyCount
+		}
+	}
+
+	b, err := getBook(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return b, tx.Commit()
+}
+
+// lockBook locks the book row. Lending, returns and hold allocation lock the
+// book first, so they change its items one at a time.
+func lockBook(ctx context.Context, tx *sql.Tx, id int64) error {
+	return lockBookAt(ctx, tx, id, 0)
+}
+
+// lockBookAt locks the book row like lockBook, and checks that the book is
+// still at the given version.
+func lockBookAt(ctx context.Context, tx *sql.Tx, id, version int64) error {
+	var current int64
+	err := tx.QueryRowContext(ctx, "SELECT version FROM books WHERE id = $1 FOR UPDATE", id).Scan(&current)
+	if stdErrors.Is(err, sql.ErrNoRows) {
+		return errors.ErrBookNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return checkVersion(current, version)
+}
+
+func (p *PostgresRepo) DeleteBook(ctx context.Context, id, version int64) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var workID, current int64
+	err = tx.QueryRowContext(ctx, "SELECT work_id, version FROM books WHERE id = $1 FOR UPDATE", id).Scan(&workID, &current)
 	if err != nil {
 		if stdErrors.Is(err, sql.ErrNoRows) {
-			return nil, errors.ErrBookNotFound
+			return errors.ErrBookNotFound
+		}
+		return err
+	}
+	if err = checkVersion(current, version); err != nil {
+		return err
+	}
+
+	// Loans keep the book for the history; the schema refuses to drop it too
+	var inUse bool
+	query := `SELECT EXISTS(SELECT 1 FROM loans WHERE book_id = $1)
+		OR EXISTS(SELECT 1 FROM holds WHERE book_id = $1 AND status IN ($2, $3))`
+	if err = tx.QueryRowContext(ctx, query, id, models.HoldWaiting, models.HoldReady).Scan(&inUse); err != nil {
+		return err
+	}
+	if inUse {
+		return errors.ErrBookHasLoans
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM books WHERE id = $1", id); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM works WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM books WHERE work_id = $1)", workID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *PostgresRepo) GetWork(ctx context.Context, id int64) (*models.Work, error) {
+	var w models.Work
+	if err := p.DB.QueryRowContext(ctx, "SELECT id, title FROM works WHERE id = $1", id).Scan(&w.ID, &w.Title); err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrWorkNotFound
 		}
 		return nil, err
 	}
-	if currentCopies <= 0 {
-		return nil, errors.ErrNoCopies
+
+	rows, err := p.DB.QueryContext(ctx, "SELECT "+bookColumns+" FROM catalog WHERE work_id = $1 ORDER BY id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	w.Editions = []models.BookDetail{}
+	for rows.Next() {
+		b, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		w.Editions = append(w.Editions, *b)
 	}
+	return &w, rows.Err()
+}
 
-	var exists bool
-	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM loans WHERE borrower = $1 AND title = $2)", loan.NameOfBorrower, loan.BookTitle).Scan(&exists)
+const itemColumns = "id, book_id, COALESCE(barcode, ''), condition, status"
+
+func scanItem(row rowScanner) (*models.Item, error) {
+	var i models.Item
+	if err := row.Scan(&i.ID, &i.BookID, &i.Barcode, &i.Condition, &i.Status); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (p *PostgresRepo) AddItem(ctx context.Context, item *models.Item) (*models.Item, error) {
+	if err := p.bookExists(ctx, item.BookID); err != nil {
+		return nil, err
+	}
+	query := "INSERT INTO items (book_id, barcode, condition, status) VALUES ($1, NULLIF($2, ''), $3, $4) RETURNING " + itemColumns
+	i, err := scanItem(p.DB.QueryRowContext(ctx, query, item.BookID, item.Barcode, item.Condition, item.Status))
 	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, errors.ErrItemExists
+		}
 		return nil, err
 	}
-	if exists {
-		return nil, errors.ErrDuplicateLoan
+	return i, nil
+}
+
+func (p *PostgresRepo) GetItem(ctx context.Context, id int64) (*models.Item, error) {
+	i, err := scanItem(p.DB.QueryRowContext(ctx, "SELECT "+itemColumns+" FROM items WHERE id = $1", id))
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrItemNotFound
+		}
+		return nil, err
+	}
+	return i, nil
+}
+
+func (p *PostgresRepo) ListItems(ctx context.Context, bookID int64) ([]models.Item, error) {
+	if err := p.bookExists(ctx, bookID); err != nil {
+		return nil, err
+	}
+	rows, err := p.DB.QueryContext(ctx, "SELECT "+itemColumns+" FROM items WHERE book_id = $1 ORDER BY id", bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.Item{}
+	for rows.Next() {
+		i, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *i)
 	}
+	return items, rows.Err()
+}
 
-	if _, err = tx.Exec("UPDATE books SET available_copies = available_copies - 1 WHERE title = $1", loan.BookTitle); err != nil {
+func (p *PostgresRepo) UpdateItem(ctx context.Context, id int64, item *models.Item) (*models.Item, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	_, err = tx.Exec("INSERT INTO loans (borrower, title, loan_date, return_date) VALUES ($1, $2, $3, $4)",
-		loan.NameOfBorrower, loan.BookTitle, loan.LoanDate, loan.ReturnDate)
+	var bookID int64
+	if err = tx.QueryRowContext(ctx, "SELECT book_id FROM items WHERE id = $1", id).Scan(&bookID); err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrItemNotFound
+		}
+		return nil, err
+	}
+	// Lock the book like lending does, so the item cannot be lent meanwhile
+	if err = lockBook(ctx, tx, bookID); err != nil {
+		return nil, err
+	}
+	var current string
+	if err = tx.QueryRowContext(ctx, "SELECT status FROM items WHERE id = $1", id).Scan(&current); err != nil {
+		return nil, err
+	}
+	status := item.Status
+	if status == "" {
+		status = current
+	}
+	if status != current && (current == models.ItemOnLoan || current == models.ItemReserved) {
+		return nil, errors.ErrItemInUse
+	}
 
+	query := "UPDATE items SET barcode = NULLIF($1, ''), condition = $2, status = $3 WHERE id = $4 RETURNING " + itemColumns
+	i, err := scanItem(tx.QueryRowContext(ctx, query, item.Barcode, item.Condition, status, id))
 	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, errors.ErrItemExists
+		}
 		return nil, err
 	}
+	return i, tx.Commit()
+}
+
+// loanColumns selects a loan together with its borrower's current name, its
+// book's current title and its item's barcode, and whether the book is
+// digital. Queries using it alias the loan as l and add loanJoins.
+const loanColumns = `l.id, l.borrower_id, b.name, l.book_id, bk.title, l.item_id, COALESCE(i.barcode, ''), l.loan_date,
+	l.return_date, l.renewals, l.status, l.returned_at, l.returned_reason, l.version, bk.digital`
 
-	return loan, tx.Commit()
+const loanJoins = " JOIN borrowers b ON b.id = l.borrower_id JOIN books bk ON bk.id = l.book_id JOIN items i ON i.id = l.item_id"
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanLoan reads a loan as it is stored.
+func scanLoan(row rowScanner) (*models.LoanDetail, error) {
+	return scanLoanAt(row, time.Time{})
 }
 
-func (p *PostgresRepo) ExtendLoan(name, title string, newReturnDate time.Time) (*models.LoanDetail, error) {
+// scanLoanAt reads a loan, shown as ended if it is an e-book loan past its
+// return date at now.
+func scanLoanAt(row rowScanner, now time.Time) (*models.LoanDetail, error) {
 	var l models.LoanDetail
-	query := "UPDATE loans SET return_date = $1 WHERE borrower = $2 AND title = $3 RETURNING borrower, title, loan_date, return_date"
-	err := p.DB.QueryRow(query, newReturnDate, name, title).Scan(&l.NameOfBorrower, &l.BookTitle, &l.LoanDate, &l.ReturnDate)
+	var digital bool
+	err := row.Scan(&l.ID, &l.BorrowerID, &l.NameOfBorrower, &l.BookID, &l.BookTitle, &l.ItemID, &l.Barcode,
+		&l.LoanDate, &l.ReturnDate, &l.Renewals, &l.Status, &l.ReturnedAt, &l.ReturnedReason, &l.Version, &digital)
+	if err != nil {
+		return nil, err
+	}
+	if digital {
+		showExpired(&l, now)
+	}
+	return &l, nil
+}
+
+func (p *PostgresRepo) queryLoans(ctx context.Context, now time.Time, query string, args ...any) ([]models.LoanDetail, error) {
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := []models.LoanDetail{}
+	for rows.Next() {
+		l, err := scanLoanAt(rows, now)
+		if err != nil {
+			return nil, err
+		}
+		loans = append(loans, *l)
+	}
+	return loans, rows.Err()
+}
+
+func (p *PostgresRepo) GetLoan(ctx context.Context, borrowerID, bookID int64, now time.Time) (*models.LoanDetail, error) {
+	query := `SELECT ` + loanColumns + ` FROM loans l` + loanJoins + `
+		WHERE l.borrower_id = $1 AND l.book_id = $2 AND l.status = $3`
+	l, err := scanLoanAt(p.DB.QueryRowContext(ctx, query, borrowerID, bookID, models.LoanActive), now)
+	if stdErrors.Is(err, sql.ErrNoRows) || err == nil && l.Status != models.LoanActive {
+		return nil, errors.ErrLoanNotFound
+	}
+	return l, err
+}
+
+func (p *PostgresRepo) GetLoanByID(ctx context.Context, id int64, now time.Time) (*models.LoanDetail, error) {
+	l, err := scanLoanAt(p.DB.QueryRowContext(ctx, "SELECT "+loanColumns+" FROM loans l"+loanJoins+" WHERE l.id = $1", id), now)
 	if err != nil {
 		if stdErrors.Is(err, sql.ErrNoRows) {
 			return nil, errors.ErrLoanNotFound
 		}
 		return nil, err
 	}
-	return &l, nil
+	return l, nil
 }
 
-func (p *PostgresRepo) ReturnBook(name, title string) error {
-	tx, err := p.DB.Begin()
+func (p *PostgresRepo) BorrowBook(ctx context.Context, loan *models.LoanDetail, pickupDeadline time.Time, check BorrowCheck) (*models.LoanDetail, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	res, err := tx.Exec("DELETE FROM loans WHERE borrower = $1 AND title = $2", name, title)
+	// Lock the member, then the book, so the member's borrows are checked one at a time
+	var locked int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM borrowers WHERE id = $1 FOR UPDATE", loan.BorrowerID).Scan(&locked)
 	if err != nil {
-		return err
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrBorrowerNotFound
+		}
+		return nil, err
 	}
-	count, err := res.RowsAffected()
+	if err = checkBorrower(ctx, tx, loan.BorrowerID, loan.LoanDate, check); err != nil {
+		return nil, err
+	}
+	if err = lockBook(ctx, tx, loan.BookID); err != nil {
+		return nil, err
+	}
+	if err = expireBookLoans(ctx, tx, loan.BookID, loan.LoanDate, pickupDeadline); err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM loans WHERE borrower_id = $1 AND book_id = $2 AND status = $3)",
+		loan.BorrowerID, loan.BookID, models.LoanActive).Scan(&exists)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if count == 0 {
-		return errors.ErrLoanNotFound
+	if exists {
+		return nil, errors.ErrDuplicateLoan
 	}
 
-	_, err = tx.Exec("UPDATE books SET available_copies = available_copies + 1 WHERE title = $1", title)
+	// A ready hold already has an item set aside for this borrower
+	var itemID int64
+	err = tx.QueryRowContext(ctx, "UPDATE holds SET status = $1 WHERE borrower_id = $2 AND book_id = $3 AND status = $4 RETURNING item_id",
+		models.HoldFulfilled, loan.BorrowerID, loan.BookID, models.HoldReady).Scan(&itemID)
+	if stdErrors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx, "SELECT id FROM items WHERE book_id = $1 AND status = $2 ORDER BY id LIMIT 1",
+			loan.BookID, models.ItemAvailable).Scan(&itemID)
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrNoCopies
+		}
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return tx.Commit()
+	if _, err = tx.ExecContext(ctx, "UPDATE items SET status = $1 WHERE id = $2", models.ItemOnLoan, itemID); err != nil {
+		return nil, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, "INSERT INTO loans (borrower_id, book_id, item_id, loan_date, return_date, status) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		loan.BorrowerID, loan.BookID, itemID, loan.LoanDate, loan.ReturnDate, models.LoanActive).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := scanLoan(tx.QueryRowContext(ctx, "SELECT "+loanColumns+" FROM loans l"+loanJoins+" WHERE l.id = $1", id))
+	if err != nil {
+		return nil, err
+	}
+	return l, tx.Commit()
+}
+
+func (p *PostgresRepo) ExtendLoan(ctx context.Context, borrowerID, bookID int64, newReturnDate time.Time, version int64) (*models.LoanDetail, error) {
+	query := `WITH l AS (
+			UPDATE loans SET return_date = $1, renewals = renewals + 1, version = version + 1
+			WHERE borrower_id = $2 AND book_id = $3 AND status = $4 AND version = COALESCE($5, version)
+			RETURNING *
+		)
+		SELECT ` + loanColumns + ` FROM l` + loanJoins
+	l, err := scanLoan(p.DB.QueryRowContext(ctx, query, newReturnDate, borrowerID, bookID, models.LoanActive,
+		versionArg(version)))
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, loanUnchanged(ctx, p.DB, borrowerID, bookID, version)
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+// versionArg passes an expected version to a query comparing it as
+// "version = COALESCE($n, version)": zero is sent as NULL, which matches
+// every version.
+func versionArg(version int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: version, Valid: version != 0}
+}
+
+// loanUnchanged tells why an update of the borrower's active loan of the book
+// matched no row: there is no such loan, or it is no longer at the expected
+// version.
+func loanUnchanged(ctx context.Context, q queryRower, borrowerID, bookID, version int64) error {
+	if version == 0 {
+		return errors.ErrLoanNotFound
+	}
+	var exists bool
+	err := q.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM loans WHERE borrower_id = $1 AND book_id = $2 AND status = $3)",
+		borrowerID, bookID, models.LoanActive).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.ErrVersionMismatch
+	}
+	return errors.ErrLoanNotFound
+}
+
+func (p *PostgresRepo) CountLoans(ctx context.Context, borrowerID int64) (int, error) {
+	var count int
+	err := p.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM loans WHERE borrower_id = $1 AND status = $2", borrowerID, models.LoanActive).Scan(&count)
+	return count, err
+}
+
+func (p *PostgresRepo) NextReturnDate(ctx context.Context, bookID int64, now time.Time) (time.Time, error) {
+	var next time.Time
+	err := p.DB.QueryRowContext(ctx, nextReturnQuery, bookID, models.LoanActive, now).Scan(&next)
+	if stdErrors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return next, err
+}
+
+// nextReturnQuery finds the earliest return date among the book's active
+// loans, leaving out e-book loans that ran out by now.
+const nextReturnQuery = `SELECT l.return_date FROM loans l JOIN books bk ON bk.id = l.book_id
+	WHERE l.book_id = $1 AND l.status = $2 AND NOT (bk.digital AND l.return_date < $3)
+	ORDER BY l.return_date LIMIT 1`
+
+func (p *PostgresRepo) ListOverdueLoans(ctx context.Context, now time.Time) ([]models.LoanDetail, error) {
+	query := `SELECT ` + loanColumns + ` FROM loans l` + loanJoins + `
+		WHERE l.status = $1 AND l.return_date < $2 AND NOT bk.digital ORDER BY l.return_date`
+	return p.queryLoans(ctx, time.Time{}, query, models.LoanActive, now)
+}
+
+func (p *PostgresRepo) ListLoansByBorrower(ctx context.Context, borrowerID int64, page models.Page, now time.Time) (*models.LoanPage, error) {
+	if _, err := p.GetBorrower(ctx, borrowerID); err != nil {
+		return nil, err
+	}
+	return p.loanPage(ctx, "l.borrower_id = $1", borrowerID, page, now)
+}
+
+func (p *PostgresRepo) ListLoansByBook(ctx context.Context, bookID int64, page models.Page, now time.Time) (*models.LoanPage, error) {
+	if _, err := p.GetBook(ctx, bookID); err != nil {
+		return nil, err
+	}
+	return p.loanPage(ctx, "l.book_id = $1", bookID, page, now)
+}
+
+// loanPage returns one page of loans matching filter, newest first, with the
+// total number of matches.
+func (p *PostgresRepo) loanPage(ctx context.Context, filter string, arg any, page models.Page, now time.Time) (*models.LoanPage, error) {
+	var total int
+	if err := p.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM loans l WHERE "+filter, arg).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + loanColumns + ` FROM loans l` + loanJoins + `
+		WHERE ` + filter + ` ORDER BY l.loan_date DESC, l.id DESC LIMIT $2 OFFSET $3`
+	loans, err := p.queryLoans(ctx, now, query, arg, page.Limit, page.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return &models.LoanPage{Items: loans, Total: total, Limit: page.Limit, Offset: page.Offset}, nil
+}
+
+func (p *PostgresRepo) MarkLoanLost(ctx context.Context, id int64, at time.Time) (*models.LoanDetail, error) {
+	// The item is gone, so unlike a return nothing is released to the shelf or the hold queue
+	query := `WITH l AS (
+			UPDATE loans SET status = $1, returned_at = $2, returned_reason = $3, version = version + 1
+			WHERE id = $4 AND status = $5
+			RETURNING *
+		), gone AS (
+			UPDATE items SET status = $6 WHERE id = (SELECT item_id FROM l)
+		)
+		SELECT ` + loanColumns + ` FROM l` + loanJoins
+	l, err := scanLoan(p.DB.QueryRowContext(ctx, query, models.LoanLost, at, models.ReturnReasonLost, id, models.LoanActive,
+		models.ItemLost))
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrLoanNotFound
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+func (p *PostgresRepo) PurgeLoans(ctx context.Context, before time.Time) (int, error) {
+	res, err := p.DB.ExecContext(ctx, "DELETE FROM loans WHERE status <> $1 AND returned_at < $2", models.LoanActive, before)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+func (p *PostgresRepo) ReturnBook(ctx context.Context, borrowerID, bookID int64, returnedAt, pickupDeadline time.Time, version int64, fee LateFee) (*models.FineEntry, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the book first so concurrent returns hand items to the hold queue one at a time
+	if _, err = tx.ExecContext(ctx, "SELECT 1 FROM books WHERE id = $1 FOR UPDATE", bookID); err != nil {
+		return nil, err
+	}
+
+	ended, err := endLoan(ctx, tx, borrowerID, bookID, returnedAt, models.ReturnReasonReturned, version)
+	if err != nil {
+		return nil, err
+	}
+	if ended == nil {
+		return nil, loanUnchanged(ctx, tx, borrowerID, bookID, version)
+	}
+
+	if err = releaseItem(ctx, tx, bookID, ended.itemID, pickupDeadline); err != nil {
+		return nil, err
+	}
+	charge, err := chargeLateFee(ctx, tx, borrowerID, bookID, ended.returnDate, returnedAt, fee)
+	if err != nil {
+		return nil, err
+	}
+	return charge, tx.Commit()
+}
+
+// chargeLateFee charges the borrower what fee comes to for a return of the
+// book due back at dueDate, and returns the charge, or nil when there is none.
+func chargeLateFee(ctx context.Context, tx *sql.Tx, borrowerID, bookID int64, dueDate, returnedAt time.Time, fee LateFee) (*models.FineEntry, error) {
+	if fee == nil {
+		return nil, nil
+	}
+	amount := fee(dueDate, returnedAt)
+	if amount == 0 {
+		return nil, nil
+	}
+	f := &models.FineEntry{BorrowerID: borrowerID, Kind: models.FineCharge, AmountCents: amount, Note: lateReturnNote, CreatedAt: returnedAt}
+	if err := tx.QueryRowContext(ctx, "SELECT title FROM books WHERE id = $1", bookID).Scan(&f.BookTitle); err != nil {
+		return nil, err
+	}
+	err := tx.QueryRowContext(ctx, "INSERT INTO fines (borrower_id, kind, amount_cents, book_title, note, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		f.BorrowerID, f.Kind, f.AmountCents, f.BookTitle, f.Note, f.CreatedAt).Scan(&f.ID)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *PostgresRepo) ExpireDigitalLoans(ctx context.Context, now, pickupDeadline time.Time) (int, error) {
+	query := `SELECT l.borrower_id, l.book_id FROM loans l JOIN books b ON b.id = l.book_id
+		WHERE b.digital AND l.status = $1 AND l.return_date < $2 ORDER BY l.return_date`
+	rows, err := p.DB.QueryContext(ctx, query, models.LoanActive, now)
+	if err != nil {
+		return 0, err
+	}
+	type dueLoan struct {
+		borrowerID int64
+		bookID     int64
+	}
+	var due []dueLoan
+	for rows.Next() {
+		var l dueLoan
+		if err := rows.Scan(&l.borrowerID, &l.bookID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, l := range due {
+		ok, err := p.expireLoan(ctx, l.borrowerID, l.bookID, now, pickupDeadline)
+		if err != nil {
+			return expired, err
+		}
+		if ok {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// expireLoan ends a single overdue digital loan at its return date. It
+// reports false when the loan was returned or extended concurrently.
+func (p *PostgresRepo) expireLoan(ctx context.Context, borrowerID, bookID int64, now, pickupDeadline time.Time) (bool, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "SELECT 1 FROM books WHERE id = $1 FOR UPDATE", bookID); err != nil {
+		return false, err
+	}
+
+	var returnDate time.Time
+	err = tx.QueryRowContext(ctx, "SELECT return_date FROM loans WHERE borrower_id = $1 AND book_id = $2 AND status = $3 FOR UPDATE",
+		borrowerID, bookID, models.LoanActive).Scan(&returnDate)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !returnDate.Before(now) {
+		return false, nil
+	}
+
+	ended, err := endLoan(ctx, tx, borrowerID, bookID, returnDate, models.ReturnReasonExpired, 0)
+	if err != nil || ended == nil {
+		return false, err
+	}
+	if err = releaseItem(ctx, tx, bookID, ended.itemID, pickupDeadline); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// expireBookLoans closes the book's e-book loans that ran out by now at their
+// return date, releasing their items. The caller must have locked the book row.
+func expireBookLoans(ctx context.Context, tx *sql.Tx, bookID int64, now, pickupDeadline time.Time) error {
+	rows, err := tx.QueryContext(ctx, `SELECT l.borrower_id, l.return_date FROM loans l JOIN books bk ON bk.id = l.book_id
+		WHERE l.book_id = $1 AND bk.digital AND l.status = $2 AND l.return_date < $3 ORDER BY l.return_date, l.id`,
+		bookID, models.LoanActive, now)
+	if err != nil {
+		return err
+	}
+	type dueLoan struct {
+		borrowerID int64
+		returnDate time.Time
+	}
+	var due []dueLoan
+	for rows.Next() {
+		var l dueLoan
+		if err := rows.Scan(&l.borrowerID, &l.returnDate); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range due {
+		ended, err := endLoan(ctx, tx, l.borrowerID, bookID, l.returnDate.UTC(), models.ReturnReasonExpired, 0)
+		if err != nil {
+			return err
+		}
+		if ended == nil {
+			continue
+		}
+		if err = releaseItem(ctx, tx, bookID, ended.itemID, pickupDeadline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closedLoan is what endLoan leaves to settle: the item to release and when
+// the loan was due back.
+type closedLoan struct {
+	itemID     int64
+	returnDate time.Time
+}
+
+// endLoan closes an active loan at the given version, keeping it as history.
+// It returns nil when there was no such loan.
+func endLoan(ctx context.Context, tx *sql.Tx, borrowerID, bookID int64, returnedAt time.Time, reason string, version int64) (*closedLoan, error) {
+	query := `UPDATE loans SET status = $1, returned_at = $2, returned_reason = $3, version = version + 1
+		WHERE borrower_id = $4 AND book_id = $5 AND status = $6 AND version = COALESCE($7, version) RETURNING item_id, return_date`
+	var l closedLoan
+	err := tx.QueryRowContext(ctx, query, models.LoanReturned, returnedAt, reason, borrowerID, bookID, models.LoanActive,
+		versionArg(version)).Scan(&l.itemID, &l.returnDate)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (p *PostgresRepo) PlaceHold(ctx context.Context, hold *models.HoldDetail) (*models.HoldDetail, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = lockBook(ctx, tx, hold.BookID); err != nil {
+		return nil, err
+	}
+	var currentCopies int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM items WHERE book_id = $1 AND status = $2", hold.BookID, models.ItemAvailable).Scan(&currentCopies)
+	if err != nil {
+		return nil, err
+	}
+
+	var hasLoan bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM loans WHERE borrower_id = $1 AND book_id = $2 AND status = $3)",
+		hold.BorrowerID, hold.BookID, models.LoanActive).Scan(&hasLoan)
+	if err != nil {
+		return nil, err
+	}
+	if hasLoan {
+		return nil, errors.ErrDuplicateLoan
+	}
+
+	var hasHold bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM holds WHERE borrower_id = $1 AND book_id = $2 AND status IN ($3, $4))",
+		hold.BorrowerID, hold.BookID, models.HoldWaiting, models.HoldReady).Scan(&hasHold)
+	if err != nil {
+		return nil, err
+	}
+	if hasHold {
+		return nil, errors.ErrDuplicateHold
+	}
+	if currentCopies > 0 {
+		return nil, errors.ErrCopiesAvailable
+	}
+
+	h := models.HoldDetail{
+		BorrowerID:     hold.BorrowerID,
+		NameOfBorrower: hold.NameOfBorrower,
+		BookID:         hold.BookID,
+		BookTitle:      hold.BookTitle,
+		Status:         models.HoldWaiting,
+		PlacedAt:       hold.PlacedAt,
+	}
+	err = tx.QueryRowContext(ctx, "INSERT INTO holds (borrower_id, book_id, status, placed_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		h.BorrowerID, h.BookID, h.Status, h.PlacedAt).Scan(&h.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &h, tx.Commit()
+}
+
+func (p *PostgresRepo) ListHolds(ctx context.Context, bookID int64) ([]models.HoldDetail, error) {
+	if err := p.bookExists(ctx, bookID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT h.id, h.borrower_id, b.name, h.book_id, bk.title, h.status, h.placed_at, h.expires_at,
+			COALESCE(h.item_id, 0)
+		FROM holds h JOIN borrowers b ON b.id = h.borrower_id JOIN books bk ON bk.id = h.book_id
+		WHERE h.book_id = $1 AND h.status IN ($2, $3) ORDER BY h.id`
+	rows, err := p.DB.QueryContext(ctx, query, bookID, models.HoldWaiting, models.HoldReady)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []models.HoldDetail{}
+	for rows.Next() {
+		var h models.HoldDetail
+		if err := rows.Scan(&h.ID, &h.BorrowerID, &h.NameOfBorrower, &h.BookID, &h.BookTitle, &h.Status, &h.PlacedAt, &h.ExpiresAt,
+			&h.ItemID); err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+	return holds, rows.Err()
+}
+
+func (p *PostgresRepo) ExpireHolds(ctx context.Context, now, pickupDeadline time.Time) (int, error) {
+	rows, err := p.DB.QueryContext(ctx, "SELECT id, book_id FROM holds WHERE status = $1 AND expires_at < $2 ORDER BY id", models.HoldReady, now)
+	if err != nil {
+		return 0, err
+	}
+	type staleHold struct {
+		id     int64
+		bookID int64
+	}
+	var stale []staleHold
+	for rows.Next() {
+		var h staleHold
+		if err := rows.Scan(&h.id, &h.bookID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale = append(stale, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, h := range stale {
+		ok, err := p.expireHold(ctx, h.id, h.bookID, pickupDeadline)
+		if err != nil {
+			return expired, err
+		}
+		if ok {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (p *PostgresRepo) PurgeHolds(ctx context.Context, before time.Time) (int, error) {
+	res, err := p.DB.ExecContext(ctx, "DELETE FROM holds WHERE status IN ($1, $2) AND placed_at < $3",
+		models.HoldFulfilled, models.HoldExpired, before)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// expireHold marks a single ready hold as expired and passes its copy on.
+// It reports false when the hold was picked up or expired concurrently.
+func (p *PostgresRepo) expireHold(ctx context.Context, id, bookID int64, pickupDeadline time.Time) (bool, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "SELECT 1 FROM books WHERE id = $1 FOR UPDATE", bookID); err != nil {
+		return false, err
+	}
+	var itemID int64
+	err = tx.QueryRowContext(ctx, "UPDATE holds SET status = $1 WHERE id = $2 AND status = $3 RETURNING item_id",
+		models.HoldExpired, id, models.HoldReady).Scan(&itemID)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err = releaseItem(ctx, tx, bookID, itemID, pickupDeadline); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// releaseItem sets a freed item aside for the head of the hold queue, or puts
+// it back on the shelf when nobody is waiting. The caller must have locked the book row.
+func releaseItem(ctx context.Context, tx *sql.Tx, bookID, itemID int64, pickupDeadline time.Time) error {
+	res, err := tx.ExecContext(ctx, `UPDATE holds SET status = $1, expires_at = $2, item_id = $3
+		WHERE id = (SELECT id FROM holds WHERE book_id = $4 AND status = $5 ORDER BY id LIMIT 1)`,
+		models.HoldReady, pickupDeadline, itemID, bookID, models.HoldWaiting)
+	if err != nil {
+		return err
+	}
+	allocated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	status := models.ItemAvailable
+	if allocated > 0 {
+		status = models.ItemReserved
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE items SET status = $1 WHERE id = $2", status, itemID)
+	return err
+}
+
+func (p *PostgresRepo) CreateBorrower(ctx context.Context, borrower *models.Borrower) (*models.Borrower, error) {
+	b := *borrower
+	err := p.DB.QueryRowContext(ctx, "INSERT INTO borrowers (name, email, phone, tier, status, membership_expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		b.Name, b.Email, b.Phone, b.Tier, b.Status, b.MembershipExpiresAt).Scan(&b.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, errors.ErrBorrowerExists
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (p *PostgresRepo) GetBorrower(ctx context.Context, id int64) (*models.Borrower, error) {
+	var b models.Borrower
+	err := p.DB.QueryRowContext(ctx, "SELECT id, name, email, phone, tier, status, membership_expires_at FROM borrowers WHERE id = $1", id).
+		Scan(&b.ID, &b.Name, &b.Email, &b.Phone, &b.Tier, &b.Status, &b.MembershipExpiresAt)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrBorrowerNotFound
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (p *PostgresRepo) UpdateBorrower(ctx context.Context, id int64, borrower *models.Borrower) (*models.Borrower, error) {
+	b := *borrower
+	b.ID = id
+	query := "UPDATE borrowers SET name = $1, email = $2, phone = $3, tier = $4, status = $5, membership_expires_at = $6 WHERE id = $7"
+	res, err := p.DB.ExecContext(ctx, query, b.Name, b.Email, b.Phone, b.Tier, b.Status, b.MembershipExpiresAt, id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, errors.ErrBorrowerExists
+		}
+		return nil, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, errors.ErrBorrowerNotFound
+	}
+	return &b, nil
+}
+
+func (p *PostgresRepo) DeleteBorrower(ctx context.Context, id int64) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM borrowers WHERE id = $1 FOR UPDATE", id).Scan(&locked)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return errors.ErrBorrowerNotFound
+		}
+		return err
+	}
+
+	// Ended loans are history, kept like a book's until PurgeLoans drops them
+	var outstanding bool
+	query := `SELECT EXISTS(SELECT 1 FROM loans WHERE borrower_id = $1)
+		OR EXISTS(SELECT 1 FROM holds WHERE borrower_id = $1 AND status IN ($2, $3))`
+	if err = tx.QueryRowContext(ctx, query, id, models.HoldWaiting, models.HoldReady).Scan(&outstanding); err != nil {
+		return err
+	}
+	if outstanding {
+		return errors.ErrBorrowerHasLoans
+	}
+	balance, err := fineBalance(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if balance > 0 {
+		return errors.ErrBorrowerHasLoans
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM holds WHERE borrower_id = $1", id); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM fines WHERE borrower_id = $1", id); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM borrowers WHERE id = $1", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *PostgresRepo) AddFineEntry(ctx context.Context, entry *models.FineEntry) (*models.FineEntry, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the member so concurrent payments cannot overdraw the balance
+	var locked int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM borrowers WHERE id = $1 FOR UPDATE", entry.BorrowerID).Scan(&locked)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrBorrowerNotFound
+		}
+		return nil, err
+	}
+
+	if entry.Kind != models.FineCharge {
+		balance, err := fineBalance(ctx, tx, entry.BorrowerID)
+		if err != nil {
+			return nil, err
+		}
+		if entry.AmountCents > balance {
+			return nil, errors.ErrAmountExceedsBalance
+		}
+	}
+
+	f := *entry
+	err = tx.QueryRowContext(ctx, "INSERT INTO fines (borrower_id, kind, amount_cents, book_title, note, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		f.BorrowerID, f.Kind, f.AmountCents, f.BookTitle, f.Note, f.CreatedAt).Scan(&f.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &f, tx.Commit()
+}
+
+func (p *PostgresRepo) ListFineEntries(ctx context.Context, borrowerID int64) ([]models.FineEntry, error) {
+	if _, err := p.GetBorrower(ctx, borrowerID); err != nil {
+		return nil, err
+	}
+
+	rows, err := p.DB.QueryContext(ctx, "SELECT id, borrower_id, kind, amount_cents, book_title, note, created_at FROM fines WHERE borrower_id = $1 ORDER BY id", borrowerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.FineEntry{}
+	for rows.Next() {
+		var f models.FineEntry
+		if err := rows.Scan(&f.ID, &f.BorrowerID, &f.Kind, &f.AmountCents, &f.BookTitle, &f.Note, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, f)
+	}
+	return entries, rows.Err()
+}
+
+func (p *PostgresRepo) FineBalance(ctx context.Context, borrowerID int64) (int64, error) {
+	if _, err := p.GetBorrower(ctx, borrowerID); err != nil {
+		return 0, err
+	}
+	return fineBalance(ctx, p.DB, borrowerID)
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// checkBorrower runs check, unless nil, on the loans the borrower holds at
+// now and the fines they owe, as q sees them.
+func checkBorrower(ctx context.Context, q queryRower, borrowerID int64, now time.Time, check BorrowCheck) error {
+	if check == nil {
+		return nil
+	}
+	var active int
+	query := `SELECT COUNT(*) FROM loans l JOIN books bk ON bk.id = l.book_id
+		WHERE l.borrower_id = $1 AND l.status = $2 AND NOT (bk.digital AND l.return_date < $3)`
+	err := q.QueryRowContext(ctx, query, borrowerID, models.LoanActive, now).Scan(&active)
+	if err != nil {
+		return err
+	}
+	owed, err := fineBalance(ctx, q, borrowerID)
+	if err != nil {
+		return err
+	}
+	return check(active, owed)
+}
+
+func fineBalance(ctx context.Context, q queryRower, borrowerID int64) (int64, error) {
+	var balance int64
+	query := "SELECT COALESCE(SUM(CASE WHEN kind = $1 THEN amount_cents ELSE -amount_cents END), 0) FROM fines WHERE borrower_id = $2"
+	err := q.QueryRowContext(ctx, query, models.FineCharge, borrowerID).Scan(&balance)
+	return balance, err
 }
 
-func (p *PostgresRepo) Ping() error {
-	return p.DB.Ping()
+func (p *PostgresRepo) Ping(ctx context.Context) error {
+	return p.DB.PingContext(ctx)
 }
@@ -1,15 +1,118 @@
 package repository
 
 import (
+	"context"
 	"e-library-api/internal/models"
 	"time"
 )
 
+// LibraryRepository stores the library. Books and loans carry a version that
+// changes with every change to them. Methods taking a version only change a
+// record still at that version, and fail with ErrVersionMismatch otherwise;
+// a zero version changes the record whatever its version.
 type LibraryRepository interface {
-	GetBook(title string) (*models.BookDetail, error)
-	GetLoan(name, title string) (*models.LoanDetail, error)
-	BorrowBook(loan *models.LoanDetail) (*models.LoanDetail, error)
-	ExtendLoan(name, title string, newReturnDate time.Time) (*models.LoanDetail, error)
-	ReturnBook(name, title string) error
-	Ping() error
+	GetBook(ctx context.Context, id int64) (*models.BookDetail, error)
+	// ListBooks returns one page of the catalog using keyset pagination, so
+	// pages stay stable while books are added or removed
+	ListBooks(ctx context.Context, query models.BookQuery) (*models.BookPage, error)
+	// SearchBooks ranks books matching every word of the query in their
+	// title, authors, subjects or description, best match first
+	SearchBooks(ctx context.Context, query string, limit int) ([]models.SearchResult, error)
+	// CreateBook adds an edition to the work named by book.WorkID, or to a
+	// new work when it is zero
+	CreateBook(ctx context.Context, book *models.BookDetail) (*models.BookDetail, error)
+	UpdateBook(ctx context.Context, id int64, book *models.BookDetail, version int64) (*models.BookDetail, error)
+	// AdjustCopies adds items without barcodes, or withdraws items from the
+	// shelf when delta is negative
+	AdjustCopies(ctx context.Context, id int64, delta int, version int64) (*models.BookDetail, error)
+	// DeleteBook removes an edition with its items, and its work once no
+	// editions are left. Editions that were ever lent out stay, so that the
+	// loan history keeps them; so do editions members are waiting for.
+	DeleteBook(ctx context.Context, id, version int64) error
+	GetWork(ctx context.Context, id int64) (*models.Work, error)
+	AddItem(ctx context.Context, item *models.Item) (*models.Item, error)
+	GetItem(ctx context.Context, id int64) (*models.Item, error)
+	ListItems(ctx context.Context, bookID int64) ([]models.Item, error)
+	// UpdateItem changes an item's barcode, condition and status; an empty
+	// status keeps the current one. The status of an item on loan or set
+	// aside for a hold cannot be changed.
+	UpdateItem(ctx context.Context, id int64, item *models.Item) (*models.Item, error)
+	// GetLoan returns the borrower's active loan of the book. The loan reads
+	// take the current time: an e-book loan past its return date has ended
+	// then, even before ExpireDigitalLoans has closed it.
+	GetLoan(ctx context.Context, borrowerID, bookID int64, now time.Time) (*models.LoanDetail, error)
+	// GetLoanByID returns a loan, active or ended
+	GetLoanByID(ctx context.Context, id int64, now time.Time) (*models.LoanDetail, error)
+	// BorrowBook lends the item set aside by the borrower's ready hold, or
+	// else any item on the shelf. check, unless nil, is run on the borrower's
+	// standing inside the same transaction, so that concurrent borrows
+	// cannot together go past a limit it enforces.
+	//
+	// E-book loans past their return date at loan.LoanDate have ended, even
+	// before ExpireDigitalLoans has closed them: they are not counted for
+	// check, and the book's are closed first, so their items can be lent
+	// again or go to the hold queue with pickupDeadline.
+	BorrowBook(ctx context.Context, loan *models.LoanDetail, pickupDeadline time.Time, check BorrowCheck) (*models.LoanDetail, error)
+	// ExtendLoan moves the due date and counts the renewal against the loan
+	ExtendLoan(ctx context.Context, borrowerID, bookID int64, newReturnDate time.Time, version int64) (*models.LoanDetail, error)
+	CountLoans(ctx context.Context, borrowerID int64) (int, error)
+	// NextReturnDate is when the first active loan of the book is due back,
+	// or the zero time when no copy is on loan at now
+	NextReturnDate(ctx context.Context, bookID int64, now time.Time) (time.Time, error)
+	// ListOverdueLoans returns the active loans past their return date, of
+	// printed books only, as e-book loans end at theirs
+	ListOverdueLoans(ctx context.Context, now time.Time) ([]models.LoanDetail, error)
+	// ReturnBook ends the borrower's loan of the book. fee, unless nil, is
+	// run on the loan's return date in the same transaction, and what it
+	// comes to is charged to the borrower's ledger as a late return; the
+	// charge is returned, or nil when there is none.
+	ReturnBook(ctx context.Context, borrowerID, bookID int64, returnedAt, pickupDeadline time.Time, version int64, fee LateFee) (*models.FineEntry, error)
+	// ExpireDigitalLoans ends loans of digital books whose return date has
+	// passed, closing them with the expired reason
+	ExpireDigitalLoans(ctx context.Context, now, pickupDeadline time.Time) (int, error)
+	// MarkLoanLost closes an active loan as lost, along with its item.
+	MarkLoanLost(ctx context.Context, id int64, at time.Time) (*models.LoanDetail, error)
+	// ListLoansByBorrower and ListLoansByBook return active and ended loans,
+	// newest first
+	ListLoansByBorrower(ctx context.Context, borrowerID int64, page models.Page, now time.Time) (*models.LoanPage, error)
+	ListLoansByBook(ctx context.Context, bookID int64, page models.Page, now time.Time) (*models.LoanPage, error)
+	// PurgeLoans deletes ended loans that were closed before the cutoff
+	PurgeLoans(ctx context.Context, before time.Time) (int, error)
+	PlaceHold(ctx context.Context, hold *models.HoldDetail) (*models.HoldDetail, error)
+	ListHolds(ctx context.Context, bookID int64) ([]models.HoldDetail, error)
+	ExpireHolds(ctx context.Context, now, pickupDeadline time.Time) (int, error)
+	// PurgeHolds deletes fulfilled and expired holds placed before the cutoff
+	PurgeHolds(ctx context.Context, before time.Time) (int, error)
+	CreateBorrower(ctx context.Context, borrower *models.Borrower) (*models.Borrower, error)
+	GetBorrower(ctx context.Context, id int64) (*models.Borrower, error)
+	UpdateBorrower(ctx context.Context, id int64, borrower *models.Borrower) (*models.Borrower, error)
+	DeleteBorrower(ctx context.Context, id int64) error
+	AddFineEntry(ctx context.Context, entry *models.FineEntry) (*models.FineEntry, error)
+	ListFineEntries(ctx context.Context, borrowerID int64) ([]models.FineEntry, error)
+	FineBalance(ctx context.Context, borrowerID int64) (int64, error)
+	Ping(ctx context.Context) error
+}
+
+// BorrowCheck decides whether a borrower may take out another loan, given the
+// number of active loans they hold and the fines they owe, in cents.
+type BorrowCheck func(activeLoans int, finesOwed int64) error
+
+// LateFee prices a loan due back at dueDate and returned at returnedAt, in
+// cents; zero charges nothing.
+type LateFee func(dueDate, returnedAt time.Time) int64
+
+// lateReturnNote describes the ledger entries ReturnBook charges.
+const lateReturnNote = "late return"
+
+// showExpired shows an active loan whose return date is before now as ended,
+// the way ExpireDigitalLoans will close it. It does not look at the book:
+// callers only pass it loans of digital books.
+func showExpired(l *models.LoanDetail, now time.Time) {
+	if l.Status != models.LoanActive || !l.ReturnDate.Before(now) {
+		return
+	}
+	returnedAt := l.ReturnDate
+	l.Status = models.LoanReturned
+	l.ReturnedAt = &returnedAt
+	l.ReturnedReason = models.ReturnReasonExpired
 }
@@ -0,0 +1,1051 @@
+// Package repotest checks that an implementation of
+// repository.LibraryRepository honours the contract the service relies on.
+// Every repository runs the same suite, so they behave identically. Input is
+// shaped the way the service layer passes it, already validated and normalized.
+package repotest
+
+import (
+	"context"
+	"e-library-api/internal/errors"
+	"e-library-api/internal/models"
+	"e-library-api/internal/repository"
+	stdErrors "errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory returns a repository for a single test. It may already hold books
+// of its own, such as seed data, but none created by another test.
+type Factory func(t *testing.T) repository.LibraryRepository
+
+// ctx is passed to every repository call; the suite never cancels it.
+var ctx = context.Background()
+
+// missingID is an ID no test creates.
+const missingID = 999_999
+
+// Run runs every contract as a subtest against a new repository from
+// newRepo.
+func Run(t *testing.T, newRepo Factory) {
+	contracts := []struct {
+		name string
+		run  func(t *testing.T, repo repository.LibraryRepository)
+	}{
+		{"NotFound", testNotFound},
+		{"UniqueFields", testUniqueFields},
+		{"Editions", testEditions},
+		{"Search", testSearch},
+		{"CopyAccounting", testCopyAccounting},
+		{"Items", testItems},
+		{"DuplicateLoan", testDuplicateLoan},
+		{"LoanLifecycle", testLoanLifecycle},
+		{"LostLoan", testLostLoan},
+		{"DigitalLoansExpire", testDigitalLoansExpire},
+		{"BorrowAfterExpiry", testBorrowAfterExpiry},
+		{"HoldQueue", testHoldQueue},
+		{"HoldsExpire", testHoldsExpire},
+		{"DeleteGuards", testDeleteGuards},
+		{"DeleteKeepsHistory", testDeleteKeepsHistory},
+		{"Fines", testFines},
+		{"LateFeeOnReturn", testLateFeeOnReturn},
+		{"ConcurrentBorrowOfLastCopy", testConcurrentBorrowOfLastCopy},
+		{"BorrowCheck", testBorrowCheck},
+		{"ConcurrentBorrowsOverLimit", testConcurrentBorrowsOverLimit},
+		{"Versions", testVersions},
+	}
+	for _, c := range contracts {
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, newRepo(t))
+		})
+	}
+}
+
+// now is truncated to whole seconds, which every repository stores exactly.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// edition returns a printed book shaped as the service passes it to the
+// repository, with empty rather than nil lists.
+func edition(title string) *models.BookDetail {
+	return &models.BookDetail{
+		Title:    title,
+		Authors:  []string{},
+		Subjects: []string{},
+		Format:   models.FormatPrint,
+	}
+}
+
+func addBook(t *testing.T, repo repository.LibraryRepository, title string, copies int) *models.BookDetail {
+	t.Helper()
+	book := edition(title)
+	book.AvailableCopies = copies
+	book, err := repo.CreateBook(ctx, book)
+	require.NoError(t, err)
+	return book
+}
+
+func addBorrower(t *testing.T, repo repository.LibraryRepository, name string) *models.Borrower {
+	t.Helper()
+	b, err := repo.CreateBorrower(ctx, &models.Borrower{
+		Name:                name,
+		Email:               name + "@example.com",
+		Tier:                models.DefaultTier,
+		Status:              models.MemberActive,
+		MembershipExpiresAt: now().AddDate(1, 0, 0),
+	})
+	require.NoError(t, err)
+	return b
+}
+
+func newLoan(borrower *models.Borrower, book *models.BookDetail, due time.Time) *models.LoanDetail {
+	return &models.LoanDetail{
+		BorrowerID: borrower.ID,
+		BookID:     book.ID,
+		LoanDate:   now(),
+		ReturnDate: due,
+	}
+}
+
+func borrow(t *testing.T, repo repository.LibraryRepository, borrower *models.Borrower, book *models.BookDetail) *models.LoanDetail {
+	t.Helper()
+	loan, err := repo.BorrowBook(ctx, newLoan(borrower, book, now().AddDate(0, 0, 14)), now(), nil)
+	require.NoError(t, err)
+	return loan
+}
+
+// giveBack returns a loan without charging a late fee.
+func giveBack(t *testing.T, repo repository.LibraryRepository, borrowerID, bookID int64, at, pickupDeadline time.Time, version int64) {
+	t.Helper()
+	_, err := repo.ReturnBook(ctx, borrowerID, bookID, at, pickupDeadline, version, nil)
+	require.NoError(t, err)
+}
+
+func availableCopies(t *testing.T, repo repository.LibraryRepository, bookID int64) int {
+	t.Helper()
+	book, err := repo.GetBook(ctx, bookID)
+	require.NoError(t, err)
+	return book.AvailableCopies
+}
+
+func testNotFound(t *testing.T, repo repository.LibraryRepository) {
+	book := addBook(t, repo, "Present", 1)
+	alice := addBorrower(t, repo, "alice")
+	at := now()
+
+	_, err := repo.GetBook(ctx, missingID)
+	assert.ErrorIs(t, err, errors.ErrBookNotFound)
+	_, err = repo.UpdateBook(ctx, missingID, edition("Gone"), 0)
+	assert.ErrorIs(t, err, errors.ErrBookNotFound)
+	_, err = repo.AdjustCopies(ctx, missingID, 1, 0)
+	assert.ErrorIs(t, err, errors.ErrBookNotFound)
+	assert.ErrorIs(t, repo.DeleteBook(ctx, missingID, 0), errors.ErrBookNotFound)
+	_, err = repo.ListHolds(ctx, missingID)
+	assert.ErrorIs(t, err, errors.ErrBookNotFound)
+	_, err = repo.BorrowBook(ctx, &models.LoanDetail{BorrowerID: alice.ID, BookID: missingID, LoanDate: at, ReturnDate: at}, now(), nil)
+	assert.ErrorIs(t, err, errors.ErrBookNotFound)
+	_, err = repo.PlaceHold(ctx, &models.HoldDetail{BorrowerID: alice.ID, BookID: missingID, PlacedAt: at})
+	assert.ErrorIs(t, err, errors.ErrBookNotFound)
+
+	_, err = repo.GetWork(ctx, missingID)
+	assert.ErrorIs(t, err, errors.ErrWorkNotFound)
+	orphan := edition("Orphan")
+	orphan.WorkID = missingID
+	_, err = repo.CreateBook(ctx, orphan)
+	assert.ErrorIs(t, err, errors.ErrWorkNotFound)
+
+	_, err = repo.AddItem(ctx, &models.Item{BookID: missingID, Condition: models.ConditionGood, Status: models.ItemAvailable})
+	assert.ErrorIs(t, err, errors.ErrBookNotFound)
+	_, err = repo.GetItem(ctx, missingID)
+	assert.ErrorIs(t, err, errors.ErrItemNotFound)
+	_, err = repo.UpdateItem(ctx, missingID, &models.Item{Condition: models.ConditionGood})
+	assert.ErrorIs(t, err, errors.ErrItemNotFound)
+
+	// alice exists and so does the book, but she has not borrowed it
+	_, err = repo.GetLoan(ctx, alice.ID, book.ID, now())
+	assert.ErrorIs(t, err, errors.ErrLoanNotFound)
+	_, err = repo.ExtendLoan(ctx, alice.ID, book.ID, at, 0)
+	assert.ErrorIs(t, err, errors.ErrLoanNotFound)
+	_, err = repo.ReturnBook(ctx, alice.ID, book.ID, at, at, 0, nil)
+	assert.ErrorIs(t, err, errors.ErrLoanNotFound)
+	_, err = repo.MarkLoanLost(ctx, missingID, at)
+	assert.ErrorIs(t, err, errors.ErrLoanNotFound)
+
+	_, err = repo.GetBorrower(ctx, missingID)
+	assert.ErrorIs(t, err, errors.ErrBorrowerNotFound)
+	_, err = repo.UpdateBorrower(ctx, missingID, &models.Borrower{Name: "nobody", Email: "nobody@example.com"})
+	assert.ErrorIs(t, err, errors.ErrBorrowerNotFound)
+	assert.ErrorIs(t, repo.DeleteBorrower(ctx, missingID), errors.ErrBorrowerNotFound)
+	_, err = repo.AddFineEntry(ctx, &models.FineEntry{BorrowerID: missingID, Kind: models.FineCharge, AmountCents: 1, CreatedAt: at})
+	assert.ErrorIs(t, err, errors.ErrBorrowerNotFound)
+	_, err = repo.ListFineEntries(ctx, missingID)
+	assert.ErrorIs(t, err, errors.ErrBorrowerNotFound)
+	_, err = repo.FineBalance(ctx, missingID)
+	assert.ErrorIs(t, err, errors.ErrBorrowerNotFound)
+}
+
+func testUniqueFields(t *testing.T, repo repository.LibraryRepository) {
+	book := edition("SICP")
+	book.ISBN = "9780262510875"
+	book, err := repo.CreateBook(ctx, book)
+	require.NoError(t, err)
+	again := edition("SICP again")
+	again.ISBN = book.ISBN
+	_, err = repo.CreateBook(ctx, again)
+	assert.ErrorIs(t, err, errors.ErrBookExists)
+	other := edition("CLRS")
+	other.ISBN = "9780262033848"
+	other, err = repo.CreateBook(ctx, other)
+	require.NoError(t, err)
+	other.ISBN = book.ISBN
+	_, err = repo.UpdateBook(ctx, other.ID, other, 0)
+	assert.ErrorIs(t, err, errors.ErrBookExists)
+	// Books without an ISBN do not clash with each other
+	addBook(t, repo, "No ISBN", 0)
+	addBook(t, repo, "No ISBN either", 0)
+
+	alice := addBorrower(t, repo, "alice")
+	bob := addBorrower(t, repo, "bob")
+	_, err = repo.CreateBorrower(ctx, &models.Borrower{Name: "Alice", Email: alice.Email, Tier: models.DefaultTier, Status: models.MemberActive})
+	assert.ErrorIs(t, err, errors.ErrBorrowerExists)
+	bob.Email = alice.Email
+	_, err = repo.UpdateBorrower(ctx, bob.ID, bob)
+	assert.ErrorIs(t, err, errors.ErrBorrowerExists)
+
+	first, err := repo.AddItem(ctx, &models.Item{BookID: book.ID, Barcode: "B-1", Condition: models.ConditionNew, Status: models.ItemAvailable})
+	require.NoError(t, err)
+	_, err = repo.AddItem(ctx, &models.Item{BookID: other.ID, Barcode: "B-1", Condition: models.ConditionNew, Status: models.ItemAvailable})
+	assert.ErrorIs(t, err, errors.ErrItemExists)
+	second, err := repo.AddItem(ctx, &models.Item{BookID: book.ID, Barcode: "B-2", Condition: models.ConditionNew, Status: models.ItemAvailable})
+	require.NoError(t, err)
+	_, err = repo.UpdateItem(ctx, second.ID, &models.Item{Barcode: first.Barcode, Condition: models.ConditionNew})
+	assert.ErrorIs(t, err, errors.ErrItemExists)
+}
+
+func testEditions(t *testing.T, repo repository.LibraryRepository) {
+	first := addBook(t, repo, "Dune", 1)
+	second := edition("Dune (Deluxe)")
+	second.WorkID = first.WorkID
+	second, err := repo.CreateBook(ctx, second)
+	require.NoError(t, err)
+	assert.Equal(t, first.WorkID, second.WorkID)
+
+	work, err := repo.GetWork(ctx, first.WorkID)
+	require.NoError(t, err)
+	require.Len(t, work.Editions, 2)
+	assert.Equal(t, first.ID, work.Editions[0].ID)
+	assert.Equal(t, second.ID, work.Editions[1].ID)
+
+	// The work goes with its last edition
+	require.NoError(t, repo.DeleteBook(ctx, first.ID, 0))
+	_, err = repo.GetWork(ctx, first.WorkID)
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteBook(ctx, second.ID, 0))
+	_, err = repo.GetWork(ctx, first.WorkID)
+	assert.ErrorIs(t, err, errors.ErrWorkNotFound)
+	_, err = repo.GetBook(ctx, first.ID)
+	assert.ErrorIs(t, err, errors.ErrBookNotFound)
+}
+
+func testSearch(t *testing.T, repo repository.LibraryRepository) {
+	book, err := repo.CreateBook(ctx, &models.BookDetail{
+		Title:       "Structure and Interpretation of Computer Programs",
+		Authors:     []string{"Harold Abelson", "Gerald Jay Sussman"},
+		Subjects:    []string{"Lisp"},
+		Description: "An introduction to computation through Scheme.",
+		Format:      models.FormatPrint,
+	})
+	require.NoError(t, err)
+	addBook(t, repo, "Cooking for Engineers", 0)
+
+	results, err := repo.SearchBooks(ctx, "sussman interpretation", 10)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, book.ID, results[0].ID)
+	assert.Equal(t, []string{"Harold Abelson", "Gerald Jay Sussman"}, results[0].Authors)
+
+	// Every word has to match
+	results, err = repo.SearchBooks(ctx, "sussman cooking", 10)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	// Updates are searchable at once
+	book.Title = "Zymurgy for Programmers"
+	_, err = repo.UpdateBook(ctx, book.ID, book, 0)
+	require.NoError(t, err)
+	results, err = repo.SearchBooks(ctx, "zymurgy", 10)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, book.ID, results[0].ID)
+}
+
+func testCopyAccounting(t *testing.T, repo repository.LibraryRepository) {
+	book := addBook(t, repo, "Refactoring", 2)
+	assert.Equal(t, 2, book.AvailableCopies)
+	alice := addBorrower(t, repo, "alice")
+	bob := addBorrower(t, repo, "bob")
+	carol := addBorrower(t, repo, "carol")
+
+	borrow(t, repo, alice, book)
+	assert.Equal(t, 1, availableCopies(t, repo, book.ID))
+	borrow(t, repo, bob, book)
+	assert.Equal(t, 0, availableCopies(t, repo, book.ID))
+	_, err := repo.BorrowBook(ctx, newLoan(carol, book, now().AddDate(0, 0, 14)), now(), nil)
+	assert.ErrorIs(t, err, errors.ErrNoCopies)
+
+	at := now()
+	giveBack(t, repo, alice.ID, book.ID, at, at.Add(time.Hour), 0)
+	assert.Equal(t, 1, availableCopies(t, repo, book.ID))
+
+	// Only copies on the shelf can be withdrawn
+	_, err = repo.AdjustCopies(ctx, book.ID, -2, 0)
+	assert.ErrorIs(t, err, errors.ErrInvalidCopyCount)
+	book, err = repo.AdjustCopies(ctx, book.ID, 3, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 4, book.AvailableCopies)
+	book, err = repo.AdjustCopies(ctx, book.ID, -4, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, book.AvailableCopies)
+
+	items, err := repo.ListItems(ctx, book.ID)
+	require.NoError(t, err)
+	statuses := map[string]int{}
+	for _, item := range items {
+		statuses[item.Status]++
+	}
+	assert.Equal(t, map[string]int{models.ItemOnLoan: 1, models.ItemWithdrawn: 4}, statuses)
+
+	// Editing the book does not touch its copies
+	book.Title = "Refactoring, 2nd edition"
+	book, err = repo.UpdateBook(ctx, book.ID, book, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, book.AvailableCopies)
+	giveBack(t, repo, bob.ID, book.ID, at, at.Add(time.Hour), 0)
+	assert.Equal(t, 1, availableCopies(t, repo, book.ID))
+}
+
+func testItems(t *testing.T, repo repository.LibraryRepository) {
+	book := addBook(t, repo, "Dracula", 0)
+	item, err := repo.AddItem(ctx, &models.Item{BookID: book.ID, Barcode: "D-1", Condition: models.ConditionFair, Status: models.ItemAvailable})
+	require.NoError(t, err)
+	assert.Equal(t, book.ID, item.BookID)
+	assert.Equal(t, 1, availableCopies(t, repo, book.ID))
+
+	got, err := repo.GetItem(ctx, item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, *item, *got)
+
+	alice := addBorrower(t, repo, "alice")
+	loan := borrow(t, repo, alice, book)
+	assert.Equal(t, item.ID, loan.ItemID)
+	assert.Equal(t, "D-1", loan.Barcode)
+
+	// An item on loan cannot be withdrawn, but its details can change
+	_, err = repo.UpdateItem(ctx, item.ID, &models.Item{Barcode: "D-1", Condition: models.ConditionPoor, Status: models.ItemWithdrawn})
+	assert.ErrorIs(t, err, errors.ErrItemInUse)
+	updated, err := repo.UpdateItem(ctx, item.ID, &models.Item{Barcode: "D-100", Condition: models.ConditionPoor})
+	require.NoError(t, err)
+	assert.Equal(t, models.ItemOnLoan, updated.Status)
+	assert.Equal(t, models.ConditionPoor, updated.Condition)
+
+	at := now()
+	giveBack(t, repo, alice.ID, book.ID, at, at.Add(time.Hour), 0)
+	updated, err = repo.UpdateItem(ctx, item.ID, &models.Item{Barcode: "D-100", Condition: models.ConditionPoor, Status: models.ItemWithdrawn})
+	require.NoError(t, err)
+	assert.Equal(t, models.ItemWithdrawn, updated.Status)
+	assert.Equal(t, 0, availableCopies(t, repo, book.ID))
+}
+
+func testDuplicateLoan(t *testing.T, repo repository.LibraryRepository) {
+	book := addBook(t, repo, "Emma", 2)
+	alice := addBorrower(t, repo, "alice")
+	borrow(t, repo, alice, book)
+
+	_, err := repo.BorrowBook(ctx, newLoan(alice, book, now().AddDate(0, 0, 14)), now(), nil)
+	assert.ErrorIs(t, err, errors.ErrDuplicateLoan)
+	assert.Equal(t, 1, availableCopies(t, repo, book.ID), "a refused loan must not take a copy")
+
+	// Nor can she queue for a book she already has
+	_, err = repo.PlaceHold(ctx, &models.HoldDetail{BorrowerID: alice.ID, BookID: book.ID, PlacedAt: now()})
+	assert.Error(t, err)
+
+	// Once returned, the book can be borrowed again
+	at := now()
+	giveBack(t, repo, alice.ID, book.ID, at, at.Add(time.Hour), 0)
+	borrow(t, repo, alice, book)
+}
+
+func testLoanLifecycle(t *testing.T, repo repository.LibraryRepository) {
+	book := addBook(t, repo, "Middlemarch", 1)
+	alice := addBorrower(t, repo, "alice")
+	start := now()
+
+	loan, err := repo.BorrowBook(ctx, newLoan(alice, book, start.AddDate(0, 0, 14)), now(), nil)
+	require.NoError(t, err)
+	assert.NotZero(t, loan.ID)
+	assert.Equal(t, models.LoanActive, loan.Status)
+	assert.Equal(t, "alice", loan.NameOfBorrower)
+	assert.Equal(t, "Middlemarch", loan.BookTitle)
+	assert.NotZero(t, loan.ItemID)
+	assert.Nil(t, loan.ReturnedAt)
+
+	got, err := repo.GetLoan(ctx, alice.ID, book.ID, now())
+	require.NoError(t, err)
+	assert.Equal(t, loan.ID, got.ID)
+	assert.Equal(t, loan.ItemID, got.ItemID)
+	assert.WithinDuration(t, loan.ReturnDate, got.ReturnDate, time.Second)
+	got, err = repo.GetLoanByID(ctx, loan.ID, now())
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, got.BorrowerID)
+	assert.Equal(t, "Middlemarch", got.BookTitle)
+	_, err = repo.GetLoanByID(ctx, loan.ID+1000, now())
+	assert.ErrorIs(t, err, errors.ErrLoanNotFound)
+
+	count, err := repo.CountLoans(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	extended, err := repo.ExtendLoan(ctx, alice.ID, book.ID, start.AddDate(0, 0, 35), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, extended.Renewals)
+	assert.WithinDuration(t, start.AddDate(0, 0, 35), extended.ReturnDate, time.Second)
+	next, err := repo.NextReturnDate(ctx, book.ID, now())
+	require.NoError(t, err)
+	assert.WithinDuration(t, extended.ReturnDate, next, time.Second)
+
+	overdue, err := repo.ListOverdueLoans(ctx, start.AddDate(0, 0, 30))
+	require.NoError(t, err)
+	assert.Empty(t, overdue, "the extension moved the due date")
+	overdue, err = repo.ListOverdueLoans(ctx, start.AddDate(0, 0, 36))
+	require.NoError(t, err)
+	require.Len(t, overdue, 1)
+	assert.Equal(t, loan.ID, overdue[0].ID)
+
+	returned := start.AddDate(0, 0, 20)
+	giveBack(t, repo, alice.ID, book.ID, returned, returned.Add(time.Hour), 0)
+	_, err = repo.GetLoan(ctx, alice.ID, book.ID, now())
+	assert.ErrorIs(t, err, errors.ErrLoanNotFound)
+	got, err = repo.GetLoanByID(ctx, loan.ID, now())
+	require.NoError(t, err, "ended loans can still be looked up")
+	assert.Equal(t, models.LoanReturned, got.Status)
+	next, err = repo.NextReturnDate(ctx, book.ID, now())
+	require.NoError(t, err)
+	assert.True(t, next.IsZero(), "no copy is on loan")
+	count, err = repo.CountLoans(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	// Ended loans stay in the history, newest first
+	second := borrow(t, repo, alice, book)
+	for _, page := range []func() (*models.LoanPage, error){
+		func() (*models.LoanPage, error) {
+			return repo.ListLoansByBorrower(ctx, alice.ID, models.Page{Limit: 10}, now())
+		},
+		func() (*models.LoanPage, error) {
+			return repo.ListLoansByBook(ctx, book.ID, models.Page{Limit: 10}, now())
+		},
+	} {
+		history, err := page()
+		require.NoError(t, err)
+		assert.Equal(t, 2, history.Total)
+		require.Len(t, history.Items, 2)
+		assert.Equal(t, second.ID, history.Items[0].ID)
+		assert.Equal(t, models.LoanActive, history.Items[0].Status)
+		ended := history.Items[1]
+		assert.Equal(t, loan.ID, ended.ID)
+		assert.Equal(t, models.LoanReturned, ended.Status)
+		assert.Equal(t, models.ReturnReasonReturned, ended.ReturnedReason)
+		require.NotNil(t, ended.ReturnedAt)
+		assert.WithinDuration(t, returned, *ended.ReturnedAt, time.Second)
+	}
+	history, err := repo.ListLoansByBorrower(ctx, alice.ID, models.Page{Limit: 1, Offset: 1}, now())
+	require.NoError(t, err)
+	assert.Equal(t, 2, history.Total)
+	require.Len(t, history.Items, 1)
+	assert.Equal(t, loan.ID, history.Items[0].ID)
+
+	// Purging removes ended loans only
+	purged, err := repo.PurgeLoans(ctx, returned)
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+	purged, err = repo.PurgeLoans(ctx, returned.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	history, err = repo.ListLoansByBook(ctx, book.ID, models.Page{Limit: 10}, now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, history.Total)
+}
+
+func testLostLoan(t *testing.T, repo repository.LibraryRepository) {
+	book := addBook(t, repo, "Ulysses", 1)
+	alice := addBorrower(t, repo, "alice")
+	bob := addBorrower(t, repo, "bob")
+	loan := borrow(t, repo, alice, book)
+	_, err := repo.PlaceHold(ctx, &models.HoldDetail{BorrowerID: bob.ID, BookID: book.ID, PlacedAt: now()})
+	require.NoError(t, err)
+
+	at := now()
+	lost, err := repo.MarkLoanLost(ctx, loan.ID, at)
+	require.NoError(t, err)
+	assert.Equal(t, models.LoanLost, lost.Status)
+	assert.Equal(t, models.ReturnReasonLost, lost.ReturnedReason)
+	_, err = repo.MarkLoanLost(ctx, loan.ID, at)
+	assert.ErrorIs(t, err, errors.ErrLoanNotFound)
+
+	// The copy is gone: nothing goes back on the shelf or to bob
+	item, err := repo.GetItem(ctx, loan.ItemID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ItemLost, item.Status)
+	assert.Equal(t, 0, availableCopies(t, repo, book.ID))
+	holds, err := repo.ListHolds(ctx, book.ID)
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, models.HoldWaiting, holds[0].Status)
+}
+
+func testDigitalLoansExpire(t *testing.T, repo repository.LibraryRepository) {
+	ebook := edition("Neuromancer")
+	ebook.Format = models.FormatEPUB
+	ebook.Digital = true
+	ebook.AvailableCopies = 1
+	ebook, err := repo.CreateBook(ctx, ebook)
+	require.NoError(t, err)
+	print := addBook(t, repo, "Neuromancer (paperback)", 1)
+	alice := addBorrower(t, repo, "alice")
+	bob := addBorrower(t, repo, "bob")
+
+	due := now().AddDate(0, 0, 14)
+	loan, err := repo.BorrowBook(ctx, newLoan(alice, ebook, due), now(), nil)
+	require.NoError(t, err)
+	_, err = repo.BorrowBook(ctx, newLoan(alice, print, due), now(), nil)
+	require.NoError(t, err)
+	_, err = repo.PlaceHold(ctx, &models.HoldDetail{BorrowerID: bob.ID, BookID: ebook.ID, PlacedAt: now()})
+	require.NoError(t, err)
+
+	expired, err := repo.ExpireDigitalLoans(ctx, due, due.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, expired, "a loan is due until its return date has passed")
+	_, err = repo.GetLoan(ctx, alice.ID, ebook.ID, due)
+	assert.NoError(t, err)
+
+	// Past its return date the e-book loan reads as ended before it is closed
+	after := due.Add(time.Minute)
+	_, err = repo.GetLoan(ctx, alice.ID, ebook.ID, after)
+	assert.ErrorIs(t, err, errors.ErrLoanNotFound)
+	got, err := repo.GetLoanByID(ctx, loan.ID, after)
+	require.NoError(t, err)
+	assert.Equal(t, models.LoanReturned, got.Status)
+	assert.Equal(t, models.ReturnReasonExpired, got.ReturnedReason)
+	history, err := repo.ListLoansByBorrower(ctx, alice.ID, models.Page{Limit: 10}, after)
+	require.NoError(t, err)
+	require.Len(t, history.Items, 2)
+	for _, l := range history.Items {
+		if l.BookID == ebook.ID {
+			assert.Equal(t, models.LoanReturned, l.Status)
+		} else {
+			assert.Equal(t, models.LoanActive, l.Status)
+		}
+	}
+	overdue, err := repo.ListOverdueLoans(ctx, after)
+	require.NoError(t, err)
+	require.Len(t, overdue, 1, "e-book loans are never overdue")
+	assert.Equal(t, print.ID, overdue[0].BookID)
+
+	expired, err = repo.ExpireDigitalLoans(ctx, after, after.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired, "printed books are never returned automatically")
+
+	history, err = repo.ListLoansByBook(ctx, ebook.ID, models.Page{Limit: 10}, now())
+	require.NoError(t, err)
+	require.Len(t, history.Items, 1)
+	assert.Equal(t, models.ReturnReasonExpired, history.Items[0].ReturnedReason)
+	require.NotNil(t, history.Items[0].ReturnedAt)
+	assert.WithinDuration(t, due, *history.Items[0].ReturnedAt, time.Second, "the loan ends at its return date")
+
+	// The license passes to the next member in the queue
+	holds, err := repo.ListHolds(ctx, ebook.ID)
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, models.HoldReady, holds[0].Status)
+
+	_, err = repo.GetLoan(ctx, alice.ID, print.ID, now())
+	assert.NoError(t, err)
+}
+
+// testBorrowAfterExpiry checks that an e-book loan past its return date
+// stops holding its license and counting against the member as soon as it
+// has run out, with nothing having run ExpireDigitalLoans.
+func testBorrowAfterExpiry(t *testing.T, repo repository.LibraryRepository) {
+	ebook := edition("Snow Crash")
+	ebook.Format = models.FormatEPUB
+	ebook.Digital = true
+	ebook.AvailableCopies = 1
+	ebook, err := repo.CreateBook(ctx, ebook)
+	require.NoError(t, err)
+	print := addBook(t, repo, "Cryptonomicon", 1)
+	alice := addBorrower(t, repo, "alice")
+	bob := addBorrower(t, repo, "bob")
+
+	start := now().AddDate(0, 0, -14)
+	due := now().Add(-time.Minute)
+	first, err := repo.BorrowBook(ctx, &models.LoanDetail{BorrowerID: alice.ID, BookID: ebook.ID, LoanDate: start, ReturnDate: due}, start, nil)
+	require.NoError(t, err)
+
+	next, err := repo.NextReturnDate(ctx, ebook.ID, now())
+	require.NoError(t, err)
+	assert.True(t, next.IsZero(), "a loan that ran out holds no copy")
+
+	var loans int
+	_, err = repo.BorrowBook(ctx, newLoan(alice, print, now().AddDate(0, 0, 14)), now(), func(activeLoans int, _ int64) error {
+		loans = activeLoans
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Zero(t, loans, "a loan that ran out is not counted")
+
+	again, err := repo.BorrowBook(ctx, newLoan(alice, ebook, now().AddDate(0, 0, 14)), now(), nil)
+	require.NoError(t, err, "a loan that ran out is no duplicate")
+	assert.Equal(t, first.ItemID, again.ItemID, "its license is lent again")
+	got, err := repo.GetLoanByID(ctx, first.ID, start)
+	require.NoError(t, err)
+	assert.Equal(t, models.LoanReturned, got.Status, "the loan was closed, not only shown as ended")
+	assert.Equal(t, models.ReturnReasonExpired, got.ReturnedReason)
+	require.NotNil(t, got.ReturnedAt)
+	assert.WithinDuration(t, due, *got.ReturnedAt, time.Second)
+
+	_, err = repo.BorrowBook(ctx, newLoan(bob, ebook, now().AddDate(0, 0, 14)), now(), nil)
+	assert.ErrorIs(t, err, errors.ErrNoCopies)
+	next, err = repo.NextReturnDate(ctx, ebook.ID, now())
+	require.NoError(t, err)
+	assert.WithinDuration(t, again.ReturnDate, next, time.Second)
+	history, err := repo.ListLoansByBook(ctx, ebook.ID, models.Page{Limit: 10}, now())
+	require.NoError(t, err)
+	assert.Len(t, history.Items, 2)
+}
+
+func testHoldQueue(t *testing.T, repo repository.LibraryRepository) {
+	book := addBook(t, repo, "Beloved", 1)
+	alice := addBorrower(t, repo, "alice")
+	bob := addBorrower(t, repo, "bob")
+	carol := addBorrower(t, repo, "carol")
+	dave := addBorrower(t, repo, "dave")
+
+	_, err := repo.PlaceHold(ctx, &models.HoldDetail{BorrowerID: bob.ID, BookID: book.ID, PlacedAt: now()})
+	assert.ErrorIs(t, err, errors.ErrCopiesAvailable)
+
+	loan := borrow(t, repo, alice, book)
+	placed := now()
+	hold, err := repo.PlaceHold(ctx, &models.HoldDetail{BorrowerID: bob.ID, BookID: book.ID, PlacedAt: placed})
+	require.NoError(t, err)
+	assert.NotZero(t, hold.ID)
+	assert.Equal(t, models.HoldWaiting, hold.Status)
+	_, err = repo.PlaceHold(ctx, &models.HoldDetail{BorrowerID: bob.ID, BookID: book.ID, PlacedAt: placed})
+	assert.ErrorIs(t, err, errors.ErrDuplicateHold)
+	_, err = repo.PlaceHold(ctx, &models.HoldDetail{BorrowerID: carol.ID, BookID: book.ID, PlacedAt: placed.Add(time.Second)})
+	require.NoError(t, err)
+
+	// The returned copy is set aside for the head of the queue
+	at := now()
+	giveBack(t, repo, alice.ID, book.ID, at, at.Add(time.Hour), 0)
+	assert.Equal(t, 0, availableCopies(t, repo, book.ID))
+	holds, err := repo.ListHolds(ctx, book.ID)
+	require.NoError(t, err)
+	require.Len(t, holds, 2)
+	assert.Equal(t, bob.ID, holds[0].BorrowerID)
+	assert.Equal(t, "bob", holds[0].NameOfBorrower)
+	assert.Equal(t, "Beloved", holds[0].BookTitle)
+	assert.Equal(t, models.HoldReady, holds[0].Status)
+	assert.Equal(t, loan.ItemID, holds[0].ItemID)
+	require.NotNil(t, holds[0].ExpiresAt)
+	assert.WithinDuration(t, at.Add(time.Hour), *holds[0].ExpiresAt, time.Second)
+	assert.Equal(t, carol.ID, holds[1].BorrowerID)
+	assert.Equal(t, models.HoldWaiting, holds[1].Status)
+	item, err := repo.GetItem(ctx, loan.ItemID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ItemReserved, item.Status)
+
+	// Nobody else can take it, and bob gets the copy set aside for him
+	_, err = repo.BorrowBook(ctx, newLoan(dave, book, now().AddDate(0, 0, 14)), now(), nil)
+	assert.ErrorIs(t, err, errors.ErrNoCopies)
+	bobLoan := borrow(t, repo, bob, book)
+	assert.Equal(t, loan.ItemID, bobLoan.ItemID)
+	holds, err = repo.ListHolds(ctx, book.ID)
+	require.NoError(t, err)
+	require.Len(t, holds, 1, "fulfilled holds leave the queue")
+	assert.Equal(t, carol.ID, holds[0].BorrowerID)
+
+	// Fulfilled holds are purged by the date they were placed
+	purged, err := repo.PurgeHolds(ctx, placed)
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+	purged, err = repo.PurgeHolds(ctx, placed.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged, "waiting holds are never purged")
+}
+
+func testHoldsExpire(t *testing.T, repo repository.LibraryRepository) {
+	book := addBook(t, repo, "Persuasion", 1)
+	alice := addBorrower(t, repo, "alice")
+	bob := addBorrower(t, repo, "bob")
+	carol := addBorrower(t, repo, "carol")
+
+	borrow(t, repo, alice, book)
+	_, err := repo.PlaceHold(ctx, &models.HoldDetail{BorrowerID: bob.ID, BookID: book.ID, PlacedAt: now()})
+	require.NoError(t, err)
+	_, err = repo.PlaceHold(ctx, &models.HoldDetail{BorrowerID: carol.ID, BookID: book.ID, PlacedAt: now().Add(time.Second)})
+	require.NoError(t, err)
+	at := now()
+	deadline := at.Add(time.Hour)
+	giveBack(t, repo, alice.ID, book.ID, at, deadline, 0)
+
+	expired, err := repo.ExpireHolds(ctx, deadline.Add(-time.Minute), deadline.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, expired)
+
+	// bob missed his pickup, so the copy moves on to carol
+	expired, err = repo.ExpireHolds(ctx, deadline.Add(time.Minute), deadline.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	holds, err := repo.ListHolds(ctx, book.ID)
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, carol.ID, holds[0].BorrowerID)
+	assert.Equal(t, models.HoldReady, holds[0].Status)
+	_, err = repo.BorrowBook(ctx, newLoan(bob, book, now().AddDate(0, 0, 14)), now(), nil)
+	assert.ErrorIs(t, err, errors.ErrNoCopies)
+
+	// With nobody left waiting the copy goes back on the shelf
+	expired, err = repo.ExpireHolds(ctx, deadline.Add(3*time.Hour), deadline.Add(4*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assert.Equal(t, 1, availableCopies(t, repo, book.ID))
+}
+
+func testDeleteGuards(t *testing.T, repo repository.LibraryRepository) {
+	book := addBook(t, repo, "Walden", 1)
+	alice := addBorrower(t, repo, "alice")
+	bob := addBorrower(t, repo, "bob")
+	borrow(t, repo, alice, book)
+	_, err := repo.PlaceHold(ctx, &models.HoldDetail{BorrowerID: bob.ID, BookID: book.ID, PlacedAt: now()})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, repo.DeleteBook(ctx, book.ID, 0), errors.ErrBookHasLoans)
+	assert.ErrorIs(t, repo.DeleteBorrower(ctx, alice.ID), errors.ErrBorrowerHasLoans)
+	assert.ErrorIs(t, repo.DeleteBorrower(ctx, bob.ID), errors.ErrBorrowerHasLoans, "bob is waiting for a copy")
+
+	at := now()
+	giveBack(t, repo, alice.ID, book.ID, at, at.Add(time.Hour), 0)
+	_, err = repo.AddFineEntry(ctx, &models.FineEntry{BorrowerID: alice.ID, Kind: models.FineCharge, AmountCents: 25, CreatedAt: at})
+	require.NoError(t, err)
+	assert.ErrorIs(t, repo.DeleteBorrower(ctx, alice.ID), errors.ErrBorrowerHasLoans, "alice owes a fine")
+	_, err = repo.AddFineEntry(ctx, &models.FineEntry{BorrowerID: alice.ID, Kind: models.FineWaiver, AmountCents: 25, CreatedAt: at})
+	require.NoError(t, err)
+
+	// Her ended loan is history, kept until it is purged
+	assert.ErrorIs(t, repo.DeleteBorrower(ctx, alice.ID), errors.ErrBorrowerHasLoans, "alice's loan is on record")
+	purged, err := repo.PurgeLoans(ctx, at.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	require.NoError(t, repo.DeleteBorrower(ctx, alice.ID))
+	_, err = repo.GetBorrower(ctx, alice.ID)
+	assert.ErrorIs(t, err, errors.ErrBorrowerNotFound)
+
+	// The copy set aside for Bob keeps the book until his hold lapses
+	assert.ErrorIs(t, repo.DeleteBook(ctx, book.ID, 0), errors.ErrBookHasLoans)
+	expired, err := repo.ExpireHolds(ctx, at.Add(2*time.Hour), at.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	require.NoError(t, repo.DeleteBook(ctx, book.ID, 0))
+	_, err = repo.GetBook(ctx, book.ID)
+	assert.ErrorIs(t, err, errors.ErrBookNotFound)
+}
+
+func testDeleteKeepsHistory(t *testing.T, repo repository.LibraryRepository) {
+	book := addBook(t, repo, "Persuasion", 1)
+	alice := addBorrower(t, repo, "alice")
+	loan := borrow(t, repo, alice, book)
+	at := now()
+	giveBack(t, repo, alice.ID, book.ID, at, at.Add(time.Hour), 0)
+
+	// Ended loans are history, which neither a book delete nor a borrower
+	// delete may take with it
+	assert.ErrorIs(t, repo.DeleteBook(ctx, book.ID, 0), errors.ErrBookHasLoans)
+	_, err := repo.GetBook(ctx, book.ID)
+	require.NoError(t, err)
+	assert.ErrorIs(t, repo.DeleteBorrower(ctx, alice.ID), errors.ErrBorrowerHasLoans)
+	_, err = repo.GetBorrower(ctx, alice.ID)
+	require.NoError(t, err)
+	history, err := repo.ListLoansByBook(ctx, book.ID, models.Page{Limit: 10}, now())
+	require.NoError(t, err)
+	require.Equal(t, 1, history.Total)
+	assert.Equal(t, loan.ID, history.Items[0].ID)
+	assert.Equal(t, "Persuasion", history.Items[0].BookTitle)
+	history, err = repo.ListLoansByBorrower(ctx, alice.ID, models.Page{Limit: 10}, now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, history.Total)
+}
+
+func testFines(t *testing.T, repo repository.LibraryRepository) {
+	alice := addBorrower(t, repo, "alice")
+	at := now()
+
+	balance, err := repo.FineBalance(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Zero(t, balance)
+	_, err = repo.AddFineEntry(ctx, &models.FineEntry{BorrowerID: alice.ID, Kind: models.FinePayment, AmountCents: 1, CreatedAt: at})
+	assert.ErrorIs(t, err, errors.ErrAmountExceedsBalance)
+
+	charge, err := repo.AddFineEntry(ctx, &models.FineEntry{BorrowerID: alice.ID, Kind: models.FineCharge, AmountCents: 150, BookTitle: "Walden", Note: "late", CreatedAt: at})
+	require.NoError(t, err)
+	assert.NotZero(t, charge.ID)
+	_, err = repo.AddFineEntry(ctx, &models.FineEntry{BorrowerID: alice.ID, Kind: models.FinePayment, AmountCents: 100, CreatedAt: at.Add(time.Second)})
+	require.NoError(t, err)
+	_, err = repo.AddFineEntry(ctx, &models.FineEntry{BorrowerID: alice.ID, Kind: models.FineWaiver, AmountCents: 60, CreatedAt: at.Add(2 * time.Second)})
+	assert.ErrorIs(t, err, errors.ErrAmountExceedsBalance)
+	_, err = repo.AddFineEntry(ctx, &models.FineEntry{BorrowerID: alice.ID, Kind: models.FineWaiver, AmountCents: 20, CreatedAt: at.Add(2 * time.Second)})
+	require.NoError(t, err)
+
+	balance, err = repo.FineBalance(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), balance)
+
+	entries, err := repo.ListFineEntries(ctx, alice.ID)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, charge.ID, entries[0].ID)
+	assert.Equal(t, "Walden", entries[0].BookTitle)
+	assert.Equal(t, "late", entries[0].Note)
+	assert.Equal(t, models.FinePayment, entries[1].Kind)
+	assert.Equal(t, models.FineWaiver, entries[2].Kind)
+}
+
+func testLateFeeOnReturn(t *testing.T, repo repository.LibraryRepository) {
+	alice := addBorrower(t, repo, "alice")
+	book := addBook(t, repo, "Bleak House", 2)
+	due := now().AddDate(0, 0, -3)
+	returned := now()
+
+	_, err := repo.BorrowBook(ctx, newLoan(alice, book, due), now(), nil)
+	require.NoError(t, err)
+	var feeDue, feeReturned time.Time
+	fee := func(dueDate, returnedAt time.Time) int64 {
+		feeDue, feeReturned = dueDate, returnedAt
+		return 75
+	}
+	charge, err := repo.ReturnBook(ctx, alice.ID, book.ID, returned, returned.Add(time.Hour), 0, fee)
+	require.NoError(t, err)
+	require.NotNil(t, charge)
+	assert.True(t, feeDue.Equal(due))
+	assert.True(t, feeReturned.Equal(returned))
+	assert.NotZero(t, charge.ID)
+	assert.Equal(t, models.FineCharge, charge.Kind)
+	assert.Equal(t, int64(75), charge.AmountCents)
+	assert.Equal(t, "Bleak House", charge.BookTitle)
+	assert.Equal(t, "late return", charge.Note)
+
+	balance, err := repo.FineBalance(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(75), balance)
+	entries, err := repo.ListFineEntries(ctx, alice.ID)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, charge.ID, entries[0].ID)
+
+	// A return the fee lets off charges nothing
+	_, err = repo.BorrowBook(ctx, newLoan(alice, book, due), now(), nil)
+	require.NoError(t, err)
+	charge, err = repo.ReturnBook(ctx, alice.ID, book.ID, returned, returned.Add(time.Hour), 0, func(time.Time, time.Time) int64 { return 0 })
+	require.NoError(t, err)
+	assert.Nil(t, charge)
+	balance, err = repo.FineBalance(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(75), balance)
+}
+
+func testConcurrentBorrowOfLastCopy(t *testing.T, repo repository.LibraryRepository) {
+	book := addBook(t, repo, "Clean Code", 1)
+
+	const borrowers = 10
+	members := make([]*models.Borrower, borrowers)
+	for i := range members {
+		members[i] = addBorrower(t, repo, fmt.Sprintf("member%d", i))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, borrowers)
+	start := make(chan struct{})
+	for _, m := range members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := repo.BorrowBook(ctx, newLoan(m, book, now().AddDate(0, 0, 14)), now(), nil)
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	lent := 0
+	for err := range errs {
+		if err == nil {
+			lent++
+			continue
+		}
+		assert.ErrorIs(t, err, errors.ErrNoCopies)
+	}
+	assert.Equal(t, 1, lent)
+	assert.Equal(t, 0, availableCopies(t, repo, book.ID))
+	history, err := repo.ListLoansByBook(ctx, book.ID, models.Page{Limit: 10}, now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, history.Total)
+}
+
+// testBorrowCheck checks that a borrow check sees the member's active loans
+// and fines, and that a loan it refuses is not made.
+func testBorrowCheck(t *testing.T, repo repository.LibraryRepository) {
+	walden := addBook(t, repo, "Walden", 1)
+	emma := addBook(t, repo, "Emma", 1)
+	alice := addBorrower(t, repo, "alice")
+	_, err := repo.AddFineEntry(ctx, &models.FineEntry{BorrowerID: alice.ID, Kind: models.FineCharge, AmountCents: 75, CreatedAt: now()})
+	require.NoError(t, err)
+
+	_, err = repo.BorrowBook(ctx, newLoan(alice, walden, now().AddDate(0, 0, 14)), now(), nil)
+	require.NoError(t, err)
+
+	refused := stdErrors.New("refused")
+	var loans int
+	var owed int64
+	_, err = repo.BorrowBook(ctx, newLoan(alice, emma, now().AddDate(0, 0, 14)), now(), func(activeLoans int, finesOwed int64) error {
+		loans, owed = activeLoans, finesOwed
+		return refused
+	})
+	assert.ErrorIs(t, err, refused)
+	assert.Equal(t, 1, loans)
+	assert.Equal(t, int64(75), owed)
+	assert.Equal(t, 1, availableCopies(t, repo, emma.ID), "a refused loan takes no copy")
+	_, err = repo.GetLoan(ctx, alice.ID, emma.ID, now())
+	assert.ErrorIs(t, err, errors.ErrLoanNotFound)
+}
+
+// testConcurrentBorrowsOverLimit checks that a member borrowing several books
+// at once cannot go past the limit a borrow check enforces.
+func testConcurrentBorrowsOverLimit(t *testing.T, repo repository.LibraryRepository) {
+	alice := addBorrower(t, repo, "alice")
+	const limit, attempts = 2, 10
+	books := make([]*models.BookDetail, attempts)
+	for i := range books {
+		books[i] = addBook(t, repo, fmt.Sprintf("Volume %d", i), 1)
+	}
+	errLimit := stdErrors.New("limit reached")
+	check := func(activeLoans int, _ int64) error {
+		if activeLoans >= limit {
+			return errLimit
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, attempts)
+	start := make(chan struct{})
+	for _, book := range books {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := repo.BorrowBook(ctx, newLoan(alice, book, now().AddDate(0, 0, 14)), now(), check)
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	lent := 0
+	for err := range errs {
+		if err == nil {
+			lent++
+			continue
+		}
+		assert.ErrorIs(t, err, errLimit)
+	}
+	assert.Equal(t, limit, lent)
+	active, err := repo.CountLoans(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, limit, active)
+}
+
+// testVersions checks that a book's version changes with everything shown
+// about it, its available copies included, and a loan's with every renewal
+// and its end; and that changes made against an older version are refused.
+// Versions are opaque, so only whether they changed is checked.
+func testVersions(t *testing.T, repo repository.LibraryRepository) {
+	book := addBook(t, repo, "Bleak House", 2)
+	alice := addBorrower(t, repo, "alice")
+	assert.NotZero(t, book.Version)
+
+	got, err := repo.GetBook(ctx, book.ID)
+	require.NoError(t, err)
+	assert.Equal(t, book.Version, got.Version, "reading a book leaves its version")
+
+	stale := book.Version
+	book.Publisher = "Bradbury & Evans"
+	book, err = repo.UpdateBook(ctx, book.ID, book, stale)
+	require.NoError(t, err)
+	assert.NotEqual(t, stale, book.Version)
+	_, err = repo.UpdateBook(ctx, book.ID, book, stale)
+	assert.ErrorIs(t, err, errors.ErrVersionMismatch)
+	_, err = repo.AdjustCopies(ctx, book.ID, 1, stale)
+	assert.ErrorIs(t, err, errors.ErrVersionMismatch)
+	assert.Equal(t, 2, availableCopies(t, repo, book.ID), "a refused change changes nothing")
+
+	previous := book.Version
+	book, err = repo.AdjustCopies(ctx, book.ID, 1, book.Version)
+	require.NoError(t, err)
+	assert.NotEqual(t, previous, book.Version)
+
+	// Lending changes the available copies, and so the book's version
+	previous = book.Version
+	loan := borrow(t, repo, alice, book)
+	assert.NotZero(t, loan.Version)
+	got, err = repo.GetBook(ctx, book.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, previous, got.Version)
+
+	extended, err := repo.ExtendLoan(ctx, alice.ID, book.ID, loan.ReturnDate.AddDate(0, 0, 7), loan.Version)
+	require.NoError(t, err)
+	assert.NotEqual(t, loan.Version, extended.Version)
+	fetched, err := repo.GetLoanByID(ctx, loan.ID, now())
+	require.NoError(t, err)
+	assert.Equal(t, extended.Version, fetched.Version)
+
+	at := now()
+	_, err = repo.ExtendLoan(ctx, alice.ID, book.ID, at, loan.Version)
+	assert.ErrorIs(t, err, errors.ErrVersionMismatch)
+	_, err = repo.ReturnBook(ctx, alice.ID, book.ID, at, at.Add(time.Hour), loan.Version, nil)
+	assert.ErrorIs(t, err, errors.ErrVersionMismatch)
+	giveBack(t, repo, alice.ID, book.ID, at, at.Add(time.Hour), extended.Version)
+	fetched, err = repo.GetLoanByID(ctx, loan.ID, now())
+	require.NoError(t, err)
+	assert.NotEqual(t, extended.Version, fetched.Version)
+
+	// Once the loan has ended there is nothing left to change
+	_, err = repo.ReturnBook(ctx, alice.ID, book.ID, at, at.Add(time.Hour), fetched.Version, nil)
+	assert.ErrorIs(t, err, errors.ErrLoanNotFound)
+
+	// Deleting a book checks its version too
+	spare := addBook(t, repo, "Little Dorrit", 1)
+	stale = spare.Version
+	spare, err = repo.AdjustCopies(ctx, spare.ID, 1, stale)
+	require.NoError(t, err)
+	assert.ErrorIs(t, repo.DeleteBook(ctx, spare.ID, stale), errors.ErrVersionMismatch)
+	_, err = repo.GetBook(ctx, spare.ID)
+	require.NoError(t, err, "a refused delete leaves the book")
+	require.NoError(t, repo.DeleteBook(ctx, spare.ID, spare.Version))
+}
@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"e-library-api/internal/models"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// A term found in the title counts more than one in the authors or subjects,
+// which count more than one in the description.
+const (
+	titleWeight       = 3.0
+	authorWeight      = 2.0
+	subjectWeight     = 2.0
+	descriptionWeight = 1.0
+)
+
+// How much a query word earns depending on how it matched an indexed term.
+const (
+	exactMatch  = 1.0
+	prefixMatch = 0.7
+	fuzzyMatch  = 0.4
+)
+
+// stopwords are too common to tell books apart and are left out of the index.
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "as": true, "at": true, "by": true, "for": true, "from": true,
+	"in": true, "is": true, "it": true, "of": true, "on": true, "or": true, "the": true, "to": true, "with": true,
+}
+
+// searchIndex is an inverted index from stemmed terms to the books that
+// contain them. It is not safe for concurrent use; MemoryRepo guards it with
+// its own lock.
+type searchIndex struct {
+	// postings maps a term to the weight it carries in each book, by book ID
+	postings map[string]map[int64]float64
+	// docs maps a book ID to its terms so the book can be removed again
+	docs map[int64][]string
+	// terms is the sorted vocabulary, used to look up prefixes
+	terms []string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[int64]float64),
+		docs:     make(map[int64][]string),
+	}
+}
+
+func (ix *searchIndex) add(b *models.BookDetail) {
+	weights := make(map[string]float64)
+	addField := func(text string, weight float64) {
+		for _, word := range tokenize(text) {
+			weights[stem(word)] += weight
+		}
+	}
+	addField(b.Title, titleWeight)
+	for _, author := range b.Authors {
+		addField(author, authorWeight)
+	}
+	for _, subject := range b.Subjects {
+		addField(subject, subjectWeight)
+	}
+	addField(b.Description, descriptionWeight)
+
+	for term, weight := range weights {
+		if ix.postings[term] == nil {
+			ix.postings[term] = make(map[int64]float64)
+			i := sort.SearchStrings(ix.terms, term)
+			ix.terms = slices.Insert(ix.terms, i, term)
+		}
+		ix.postings[term][b.ID] = weight
+		ix.docs[b.ID] = append(ix.docs[b.ID], term)
+	}
+}
+
+func (ix *searchIndex) remove(id int64) {
+	for _, term := range ix.docs[id] {
+		delete(ix.postings[term], id)
+		if len(ix.postings[term]) > 0 {
+			continue
+		}
+		delete(ix.postings, term)
+		i := sort.SearchStrings(ix.terms, term)
+		ix.terms = slices.Delete(ix.terms, i, i+1)
+	}
+	delete(ix.docs, id)
+}
+
+// search scores the books matching every word of the query, by book ID.
+func (ix *searchIndex) search(query string) map[int64]float64 {
+	var scores map[int64]float64
+	for _, word := range tokenize(query) {
+		matches := ix.match(word)
+		if scores == nil {
+			scores = matches
+			continue
+		}
+		for id := range scores {
+			if score, ok := matches[id]; ok {
+				scores[id] += score
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	return scores
+}
+
+// match scores the books containing a single query word. Besides the word
+// itself, it matches longer words it is the start of, and only when neither
+// finds anything does it fall back to words within a small edit distance.
+func (ix *searchIndex) match(word string) map[int64]float64 {
+	result := make(map[int64]float64)
+	credit := func(term string, factor float64) {
+		for id, weight := range ix.postings[term] {
+			result[id] = max(result[id], weight*factor)
+		}
+	}
+
+	term := stem(word)
+	credit(term, exactMatch)
+	for i := sort.SearchStrings(ix.terms, word); i < len(ix.terms) && strings.HasPrefix(ix.terms[i], word); i++ {
+		if ix.terms[i] != term {
+			credit(ix.terms[i], prefixMatch)
+		}
+	}
+	if len(result) > 0 {
+		return result
+	}
+
+	edits := allowedEdits(term)
+	for _, candidate := range ix.terms {
+		if abs(len(candidate)-len(term)) <= edits && levenshtein(candidate, term) <= edits {
+			credit(candidate, fuzzyMatch)
+		}
+	}
+	return result
+}
+
+// tokenize splits text into lower-case words, dropping stopwords.
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	kept := words[:0]
+	for _, w := range words {
+		if !stopwords[w] {
+			kept = append(kept, w)
+		}
+	}
+	return kept
+}
+
+// stem strips common English inflections so that "patterns" finds "pattern"
+// and "programming" finds "program". It is deliberately light: a stem that
+// is slightly off still matches by prefix or edit distance.
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses"):
+		return word[:len(word)-2]
+	case len(word) > 5 && strings.HasSuffix(word, "ing"):
+		return undouble(word[:len(word)-3])
+	case len(word) > 4 && strings.HasSuffix(word, "ed"):
+		return undouble(word[:len(word)-2])
+	case len(word) > 4 && strings.HasSuffix(word, "ly"):
+		return word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// undouble drops a doubled final consonant left behind by a suffix, as in "programm".
+func undouble(word string) string {
+	n := len(word)
+	if n > 2 && word[n-1] == word[n-2] && !strings.ContainsRune("aeiouylsz", rune(word[n-1])) {
+		return word[:n-1]
+	}
+	return word
+}
+
+// allowedEdits is the typo tolerance for a term: none for short words, where
+// a single edit already turns one word into another.
+func allowedEdits(term string) int {
+	switch n := len([]rune(term)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// levenshtein counts the single-character insertions, deletions and
+// substitutions needed to turn a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
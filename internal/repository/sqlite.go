@@ -0,0 +1,1123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"e-library-api/internal/errors"
+	"e-library-api/internal/models"
+	"encoding/json"
+	stdErrors "errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return stdErrors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// OpenSQLite opens the database file at path, creating it if needed.
+// Transactions begin IMMEDIATE, taking the write lock up front, so the
+// read-then-write transactions below run one at a time the way row locks
+// serialise them on Postgres; a writer waits up to five seconds for the lock.
+func OpenSQLite(path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// SQLiteRepo stores the library in an embedded SQLite file. Queries mirror
+// PostgresRepo; timestamps are stored in UTC so they compare as text.
+type SQLiteRepo struct {
+	DB *sql.DB
+}
+
+func NewSQLiteRepo(db *sql.DB) *SQLiteRepo {
+	return &SQLiteRepo{DB: db}
+}
+
+// stringList stores a list of strings as a JSON array in a text column.
+type stringList []string
+
+func (l *stringList) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case []byte:
+		return json.Unmarshal(v, l)
+	case nil:
+		*l = nil
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into a string list", src)
+}
+
+func (l stringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	raw, err := json.Marshal([]string(l))
+	return string(raw), err
+}
+
+// scanSQLiteBook reads the columns listed in bookColumns.
+func scanSQLiteBook(row rowScanner, extra ...any) (*models.BookDetail, error) {
+	var b models.BookDetail
+	dest := []any{&b.ID, &b.WorkID, &b.Title, (*stringList)(&b.Authors), &b.Description, &b.ISBN, &b.Publisher, &b.Year,
+		&b.Language, (*stringList)(&b.Subjects), &b.Format, &b.Category, &b.Digital, &b.AvailableCopies, &b.Version}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	if b.Authors == nil {
+		b.Authors = []string{}
+	}
+	if b.Subjects == nil {
+		b.Subjects = []string{}
+	}
+	return &b, nil
+}
+
+func (s *SQLiteRepo) GetBook(ctx context.Context, id int64) (*models.BookDetail, error) {
+	return getSQLiteBook(ctx, s.DB, id)
+}
+
+func getSQLiteBook(ctx context.Context, q queryRower, id int64) (*models.BookDetail, error) {
+	b, err := scanSQLiteBook(q.QueryRowContext(ctx, "SELECT "+bookColumns+" FROM catalog WHERE id = $1", id))
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrBookNotFound
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// requireBook returns ErrBookNotFound unless the book exists. Inside a
+// transaction it stands in for locking the book row: the transaction already
+// holds the database's write lock.
+func requireBook(ctx context.Context, q queryRower, id int64) error {
+	return requireBookAt(ctx, q, id, 0)
+}
+
+// requireBookAt is requireBook that also checks that the book is still at the
+// given version.
+func requireBookAt(ctx context.Context, q queryRower, id, version int64) error {
+	var current int64
+	err := q.QueryRowContext(ctx, "SELECT version FROM books WHERE id = $1", id).Scan(&current)
+	if stdErrors.Is(err, sql.ErrNoRows) {
+		return errors.ErrBookNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return checkVersion(current, version)
+}
+
+func (s *SQLiteRepo) ListBooks(ctx context.Context, query models.BookQuery) (*models.BookPage, error) {
+	cursor, err := decodeBookCursor(query)
+	if err != nil {
+		return nil, err
+	}
+	order := bookOrder[query.Sort]
+
+	var where []string
+	var args []any
+	if query.Query != "" {
+		// LIKE ignores case, for ASCII letters only
+		args = append(args, "%"+likeEscaper.Replace(query.Query)+"%")
+		where = append(where, fmt.Sprintf(`title LIKE $%d ESCAPE '\'`, len(args)))
+	}
+	if query.Available != nil {
+		if *query.Available {
+			where = append(where, "available_copies > 0")
+		} else {
+			where = append(where, "available_copies = 0")
+		}
+	}
+	if cursor != nil {
+		if order.byCopies {
+			args = append(args, cursor.Copies, cursor.Title, cursor.ID)
+			where = append(where, fmt.Sprintf("(available_copies, title, id) %s ($%d, $%d, $%d)",
+				order.after, len(args)-2, len(args)-1, len(args)))
+		} else {
+			args = append(args, cursor.Title, cursor.ID)
+			where = append(where, fmt.Sprintf("(title, id) %s ($%d, $%d)", order.after, len(args)-1, len(args)))
+		}
+	}
+
+	sqlQuery := "SELECT " + bookColumns + " FROM catalog"
+	if len(where) > 0 {
+		sqlQuery += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Fetch one more than requested to know whether another page follows
+	args = append(args, query.Limit+1)
+	sqlQuery += fmt.Sprintf(" ORDER BY %s LIMIT $%d", order.orderBy, len(args))
+
+	rows, err := s.DB.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &models.BookPage{Items: []models.BookDetail{}}
+	for rows.Next() {
+		b, err := scanSQLiteBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, *b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Items) > query.Limit {
+		page.Items = page.Items[:query.Limit]
+		page.NextCursor = encodeBookCursor(query.Sort, page.Items[len(page.Items)-1])
+	}
+	return page, nil
+}
+
+// searchRank scores a full-text match with the same field weights as the
+// in-memory index. bm25 is lower for better matches, hence the sign.
+var searchRank = fmt.Sprintf("-bm25(books_fts, %g, %g, %g, %g)", titleWeight, authorWeight, subjectWeight, descriptionWeight)
+
+func (s *SQLiteRepo) SearchBooks(ctx context.Context, query string, limit int) ([]models.SearchResult, error) {
+	words := tokenize(query)
+	results := []models.SearchResult{}
+	if len(words) == 0 {
+		return results, nil
+	}
+	// Every word may also be the start of a longer one. Words are made of
+	// letters and digits only, so quoting them is safe.
+	terms := make([]string, len(words))
+	for i, w := range words {
+		terms[i] = `"` + w + `"*`
+	}
+	sqlQuery := `SELECT ` + bookColumns + `, m.score FROM catalog
+		JOIN (SELECT rowid, ` + searchRank + ` AS score FROM books_fts WHERE books_fts MATCH $1) m ON m.rowid = catalog.id
+		ORDER BY m.score DESC, title, id
+		LIMIT $2`
+	rows, err := s.DB.QueryContext(ctx, sqlQuery, strings.Join(terms, " AND "), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var score float64
+		b, err := scanSQLiteBook(rows, &score)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, models.SearchResult{BookDetail: *b, Score: score})
+	}
+	return results, rows.Err()
+}
+
+func (s *SQLiteRepo) CreateBook(ctx context.Context, book *models.BookDetail) (*models.BookDetail, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	workID := book.WorkID
+	if workID == 0 {
+		if err = tx.QueryRowContext(ctx, "INSERT INTO works (title) VALUES ($1) RETURNING id", book.Title).Scan(&workID); err != nil {
+			return nil, err
+		}
+	} else {
+		err = tx.QueryRowContext(ctx, "SELECT id FROM works WHERE id = $1", workID).Scan(&workID)
+		if err != nil {
+			if stdErrors.Is(err, sql.ErrNoRows) {
+				return nil, errors.ErrWorkNotFound
+			}
+			return nil, err
+		}
+	}
+
+	query := `INSERT INTO books (work_id, title, authors, description, isbn, publisher, year, language, subjects,
+			format, category, digital)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id`
+	var id int64
+	err = tx.QueryRowContext(ctx, query, workID, book.Title, stringList(book.Authors), book.Description, book.ISBN,
+		book.Publisher, book.Year, book.Language, stringList(book.Subjects), book.Format, book.Category,
+		book.Digital).Scan(&id)
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			return nil, errors.ErrBookExists
+		}
+		return nil, err
+	}
+	if err = addSQLiteItems(ctx, tx, id, book.AvailableCopies); err != nil {
+		return nil, err
+	}
+
+	b, err := getSQLiteBook(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return b, tx.Commit()
+}
+
+// addSQLiteItems puts count new items without barcodes on the shelf.
+func addSQLiteItems(ctx context.Context, tx *sql.Tx, bookID int64, count int) error {
+	_, err := tx.ExecContext(ctx, `WITH RECURSIVE n(i) AS (SELECT 1 WHERE $4 > 0 UNION ALL SELECT i + 1 FROM n WHERE i < $4)
+		INSERT INTO items (book_id, condition, status) SELECT $1, $2, $3 FROM n`,
+		bookID, models.ConditionGood, models.ItemAvailable, count)
+	return err
+}
+
+func (s *SQLiteRepo) UpdateBook(ctx context.Context, id int64, book *models.BookDetail, version int64) (*models.BookDetail, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = requireBookAt(ctx, tx, id, version); err != nil {
+		return nil, err
+	}
+	query := `UPDATE books SET title = $1, authors = $2, description = $3, isbn = NULLIF($4, ''), publisher = $5,
+			year = $6, language = $7, subjects = $8, format = $9, category = $10, digital = $11, version = version + 1
+		WHERE id = $12`
+	_, err = tx.ExecContext(ctx, query, book.Title, stringList(book.Authors), book.Description, book.ISBN,
+		book.Publisher, book.Year, book.Language, stringList(book.Subjects), book.Format, book.Category, book.Digital, id)
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			return nil, errors.ErrBookExists
+		}
+		return nil, err
+	}
+
+	b, err := getSQLiteBook(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return b, tx.Commit()
+}
+
+func (s *SQLiteRepo) AdjustCopies(ctx context.Context, id int64, delta int, version int64) (*models.BookDetail, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = requireBookAt(ctx, tx, id, version); err != nil {
+		return nil, err
+	}
+	if delta > 0 {
+		if err = addSQLiteItems(ctx, tx, id, delta); err != nil {
+			return nil, err
+		}
+	} else {
+		res, err := tx.ExecContext(ctx, `UPDATE items SET status = $1 WHERE id IN (
+				SELECT id FROM items WHERE book_id = $2 AND status = $3 ORDER BY id DESC LIMIT $4)`,
+			models.ItemWithdrawn, id, models.ItemAvailable, -delta)
+		if err != nil {
+			return nil, err
+		}
+		withdrawn, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if withdrawn < int64(-delta) {
+			return nil, errors.ErrInvalidCopyCount
+		}
+	}
+
+	b, err := getSQLiteBook(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return b, tx.Commit()
+}
+
+func (s *SQLiteRepo) DeleteBook(ctx context.Context, id, version int64) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var workID, current int64
+	err = tx.QueryRowContext(ctx, "SELECT work_id, version FROM books WHERE id = $1", id).Scan(&workID, &current)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return errors.ErrBookNotFound
+		}
+		return err
+	}
+	if err = checkVersion(current, version); err != nil {
+		return err
+	}
+
+	// Loans keep the book for the history; the schema refuses to drop it too
+	var inUse bool
+	query := `SELECT EXISTS(SELECT 1 FROM loans WHERE book_id = $1)
+		OR EXISTS(SELECT 1 FROM holds WHERE book_id = $1 AND status IN ($2, $3))`
+	if err = tx.QueryRowContext(ctx, query, id, models.HoldWaiting, models.HoldReady).Scan(&inUse); err != nil {
+		return err
+	}
+	if inUse {
+		return errors.ErrBookHasLoans
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM books WHERE id = $1", id); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM works WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM books WHERE work_id = $1)", workID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteRepo) GetWork(ctx context.Context, id int64) (*models.Work, error) {
+	var w models.Work
+	if err := s.DB.QueryRowContext(ctx, "SELECT id, title FROM works WHERE id = $1", id).Scan(&w.ID, &w.Title); err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrWorkNotFound
+		}
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+bookColumns+" FROM catalog WHERE work_id = $1 ORDER BY id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	w.Editions = []models.BookDetail{}
+	for rows.Next() {
+		b, err := scanSQLiteBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		w.Editions = append(w.Editions, *b)
+	}
+	return &w, rows.Err()
+}
+
+func (s *SQLiteRepo) AddItem(ctx context.Context, item *models.Item) (*models.Item, error) {
+	if err := requireBook(ctx, s.DB, item.BookID); err != nil {
+		return nil, err
+	}
+	query := "INSERT INTO items (book_id, barcode, condition, status) VALUES ($1, NULLIF($2, ''), $3, $4) RETURNING " + itemColumns
+	i, err := scanItem(s.DB.QueryRowContext(ctx, query, item.BookID, item.Barcode, item.Condition, item.Status))
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			return nil, errors.ErrItemExists
+		}
+		return nil, err
+	}
+	return i, nil
+}
+
+func (s *SQLiteRepo) GetItem(ctx context.Context, id int64) (*models.Item, error) {
+	i, err := scanItem(s.DB.QueryRowContext(ctx, "SELECT "+itemColumns+" FROM items WHERE id = $1", id))
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrItemNotFound
+		}
+		return nil, err
+	}
+	return i, nil
+}
+
+func (s *SQLiteRepo) ListItems(ctx context.Context, bookID int64) ([]models.Item, error) {
+	if err := requireBook(ctx, s.DB, bookID); err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+itemColumns+" FROM items WHERE book_id = $1 ORDER BY id", bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.Item{}
+	for rows.Next() {
+		i, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *i)
+	}
+	return items, rows.Err()
+}
+
+func (s *SQLiteRepo) UpdateItem(ctx context.Context, id int64, item *models.Item) (*models.Item, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var current string
+	if err = tx.QueryRowContext(ctx, "SELECT status FROM items WHERE id = $1", id).Scan(&current); err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrItemNotFound
+		}
+		return nil, err
+	}
+	status := item.Status
+	if status == "" {
+		status = current
+	}
+	if status != current && (current == models.ItemOnLoan || current == models.ItemReserved) {
+		return nil, errors.ErrItemInUse
+	}
+
+	query := "UPDATE items SET barcode = NULLIF($1, ''), condition = $2, status = $3 WHERE id = $4 RETURNING " + itemColumns
+	i, err := scanItem(tx.QueryRowContext(ctx, query, item.Barcode, item.Condition, status, id))
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			return nil, errors.ErrItemExists
+		}
+		return nil, err
+	}
+	return i, tx.Commit()
+}
+
+func (s *SQLiteRepo) queryLoans(ctx context.Context, now time.Time, query string, args ...any) ([]models.LoanDetail, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := []models.LoanDetail{}
+	for rows.Next() {
+		l, err := scanLoanAt(rows, now)
+		if err != nil {
+			return nil, err
+		}
+		loans = append(loans, *l)
+	}
+	return loans, rows.Err()
+}
+
+func (s *SQLiteRepo) GetLoan(ctx context.Context, borrowerID, bookID int64, now time.Time) (*models.LoanDetail, error) {
+	query := `SELECT ` + loanColumns + ` FROM loans l` + loanJoins + `
+		WHERE l.borrower_id = $1 AND l.book_id = $2 AND l.status = $3`
+	l, err := scanLoanAt(s.DB.QueryRowContext(ctx, query, borrowerID, bookID, models.LoanActive), now)
+	if stdErrors.Is(err, sql.ErrNoRows) || err == nil && l.Status != models.LoanActive {
+		return nil, errors.ErrLoanNotFound
+	}
+	return l, err
+}
+
+func (s *SQLiteRepo) GetLoanByID(ctx context.Context, id int64, now time.Time) (*models.LoanDetail, error) {
+	l, err := scanLoanAt(s.DB.QueryRowContext(ctx, "SELECT "+loanColumns+" FROM loans l"+loanJoins+" WHERE l.id = $1", id), now)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrLoanNotFound
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+// getSQLiteLoan reads a loan by ID.
+func getSQLiteLoan(ctx context.Context, q queryRower, id int64) (*models.LoanDetail, error) {
+	return scanLoan(q.QueryRowContext(ctx, "SELECT "+loanColumns+" FROM loans l"+loanJoins+" WHERE l.id = $1", id))
+}
+
+// BorrowBook runs in an IMMEDIATE transaction, so concurrent borrows of the
+// last copy cannot both find it on the shelf.
+func (s *SQLiteRepo) BorrowBook(ctx context.Context, loan *models.LoanDetail, pickupDeadline time.Time, check BorrowCheck) (*models.LoanDetail, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = checkBorrower(ctx, tx, loan.BorrowerID, loan.LoanDate.UTC(), check); err != nil {
+		return nil, err
+	}
+	if err = requireBook(ctx, tx, loan.BookID); err != nil {
+		return nil, err
+	}
+	if err = expireBookLoans(ctx, tx, loan.BookID, loan.LoanDate.UTC(), pickupDeadline.UTC()); err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM loans WHERE borrower_id = $1 AND book_id = $2 AND status = $3)",
+		loan.BorrowerID, loan.BookID, models.LoanActive).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, errors.ErrDuplicateLoan
+	}
+
+	// A ready hold already has an item set aside for this borrower
+	var itemID int64
+	err = tx.QueryRowContext(ctx, "UPDATE holds SET status = $1 WHERE borrower_id = $2 AND book_id = $3 AND status = $4 RETURNING item_id",
+		models.HoldFulfilled, loan.BorrowerID, loan.BookID, models.HoldReady).Scan(&itemID)
+	if stdErrors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx, "SELECT id FROM items WHERE book_id = $1 AND status = $2 ORDER BY id LIMIT 1",
+			loan.BookID, models.ItemAvailable).Scan(&itemID)
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrNoCopies
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.ExecContext(ctx, "UPDATE items SET status = $1 WHERE id = $2", models.ItemOnLoan, itemID); err != nil {
+		return nil, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, "INSERT INTO loans (borrower_id, book_id, item_id, loan_date, return_date, status) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		loan.BorrowerID, loan.BookID, itemID, loan.LoanDate.UTC(), loan.ReturnDate.UTC(), models.LoanActive).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := getSQLiteLoan(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return l, tx.Commit()
+}
+
+func (s *SQLiteRepo) ExtendLoan(ctx context.Context, borrowerID, bookID int64, newReturnDate time.Time, version int64) (*models.LoanDetail, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `UPDATE loans SET return_date = $1, renewals = renewals + 1, version = version + 1
+		WHERE borrower_id = $2 AND book_id = $3 AND status = $4 AND version = COALESCE($5, version) RETURNING id`,
+		newReturnDate.UTC(), borrowerID, bookID, models.LoanActive, versionArg(version)).Scan(&id)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, loanUnchanged(ctx, tx, borrowerID, bookID, version)
+		}
+		return nil, err
+	}
+
+	l, err := getSQLiteLoan(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return l, tx.Commit()
+}
+
+func (s *SQLiteRepo) CountLoans(ctx context.Context, borrowerID int64) (int, error) {
+	var count int
+	err := s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM loans WHERE borrower_id = $1 AND status = $2", borrowerID, models.LoanActive).Scan(&count)
+	return count, err
+}
+
+func (s *SQLiteRepo) NextReturnDate(ctx context.Context, bookID int64, now time.Time) (time.Time, error) {
+	var next time.Time
+	err := s.DB.QueryRowContext(ctx, nextReturnQuery, bookID, models.LoanActive, now.UTC()).Scan(&next)
+	if stdErrors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return next, err
+}
+
+func (s *SQLiteRepo) ListOverdueLoans(ctx context.Context, now time.Time) ([]models.LoanDetail, error) {
+	query := `SELECT ` + loanColumns + ` FROM loans l` + loanJoins + `
+		WHERE l.status = $1 AND l.return_date < $2 AND NOT bk.digital ORDER BY l.return_date`
+	return s.queryLoans(ctx, time.Time{}, query, models.LoanActive, now.UTC())
+}
+
+func (s *SQLiteRepo) ListLoansByBorrower(ctx context.Context, borrowerID int64, page models.Page, now time.Time) (*models.LoanPage, error) {
+	if _, err := s.GetBorrower(ctx, borrowerID); err != nil {
+		return nil, err
+	}
+	return s.loanPage(ctx, "l.borrower_id = $1", borrowerID, page, now)
+}
+
+func (s *SQLiteRepo) ListLoansByBook(ctx context.Context, bookID int64, page models.Page, now time.Time) (*models.LoanPage, error) {
+	if err := requireBook(ctx, s.DB, bookID); err != nil {
+		return nil, err
+	}
+	return s.loanPage(ctx, "l.book_id = $1", bookID, page, now)
+}
+
+// loanPage returns one page of loans matching filter, newest first, with the
+// total number of matches.
+func (s *SQLiteRepo) loanPage(ctx context.Context, filter string, arg any, page models.Page, now time.Time) (*models.LoanPage, error) {
+	var total int
+	if err := s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM loans l WHERE "+filter, arg).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + loanColumns + ` FROM loans l` + loanJoins + `
+		WHERE ` + filter + ` ORDER BY l.loan_date DESC, l.id DESC LIMIT $2 OFFSET $3`
+	loans, err := s.queryLoans(ctx, now, query, arg, page.Limit, page.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return &models.LoanPage{Items: loans, Total: total, Limit: page.Limit, Offset: page.Offset}, nil
+}
+
+func (s *SQLiteRepo) MarkLoanLost(ctx context.Context, id int64, at time.Time) (*models.LoanDetail, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The item is gone, so unlike a return nothing is released to the shelf or the hold queue
+	var itemID int64
+	err = tx.QueryRowContext(ctx, `UPDATE loans SET status = $1, returned_at = $2, returned_reason = $3, version = version + 1
+		WHERE id = $4 AND status = $5 RETURNING item_id`,
+		models.LoanLost, at.UTC(), models.ReturnReasonLost, id, models.LoanActive).Scan(&itemID)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrLoanNotFound
+		}
+		return nil, err
+	}
+	if _, err = tx.ExecContext(ctx, "UPDATE items SET status = $1 WHERE id = $2", models.ItemLost, itemID); err != nil {
+		return nil, err
+	}
+
+	l, err := getSQLiteLoan(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return l, tx.Commit()
+}
+
+func (s *SQLiteRepo) PurgeLoans(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM loans WHERE status <> $1 AND returned_at < $2", models.LoanActive, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+func (s *SQLiteRepo) ReturnBook(ctx context.Context, borrowerID, bookID int64, returnedAt, pickupDeadline time.Time, version int64, fee LateFee) (*models.FineEntry, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ended, err := endLoan(ctx, tx, borrowerID, bookID, returnedAt.UTC(), models.ReturnReasonReturned, version)
+	if err != nil {
+		return nil, err
+	}
+	if ended == nil {
+		return nil, loanUnchanged(ctx, tx, borrowerID, bookID, version)
+	}
+
+	if err = releaseItem(ctx, tx, bookID, ended.itemID, pickupDeadline.UTC()); err != nil {
+		return nil, err
+	}
+	charge, err := chargeLateFee(ctx, tx, borrowerID, bookID, ended.returnDate, returnedAt.UTC(), fee)
+	if err != nil {
+		return nil, err
+	}
+	return charge, tx.Commit()
+}
+
+func (s *SQLiteRepo) ExpireDigitalLoans(ctx context.Context, now, pickupDeadline time.Time) (int, error) {
+	query := `SELECT l.borrower_id, l.book_id FROM loans l JOIN books b ON b.id = l.book_id
+		WHERE b.digital AND l.status = $1 AND l.return_date < $2 ORDER BY l.return_date`
+	rows, err := s.DB.QueryContext(ctx, query, models.LoanActive, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	type dueLoan struct {
+		borrowerID int64
+		bookID     int64
+	}
+	var due []dueLoan
+	for rows.Next() {
+		var l dueLoan
+		if err := rows.Scan(&l.borrowerID, &l.bookID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, l := range due {
+		ok, err := s.expireLoan(ctx, l.borrowerID, l.bookID, now, pickupDeadline)
+		if err != nil {
+			return expired, err
+		}
+		if ok {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// expireLoan ends a single overdue digital loan at its return date. It
+// reports false when the loan was returned or extended concurrently.
+func (s *SQLiteRepo) expireLoan(ctx context.Context, borrowerID, bookID int64, now, pickupDeadline time.Time) (bool, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var returnDate time.Time
+	err = tx.QueryRowContext(ctx, "SELECT return_date FROM loans WHERE borrower_id = $1 AND book_id = $2 AND status = $3",
+		borrowerID, bookID, models.LoanActive).Scan(&returnDate)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !returnDate.Before(now) {
+		return false, nil
+	}
+
+	ended, err := endLoan(ctx, tx, borrowerID, bookID, returnDate.UTC(), models.ReturnReasonExpired, 0)
+	if err != nil || ended == nil {
+		return false, err
+	}
+	if err = releaseItem(ctx, tx, bookID, ended.itemID, pickupDeadline.UTC()); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *SQLiteRepo) PlaceHold(ctx context.Context, hold *models.HoldDetail) (*models.HoldDetail, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = requireBook(ctx, tx, hold.BookID); err != nil {
+		return nil, err
+	}
+	var currentCopies int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM items WHERE book_id = $1 AND status = $2", hold.BookID, models.ItemAvailable).Scan(&currentCopies)
+	if err != nil {
+		return nil, err
+	}
+
+	var hasLoan bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM loans WHERE borrower_id = $1 AND book_id = $2 AND status = $3)",
+		hold.BorrowerID, hold.BookID, models.LoanActive).Scan(&hasLoan)
+	if err != nil {
+		return nil, err
+	}
+	if hasLoan {
+		return nil, errors.ErrDuplicateLoan
+	}
+
+	var hasHold bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM holds WHERE borrower_id = $1 AND book_id = $2 AND status IN ($3, $4))",
+		hold.BorrowerID, hold.BookID, models.HoldWaiting, models.HoldReady).Scan(&hasHold)
+	if err != nil {
+		return nil, err
+	}
+	if hasHold {
+		return nil, errors.ErrDuplicateHold
+	}
+	if currentCopies > 0 {
+		return nil, errors.ErrCopiesAvailable
+	}
+
+	h := models.HoldDetail{
+		BorrowerID:     hold.BorrowerID,
+		NameOfBorrower: hold.NameOfBorrower,
+		BookID:         hold.BookID,
+		BookTitle:      hold.BookTitle,
+		Status:         models.HoldWaiting,
+		PlacedAt:       hold.PlacedAt,
+	}
+	err = tx.QueryRowContext(ctx, "INSERT INTO holds (borrower_id, book_id, status, placed_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		h.BorrowerID, h.BookID, h.Status, h.PlacedAt.UTC()).Scan(&h.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &h, tx.Commit()
+}
+
+func (s *SQLiteRepo) ListHolds(ctx context.Context, bookID int64) ([]models.HoldDetail, error) {
+	if err := requireBook(ctx, s.DB, bookID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT h.id, h.borrower_id, b.name, h.book_id, bk.title, h.status, h.placed_at, h.expires_at,
+			COALESCE(h.item_id, 0)
+		FROM holds h JOIN borrowers b ON b.id = h.borrower_id JOIN books bk ON bk.id = h.book_id
+		WHERE h.book_id = $1 AND h.status IN ($2, $3) ORDER BY h.id`
+	rows, err := s.DB.QueryContext(ctx, query, bookID, models.HoldWaiting, models.HoldReady)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []models.HoldDetail{}
+	for rows.Next() {
+		var h models.HoldDetail
+		if err := rows.Scan(&h.ID, &h.BorrowerID, &h.NameOfBorrower, &h.BookID, &h.BookTitle, &h.Status, &h.PlacedAt, &h.ExpiresAt,
+			&h.ItemID); err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+	return holds, rows.Err()
+}
+
+func (s *SQLiteRepo) ExpireHolds(ctx context.Context, now, pickupDeadline time.Time) (int, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, book_id FROM holds WHERE status = $1 AND expires_at < $2 ORDER BY id", models.HoldReady, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	type staleHold struct {
+		id     int64
+		bookID int64
+	}
+	var stale []staleHold
+	for rows.Next() {
+		var h staleHold
+		if err := rows.Scan(&h.id, &h.bookID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale = append(stale, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, h := range stale {
+		ok, err := s.expireHold(ctx, h.id, h.bookID, pickupDeadline)
+		if err != nil {
+			return expired, err
+		}
+		if ok {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (s *SQLiteRepo) PurgeHolds(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM holds WHERE status IN ($1, $2) AND placed_at < $3",
+		models.HoldFulfilled, models.HoldExpired, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// expireHold marks a single ready hold as expired and passes its copy on.
+// It reports false when the hold was picked up or expired concurrently.
+func (s *SQLiteRepo) expireHold(ctx context.Context, id, bookID int64, pickupDeadline time.Time) (bool, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var itemID int64
+	err = tx.QueryRowContext(ctx, "UPDATE holds SET status = $1 WHERE id = $2 AND status = $3 RETURNING item_id",
+		models.HoldExpired, id, models.HoldReady).Scan(&itemID)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err = releaseItem(ctx, tx, bookID, itemID, pickupDeadline.UTC()); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *SQLiteRepo) CreateBorrower(ctx context.Context, borrower *models.Borrower) (*models.Borrower, error) {
+	b := *borrower
+	err := s.DB.QueryRowContext(ctx, "INSERT INTO borrowers (name, email, phone, tier, status, membership_expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		b.Name, b.Email, b.Phone, b.Tier, b.Status, b.MembershipExpiresAt.UTC()).Scan(&b.ID)
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			return nil, errors.ErrBorrowerExists
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (s *SQLiteRepo) GetBorrower(ctx context.Context, id int64) (*models.Borrower, error) {
+	var b models.Borrower
+	err := s.DB.QueryRowContext(ctx, "SELECT id, name, email, phone, tier, status, membership_expires_at FROM borrowers WHERE id = $1", id).
+		Scan(&b.ID, &b.Name, &b.Email, &b.Phone, &b.Tier, &b.Status, &b.MembershipExpiresAt)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrBorrowerNotFound
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (s *SQLiteRepo) UpdateBorrower(ctx context.Context, id int64, borrower *models.Borrower) (*models.Borrower, error) {
+	b := *borrower
+	b.ID = id
+	query := "UPDATE borrowers SET name = $1, email = $2, phone = $3, tier = $4, status = $5, membership_expires_at = $6 WHERE id = $7"
+	res, err := s.DB.ExecContext(ctx, query, b.Name, b.Email, b.Phone, b.Tier, b.Status, b.MembershipExpiresAt.UTC(), id)
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			return nil, errors.ErrBorrowerExists
+		}
+		return nil, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, errors.ErrBorrowerNotFound
+	}
+	return &b, nil
+}
+
+func (s *SQLiteRepo) DeleteBorrower(ctx context.Context, id int64) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var found int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM borrowers WHERE id = $1", id).Scan(&found)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return errors.ErrBorrowerNotFound
+		}
+		return err
+	}
+
+	// Ended loans are history, kept like a book's until PurgeLoans drops them
+	var outstanding bool
+	query := `SELECT EXISTS(SELECT 1 FROM loans WHERE borrower_id = $1)
+		OR EXISTS(SELECT 1 FROM holds WHERE borrower_id = $1 AND status IN ($2, $3))`
+	if err = tx.QueryRowContext(ctx, query, id, models.HoldWaiting, models.HoldReady).Scan(&outstanding); err != nil {
+		return err
+	}
+	if outstanding {
+		return errors.ErrBorrowerHasLoans
+	}
+	balance, err := fineBalance(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if balance > 0 {
+		return errors.ErrBorrowerHasLoans
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM holds WHERE borrower_id = $1", id); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM fines WHERE borrower_id = $1", id); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM borrowers WHERE id = $1", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteRepo) AddFineEntry(ctx context.Context, entry *models.FineEntry) (*models.FineEntry, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var found int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM borrowers WHERE id = $1", entry.BorrowerID).Scan(&found)
+	if err != nil {
+		if stdErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.ErrBorrowerNotFound
+		}
+		return nil, err
+	}
+
+	if entry.Kind != models.FineCharge {
+		balance, err := fineBalance(ctx, tx, entry.BorrowerID)
+		if err != nil {
+			return nil, err
+		}
+		if entry.AmountCents > balance {
+			return nil, errors.ErrAmountExceedsBalance
+		}
+	}
+
+	f := *entry
+	err = tx.QueryRowContext(ctx, "INSERT INTO fines (borrower_id, kind, amount_cents, book_title, note, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		f.BorrowerID, f.Kind, f.AmountCents, f.BookTitle, f.Note, f.CreatedAt.UTC()).Scan(&f.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &f, tx.Commit()
+}
+
+func (s *SQLiteRepo) ListFineEntries(ctx context.Context, borrowerID int64) ([]models.FineEntry, error) {
+	if _, err := s.GetBorrower(ctx, borrowerID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, borrower_id, kind, amount_cents, book_title, note, created_at FROM fines WHERE borrower_id = $1 ORDER BY id", borrowerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.FineEntry{}
+	for rows.Next() {
+		var f models.FineEntry
+		if err := rows.Scan(&f.ID, &f.BorrowerID, &f.Kind, &f.AmountCents, &f.BookTitle, &f.Note, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, f)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLiteRepo) FineBalance(ctx context.Context, borrowerID int64) (int64, error) {
+	if _, err := s.GetBorrower(ctx, borrowerID); err != nil {
+		return 0, err
+	}
+	return fineBalance(ctx, s.DB, borrowerID)
+}
+
+func (s *SQLiteRepo) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"e-library-api/internal/migrate"
+	"e-library-api/internal/models"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSQLiteRepo(t *testing.T) *SQLiteRepo {
+	t.Helper()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "library.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	m, err := migrate.New(db, migrate.SQLite)
+	require.NoError(t, err)
+	_, err = m.Up(context.Background())
+	require.NoError(t, err)
+	return NewSQLiteRepo(db)
+}
+
+func TestSQLiteRepo_MigrationsRollBack(t *testing.T) {
+	repo := newSQLiteRepo(t)
+	m, err := migrate.New(repo.DB, migrate.SQLite)
+	require.NoError(t, err)
+
+	for range m.Migrations {
+		rolledBack, err := m.Down(context.Background())
+		require.NoError(t, err)
+		require.NotNil(t, rolledBack)
+	}
+	rolledBack, err := m.Down(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, rolledBack)
+
+	applied, err := m.Up(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, applied, len(m.Migrations))
+}
+
+func TestSQLiteRepo_CancelledContext(t *testing.T) {
+	repo := newSQLiteRepo(t)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := repo.CreateBook(cancelled, &models.BookDetail{Title: "Never stored", Authors: []string{}, Subjects: []string{}})
+	assert.ErrorIs(t, err, context.Canceled)
+	page, err := repo.ListBooks(ctx, models.BookQuery{Sort: models.SortTitle, Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, page.Items)
+}
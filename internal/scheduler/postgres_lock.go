@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"log"
+)
+
+// PostgresLocker uses session-level advisory locks so that only one replica
+// runs each job. Each lock is held on a dedicated connection for the duration
+// of the job and released when the job returns.
+type PostgresLocker struct {
+	DB *sql.DB
+}
+
+func NewPostgresLocker(db *sql.DB) *PostgresLocker {
+	return &PostgresLocker{DB: db}
+}
+
+func (p *PostgresLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	conn, err := p.DB.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := lockKey(name)
+	var ok bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !ok {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	unlock := func() {
+		// Use a fresh context: the job context may already be cancelled at shutdown
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			log.Printf("Failed to release lock %s: %v", name, err)
+		}
+		conn.Close()
+	}
+	return unlock, true, nil
+}
+
+// lockKey maps a job name onto the 64-bit key space of Postgres advisory locks.
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("e-library-scheduler:" + name))
+	return int64(h.Sum64())
+}
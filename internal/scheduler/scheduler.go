@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a task the Scheduler runs periodically. Run receives a context that
+// is cancelled when the scheduler stops; long-running jobs should check it
+// between units of work and return once they have saved their progress.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Locker makes sure only one instance runs a given job at a time when several
+// replicas share the same storage.
+type Locker interface {
+	// TryLock takes the named lock without waiting. It reports false when
+	// another instance already holds it. The returned function releases the lock.
+	TryLock(ctx context.Context, name string) (unlock func(), ok bool, err error)
+}
+
+// LocalLocker is used when the process is the only instance, as with the memory repository.
+type LocalLocker struct{}
+
+func (LocalLocker) TryLock(context.Context, string) (func(), bool, error) {
+	return func() {}, true, nil
+}
+
+// Scheduler runs registered jobs on their own interval until stopped.
+type Scheduler struct {
+	locker Locker
+	jobs   []Job
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+}
+
+func New(locker Locker) *Scheduler {
+	return &Scheduler{locker: locker}
+}
+
+// Add registers a job. Jobs must be added before Start.
+func (s *Scheduler) Add(job Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+// Start launches one goroutine per job. It returns immediately.
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, job)
+	}
+	log.Printf("Scheduler started with %d jobs", len(s.jobs))
+}
+
+// Stop cancels the jobs' context and waits for running jobs to return, or for
+// ctx to expire, whichever comes first.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Println("Scheduler stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.runOnce(ctx, job)
+		}
+	}
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, job Job) {
+	unlock, ok, err := s.locker.TryLock(ctx, job.Name)
+	if err != nil {
+		log.Printf("Job %s: failed to acquire lock: %v", job.Name, err)
+		return
+	}
+	if !ok {
+		// Another instance is running this job
+		return
+	}
+	defer unlock()
+
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		log.Printf("Job %s failed after %s: %v", job.Name, time.Since(start), err)
+	}
+}
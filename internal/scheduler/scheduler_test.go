@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type denyLocker struct{}
+
+func (denyLocker) TryLock(context.Context, string) (func(), bool, error) {
+	return nil, false, nil
+}
+
+func TestScheduler_RunsJobsUntilStopped(t *testing.T) {
+	var runs atomic.Int32
+	s := New(LocalLocker{})
+	s.Add(Job{Name: "tick", Interval: 5 * time.Millisecond, Run: func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	}})
+
+	s.Start(context.Background())
+	assert.Eventually(t, func() bool { return runs.Load() >= 2 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Stop(ctx))
+
+	stopped := runs.Load()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, stopped, runs.Load())
+}
+
+func TestScheduler_StopWaitsForRunningJob(t *testing.T) {
+	started := make(chan struct{})
+	var checkpointed atomic.Bool
+	s := New(LocalLocker{})
+	s.Add(Job{Name: "slow", Interval: time.Millisecond, Run: func(ctx context.Context) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		checkpointed.Store(true)
+		return nil
+	}})
+
+	s.Start(context.Background())
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Stop(ctx))
+	assert.True(t, checkpointed.Load())
+}
+
+func TestScheduler_SkipsJobWhenLockHeldElsewhere(t *testing.T) {
+	var runs atomic.Int32
+	s := New(denyLocker{})
+	s.Add(Job{Name: "leader-only", Interval: time.Millisecond, Run: func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	}})
+
+	s.Start(context.Background())
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Stop(ctx))
+	assert.Equal(t, int32(0), runs.Load())
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"e-library-api/internal/errors"
+	"e-library-api/internal/models"
+	"strings"
+	"time"
+)
+
+// defaultMembershipYears applies when a member is registered without an explicit expiry.
+const defaultMembershipYears = 1
+
+func (s *LibraryService) CreateBorrower(ctx context.Context, borrower *models.Borrower) (*models.Borrower, error) {
+	normalizeBorrower(borrower)
+	if borrower.MembershipExpiresAt.IsZero() {
+		borrower.MembershipExpiresAt = time.Now().AddDate(defaultMembershipYears, 0, 0)
+	}
+	return s.Repo.CreateBorrower(ctx, borrower)
+}
+
+func (s *LibraryService) GetBorrower(ctx context.Context, id int64) (*models.Borrower, error) {
+	return s.Repo.GetBorrower(ctx, id)
+}
+
+func (s *LibraryService) UpdateBorrower(ctx context.Context, id int64, borrower *models.Borrower) (*models.Borrower, error) {
+	existing, err := s.Repo.GetBorrower(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	normalizeBorrower(borrower)
+	if borrower.MembershipExpiresAt.IsZero() {
+		borrower.MembershipExpiresAt = existing.MembershipExpiresAt
+	}
+	return s.Repo.UpdateBorrower(ctx, id, borrower)
+}
+
+// DeleteBorrower removes a member. Members with outstanding loans or holds cannot be deleted.
+func (s *LibraryService) DeleteBorrower(ctx context.Context, id int64) error {
+	return s.Repo.DeleteBorrower(ctx, id)
+}
+
+// activeBorrower looks up a member and checks that their membership allows borrowing.
+func (s *LibraryService) activeBorrower(ctx context.Context, id int64) (*models.Borrower, error) {
+	borrower, err := s.Repo.GetBorrower(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !borrower.IsActive(time.Now()) {
+		return nil, errors.ErrBorrowerInactive
+	}
+	return borrower, nil
+}
+
+// normalizeBorrower trims free-text fields and lower-cases the email so that
+// "Alice@Example.com " and "alice@example.com" are the same member.
+func normalizeBorrower(b *models.Borrower) {
+	b.Name = strings.TrimSpace(b.Name)
+	b.Email = strings.ToLower(strings.TrimSpace(b.Email))
+	b.Phone = strings.TrimSpace(b.Phone)
+	b.Tier = strings.ToLower(strings.TrimSpace(b.Tier))
+	if b.Tier == "" {
+		b.Tier = models.DefaultTier
+	}
+	if b.Status == "" {
+		b.Status = models.MemberActive
+	}
+}
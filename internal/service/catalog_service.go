@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"e-library-api/internal/isbn"
+	"e-library-api/internal/models"
+	"strings"
+)
+
+func (s *LibraryService) GetBook(ctx context.Context, id int64) (*models.BookDetail, error) {
+	return s.Repo.GetBook(ctx, id)
+}
+
+// ListBooks browses the catalog, sorted by title unless asked otherwise.
+func (s *LibraryService) ListBooks(ctx context.Context, query models.BookQuery) (*models.BookPage, error) {
+	query.Query = strings.TrimSpace(query.Query)
+	if query.Sort == "" {
+		query.Sort = models.SortTitle
+	}
+	if query.Limit == 0 {
+		query.Limit = models.DefaultPageLimit
+	}
+	return s.Repo.ListBooks(ctx, query)
+}
+
+// SearchBooks runs a ranked full-text search over titles, authors, subjects and descriptions.
+func (s *LibraryService) SearchBooks(ctx context.Context, query models.SearchQuery) ([]models.SearchResult, error) {
+	if query.Limit == 0 {
+		query.Limit = models.DefaultPageLimit
+	}
+	return s.Repo.SearchBooks(ctx, query.Query, query.Limit)
+}
+
+// CreateBook adds an edition to the catalog, either of an existing work or as
+// the first edition of a new one.
+func (s *LibraryService) CreateBook(ctx context.Context, book *models.BookDetail) (*models.BookDetail, error) {
+	b, err := normalizeBook(book)
+	if err != nil {
+		return nil, err
+	}
+	return s.Repo.CreateBook(ctx, b)
+}
+
+// UpdateBook replaces an edition's details. The edition stays with its work.
+// A non-zero version must be the book's current one.
+func (s *LibraryService) UpdateBook(ctx context.Context, id int64, book *models.BookDetail, version int64) (*models.BookDetail, error) {
+	b, err := normalizeBook(book)
+	if err != nil {
+		return nil, err
+	}
+	return s.Repo.UpdateBook(ctx, id, b, version)
+}
+
+func (s *LibraryService) AdjustCopies(ctx context.Context, id int64, delta int, version int64) (*models.BookDetail, error) {
+	return s.Repo.AdjustCopies(ctx, id, delta, version)
+}
+
+// DeleteBook removes a book from the catalog. Books that were ever lent out,
+// or that members are waiting for, cannot be deleted; withdraw their copies
+// instead.
+func (s *LibraryService) DeleteBook(ctx context.Context, id, version int64) error {
+	return s.Repo.DeleteBook(ctx, id, version)
+}
+
+// GetWork returns a work with all of its editions.
+func (s *LibraryService) GetWork(ctx context.Context, id int64) (*models.Work, error) {
+	return s.Repo.GetWork(ctx, id)
+}
+
+// AddItem registers a new copy or license of a book. It goes on the shelf
+// unless it is added as withdrawn.
+func (s *LibraryService) AddItem(ctx context.Context, bookID int64, item *models.Item) (*models.Item, error) {
+	i := normalizeItem(item)
+	i.BookID = bookID
+	if i.Status == "" {
+		i.Status = models.ItemAvailable
+	}
+	return s.Repo.AddItem(ctx, i)
+}
+
+func (s *LibraryService) GetItem(ctx context.Context, id int64) (*models.Item, error) {
+	return s.Repo.GetItem(ctx, id)
+}
+
+func (s *LibraryService) ListItems(ctx context.Context, bookID int64) ([]models.Item, error) {
+	return s.Repo.ListItems(ctx, bookID)
+}
+
+// UpdateItem records an item's barcode and condition, and withdraws it from
+// or returns it to circulation.
+func (s *LibraryService) UpdateItem(ctx context.Context, id int64, item *models.Item) (*models.Item, error) {
+	return s.Repo.UpdateItem(ctx, id, normalizeItem(item))
+}
+
+// normalizeItem trims the barcode and defaults the condition to good.
+func normalizeItem(item *models.Item) *models.Item {
+	i := *item
+	i.Barcode = strings.TrimSpace(i.Barcode)
+	if i.Condition == "" {
+		i.Condition = models.ConditionGood
+	}
+	return &i
+}
+
+// normalizeBook validates the ISBN and stores it as ISBN-13, trims the
+// descriptive fields and derives whether the edition is digital from its
+// format. Books created before formats existed only say whether they are
+// digital, so that picks the default format.
+func normalizeBook(book *models.BookDetail) (*models.BookDetail, error) {
+	b := *book
+	if b.ISBN = strings.TrimSpace(b.ISBN); b.ISBN != "" {
+		normalized, err := isbn.Normalize(b.ISBN)
+		if err != nil {
+			return nil, err
+		}
+		b.ISBN = normalized
+	}
+	b.Description = strings.TrimSpace(b.Description)
+	b.Publisher = strings.TrimSpace(b.Publisher)
+	b.Language = strings.TrimSpace(b.Language)
+	b.Authors = trimAll(book.Authors)
+	b.Subjects = trimAll(book.Subjects)
+
+	if b.Format == "" {
+		b.Format = models.FormatPrint
+		if b.Digital {
+			b.Format = models.FormatEPUB
+		}
+	}
+	b.Digital = b.Format != models.FormatPrint
+	return &b, nil
+}
+
+// trimAll trims every value and drops the blank ones.
+func trimAll(values []string) []string {
+	trimmed := []string{}
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			trimmed = append(trimmed, v)
+		}
+	}
+	return trimmed
+}
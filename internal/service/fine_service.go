@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"e-library-api/internal/models"
+	"time"
+)
+
+func (s *LibraryService) GetFineAccount(ctx context.Context, borrowerID int64) (*models.FineAccount, error) {
+	entries, err := s.Repo.ListFineEntries(ctx, borrowerID)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := s.Repo.FineBalance(ctx, borrowerID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.FineAccount{BorrowerID: borrowerID, BalanceCents: balance, Entries: entries}, nil
+}
+
+// PayFine records a payment against the member's balance. Payments larger
+// than the outstanding balance are rejected.
+func (s *LibraryService) PayFine(ctx context.Context, borrowerID int64, tx *models.FineTransaction) (*models.FineEntry, error) {
+	return s.addCredit(ctx, borrowerID, models.FinePayment, tx)
+}
+
+// WaiveFine writes off part or all of the member's balance.
+func (s *LibraryService) WaiveFine(ctx context.Context, borrowerID int64, tx *models.FineTransaction) (*models.FineEntry, error) {
+	return s.addCredit(ctx, borrowerID, models.FineWaiver, tx)
+}
+
+func (s *LibraryService) addCredit(ctx context.Context, borrowerID int64, kind string, tx *models.FineTransaction) (*models.FineEntry, error) {
+	return s.Repo.AddFineEntry(ctx, &models.FineEntry{
+		BorrowerID:  borrowerID,
+		Kind:        kind,
+		AmountCents: tx.AmountCents,
+		Note:        tx.Note,
+		CreatedAt:   time.Now(),
+	})
+}
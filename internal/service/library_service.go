@@ -1,57 +1,303 @@
 package service
 
 import (
+	"context"
+	"e-library-api/internal/errors"
 	"e-library-api/internal/models"
+	"e-library-api/internal/policy"
 	"e-library-api/internal/repository"
+	stdErrors "errors"
 	"time"
 )
 
 // LibraryServiceInterface defines the behaviors for the library service.
 type LibraryServiceInterface interface {
-	GetBook(title string) (*models.BookDetail, error)
-	BorrowBook(name, title string) (*models.LoanDetail, error)
-	ExtendLoan(name, title string) (*models.LoanDetail, error)
-	ReturnBook(name, title string) error
-	HealthCheck() error
+	GetBook(ctx context.Context, id int64) (*models.BookDetail, error)
+	ListBooks(ctx context.Context, query models.BookQuery) (*models.BookPage, error)
+	SearchBooks(ctx context.Context, query models.SearchQuery) ([]models.SearchResult, error)
+	CreateBook(ctx context.Context, book *models.BookDetail) (*models.BookDetail, error)
+	UpdateBook(ctx context.Context, id int64, book *models.BookDetail, version int64) (*models.BookDetail, error)
+	AdjustCopies(ctx context.Context, id int64, delta int, version int64) (*models.BookDetail, error)
+	DeleteBook(ctx context.Context, id, version int64) error
+	GetWork(ctx context.Context, id int64) (*models.Work, error)
+	AddItem(ctx context.Context, bookID int64, item *models.Item) (*models.Item, error)
+	GetItem(ctx context.Context, id int64) (*models.Item, error)
+	ListItems(ctx context.Context, bookID int64) ([]models.Item, error)
+	UpdateItem(ctx context.Context, id int64, item *models.Item) (*models.Item, error)
+	BorrowBook(ctx context.Context, borrowerID, bookID int64) (*models.LoanDetail, error)
+	ExtendLoan(ctx context.Context, borrowerID, bookID int64) (*models.LoanDetail, error)
+	ReturnBook(ctx context.Context, borrowerID, bookID int64) (*models.FineEntry, error)
+	GetLoan(ctx context.Context, id int64) (*models.LoanDetail, error)
+	RenewLoan(ctx context.Context, id, version int64) (*models.LoanDetail, error)
+	ReturnLoan(ctx context.Context, id, version int64) (*models.FineEntry, error)
+	ListOverdueLoans(ctx context.Context) ([]models.LoanDetail, error)
+	ListBorrowerLoans(ctx context.Context, borrowerID int64, page models.Page) (*models.LoanPage, error)
+	ListBookLoans(ctx context.Context, bookID int64, page models.Page) (*models.LoanPage, error)
+	MarkLoanLost(ctx context.Context, id int64) (*models.LoanDetail, error)
+	PlaceHold(ctx context.Context, borrowerID, bookID int64) (*models.HoldDetail, error)
+	ListHolds(ctx context.Context, bookID int64) ([]models.HoldDetail, error)
+	CreateBorrower(ctx context.Context, borrower *models.Borrower) (*models.Borrower, error)
+	GetBorrower(ctx context.Context, id int64) (*models.Borrower, error)
+	UpdateBorrower(ctx context.Context, id int64, borrower *models.Borrower) (*models.Borrower, error)
+	DeleteBorrower(ctx context.Context, id int64) error
+	GetFineAccount(ctx context.Context, borrowerID int64) (*models.FineAccount, error)
+	PayFine(ctx context.Context, borrowerID int64, tx *models.FineTransaction) (*models.FineEntry, error)
+	WaiveFine(ctx context.Context, borrowerID int64, tx *models.FineTransaction) (*models.FineEntry, error)
+	HealthCheck(ctx context.Context) error
 }
 
-// LibraryService handles business logic such as 4-week duration for books borrowed and 3-week extension
+// holdPickupWindow is how long a copy set aside for a hold waits before it
+// rolls over to the next borrower in the queue.
+const holdPickupWindow = 3 * 24 * time.Hour
+
+// LibraryService handles business logic such as loan durations, extensions
+// and borrowing limits, as configured by its lending policy
 type LibraryService struct {
-	Repo repository.LibraryRepository
+	Repo   repository.LibraryRepository
+	Policy policy.Policy
 }
 
-func NewLibraryService(r repository.LibraryRepository) *LibraryService {
-	return &LibraryService{Repo: r}
+func NewLibraryService(r repository.LibraryRepository, p policy.Policy) *LibraryService {
+	return &LibraryService{Repo: r, Policy: p}
 }
 
-func (s *LibraryService) GetBook(title string) (*models.BookDetail, error) {
-	return s.Repo.GetBook(title)
-}
+func (s *LibraryService) BorrowBook(ctx context.Context, borrowerID, bookID int64) (*models.LoanDetail, error) {
+	borrower, err := s.activeBorrower(ctx, borrowerID)
+	if err != nil {
+		return nil, err
+	}
 
-func (s *LibraryService) BorrowBook(name, title string) (*models.LoanDetail, error) {
+	// Release copies from holds that were never picked up before deciding availability
+	if _, err := s.ExpireHolds(ctx); err != nil {
+		return nil, err
+	}
+
+	book, err := s.Repo.GetBook(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
 	loan := &models.LoanDetail{
-		NameOfBorrower: name,
-		BookTitle:      title,
-		LoanDate:       time.Now(),
-		ReturnDate:     time.Now().AddDate(0, 0, 28), // 4-week rule
+		BorrowerID:     borrower.ID,
+		NameOfBorrower: borrower.Name,
+		BookID:         book.ID,
+		BookTitle:      book.Title,
+		LoanDate:       now,
+		ReturnDate:     now.AddDate(0, 0, s.Policy.LoanPeriod(borrower.Tier, book.Category)),
+	}
+	// The limits are checked in the transaction that makes the loan, so
+	// concurrent borrows by one member cannot both slip under them
+	loan, err = s.Repo.BorrowBook(ctx, loan, now.Add(holdPickupWindow), s.Policy.CheckBorrow)
+	if stdErrors.Is(err, errors.ErrNoCopies) {
+		// Tell the borrower when to try again
+		next, nextErr := s.Repo.NextReturnDate(ctx, book.ID, time.Now())
+		if nextErr != nil {
+			return nil, nextErr
+		}
+		return nil, &errors.NoCopiesError{AvailableAt: next}
+	}
+	return loan, err
+}
+
+func (s *LibraryService) ExtendLoan(ctx context.Context, borrowerID, bookID int64) (*models.LoanDetail, error) {
+	return s.extendLoan(ctx, borrowerID, bookID, 0)
+}
+
+// renewalAttempts caps how often a renewal without a version from the caller
+// is tried against a loan that keeps changing under it.
+const renewalAttempts = 3
+
+// extendLoan extends the loan if it is still at the given version, or
+// whatever its version when that is zero.
+func (s *LibraryService) extendLoan(ctx context.Context, borrowerID, bookID, version int64) (*models.LoanDetail, error) {
+	for attempt := 1; ; attempt++ {
+		loan, err := s.tryExtendLoan(ctx, borrowerID, bookID, version)
+		// Without a version from the caller, a loan renewed concurrently is
+		// checked again against the renewal limit rather than renewed twice
+		if version != 0 || attempt == renewalAttempts || !stdErrors.Is(err, errors.ErrVersionMismatch) {
+			return loan, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// tryExtendLoan checks the renewal policy against the loan as read and extends
+// it only if it has not changed since.
+func (s *LibraryService) tryExtendLoan(ctx context.Context, borrowerID, bookID, version int64) (*models.LoanDetail, error) {
+	// An e-book loan past its return date has already ended and is not found
+	loan, err := s.Repo.GetLoan(ctx, borrowerID, bookID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		version = loan.Version
+	}
+
+	holds, err := s.Repo.ListHolds(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	holdsWaiting := false
+	for _, h := range holds {
+		if h.Status == models.HoldWaiting {
+			holdsWaiting = true
+			break
+		}
+	}
+	if err := s.Policy.CheckRenewal(loan.Renewals, holdsWaiting); err != nil {
+		return nil, err
 	}
-	return s.Repo.BorrowBook(loan)
+
+	newReturnDate := loan.ReturnDate.AddDate(0, 0, s.Policy.ExtensionDays)
+	return s.Repo.ExtendLoan(ctx, borrowerID, bookID, newReturnDate, version)
+}
+
+// ReturnBook ends a loan. The freed copy goes to the first borrower waiting
+// in the hold queue, if any, instead of back on the shelf. A late return is
+// charged to the member's fines ledger and the charge is returned.
+func (s *LibraryService) ReturnBook(ctx context.Context, borrowerID, bookID int64) (*models.FineEntry, error) {
+	return s.returnBook(ctx, borrowerID, bookID, 0)
+}
+
+// returnBook ends the loan if it is still at the given version, or whatever
+// its version when that is zero.
+func (s *LibraryService) returnBook(ctx context.Context, borrowerID, bookID, version int64) (*models.FineEntry, error) {
+	// E-book loans end by themselves at the return date and are never late
+	now := time.Now()
+	if _, err := s.Repo.GetLoan(ctx, borrowerID, bookID, now); err != nil {
+		return nil, err
+	}
+	// The fine is charged in the transaction that ends the loan, so a return
+	// is never recorded without it
+	return s.Repo.ReturnBook(ctx, borrowerID, bookID, now, now.Add(holdPickupWindow), version, s.Policy.FineFor)
 }
 
-func (s *LibraryService) ExtendLoan(name, title string) (*models.LoanDetail, error) {
-	loan, err := s.Repo.GetLoan(name, title)
+// GetLoan returns a loan by its ID, whether it is active or has ended. An
+// e-book loan past its return date has ended, even before the
+// expire-ebook-loans job has closed it.
+func (s *LibraryService) GetLoan(ctx context.Context, id int64) (*models.LoanDetail, error) {
+	return s.Repo.GetLoanByID(ctx, id, time.Now())
+}
+
+// RenewLoan extends a loan named by its ID, under the same rules as
+// ExtendLoan. A non-zero version must be the loan's current one.
+func (s *LibraryService) RenewLoan(ctx context.Context, id, version int64) (*models.LoanDetail, error) {
+	loan, err := s.activeLoan(ctx, id, version)
 	if err != nil {
 		return nil, err
 	}
+	return s.extendLoan(ctx, loan.BorrowerID, loan.BookID, version)
+}
+
+// ReturnLoan ends a loan named by its ID, the same way as ReturnBook. A
+// non-zero version must be the loan's current one.
+func (s *LibraryService) ReturnLoan(ctx context.Context, id, version int64) (*models.FineEntry, error) {
+	loan, err := s.activeLoan(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	return s.returnBook(ctx, loan.BorrowerID, loan.BookID, version)
+}
+
+// activeLoan looks up a loan that has not ended yet and is still at the
+// given version, if that is not zero
+func (s *LibraryService) activeLoan(ctx context.Context, id, version int64) (*models.LoanDetail, error) {
+	loan, err := s.GetLoan(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && loan.Version != version {
+		return nil, errors.ErrVersionMismatch
+	}
+	if loan.Status != models.LoanActive {
+		return nil, errors.ErrLoanEnded
+	}
+	return loan, nil
+}
+
+// ListOverdueLoans returns active loans past their return date, oldest first.
+func (s *LibraryService) ListOverdueLoans(ctx context.Context) ([]models.LoanDetail, error) {
+	return s.Repo.ListOverdueLoans(ctx, time.Now())
+}
+
+// ListBorrowerLoans returns a page of the member's active and past loans, newest first.
+func (s *LibraryService) ListBorrowerLoans(ctx context.Context, borrowerID int64, page models.Page) (*models.LoanPage, error) {
+	return s.Repo.ListLoansByBorrower(ctx, borrowerID, withDefaultLimit(page), time.Now())
+}
+
+// ListBookLoans returns a page of the book's active and past loans, newest first.
+func (s *LibraryService) ListBookLoans(ctx context.Context, bookID int64, page models.Page) (*models.LoanPage, error) {
+	return s.Repo.ListLoansByBook(ctx, bookID, withDefaultLimit(page), time.Now())
+}
+
+// MarkLoanLost closes an active loan whose copy was lost. The copy does not
+// return to the shelf.
+func (s *LibraryService) MarkLoanLost(ctx context.Context, id int64) (*models.LoanDetail, error) {
+	return s.Repo.MarkLoanLost(ctx, id, time.Now())
+}
+
+func withDefaultLimit(page models.Page) models.Page {
+	if page.Limit == 0 {
+		page.Limit = models.DefaultPageLimit
+	}
+	return page
+}
+
+// PlaceHold queues the borrower for a book that currently has no copies available.
+func (s *LibraryService) PlaceHold(ctx context.Context, borrowerID, bookID int64) (*models.HoldDetail, error) {
+	borrower, err := s.activeBorrower(ctx, borrowerID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.ExpireHolds(ctx); err != nil {
+		return nil, err
+	}
+	book, err := s.Repo.GetBook(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	hold := &models.HoldDetail{
+		BorrowerID:     borrower.ID,
+		NameOfBorrower: borrower.Name,
+		BookID:         book.ID,
+		BookTitle:      book.Title,
+		PlacedAt:       time.Now(),
+	}
+	return s.Repo.PlaceHold(ctx, hold)
+}
+
+func (s *LibraryService) ListHolds(ctx context.Context, bookID int64) ([]models.HoldDetail, error) {
+	return s.Repo.ListHolds(ctx, bookID)
+}
+
+// ExpireHolds expires ready holds whose pickup deadline has passed and rolls
+// their copies to the next borrower in each queue.
+func (s *LibraryService) ExpireHolds(ctx context.Context) (int, error) {
+	now := time.Now()
+	return s.Repo.ExpireHolds(ctx, now, now.Add(holdPickupWindow))
+}
+
+// ExpireDigitalLoans automatically returns e-book loans that reached their
+// return date, revoking the borrower's access and releasing the copy.
+func (s *LibraryService) ExpireDigitalLoans(ctx context.Context) (int, error) {
+	now := time.Now()
+	return s.Repo.ExpireDigitalLoans(ctx, now, now.Add(holdPickupWindow))
+}
 
-	newReturnDate := loan.ReturnDate.AddDate(0, 0, 21) // 3-week extension rule
-	return s.Repo.ExtendLoan(name, title, newReturnDate)
+// PurgeHolds deletes fulfilled and expired holds older than the retention period.
+func (s *LibraryService) PurgeHolds(ctx context.Context, retention time.Duration) (int, error) {
+	return s.Repo.PurgeHolds(ctx, time.Now().Add(-retention))
 }
 
-func (s *LibraryService) ReturnBook(name, title string) error {
-	return s.Repo.ReturnBook(name, title)
+// PurgeLoans deletes ended loans closed before the retention period.
+func (s *LibraryService) PurgeLoans(ctx context.Context, retention time.Duration) (int, error) {
+	return s.Repo.PurgeLoans(ctx, time.Now().Add(-retention))
 }
 
-func (s *LibraryService) HealthCheck() error {
-	return s.Repo.Ping()
+func (s *LibraryService) HealthCheck(ctx context.Context) error {
+	return s.Repo.Ping(ctx)
 }